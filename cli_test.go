@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mrasu/ddb/server"
+	"github.com/mrasu/ddb/thelper"
+)
+
+func TestWalDump(t *testing.T) {
+	dir := prepareLogDir(t)
+	defer os.RemoveAll(dir)
+
+	out := &bytes.Buffer{}
+	thelper.AssertNoError(t, walDump(out, []string{"-dir", dir}))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	thelper.AssertInt(t, "Invalid record count", 7, len(lines))
+	thelper.AssertString(t, "Invalid CREATE TABLE", "lsn=1 CREATE_TABLE hello.world (id INT AUTO_INCREMENT, message VARCHAR(10))", lines[1])
+	thelper.AssertString(t, "Invalid INSERT", `lsn=3 INSERT trx=1 hello.world {"id":"1","message":"foo"}`, lines[3])
+	thelper.AssertBool(t, "Invalid COMMIT", true, strings.HasPrefix(lines[4], "lsn=4 COMMIT trx=1 at="))
+
+	out.Reset()
+	thelper.AssertNoError(t, walDump(out, []string{"-dir", dir, "-trx", "2"}))
+	thelper.AssertString(t, "Invalid filtered by transaction", "lsn=5 BEGIN trx=2\nlsn=6 ROLLBACK trx=2\n", out.String())
+
+	out.Reset()
+	thelper.AssertNoError(t, walDump(out, []string{"-dir", dir, "-table", "world", "-from", "2"}))
+	thelper.AssertString(t, "Invalid filtered by table", `lsn=3 INSERT trx=1 hello.world {"id":"1","message":"foo"}`+"\n", out.String())
+}
+
+func TestWalVerify(t *testing.T) {
+	dir := prepareLogDir(t)
+	defer os.RemoveAll(dir)
+
+	out := &bytes.Buffer{}
+	thelper.AssertNoError(t, walVerify(out, []string{"-dir", dir}))
+	thelper.AssertString(t, "Invalid summary", "7 records in 1 segments, last LSN 6\n", out.String())
+
+	fName := dir + "/wal_0.log"
+	bs, err := ioutil.ReadFile(fName)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, ioutil.WriteFile(fName, bs[:len(bs)-2], 0600))
+
+	out.Reset()
+	err = walVerify(out, []string{"-dir", dir})
+	thelper.AssertBool(t, "Corruption is not reported", true, err != nil)
+	thelper.AssertBool(t, "Invalid report", true, strings.HasPrefix(out.String(), "CORRUPT corrupt record in "+fName))
+}
+
+func TestSnapshotInspect(t *testing.T) {
+	dir := prepareLogDir(t)
+	defer os.RemoveAll(dir)
+
+	out := &bytes.Buffer{}
+	thelper.AssertNoError(t, snapshotInspect(out, []string{"-dir", dir, "-rows"}))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	thelper.AssertInt(t, "Invalid line count", 5, len(lines))
+	thelper.AssertBool(t, "Invalid listing", true, strings.HasPrefix(lines[0], "lsn=7 replay_lsn=7 at="))
+	thelper.AssertString(t, "Invalid database", "database hello", lines[2])
+	thelper.AssertString(t, "Invalid table", "  table world(id INT AUTO_INCREMENT, message VARCHAR(10)) rows=1", lines[3])
+	thelper.AssertString(t, "Invalid row", `    {"id":"1","message":"foo"}`, lines[4])
+}
+
+// prepareLogDir writes a WAL and a snapshot which has a committed and a rolled back transactions.
+func prepareLogDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "ddb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := server.NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	c := s.StartNewConnection()
+	for _, q := range []string{
+		"CREATE DATABASE hello",
+		"CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))",
+		"BEGIN",
+		"INSERT INTO hello.world(message) VALUES ('foo')",
+		"COMMIT",
+		"BEGIN",
+		"ROLLBACK",
+	} {
+		_, err = c.Query(q)
+		thelper.AssertNoError(t, err)
+	}
+	thelper.AssertNoError(t, s.TakeSnapshot())
+	thelper.AssertNoError(t, s.Close())
+	return dir
+}
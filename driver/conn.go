@@ -0,0 +1,139 @@
+package driver
+
+import (
+	"database/sql/driver"
+	"io"
+
+	"github.com/mrasu/ddb/server"
+	"github.com/mrasu/ddb/server/structs"
+	"github.com/pkg/errors"
+)
+
+type conn struct {
+	connection *server.Connection
+	closed     bool
+}
+
+func newConn(c *server.Connection) *conn {
+	return &conn{connection: c}
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	if c.closed {
+		return nil, driver.ErrBadConn
+	}
+	return &stmt{conn: c, query: query, numInput: countPlaceholders(query)}, nil
+}
+
+func (c *conn) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.connection.Close()
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	if c.connection.InTransaction() {
+		return nil, errors.New("transaction is already started")
+	}
+	_, err := c.connection.Query("BEGIN")
+	if err != nil {
+		return nil, err
+	}
+	return &tx{conn: c}, nil
+}
+
+func (c *conn) query(query string, args []driver.Value) (*structs.Result, error) {
+	if c.closed {
+		return nil, driver.ErrBadConn
+	}
+	sql, err := interpolate(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return c.connection.Query(sql)
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (t *tx) Commit() error {
+	_, err := t.conn.connection.Query("COMMIT")
+	return err
+}
+
+func (t *tx) Rollback() error {
+	_, err := t.conn.connection.Query("ROLLBACK")
+	return err
+}
+
+type stmt struct {
+	conn     *conn
+	query    string
+	numInput int
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return s.numInput
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	res, err := s.conn.query(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &result{affectedRows: res.AffectedRows, insertId: res.InsertId}, nil
+}
+
+type result struct {
+	affectedRows int64
+	insertId     int64
+}
+
+func (r *result) LastInsertId() (int64, error) {
+	return r.insertId, nil
+}
+
+func (r *result) RowsAffected() (int64, error) {
+	return r.affectedRows, nil
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	res, err := s.conn.query(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{result: res}, nil
+}
+
+type rows struct {
+	result *structs.Result
+	pos    int
+}
+
+func (r *rows) Columns() []string {
+	return r.result.Columns
+}
+
+func (r *rows) Close() error {
+	r.pos = len(r.result.Values)
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.result.Values) {
+		return io.EOF
+	}
+
+	for i, v := range r.result.Values[r.pos] {
+		dest[i] = v
+	}
+	r.pos++
+	return nil
+}
@@ -0,0 +1,85 @@
+// Package driver is a database/sql driver for ddb.
+//
+// The DSN is one of
+//
+//	inproc:<name>  a server.Server registered by RegisterServer
+//	file:<dir>     a server.Server storing its data under dir, recovered on first use
+//
+//	sql.Open("ddb", "file:./log")
+package driver
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"sync"
+
+	"github.com/mrasu/ddb/server"
+	"github.com/pkg/errors"
+)
+
+const (
+	inprocPrefix = "inproc:"
+	filePrefix   = "file:"
+)
+
+func init() {
+	sql.Register("ddb", &Driver{})
+}
+
+var servers = map[string]*server.Server{}
+var mu sync.Mutex
+
+// RegisterServer makes s reachable with the DSN "inproc:<name>".
+func RegisterServer(name string, s *server.Server) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	servers[inprocPrefix+name] = s
+}
+
+// UnregisterServer removes the server registered by RegisterServer.
+func UnregisterServer(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(servers, inprocPrefix+name)
+}
+
+type Driver struct{}
+
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	s, err := findServer(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(s.StartNewConnection()), nil
+}
+
+func findServer(dsn string) (*server.Server, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if s, ok := servers[dsn]; ok {
+		return s, nil
+	}
+
+	switch {
+	case strings.HasPrefix(dsn, inprocPrefix):
+		return nil, errors.Errorf("server is not registered: %s", dsn)
+	case strings.HasPrefix(dsn, filePrefix):
+		// Only one Server can own a directory because they share the WAL.
+		s, err := server.NewServerAt(strings.TrimPrefix(dsn, filePrefix))
+		if err != nil {
+			return nil, err
+		}
+		err = s.Recover()
+		if err != nil {
+			return nil, err
+		}
+		servers[dsn] = s
+		return s, nil
+	default:
+		return nil, errors.Errorf("invalid dsn: %s", dsn)
+	}
+}
@@ -0,0 +1,135 @@
+package driver
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"os"
+	"testing"
+
+	"github.com/mrasu/ddb/server"
+	"github.com/mrasu/ddb/server/wal"
+	"github.com/mrasu/ddb/thelper"
+	"github.com/rs/zerolog"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestDriver_ExecAndQuery(t *testing.T) {
+	db := openTestDB(t, "exec_and_query")
+	defer db.Close()
+
+	res, err := db.Exec("INSERT INTO hello.world(message) VALUES (?), (?)", "foo", "it's")
+	thelper.AssertNoError(t, err)
+	affected, err := res.RowsAffected()
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt64(t, "Invalid affected rows", 2, affected)
+	id, err := res.LastInsertId()
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt64(t, "Invalid insert id", 1, id)
+
+	assertMessages(t, db, []string{"foo", "it's"})
+
+	var message string
+	err = db.QueryRow("SELECT message FROM hello.world WHERE id = ?", 2).Scan(&message)
+	thelper.AssertNoError(t, err)
+	thelper.AssertString(t, "Invalid value", "it's", message)
+}
+
+func TestDriver_Commit(t *testing.T) {
+	db := openTestDB(t, "commit")
+	defer db.Close()
+
+	tx, err := db.Begin()
+	thelper.AssertNoError(t, err)
+	_, err = tx.Exec("INSERT INTO hello.world(message) VALUES ('foo')")
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, tx.Commit())
+
+	assertMessages(t, db, []string{"foo"})
+}
+
+func TestDriver_Rollback(t *testing.T) {
+	db := openTestDB(t, "rollback")
+	defer db.Close()
+
+	_, err := db.Exec("INSERT INTO hello.world(message) VALUES ('foo')")
+	thelper.AssertNoError(t, err)
+
+	tx, err := db.Begin()
+	thelper.AssertNoError(t, err)
+	_, err = tx.Exec("INSERT INTO hello.world(message) VALUES ('bar')")
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, tx.Rollback())
+
+	assertMessages(t, db, []string{"foo"})
+}
+
+func TestDriver_Open_NotRegistered(t *testing.T) {
+	db, err := sql.Open("ddb", "inproc:nothing")
+	thelper.AssertNoError(t, err)
+	defer db.Close()
+
+	if db.Ping() == nil {
+		t.Error("No error for not registered server")
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	sql, err := interpolate(
+		"SELECT * FROM a WHERE b = ? AND c = '?' AND d = ?",
+		[]driver.Value{int64(1), "x'\\y"},
+	)
+	thelper.AssertNoError(t, err)
+	thelper.AssertString(t, "Invalid interpolation", `SELECT * FROM a WHERE b = 1 AND c = '?' AND d = 'x\'\\y'`, sql)
+
+	_, err = interpolate("SELECT ?", []driver.Value{int64(1), int64(2)})
+	if err == nil {
+		t.Error("No error for the wrong number of arguments")
+	}
+}
+
+func openTestDB(t *testing.T, name string) *sql.DB {
+	s, err := server.NewTestServer(&wal.Memory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterServer(name, s)
+
+	db, err := sql.Open("ddb", "inproc:"+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("CREATE DATABASE hello")
+	thelper.AssertNoError(t, err)
+	_, err = db.Exec("CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	thelper.AssertNoError(t, err)
+	return db
+}
+
+func assertMessages(t *testing.T, db *sql.DB, eMessages []string) {
+	t.Helper()
+	rows, err := db.Query("SELECT id, message FROM hello.world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var messages []string
+	for rows.Next() {
+		var id int
+		var message string
+		thelper.AssertNoError(t, rows.Scan(&id, &message))
+		thelper.AssertInt(t, "Invalid id", len(messages)+1, id)
+		messages = append(messages, message)
+	}
+	thelper.AssertNoError(t, rows.Err())
+
+	thelper.AssertInt(t, "Invalid row size", len(eMessages), len(messages))
+	for i, m := range messages {
+		thelper.AssertString(t, "Invalid message", eMessages[i], m)
+	}
+}
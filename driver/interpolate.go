@@ -0,0 +1,104 @@
+package driver
+
+import (
+	"database/sql/driver"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Connection.Query only accepts a complete SQL, so placeholders are replaced with literals before running.
+
+func countPlaceholders(query string) int {
+	count := 0
+	scanPlaceholders(query, func(_ int) { count++ })
+	return count
+}
+
+func interpolate(query string, args []driver.Value) (string, error) {
+	if len(args) == 0 {
+		return query, nil
+	}
+
+	var positions []int
+	scanPlaceholders(query, func(pos int) { positions = append(positions, pos) })
+	if len(positions) != len(args) {
+		return "", errors.Errorf("invalid number of arguments. expected: %d, actual: %d", len(positions), len(args))
+	}
+
+	var sb strings.Builder
+	last := 0
+	for i, pos := range positions {
+		sb.WriteString(query[last:pos])
+		literal, err := toLiteral(args[i])
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(literal)
+		last = pos + 1
+	}
+	sb.WriteString(query[last:])
+	return sb.String(), nil
+}
+
+// scanPlaceholders calls fn with the position of every `?` not in quotes.
+func scanPlaceholders(query string, fn func(int)) {
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+		case '?':
+			fn(i)
+		}
+	}
+}
+
+func toLiteral(v driver.Value) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return "NULL", nil
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64), nil
+	case bool:
+		if val {
+			return "1", nil
+		}
+		return "0", nil
+	case []byte:
+		return quote(string(val)), nil
+	case string:
+		return quote(val), nil
+	case time.Time:
+		return quote(val.Format("2006-01-02 15:04:05.999999")), nil
+	default:
+		return "", errors.Errorf("not supported argument type: %T", v)
+	}
+}
+
+var quoteReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`'`, `\'`,
+	"\x00", `\0`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\x1a", `\Z`,
+)
+
+func quote(s string) string {
+	return "'" + quoteReplacer.Replace(s) + "'"
+}
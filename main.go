@@ -2,15 +2,14 @@ package main
 
 import (
 	"fmt"
-	"strconv"
+	"os"
 	"sync"
 	"time"
 
-	"github.com/mrasu/ddb/server/pbs"
-
 	"github.com/coreos/etcd/raft/raftpb"
 
 	"github.com/mrasu/ddb/server"
+	"github.com/mrasu/ddb/server/transport"
 	"github.com/rs/zerolog"
 )
 
@@ -19,6 +18,19 @@ func main() {
 	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
 	// zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
 
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "wal" {
+		walCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+		snapshotCommand(os.Args[2:])
+		return
+	}
+
 	s, err := server.NewServer()
 	if err != nil {
 		die(err)
@@ -28,9 +40,15 @@ func main() {
 		die(err)
 	}
 
-	rs := server.StartRaftServer(s, 1)
+	rs, err := server.StartRaftServer(s, &server.RaftConfig{
+		ID:        1,
+		Transport: transport.DefaultMemoryNetwork.NewTransport("raft1"),
+	})
+	if err != nil {
+		die(err)
+	}
 	time.Sleep(1 * time.Second)
-	rs2 := runAndJoin(s2, 2, -1)
+	rs2 := runAndJoin(s2, 2, rs.Address())
 	time.Sleep(1 * time.Second)
 
 	smokeRaft(rs)
@@ -39,41 +57,38 @@ func main() {
 	rs2.InspectServer()
 }
 
-func runAndJoin(s *server.Server, id uint64, parentGlobalRaftId int) *server.RaftServer {
-	rs := server.StartRaftServer(s, id)
+func runAndJoin(s *server.Server, id uint64, parentAddress string) *server.RaftServer {
+	tr := transport.DefaultMemoryNetwork.NewTransport(fmt.Sprintf("raft%d", id))
+	rs, err := server.StartRaftServer(s, &server.RaftConfig{ID: id, Transport: tr, Join: true})
+	if err != nil {
+		die(err)
+	}
 	cc := &raftpb.ConfChange{
-		NodeID: id,
-		Type:   raftpb.ConfChangeAddNode,
-		// TODO: gRPC
-		Context: []byte(strconv.Itoa(int(id) * -1)),
+		NodeID:  id,
+		Type:    raftpb.ConfChangeAddNode,
+		Context: []byte(tr.Address()),
 	}
 	time.Sleep(500 * time.Millisecond)
-	rs.AskJoin(parentGlobalRaftId, cc)
+	err = rs.AskJoin(parentAddress, cc)
+	if err != nil {
+		die(err)
+	}
 
 	return rs
 }
 
 func smokeRaft(rs *server.RaftServer) {
-	cs := &pbs.ChangeSet{
-		Lsn:  1,
-		Data: &pbs.ChangeSet_CreateDB{CreateDB: &pbs.CreateDBChangeSet{Name: "hello"}},
-	}
-	err := rs.Propose(cs)
-	if err != nil {
-		fmt.Printf("ERROR: %+v\n", err)
+	c := rs.StartNewConnection()
+	queries := []string{
+		"CREATE DATABASE hello",
+		"CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))",
+		"INSERT INTO hello.world(message) VALUES ('foo'), ('bar')",
 	}
-
-	cs = &pbs.ChangeSet{
-		Lsn: 1,
-		Data: &pbs.ChangeSet_CreateTable{CreateTable: &pbs.CreateTableChangeSet{
-			DBName:   "hello",
-			Name:     "world",
-			RowMetas: nil,
-		}},
-	}
-	err = rs.Propose(cs)
-	if err != nil {
-		fmt.Printf("ERROR: %+v\n", err)
+	for _, q := range queries {
+		_, err := c.Query(q)
+		if err != nil {
+			fmt.Printf("ERROR: %+v\n", err)
+		}
 	}
 }
 
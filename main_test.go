@@ -20,7 +20,7 @@ func TestMain(m *testing.M) {
 }
 
 func TestSmoke(t *testing.T) {
-	s, err := server.NewServer()
+	s, err := server.NewServerAt(t.TempDir())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -52,7 +52,8 @@ func TestSmoke(t *testing.T) {
 		{"id": "1", "message": "foo == x0 x1"},
 		{"id": "2", "message": "bar bar y0 y1"},
 		{"id": "3", "message": "baz z0 z1"},
-		{"id": "4", "message": "real"},
+		// Values given to rolled back rows are not reused
+		{"id": "7", "message": "real"},
 	}
 	res, _ := c.Query("SELECT * FROM hello.world")
 	data.AssertResult(t, res, eRowValues)
@@ -156,7 +157,7 @@ WHERE
 }
 
 func createDefault(t *testing.T) *server.Server {
-	s, err := server.NewServer()
+	s, err := server.NewServerAt(t.TempDir())
 	if err != nil {
 		t.Fatal(err)
 	}
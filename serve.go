@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/mrasu/ddb/server"
+	"github.com/mrasu/ddb/server/cdc"
+	"github.com/mrasu/ddb/server/mysql"
+	"github.com/mrasu/ddb/server/wal"
+)
+
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("mysql", "127.0.0.1:3306", "address to accept MySQL clients")
+	fsync := fs.String("fsync", "always", "when the WAL is flushed: always, never or an interval like 100ms")
+	snapshotEvery := fs.Int64("snapshot-every", 0, "take a snapshot every N WAL records. 0 disables it")
+	cdcAddr := fs.String("cdc", "", "address to stream committed changes over HTTP. empty disables it")
+	_ = fs.Parse(args)
+
+	policy, err := wal.ParseSyncPolicy(*fsync)
+	if err != nil {
+		die(err)
+	}
+	s, err := server.NewServer()
+	if err != nil {
+		die(err)
+	}
+	err = s.SetWalSyncPolicy(policy)
+	if err != nil {
+		die(err)
+	}
+	err = s.Recover()
+	if err != nil {
+		die(err)
+	}
+	if *snapshotEvery > 0 {
+		err = s.StartSnapshotScheduler(*snapshotEvery)
+		if err != nil {
+			die(err)
+		}
+	}
+
+	if *cdcAddr != "" {
+		cl, err := cdc.Listen(s, *cdcAddr)
+		if err != nil {
+			die(err)
+		}
+		fmt.Printf("Streaming changes on http://%s/changes\n", cl.Addr())
+		go func() {
+			err := cl.Serve()
+			if err != nil {
+				die(err)
+			}
+		}()
+	}
+
+	l, err := mysql.Listen(s, *addr)
+	if err != nil {
+		die(err)
+	}
+	fmt.Printf("Listening MySQL protocol on %s\n", l.Addr())
+	err = l.Serve()
+	if err != nil {
+		die(err)
+	}
+}
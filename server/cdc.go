@@ -0,0 +1,409 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mrasu/ddb/server/data"
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/pkg/errors"
+)
+
+// Types of ChangeEvent
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+	// ChangeTruncate removes every row of the table committed before it. Rows of transactions in progress are streamed when they are committed.
+	ChangeTruncate = "truncate"
+	// ChangeDropTable removes the table and its rows
+	ChangeDropTable = "drop_table"
+	// ChangeDropDatabase removes the database and rows of its tables. Table is empty for it.
+	ChangeDropDatabase = "drop_database"
+)
+
+// ChangeEvent is a row changed by a committed transaction, or rows removed by TRUNCATE, DROP TABLE or DROP DATABASE.
+type ChangeEvent struct {
+	// Lsn is the LSN of the COMMIT, which is shared by the changes of the transaction.
+	// A change outside transactions has the LSN of itself.
+	Lsn         int64 `json:"lsn"`
+	Transaction int64 `json:"trx"`
+	// Timestamp is Unix time in nanoseconds when the transaction is committed, or 0 outside transactions
+	Timestamp int64  `json:"timestamp"`
+	Type      string `json:"type"`
+	Database  string `json:"db"`
+	Table     string `json:"table"`
+	// Before is nil for inserts, updates written without before images and removals of whole tables
+	Before map[string]string `json:"before,omitempty"`
+	// After is nil for deletes and removals of whole tables
+	After map[string]string `json:"after,omitempty"`
+}
+
+const subscriptionBufferSize = 64
+
+// subscriptionQueueSize is the number of ChangeSets queued to a Subscription.
+// A Subscription reads the WAL again for ChangeSets dropped when it is too slow to keep the queue.
+const subscriptionQueueSize = 1024
+
+// Subscription streams ChangeEvents of transactions committed at or after the LSN given to Subscribe.
+// Events are sent in the order of the WAL, and a consumer resumes by subscribing from the next LSN of the last transaction it handled.
+type Subscription struct {
+	s       *Server
+	from    int64
+	next    int64
+	pending map[int64][]*pbs.ChangeSet
+
+	// queueMu guards queue
+	queueMu sync.Mutex
+	// queue holds ChangeSets written after the last poll in the order of the WAL
+	queue []*pbs.ChangeSet
+	// walReads is the number of times the WAL is read, which is accessed atomically
+	walReads int64
+
+	eventc  chan *ChangeEvent
+	notifyc chan struct{}
+	stopc   chan struct{}
+	donec   chan struct{}
+	err     error
+	closeMu sync.Once
+}
+
+// Subscribe starts a Subscription from the LSN.
+// It fails when the WAL at the LSN is already removed by TakeSnapshot,
+// or when the Server is replicated by raft, whose entries are applied without being written to the WAL.
+func (s *Server) Subscribe(from int64) (*Subscription, error) {
+	if s.raft != nil {
+		return nil, errors.New("Subscription is not supported by Server replicated by raft")
+	}
+	oldest := s.wal.OldestLsn()
+	if from < oldest {
+		return nil, errors.Errorf("WAL before LSN %d is already removed: %d", oldest, from)
+	}
+
+	sub := &Subscription{
+		s:    s,
+		from: from,
+		// Transactions committed after from may have begun before it.
+		next:    oldest,
+		pending: map[int64][]*pbs.ChangeSet{},
+
+		eventc:  make(chan *ChangeEvent, subscriptionBufferSize),
+		notifyc: make(chan struct{}, 1),
+		stopc:   make(chan struct{}),
+		donec:   make(chan struct{}),
+	}
+
+	s.subscriptionMu.Lock()
+	if s.subscriptions == nil {
+		s.subscriptions = map[*Subscription]bool{}
+	}
+	s.subscriptions[sub] = true
+	s.subscriptionMu.Unlock()
+
+	go sub.run()
+	return sub, nil
+}
+
+// CurrentLsn returns the LSN which the next ChangeSet gets.
+func (s *Server) CurrentLsn() int64 {
+	return s.wal.CurrentLsn()
+}
+
+// queueToSubscriptions passes cs written to the WAL to subscriptions so that they don't read it again.
+// It must be called with stateMu locked to queue ChangeSets in the order of the WAL.
+func (s *Server) queueToSubscriptions(cs *pbs.ChangeSet) {
+	s.subscriptionMu.Lock()
+	defer s.subscriptionMu.Unlock()
+
+	for sub := range s.subscriptions {
+		sub.enqueue(cs)
+	}
+}
+
+func (s *Server) hasSubscriptions() bool {
+	s.subscriptionMu.Lock()
+	defer s.subscriptionMu.Unlock()
+
+	return len(s.subscriptions) > 0
+}
+
+func (s *Server) notifySubscriptions() {
+	s.subscriptionMu.Lock()
+	defer s.subscriptionMu.Unlock()
+
+	for sub := range s.subscriptions {
+		select {
+		case sub.notifyc <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *Server) closeSubscriptions() {
+	s.subscriptionMu.Lock()
+	var subs []*Subscription
+	for sub := range s.subscriptions {
+		subs = append(subs, sub)
+	}
+	s.subscriptionMu.Unlock()
+
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
+
+// Events returns the channel of ChangeEvents, which is closed when the Subscription stops.
+func (sub *Subscription) Events() <-chan *ChangeEvent {
+	return sub.eventc
+}
+
+// Err returns the error stopping the Subscription after Events is closed.
+func (sub *Subscription) Err() error {
+	select {
+	case <-sub.donec:
+		return sub.err
+	default:
+		return nil
+	}
+}
+
+// Close stops the Subscription and waits for it.
+func (sub *Subscription) Close() {
+	sub.closeMu.Do(func() {
+		close(sub.stopc)
+	})
+	<-sub.donec
+
+	sub.s.subscriptionMu.Lock()
+	delete(sub.s.subscriptions, sub)
+	sub.s.subscriptionMu.Unlock()
+}
+
+func (sub *Subscription) run() {
+	// donec is closed first so that Err is set when Events is closed.
+	defer close(sub.eventc)
+	defer close(sub.donec)
+
+	for {
+		stopped, err := sub.poll()
+		if err != nil {
+			sub.err = err
+			return
+		}
+		if stopped {
+			return
+		}
+
+		select {
+		case <-sub.notifyc:
+		case <-sub.stopc:
+			return
+		}
+	}
+}
+
+func (sub *Subscription) enqueue(cs *pbs.ChangeSet) {
+	sub.queueMu.Lock()
+	defer sub.queueMu.Unlock()
+
+	if len(sub.queue) >= subscriptionQueueSize {
+		// The dropped ChangeSets are read from the WAL
+		sub.queue = nil
+	}
+	sub.queue = append(sub.queue, cs)
+}
+
+func (sub *Subscription) takeQueue() []*pbs.ChangeSet {
+	sub.queueMu.Lock()
+	defer sub.queueMu.Unlock()
+
+	css := sub.queue
+	sub.queue = nil
+	return css
+}
+
+// poll sends events of transactions committed in records appended after the last poll.
+// Records are taken from the queue, and the WAL is read only when the queue misses some, e.g. ones written before Subscribe.
+func (sub *Subscription) poll() (bool, error) {
+	queued := sub.takeQueue()
+	if !sub.follows(queued) {
+		css, err := sub.readWal()
+		if err != nil {
+			return false, err
+		}
+		if sub.send(css) {
+			return true, nil
+		}
+	}
+	return sub.send(queued), nil
+}
+
+// follows tells whether css have every record from the next LSN to the current one.
+func (sub *Subscription) follows(css []*pbs.ChangeSet) bool {
+	if len(css) == 0 {
+		return sub.next >= sub.s.wal.CurrentLsn()
+	}
+	next := sub.next
+	for _, cs := range css {
+		if cs.Lsn > next {
+			return false
+		}
+		if cs.Lsn == next {
+			next++
+		}
+	}
+	return true
+}
+
+// readWal reads records from the next LSN. The WAL holds only ChangeSets which are applied, like the queue.
+func (sub *Subscription) readWal() ([]*pbs.ChangeSet, error) {
+	atomic.AddInt64(&sub.walReads, 1)
+	css, err := sub.s.wal.ReadFrom(sub.next)
+	if err != nil {
+		return nil, err
+	}
+	if oldest := sub.s.wal.OldestLsn(); sub.next < oldest {
+		return nil, errors.Errorf("WAL before LSN %d is removed before it is read: %d", oldest, sub.next)
+	}
+	return css, nil
+}
+
+// send sends events of css which are not sent yet. It returns true when the Subscription is stopped.
+func (sub *Subscription) send(css []*pbs.ChangeSet) bool {
+	for _, cs := range css {
+		if cs.Lsn < sub.next {
+			continue
+		}
+		sub.next = cs.Lsn + 1
+
+		for _, e := range sub.decode(cs) {
+			if e.Lsn < sub.from {
+				continue
+			}
+			select {
+			case sub.eventc <- e:
+			case <-sub.stopc:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decode returns events made by cs. Changes of transactions are returned when they are committed.
+func (sub *Subscription) decode(cs *pbs.ChangeSet) []*ChangeEvent {
+	num, ok := transactionNumber(cs)
+	if !ok {
+		return changeEvents(cs, cs.Lsn, data.ImmediateTransactionNumber, 0)
+	}
+
+	switch c := cs.Data.(type) {
+	case *pbs.ChangeSet_Begin:
+		sub.pending[num] = []*pbs.ChangeSet{}
+	case *pbs.ChangeSet_Commit:
+		var events []*ChangeEvent
+		for _, pcs := range sub.pending[num] {
+			events = append(events, changeEvents(pcs, cs.Lsn, num, c.Commit.Timestamp)...)
+		}
+		delete(sub.pending, num)
+		return events
+	case *pbs.ChangeSet_Rollback, *pbs.ChangeSet_Abort:
+		delete(sub.pending, num)
+	default:
+		// Changes of transactions begun before the read WAL are committed before from.
+		if css, ok := sub.pending[num]; ok {
+			sub.pending[num] = append(css, cs)
+		}
+	}
+	return nil
+}
+
+// changeEvents converts row changes and removals of tables in cs to ChangeEvents committed at lsn.
+func changeEvents(cs *pbs.ChangeSet, lsn, num, timestamp int64) []*ChangeEvent {
+	var events []*ChangeEvent
+	switch c := cs.Data.(type) {
+	case *pbs.ChangeSet_InsertSets:
+		for _, row := range c.InsertSets.Rows {
+			events = append(events, &ChangeEvent{
+				Lsn:         lsn,
+				Transaction: num,
+				Timestamp:   timestamp,
+				Type:        ChangeInsert,
+				Database:    c.InsertSets.DBName,
+				Table:       c.InsertSets.TableName,
+				After:       row.Columns,
+			})
+		}
+	case *pbs.ChangeSet_UpdateSets:
+		for _, row := range c.UpdateSets.Rows {
+			var before map[string]string
+			after := map[string]string{}
+			if len(row.Before) > 0 {
+				before = row.Before
+				for k, v := range row.Before {
+					after[k] = v
+				}
+			} else {
+				// Records written by older versions have only the id
+				after[data.PrimaryKeyName] = strconv.FormatInt(row.PrimaryKeyId, 10)
+			}
+			for k, v := range row.Columns {
+				after[k] = v
+			}
+			events = append(events, &ChangeEvent{
+				Lsn:         lsn,
+				Transaction: num,
+				Timestamp:   timestamp,
+				Type:        ChangeUpdate,
+				Database:    c.UpdateSets.DBName,
+				Table:       c.UpdateSets.TableName,
+				Before:      before,
+				After:       after,
+			})
+		}
+	case *pbs.ChangeSet_DeleteSets:
+		for _, row := range c.DeleteSets.Rows {
+			before := row.Before
+			if len(before) == 0 {
+				before = map[string]string{data.PrimaryKeyName: strconv.FormatInt(row.PrimaryKeyId, 10)}
+			}
+			events = append(events, &ChangeEvent{
+				Lsn:         lsn,
+				Transaction: num,
+				Timestamp:   timestamp,
+				Type:        ChangeDelete,
+				Database:    c.DeleteSets.DBName,
+				Table:       c.DeleteSets.TableName,
+				Before:      before,
+			})
+		}
+	case *pbs.ChangeSet_TruncateTable:
+		events = append(events, &ChangeEvent{
+			Lsn:         lsn,
+			Transaction: num,
+			Timestamp:   timestamp,
+			Type:        ChangeTruncate,
+			Database:    c.TruncateTable.DBName,
+			Table:       c.TruncateTable.Name,
+		})
+	case *pbs.ChangeSet_DropTable:
+		events = append(events, &ChangeEvent{
+			Lsn:         lsn,
+			Transaction: num,
+			Timestamp:   timestamp,
+			Type:        ChangeDropTable,
+			Database:    c.DropTable.DBName,
+			Table:       c.DropTable.Name,
+		})
+	case *pbs.ChangeSet_DropDB:
+		events = append(events, &ChangeEvent{
+			Lsn:         lsn,
+			Transaction: num,
+			Timestamp:   timestamp,
+			Type:        ChangeDropDatabase,
+			Database:    c.DropDB.Name,
+		})
+	}
+	return events
+}
@@ -0,0 +1,103 @@
+package cdc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/mrasu/ddb/server"
+	"github.com/rs/zerolog/log"
+)
+
+// Listener streams committed changes over HTTP.
+// `GET /changes?from=<lsn>` responds line-delimited JSON of server.ChangeEvent until the client disconnects.
+// Without `from`, only changes committed after the request are streamed.
+type Listener struct {
+	server     *server.Server
+	listener   net.Listener
+	httpServer *http.Server
+}
+
+func Listen(s *server.Server, address string) (*Listener, error) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	cl := &Listener{
+		server:   s,
+		listener: l,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/changes", cl.serveChanges)
+	cl.httpServer = &http.Server{Handler: mux}
+	return cl, nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Serve blocks until the Listener is closed.
+func (l *Listener) Serve() error {
+	err := l.httpServer.Serve(l.listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Close stops accepting clients and disconnects the connected ones.
+func (l *Listener) Close() error {
+	return l.httpServer.Close()
+}
+
+func (l *Listener) serveChanges(w http.ResponseWriter, r *http.Request) {
+	from := l.server.CurrentLsn()
+	if v := r.URL.Query().Get("from"); v != "" {
+		lsn, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid from: %s", v), http.StatusBadRequest)
+			return
+		}
+		from = lsn
+	}
+
+	sub, err := l.server.Subscribe(from)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				if err := sub.Err(); err != nil {
+					log.Error().Err(err).Msg("change stream is stopped by error")
+				}
+				return
+			}
+			err := enc.Encode(e)
+			if err != nil {
+				return
+			}
+			if flusher != nil && len(sub.Events()) == 0 {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
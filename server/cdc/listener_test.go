@@ -0,0 +1,89 @@
+package cdc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/mrasu/ddb/server"
+	"github.com/mrasu/ddb/thelper"
+	"github.com/rs/zerolog"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestListener_Changes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cdc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := server.NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	defer s.Close()
+	l, err := Listen(s, "127.0.0.1:0")
+	thelper.AssertNoError(t, err)
+	defer l.Close()
+	go func() { _ = l.Serve() }()
+
+	c := s.StartNewConnection()
+	for _, q := range []string{
+		"CREATE DATABASE hello",
+		"CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))",
+		"INSERT INTO hello.world(message) VALUES ('foo')",
+		"UPDATE hello.world SET message = 'bar'",
+	} {
+		_, err = c.Query(q)
+		thelper.AssertNoError(t, err)
+	}
+
+	res, err := http.Get(fmt.Sprintf("http://%s/changes?from=0", l.Addr()))
+	thelper.AssertNoError(t, err)
+	defer res.Body.Close()
+	thelper.AssertInt(t, "Invalid status", http.StatusOK, res.StatusCode)
+
+	scanner := bufio.NewScanner(res.Body)
+	var events []*server.ChangeEvent
+	for len(events) < 2 && scanner.Scan() {
+		e := &server.ChangeEvent{}
+		thelper.AssertNoError(t, json.Unmarshal(scanner.Bytes(), e))
+		events = append(events, e)
+	}
+	thelper.AssertInt(t, "Invalid event count", 2, len(events))
+	thelper.AssertString(t, "Invalid type", server.ChangeInsert, events[0].Type)
+	thelper.AssertString(t, "Invalid after", "foo", events[0].After["message"])
+	thelper.AssertString(t, "Invalid type", server.ChangeUpdate, events[1].Type)
+	thelper.AssertString(t, "Invalid before", "foo", events[1].Before["message"])
+	thelper.AssertString(t, "Invalid after", "bar", events[1].After["message"])
+	thelper.AssertBool(t, "Invalid order", true, events[0].Lsn < events[1].Lsn)
+}
+
+func TestListener_Changes_InvalidFrom(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cdc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := server.NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	defer s.Close()
+	l, err := Listen(s, "127.0.0.1:0")
+	thelper.AssertNoError(t, err)
+	defer l.Close()
+	go func() { _ = l.Serve() }()
+
+	res, err := http.Get(fmt.Sprintf("http://%s/changes?from=abc", l.Addr()))
+	thelper.AssertNoError(t, err)
+	res.Body.Close()
+	thelper.AssertInt(t, "Invalid status", http.StatusBadRequest, res.StatusCode)
+}
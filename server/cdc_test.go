@@ -0,0 +1,271 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mrasu/ddb/server/data"
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/mrasu/ddb/server/wal"
+	"github.com/mrasu/ddb/thelper"
+)
+
+func TestServer_Subscribe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	defer s.Close()
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+
+	sub, err := s.Subscribe(s.CurrentLsn())
+	thelper.AssertNoError(t, err)
+	defer sub.Close()
+
+	exec(t, c, "BEGIN")
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('foo')")
+	exec(t, c, "COMMIT")
+	exec(t, c, "BEGIN")
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('bar')")
+	exec(t, c, "ROLLBACK")
+	exec(t, c, "UPDATE hello.world SET message = 'baz'")
+	exec(t, c, "DELETE FROM hello.world")
+
+	e := receiveEvent(t, sub)
+	thelper.AssertString(t, "Invalid type", ChangeInsert, e.Type)
+	thelper.AssertString(t, "Invalid table", "hello.world", e.Database+"."+e.Table)
+	thelper.AssertString(t, "Invalid after", "foo", e.After["message"])
+	commitLsn := e.Lsn
+
+	// Rolled back insert is skipped
+	e = receiveEvent(t, sub)
+	thelper.AssertString(t, "Invalid type", ChangeUpdate, e.Type)
+	thelper.AssertString(t, "Invalid before", "foo", e.Before["message"])
+	thelper.AssertString(t, "Invalid after", "baz", e.After["message"])
+	thelper.AssertString(t, "Invalid after id", "1", e.After["id"])
+
+	e = receiveEvent(t, sub)
+	thelper.AssertString(t, "Invalid type", ChangeDelete, e.Type)
+	thelper.AssertString(t, "Invalid before", "baz", e.Before["message"])
+	thelper.AssertInt(t, "Invalid after", 0, len(e.After))
+
+	// Resuming after the transaction skips it
+	resumed, err := s.Subscribe(commitLsn + 1)
+	thelper.AssertNoError(t, err)
+	defer resumed.Close()
+	e = receiveEvent(t, resumed)
+	thelper.AssertString(t, "Invalid type", ChangeUpdate, e.Type)
+}
+
+func TestServer_Subscribe_RemovedTables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	defer s.Close()
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	exec(t, c, "CREATE TABLE hello.other(id int AUTO_INCREMENT, message varchar(10))")
+
+	sub, err := s.Subscribe(s.CurrentLsn())
+	thelper.AssertNoError(t, err)
+	defer sub.Close()
+
+	exec(t, c, "TRUNCATE TABLE hello.world")
+	exec(t, c, "DROP TABLE hello.world")
+	exec(t, c, "DROP DATABASE hello")
+
+	for _, expected := range []*ChangeEvent{
+		{Type: ChangeTruncate, Database: "hello", Table: "world"},
+		{Type: ChangeDropTable, Database: "hello", Table: "world"},
+		{Type: ChangeDropDatabase, Database: "hello"},
+	} {
+		e := receiveEvent(t, sub)
+		thelper.AssertString(t, "Invalid type", expected.Type, e.Type)
+		thelper.AssertString(t, "Invalid table", expected.Database+"."+expected.Table, e.Database+"."+e.Table)
+		thelper.AssertInt(t, "Invalid before", 0, len(e.Before))
+	}
+}
+
+func TestServer_Subscribe_WaitsCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	defer s.Close()
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	exec(t, c, "BEGIN")
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('foo')")
+
+	// The transaction began before the LSN is streamed because it is committed after it.
+	sub, err := s.Subscribe(s.CurrentLsn())
+	thelper.AssertNoError(t, err)
+	defer sub.Close()
+
+	select {
+	case e := <-sub.Events():
+		t.Fatalf("Uncommitted change is streamed: %v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	exec(t, c, "COMMIT")
+	e := receiveEvent(t, sub)
+	thelper.AssertString(t, "Invalid after", "foo", e.After["message"])
+	thelper.AssertBool(t, "Commit time is not set", true, e.Timestamp > 0)
+}
+
+func TestServer_Subscribe_ReadsQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	defer s.Close()
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+
+	sub, err := s.Subscribe(s.CurrentLsn())
+	thelper.AssertNoError(t, err)
+	defer sub.Close()
+	for i := 0; i < 20; i++ {
+		exec(t, c, fmt.Sprintf("INSERT INTO hello.world(message) VALUES ('%d')", i))
+		e := receiveEvent(t, sub)
+		thelper.AssertString(t, "Invalid after", strconv.Itoa(i), e.After["message"])
+	}
+	// Only the records written before Subscribe are read from the WAL
+	thelper.AssertInt64(t, "WAL is read for written records", 1, atomic.LoadInt64(&sub.walReads))
+}
+
+func TestServer_Subscribe_QueueOverflow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	defer s.Close()
+	thelper.AssertNoError(t, s.SetWalSyncPolicy(wal.SyncPolicy{Mode: wal.SyncNever}))
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+
+	sub, err := s.Subscribe(s.CurrentLsn())
+	thelper.AssertNoError(t, err)
+	defer sub.Close()
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('first')")
+	receiveEvent(t, sub)
+	reads := atomic.LoadInt64(&sub.walReads)
+
+	// Events are not received while writing. The blocked poll holds up to a queue of records, and the next queue overflows.
+	count := subscriptionQueueSize*2 + subscriptionBufferSize*2
+	for i := 0; i < count; i++ {
+		exec(t, c, fmt.Sprintf("INSERT INTO hello.world(message) VALUES ('%d')", i))
+	}
+
+	for i := 0; i < count; i++ {
+		e := receiveEvent(t, sub)
+		thelper.AssertString(t, "Invalid after", strconv.Itoa(i), e.After["message"])
+	}
+	thelper.AssertBool(t, "Dropped records are not read from the WAL", true, atomic.LoadInt64(&sub.walReads) > reads)
+}
+
+func TestServer_Subscribe_SkipsFailedChangeSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	defer s.Close()
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.world(id int, message varchar(10))")
+	from := s.CurrentLsn()
+	queued, err := s.Subscribe(from)
+	thelper.AssertNoError(t, err)
+	defer queued.Close()
+
+	// UPDATE of a row which doesn't exist fails
+	update := &pbs.ChangeSet{Data: &pbs.ChangeSet_UpdateSets{UpdateSets: &pbs.UpdateChangeSets{
+		DBName: "hello", TableName: "world", TransactionNumber: data.ImmediateTransactionNumber,
+		Rows: []*pbs.UpdateRow{{PrimaryKey: []string{"1"}, Columns: map[string]string{"message": "bar"}}},
+	}}}
+	thelper.AssertBool(t, "UPDATE is applied", true, s.ApplyChangeSet(update, true) != nil)
+	exec(t, c, "INSERT INTO hello.world(id, message) VALUES (1, 'foo')")
+
+	// Both events from the queue and from the WAL skip the failed UPDATE
+	caughtUp, err := s.Subscribe(from)
+	thelper.AssertNoError(t, err)
+	defer caughtUp.Close()
+	for _, sub := range []*Subscription{queued, caughtUp} {
+		e := receiveEvent(t, sub)
+		thelper.AssertString(t, "Invalid type", ChangeInsert, e.Type)
+		thelper.AssertString(t, "Invalid after", "foo", e.After["message"])
+	}
+}
+
+func TestServer_Subscribe_RemovedWal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	defer s.Close()
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	thelper.AssertNoError(t, s.SetSnapshotRetention(1))
+	s.wal.SetMaxSegmentSize(1)
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('foo')")
+	thelper.AssertNoError(t, s.TakeSnapshot())
+
+	_, err = s.Subscribe(0)
+	thelper.AssertBool(t, "Removed WAL is subscribed", true, err != nil)
+}
+
+func receiveEvent(t *testing.T, sub *Subscription) *ChangeEvent {
+	t.Helper()
+	select {
+	case e, ok := <-sub.Events():
+		if !ok {
+			t.Fatalf("Subscription is stopped: %v", sub.Err())
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("No event is streamed")
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/mrasu/ddb/server/pbs"
 
@@ -17,8 +18,14 @@ type Connection struct {
 
 	immediateTransaction *data.Transaction
 	currentTransaction   *data.Transaction
+
+	// staleRead lets SELECT read the local data without confirming it is the latest in the raft group.
+	staleRead bool
 }
 
+// The variable to allow stale reads by `SET ddb_stale_read = 1`
+const staleReadVariable = "ddb_stale_read"
+
 func newConnection(server *Server) *Connection {
 	immediateTransaction := data.CreateImmediateTransaction()
 	return &Connection{
@@ -32,9 +39,15 @@ func newConnection(server *Server) *Connection {
 func (c *Connection) Query(sql string) (*structs.Result, error) {
 	result := structs.NewEmptyResult()
 	stmt, err := sqlparser.ParseStrictDDL(sql)
+	if err != nil {
+		// sqlparser cannot parse some of ALTER TABLE, like `RENAME COLUMN`
+		if _, aerr := data.ParseAlterTable(sql); aerr == nil {
+			stmt, err = &sqlparser.DDL{Action: sqlparser.AlterStr}, nil
+		}
+	}
 	if err != nil {
 		log.Error().Stack().Err(err).Str("SQL", sql).Msg("Invalid sql")
-		return result, nil
+		return result, errors.Wrap(err, "invalid sql")
 	}
 	log.Debug().Str("sql", sql).Msg("")
 
@@ -62,14 +75,26 @@ func (c *Connection) Query(sql string) (*structs.Result, error) {
 		result, err = c.selectTable(t)
 	case *sqlparser.Insert:
 		c.currentTransaction.AddHistory(sql)
-		err = c.insert(t)
+		result, err = c.insert(t)
 	case *sqlparser.Update:
 		c.currentTransaction.AddHistory(sql)
-		err = c.update(t)
+		result, err = c.update(t)
+	case *sqlparser.Delete:
+		c.currentTransaction.AddHistory(sql)
+		result, err = c.delete(t)
+	case *sqlparser.Set:
+		err = c.set(t)
 	case *sqlparser.DBDDL:
+		if t.Action == sqlparser.DropStr {
+			t.IfExists = hasIfExists(sql)
+		}
 		err = c.server.runDBDDL(t)
 	case *sqlparser.DDL:
-		err = c.server.runDDL(t)
+		if t.Action == sqlparser.AlterStr {
+			err = c.server.alterTable(sql)
+		} else {
+			err = c.server.runDDL(t)
+		}
 	default:
 		err = errors.New("Not supported query")
 	}
@@ -84,7 +109,79 @@ func (c *Connection) Query(sql string) (*structs.Result, error) {
 	return result, err
 }
 
+// hasIfExists tells whether sql has `IF EXISTS`, which sqlparser drops from DROP DATABASE.
+func hasIfExists(sql string) bool {
+	tkn := sqlparser.NewStringTokenizer(sql)
+	prev := 0
+	for {
+		typ, _ := tkn.Scan()
+		if typ == 0 || typ == sqlparser.LEX_ERROR {
+			return false
+		}
+		if prev == sqlparser.IF && typ == sqlparser.EXISTS {
+			return true
+		}
+		prev = typ
+	}
+}
+
+// Close rolls back the transaction in progress.
+func (c *Connection) Close() error {
+	if !c.InTransaction() {
+		return nil
+	}
+	return c.rollback()
+}
+
+func (c *Connection) InTransaction() bool {
+	return !c.currentTransaction.IsImmediate()
+}
+
+// SetStaleRead allows SELECT to return data which is not replicated to this node yet.
+// It makes reads cheaper because the leader is not asked.
+func (c *Connection) SetStaleRead(allowed bool) {
+	c.staleRead = allowed
+}
+
+func (c *Connection) set(q *sqlparser.Set) error {
+	for _, expr := range q.Exprs {
+		name := strings.TrimPrefix(expr.Name.Lowered(), "@@session.")
+		if name != staleReadVariable {
+			return errors.Errorf("Unknown system variable: %s", expr.Name.String())
+		}
+
+		var val string
+		switch e := expr.Expr.(type) {
+		case *sqlparser.SQLVal:
+			val = string(e.Val)
+		case *sqlparser.ColName:
+			val = e.Name.String()
+		default:
+			return errors.Errorf("Invalid value for %s: %s", name, sqlparser.String(e))
+		}
+		switch strings.ToLower(val) {
+		case "1", "on", "true":
+			c.SetStaleRead(true)
+		case "0", "off", "false":
+			c.SetStaleRead(false)
+		default:
+			return errors.Errorf("Invalid value for %s: %s", name, val)
+		}
+	}
+	return nil
+}
+
 func (c *Connection) selectTable(q *sqlparser.Select) (*structs.Result, error) {
+	if c.server.raft != nil && !c.staleRead {
+		err := c.server.raft.WaitReadIndex()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.server.stateMu.RLock()
+	defer c.server.stateMu.RUnlock()
+
 	sev := &data.SelectEvaluator{}
 	joinRows, err := sev.SelectTable(c.currentTransaction, q, q.From[0], c.server.databases)
 	if err != nil {
@@ -93,66 +190,111 @@ func (c *Connection) selectTable(q *sqlparser.Select) (*structs.Result, error) {
 	return sev.ToResult(c.currentTransaction, q, joinRows), nil
 }
 
-func (c *Connection) insert(q *sqlparser.Insert) error {
-	db, ok := c.server.databases[q.Table.Qualifier.String()]
-	if !ok {
-		return errors.Errorf("Database doesn't exist: %s", q.Table.Qualifier.String())
-	}
-	cs, err := db.CreateInsertChangeSets(c.currentTransaction, q)
+func (c *Connection) insert(q *sqlparser.Insert) (*structs.Result, error) {
+	var affectedRows, insertId int64
+	err := c.server.makeAndWriteChangeSet(func() (*pbs.ChangeSet, error) {
+		db, ok := c.server.databases[q.Table.Qualifier.String()]
+		if !ok {
+			return nil, errors.Errorf("Database doesn't exist: %s", q.Table.Qualifier.String())
+		}
+		cs, err := db.CreateInsertChangeSets(c.currentTransaction, q)
+		if err != nil {
+			return nil, err
+		}
+		if len(cs.Rows) == 0 {
+			return nil, nil
+		}
+		affectedRows = int64(len(cs.Rows))
+		insertId = db.InsertIdOf(q, cs)
+		return &pbs.ChangeSet{Data: &pbs.ChangeSet_InsertSets{InsertSets: cs}}, nil
+	})
 	if err != nil {
-		return err
-	}
-	if len(cs.Rows) == 0 {
-		return nil
+		return nil, err
 	}
-	pbcs := &pbs.ChangeSet{Data: &pbs.ChangeSet_InsertSets{InsertSets: cs}}
-	return c.server.ApplyChangeSet(pbcs, true)
+	return structs.NewChangedResult(affectedRows, insertId), nil
 }
 
-func (c *Connection) update(q *sqlparser.Update) error {
+func (c *Connection) update(q *sqlparser.Update) (*structs.Result, error) {
 	if len(q.TableExprs) > 1 {
-		return errors.New("Update allow only one table")
+		return nil, errors.New("Update allow only one table")
 	}
 	expr := q.TableExprs[0]
 
-	switch e := expr.(type) {
-	case *sqlparser.AliasedTableExpr:
-		switch te := e.Expr.(type) {
-		case sqlparser.TableName:
-			dbName := te.Qualifier.String()
-			tName := te.Name.String()
-			db, ok := c.server.databases[dbName]
-			if !ok {
-				return errors.Errorf("Database doesn't exist: %s", dbName)
+	var affectedRows int64
+	err := c.server.makeAndWriteChangeSet(func() (*pbs.ChangeSet, error) {
+		switch e := expr.(type) {
+		case *sqlparser.AliasedTableExpr:
+			switch te := e.Expr.(type) {
+			case sqlparser.TableName:
+				dbName := te.Qualifier.String()
+				tName := te.Name.String()
+				db, ok := c.server.databases[dbName]
+				if !ok {
+					return nil, errors.Errorf("Database doesn't exist: %s", dbName)
+				}
+				cs, err := db.CreateUpdateChangeSets(c.currentTransaction, q, tName)
+				if err != nil || len(cs.Rows) == 0 {
+					return nil, err
+				}
+				affectedRows = int64(len(cs.Rows))
+				return &pbs.ChangeSet{Data: &pbs.ChangeSet_UpdateSets{UpdateSets: cs}}, nil
+			default:
+				return nil, errors.Errorf("Not allowed expression: %v", e)
 			}
-			return c.updateTable(q, db, tName)
 		default:
-			return errors.Errorf("Not allowed expression: %v", e)
+			return nil, errors.Errorf("Not allowed expression: %v", e)
 		}
-	default:
-		return errors.Errorf("Not allowed expression: %v", e)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return structs.NewChangedResult(affectedRows, 0), nil
 }
 
-func (c *Connection) updateTable(q *sqlparser.Update, db *data.Database, tName string) error {
-	cs, err := db.CreateUpdateChangeSets(c.currentTransaction, q, tName)
-	if err != nil {
-		return err
+func (c *Connection) delete(q *sqlparser.Delete) (*structs.Result, error) {
+	if len(q.Targets) > 0 || len(q.TableExprs) > 1 {
+		return nil, errors.New("Delete allow only one table")
 	}
-	if len(cs.Rows) == 0 {
-		return nil
+	if len(q.OrderBy) > 0 || q.Limit != nil {
+		return nil, errors.New("Delete doesn't support ORDER BY and LIMIT")
 	}
 
-	pbcs := &pbs.ChangeSet{Data: &pbs.ChangeSet_UpdateSets{UpdateSets: cs}}
-	return c.server.ApplyChangeSet(pbcs, true)
+	var affectedRows int64
+	err := c.server.makeAndWriteChangeSet(func() (*pbs.ChangeSet, error) {
+		switch e := q.TableExprs[0].(type) {
+		case *sqlparser.AliasedTableExpr:
+			switch te := e.Expr.(type) {
+			case sqlparser.TableName:
+				dbName := te.Qualifier.String()
+				db, ok := c.server.databases[dbName]
+				if !ok {
+					return nil, errors.Errorf("Database doesn't exist: %s", dbName)
+				}
+				cs, err := db.CreateDeleteChangeSets(c.currentTransaction, q, te.Name.String())
+				if err != nil || len(cs.Rows) == 0 {
+					return nil, err
+				}
+				affectedRows = int64(len(cs.Rows))
+				return &pbs.ChangeSet{Data: &pbs.ChangeSet_DeleteSets{DeleteSets: cs}}, nil
+			default:
+				return nil, errors.Errorf("Not allowed expression: %v", e)
+			}
+		default:
+			return nil, errors.Errorf("Not allowed expression: %v", e)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return structs.NewChangedResult(affectedRows, 0), nil
 }
 
 func (c *Connection) begin() error {
-	trx := data.StartNewTransaction()
+	trx := data.StartNewTransactionOf(c.server.nodeID())
 
 	cs := trx.CreateBeginChangeSet()
 	pbcs := &pbs.ChangeSet{Data: &pbs.ChangeSet_Begin{Begin: cs}}
-	err := c.server.ApplyChangeSet(pbcs, true)
+	err := c.server.writeChangeSet(pbcs)
 	if err != nil {
 		return err
 	}
@@ -165,7 +307,7 @@ func (c *Connection) rollback() error {
 	if c.currentTransaction != nil {
 		cs := c.currentTransaction.CreateRollbackChangeSet()
 		pbcs := &pbs.ChangeSet{Data: &pbs.ChangeSet_Rollback{Rollback: cs}}
-		err := c.server.ApplyChangeSet(pbcs, true)
+		err := c.server.writeChangeSet(pbcs)
 		if err != nil {
 			return err
 		}
@@ -176,9 +318,11 @@ func (c *Connection) rollback() error {
 
 func (c *Connection) commit() error {
 	if c.currentTransaction != nil {
-		cs := c.currentTransaction.CreateCommitChangeSet()
-		pbcs := &pbs.ChangeSet{Data: &pbs.ChangeSet_Commit{Commit: cs}}
-		err := c.server.ApplyChangeSet(pbcs, true)
+		// COMMIT holds values of rows read by the transaction
+		err := c.server.makeAndWriteChangeSet(func() (*pbs.ChangeSet, error) {
+			cs := c.currentTransaction.CreateCommitChangeSet()
+			return &pbs.ChangeSet{Data: &pbs.ChangeSet_Commit{Commit: cs}}, nil
+		})
 		if err != nil {
 			return err
 		}
@@ -190,7 +334,7 @@ func (c *Connection) commit() error {
 func (c *Connection) abort() error {
 	cs := c.currentTransaction.CreateAbortChangeSet()
 	pbcs := &pbs.ChangeSet{Data: &pbs.ChangeSet_Abort{Abort: cs}}
-	return c.server.ApplyChangeSet(pbcs, true)
+	return c.server.writeChangeSet(pbcs)
 }
 
 func (c *Connection) retryTransaction() error {
@@ -3,6 +3,7 @@ package server
 import (
 	"io"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/mrasu/ddb/server/data/types"
@@ -10,10 +11,12 @@ import (
 	"github.com/rs/zerolog"
 
 	"github.com/mrasu/ddb/server/data"
+	"github.com/mrasu/ddb/server/pbs"
 
 	"github.com/mrasu/ddb/server/structs"
 
 	"github.com/mrasu/ddb/server/wal"
+	"github.com/mrasu/ddb/thelper"
 )
 
 func TestMain(m *testing.M) {
@@ -77,11 +80,11 @@ func TestConnection_Query_CreateTable(t *testing.T) {
 	assertTable(t, table, "world", eMetas)
 
 	css := readWal(t, s.wal, 1)
-	tcs := css[0].(*structs.CreateTableChangeSet)
+	tcs := css[0].GetCreateTable()
 	if tcs.Name != "world" {
 		t.Errorf("Invalid table name: expected: %s, real: %s", "world", table.Name)
 	}
-	assertRowMetas(t, "world", tcs.RowMetas, eMetas)
+	assertRowMetas(t, "world", data.ToRowMetas(tcs.RowMetas), eMetas)
 }
 
 func TestConnection_Query_Begin(t *testing.T) {
@@ -97,7 +100,7 @@ func TestConnection_Query_Begin(t *testing.T) {
 	}
 
 	css := readWal(t, s.wal, 1)
-	if _, ok := css[0].(*structs.BeginChangeSet); !ok {
+	if css[0].GetBegin() == nil {
 		t.Errorf("Wal doesn't record BEGIN")
 	}
 }
@@ -122,10 +125,10 @@ func TestConnection_Query_Rollback(t *testing.T) {
 	}
 
 	css := readWal(t, s.wal, 2)
-	if _, ok := css[0].(*structs.BeginChangeSet); !ok {
+	if css[0].GetBegin() == nil {
 		t.Errorf("Wal doesn't record BEGIN")
 	}
-	if _, ok := css[1].(*structs.RollbackChangeSet); !ok {
+	if css[1].GetRollback() == nil {
 		t.Errorf("Wal doesn't record ROLLBACK")
 	}
 }
@@ -150,10 +153,10 @@ func TestConnection_Query_Commit(t *testing.T) {
 	}
 
 	css := readWal(t, s.wal, 2)
-	if _, ok := css[0].(*structs.BeginChangeSet); !ok {
+	if css[0].GetBegin() == nil {
 		t.Errorf("Wal doesn't record BEGIN")
 	}
-	if _, ok := css[1].(*structs.CommitChangeSet); !ok {
+	if css[1].GetCommit() == nil {
 		t.Errorf("Wal doesn't record COMMIT")
 	}
 }
@@ -195,31 +198,31 @@ func TestConnection_Query_Commit_AbortAndRetry(t *testing.T) {
 	}
 
 	css := readWal(t, s.wal, 9)
-	if _, ok := css[0].(*structs.BeginChangeSet); !ok {
+	if css[0].GetBegin() == nil {
 		t.Errorf("Wal doesn't record BEGIN")
 	}
-	if _, ok := css[1].(*structs.BeginChangeSet); !ok {
+	if css[1].GetBegin() == nil {
 		t.Errorf("Wal doesn't record BEGIN")
 	}
-	if _, ok := css[2].(*structs.UpdateChangeSet); !ok {
+	if css[2].GetUpdateSets() == nil {
 		t.Errorf("Wal doesn't record UPDATE")
 	}
-	if _, ok := css[3].(*structs.UpdateChangeSet); !ok {
+	if css[3].GetUpdateSets() == nil {
 		t.Errorf("Wal doesn't record UPDATE")
 	}
-	if _, ok := css[4].(*structs.CommitChangeSet); !ok {
+	if css[4].GetCommit() == nil {
 		t.Errorf("Wal doesn't record COMMIT")
 	}
-	if _, ok := css[5].(*structs.AbortChangeSet); !ok {
+	if css[5].GetAbort() == nil {
 		t.Errorf("Wal doesn't record ABORT")
 	}
-	if _, ok := css[6].(*structs.BeginChangeSet); !ok {
+	if css[6].GetBegin() == nil {
 		t.Errorf("Wal doesn't record BEGIN")
 	}
-	if _, ok := css[7].(*structs.UpdateChangeSet); !ok {
+	if css[7].GetUpdateSets() == nil {
 		t.Errorf("Wal doesn't record UPDATE")
 	}
-	if _, ok := css[8].(*structs.CommitChangeSet); !ok {
+	if css[8].GetCommit() == nil {
 		t.Errorf("Wal doesn't record COMMIT")
 	}
 }
@@ -260,6 +263,74 @@ func TestConnection_Query_Select(t *testing.T) {
 	}
 }
 
+func TestConnection_Query_Select_NoRow(t *testing.T) {
+	_, c := newDefaultConnection(t, func(_ *Connection) {})
+
+	r := exec(t, c, "SELECT * FROM hello.world")
+	thelper.AssertInt(t, "Invalid columns size", 2, len(r.Columns))
+	thelper.AssertString(t, "Invalid column", "id", r.Columns[0])
+	thelper.AssertString(t, "Invalid column", "message", r.Columns[1])
+	thelper.AssertInt(t, "Invalid values size", 0, len(r.Values))
+
+	r = exec(t, c, "SELECT message FROM hello.world WHERE id = 1")
+	thelper.AssertInt(t, "Invalid columns size", 1, len(r.Columns))
+	thelper.AssertString(t, "Invalid column", "message", r.Columns[0])
+}
+
+func TestConnection_Query_AffectedRows(t *testing.T) {
+	_, c := newDefaultConnection(t, func(_ *Connection) {})
+
+	r := exec(t, c, "INSERT INTO hello.world(message) VALUES('hello'), ('world')")
+	thelper.AssertInt64(t, "Invalid affected rows", 2, r.AffectedRows)
+	thelper.AssertInt64(t, "Invalid insert id", 1, r.InsertId)
+
+	r = exec(t, c, "INSERT INTO hello.world(id, message) VALUES(10, 'foo')")
+	thelper.AssertInt64(t, "Invalid insert id for given id", 0, r.InsertId)
+
+	r = exec(t, c, "UPDATE hello.world SET message = 'bar' WHERE id <> 1")
+	thelper.AssertInt64(t, "Invalid affected rows", 2, r.AffectedRows)
+
+	r = exec(t, c, "DELETE FROM hello.world WHERE id = 100")
+	thelper.AssertInt64(t, "Invalid affected rows", 0, r.AffectedRows)
+	r = exec(t, c, "DELETE FROM hello.world")
+	thelper.AssertInt64(t, "Invalid affected rows", 3, r.AffectedRows)
+}
+
+func TestConnection_Close(t *testing.T) {
+	s, c := newDefaultConnection(t, func(_ *Connection) {})
+
+	exec(t, c, "BEGIN")
+	exec(t, c, "INSERT INTO hello.world(message) VALUES('hello')")
+	thelper.AssertNoError(t, c.Close())
+	thelper.AssertBool(t, "Transaction is not finished", false, c.InTransaction())
+	thelper.AssertInt(t, "Transaction is left", 0, s.transactionHolder.Count())
+
+	c2 := s.StartNewConnection()
+	thelper.AssertInt(t, "Rolled back row is seen", 0, len(exec(t, c2, "SELECT * FROM hello.world").Values))
+}
+
+func TestConnection_Query_Concurrent(t *testing.T) {
+	s, _ := newDefaultConnection(t, func(_ *Connection) {})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := s.StartNewConnection()
+			for j := 0; j < 10; j++ {
+				for _, sql := range []string{"BEGIN", "INSERT INTO hello.world(message) VALUES('hello')", "COMMIT", "SELECT * FROM hello.world"} {
+					_, err := c.Query(sql)
+					thelper.AssertNoError(t, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	thelper.AssertInt(t, "Invalid row size", 100, len(exec(t, s.StartNewConnection(), "SELECT * FROM hello.world").Values))
+}
+
 func TestConnection_Query_Insert(t *testing.T) {
 	s, c := newDefaultConnection(t, func(_ *Connection) {})
 
@@ -286,12 +357,15 @@ func TestConnection_Query_Insert(t *testing.T) {
 		}
 	}
 
-	css := readWal(t, s.wal, 2)
-	for i, cs := range css {
-		ics, ok := cs.(*structs.InsertChangeSet)
-		if !ok {
-			t.Errorf("Wal doesn't record INSERT")
-		}
+	css := readWal(t, s.wal, 1)
+	ics := css[0].GetInsertSets()
+	if ics == nil {
+		t.Fatalf("Wal doesn't record INSERT")
+	}
+	if len(ics.Rows) != 2 {
+		t.Fatalf("Invalid wal: row size: %d", len(ics.Rows))
+	}
+	for i, ics := range ics.Rows {
 		if len(ics.Columns) != 2 {
 			t.Errorf("Invalid wal: column size: %d", len(ics.Columns))
 		}
@@ -350,10 +424,11 @@ func TestConnection_Query_Update(t *testing.T) {
 	}
 
 	css := readWal(t, s.wal, 1)
-	ics, ok := css[0].(*structs.UpdateChangeSet)
-	if !ok {
-		t.Errorf("Wal doesn't record UPDATE")
+	ucs := css[0].GetUpdateSets()
+	if ucs == nil || len(ucs.Rows) != 1 {
+		t.Fatalf("Wal doesn't record UPDATE")
 	}
+	ics := ucs.Rows[0]
 	if len(ics.Columns) != 1 {
 		t.Errorf("Invalid wal: column size: %d", len(ics.Columns))
 	}
@@ -380,6 +455,277 @@ func TestConnection_Query_UpdateTransactionHistory(t *testing.T) {
 	}
 }
 
+func TestConnection_Query_Delete(t *testing.T) {
+	s, c := newDefaultConnection(t, func(c *Connection) {
+		exec(t, c, "INSERT INTO hello.world(id, message) VALUES(1, 'hello'), (2, 'world')")
+	})
+
+	exec(t, c, "DELETE FROM hello.world WHERE id = 1")
+	res := exec(t, c, "SELECT * FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"id": "2", "message": "world"}})
+
+	css := readWal(t, s.wal, 1)
+	dcs := css[0].GetDeleteSets()
+	if dcs == nil || len(dcs.Rows) != 1 {
+		t.Fatalf("Wal doesn't record DELETE")
+	}
+	thelper.AssertInt64(t, "Invalid wal", 1, dcs.Rows[0].PrimaryKeyId)
+	thelper.AssertString(t, "Invalid before image", "hello", dcs.Rows[0].Before["message"])
+}
+
+func TestConnection_Query_Delete_Transaction(t *testing.T) {
+	s, c := newDefaultConnection(t, func(c *Connection) {
+		exec(t, c, "INSERT INTO hello.world(id, message) VALUES(1, 'hello'), (2, 'world')")
+	})
+	c2 := s.StartNewConnection()
+
+	exec(t, c, "BEGIN")
+	exec(t, c, "DELETE FROM hello.world")
+	thelper.AssertInt(t, "Deleted rows are seen", 0, len(exec(t, c, "SELECT * FROM hello.world").Values))
+	// Other transactions see rows until the delete is committed
+	data.AssertResult(t, exec(t, c2, "SELECT message FROM hello.world"), []map[string]string{{"message": "hello"}, {"message": "world"}})
+	exec(t, c, "ROLLBACK")
+	data.AssertResult(t, exec(t, c, "SELECT message FROM hello.world"), []map[string]string{{"message": "hello"}, {"message": "world"}})
+
+	exec(t, c, "BEGIN")
+	exec(t, c, "INSERT INTO hello.world(id, message) VALUES(3, 'foo')")
+	exec(t, c, "DELETE FROM hello.world WHERE id <> 2")
+	exec(t, c, "COMMIT")
+	data.AssertResult(t, exec(t, c2, "SELECT * FROM hello.world"), []map[string]string{{"id": "2", "message": "world"}})
+}
+
+func TestConnection_Query_Delete_Conflict(t *testing.T) {
+	s, c := newDefaultConnection(t, func(c *Connection) {
+		exec(t, c, "INSERT INTO hello.world(id, message) VALUES(1, 'hello')")
+	})
+	c2 := s.StartNewConnection()
+
+	exec(t, c, "BEGIN")
+	exec(t, c2, "BEGIN")
+	exec(t, c, "UPDATE hello.world SET message = 'foo' WHERE id = 1")
+	exec(t, c2, "DELETE FROM hello.world WHERE id = 1")
+	exec(t, c2, "COMMIT")
+	// The update conflicts with the delete and is retried without the row
+	exec(t, c, "COMMIT")
+
+	rows := data.CopyRows(data.CopyTables(s.databases["hello"])[0])
+	thelper.AssertInt(t, "Deleted row remains", 0, len(rows))
+}
+
+func TestConnection_Query_DropDatabase(t *testing.T) {
+	s, c := newDefaultConnection(t, func(c *Connection) {})
+
+	exec(t, c, "DROP DATABASE hello")
+	thelper.AssertInt(t, "Database remains", 0, len(s.databases))
+
+	_, err := c.Query("DROP DATABASE hello")
+	thelper.AssertBool(t, "No error for a missing database", true, err != nil)
+	exec(t, c, "DROP DATABASE IF EXISTS hello")
+
+	// The name can be used again
+	exec(t, c, "CREATE DATABASE hello")
+	thelper.AssertInt(t, "Database is not created", 1, len(s.databases))
+}
+
+func TestConnection_Query_DropTable(t *testing.T) {
+	s, c := newDefaultConnection(t, func(c *Connection) {
+		exec(t, c, "INSERT INTO hello.world(id, message) VALUES(1, 'hello')")
+	})
+
+	exec(t, c, "DROP TABLE hello.world")
+	thelper.AssertInt(t, "Table remains", 0, len(data.CopyTables(s.databases["hello"])))
+
+	_, err := c.Query("DROP TABLE hello.world")
+	thelper.AssertBool(t, "No error for a missing table", true, err != nil)
+	exec(t, c, "DROP TABLE IF EXISTS hello.world")
+	exec(t, c, "DROP TABLE IF EXISTS nothing.world")
+}
+
+func TestConnection_Query_DropTable_InTransaction(t *testing.T) {
+	s, c := newDefaultConnection(t, func(c *Connection) {})
+	c2 := s.StartNewConnection()
+
+	exec(t, c, "BEGIN")
+	exec(t, c, "INSERT INTO hello.world(id, message) VALUES(1, 'hello')")
+	exec(t, c2, "DROP TABLE hello.world")
+
+	// The insert conflicts with the drop and fails on retry
+	_, err := c.Query("COMMIT")
+	thelper.AssertBool(t, "No error for a dropped table", true, err != nil)
+	exec(t, c, "ROLLBACK")
+	thelper.AssertInt(t, "Table is created", 0, len(data.CopyTables(s.databases["hello"])))
+}
+
+func TestConnection_Query_Truncate(t *testing.T) {
+	s, c := newDefaultConnection(t, func(c *Connection) {
+		exec(t, c, "INSERT INTO hello.world(id, message) VALUES(1, 'hello'), (2, 'world')")
+	})
+	c2 := s.StartNewConnection()
+
+	exec(t, c2, "BEGIN")
+	exec(t, c2, "INSERT INTO hello.world(id, message) VALUES(3, 'foo')")
+	exec(t, c, "TRUNCATE TABLE hello.world")
+	exec(t, c2, "COMMIT")
+
+	res := exec(t, c, "SELECT * FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"id": "3", "message": "foo"}})
+}
+
+func TestConnection_Query_AlterTable(t *testing.T) {
+	_, c := newDefaultConnection(t, func(c *Connection) {
+		exec(t, c, "INSERT INTO hello.world(id, message) VALUES(1, 'hello')")
+	})
+
+	exec(t, c, "ALTER TABLE hello.world ADD COLUMN lang varchar(5) NOT NULL DEFAULT 'en'")
+	exec(t, c, "ALTER TABLE hello.world RENAME COLUMN message TO greeting")
+	exec(t, c, "ALTER TABLE hello.world MODIFY greeting varchar(5)")
+	res := exec(t, c, "SELECT * FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"id": "1", "greeting": "hello", "lang": "en"}})
+
+	_, err := c.Query("ALTER TABLE hello.world MODIFY greeting varchar(2)")
+	thelper.AssertBool(t, "Too long value is allowed", true, err != nil)
+
+	exec(t, c, "ALTER TABLE hello.world DROP COLUMN lang")
+	exec(t, c, "RENAME TABLE hello.world TO hello.earth")
+	res = exec(t, c, "SELECT * FROM hello.earth")
+	data.AssertResult(t, res, []map[string]string{{"id": "1", "greeting": "hello"}})
+	_, err = c.Query("SELECT * FROM hello.world")
+	thelper.AssertBool(t, "Renamed table remains", true, err != nil)
+}
+
+func TestConnection_Query_AlterTable_InTransaction(t *testing.T) {
+	s, c := newDefaultConnection(t, func(c *Connection) {
+		exec(t, c, "INSERT INTO hello.world(id, message) VALUES(1, 'hello')")
+	})
+	c2 := s.StartNewConnection()
+
+	exec(t, c, "BEGIN")
+	exec(t, c, "UPDATE hello.world SET message = 'foo' WHERE id = 1")
+	exec(t, c2, "ALTER TABLE hello.world RENAME COLUMN message TO greeting")
+
+	// The update of the former column conflicts and fails on retry
+	_, err := c.Query("COMMIT")
+	thelper.AssertBool(t, "No error for a renamed column", true, err != nil)
+	exec(t, c, "ROLLBACK")
+
+	res := exec(t, c, "SELECT * FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"id": "1", "greeting": "hello"}})
+}
+
+func TestConnection_Query_Index(t *testing.T) {
+	_, c := newDefaultConnection(t, func(c *Connection) {
+		exec(t, c, `CREATE TABLE hello.people(
+			id INT AUTO_INCREMENT,
+			name VARCHAR(20),
+			age INT,
+			KEY idx_age (age)
+		)`)
+		exec(t, c, "INSERT INTO hello.people(name, age) VALUES('alice', 30), ('bob', 20), ('carol', 40)")
+	})
+
+	res := exec(t, c, "SELECT name FROM hello.people WHERE age >= 25")
+	data.AssertResult(t, res, []map[string]string{{"name": "alice"}, {"name": "carol"}})
+
+	exec(t, c, "UPDATE hello.people SET age = 50 WHERE age = 20")
+	res = exec(t, c, "SELECT name FROM hello.people WHERE age > 45")
+	data.AssertResult(t, res, []map[string]string{{"name": "bob"}})
+
+	exec(t, c, "CREATE INDEX idx_name ON hello.people (name)")
+	exec(t, c, "BEGIN")
+	exec(t, c, "INSERT INTO hello.people(name, age) VALUES('dave', 10)")
+	res = exec(t, c, "SELECT age FROM hello.people WHERE name = 'dave'")
+	data.AssertResult(t, res, []map[string]string{{"age": "10"}})
+	exec(t, c, "COMMIT")
+	res = exec(t, c, "SELECT id FROM hello.people WHERE name = 'dave'")
+	data.AssertResult(t, res, []map[string]string{{"id": "4"}})
+
+	_, err := c.Query("CREATE UNIQUE INDEX idx_unique ON hello.people (name)")
+	thelper.AssertBool(t, "UNIQUE index is created", true, err != nil)
+	_, err = c.Query("ALTER TABLE hello.people DROP COLUMN age")
+	thelper.AssertBool(t, "Indexed column is dropped", true, err != nil)
+
+	exec(t, c, "DROP INDEX idx_age ON hello.people")
+	exec(t, c, "ALTER TABLE hello.people DROP COLUMN age")
+	res = exec(t, c, "SELECT name FROM hello.people WHERE name = 'bob'")
+	data.AssertResult(t, res, []map[string]string{{"name": "bob"}})
+}
+
+func TestConnection_Query_PrimaryKey(t *testing.T) {
+	_, c := newDefaultConnection(t, func(c *Connection) {
+		exec(t, c, `CREATE TABLE hello.scores(
+			name VARCHAR(10) NOT NULL,
+			game INT NOT NULL,
+			score INT,
+			PRIMARY KEY (name, game)
+		)`)
+		exec(t, c, "INSERT INTO hello.scores(name, game, score) VALUES('alice', 1, 10), ('alice', 2, 20), ('bob', 1, 30)")
+	})
+
+	_, err := c.Query("INSERT INTO hello.scores(name, game, score) VALUES('bob', 1, 40)")
+	thelper.AssertBool(t, "Duplicated primary key is inserted", true, err != nil)
+	_, err = c.Query("INSERT INTO hello.scores(name, score) VALUES('carol', 40)")
+	thelper.AssertBool(t, "NULL primary key is inserted", true, err != nil)
+	_, err = c.Query("UPDATE hello.scores SET game = 2 WHERE name = 'alice' AND game = 1")
+	thelper.AssertBool(t, "Primary key is updated to the existing one", true, err != nil)
+
+	exec(t, c, "UPDATE hello.scores SET score = 15 WHERE name = 'alice' AND game = 1")
+	exec(t, c, "UPDATE hello.scores SET game = 3 WHERE name = 'bob'")
+	exec(t, c, "DELETE FROM hello.scores WHERE name = 'alice' AND game = 2")
+	res := exec(t, c, "SELECT * FROM hello.scores")
+	data.AssertResult(t, res, []map[string]string{
+		{"name": "alice", "game": "1", "score": "15"},
+		{"name": "bob", "game": "3", "score": "30"},
+	})
+
+	exec(t, c, "CREATE TABLE hello.logs(message VARCHAR(10))")
+	exec(t, c, "INSERT INTO hello.logs(message) VALUES('foo'), ('foo')")
+	_, err = c.Query("DELETE FROM hello.logs")
+	thelper.AssertBool(t, "Table without primary key is changed", true, err != nil)
+}
+
+func TestConnection_Query_PrimaryKey_InTransaction(t *testing.T) {
+	s, c := newDefaultConnection(t, func(c *Connection) {})
+	c2 := s.StartNewConnection()
+
+	exec(t, c, "BEGIN")
+	exec(t, c2, "BEGIN")
+	exec(t, c, "INSERT INTO hello.world(id, message) VALUES(1, 'first')")
+	exec(t, c2, "INSERT INTO hello.world(id, message) VALUES(1, 'second')")
+	_, err := c.Query("INSERT INTO hello.world(id, message) VALUES(1, 'again')")
+	thelper.AssertBool(t, "Duplicated primary key in the transaction is inserted", true, err != nil)
+	exec(t, c, "COMMIT")
+
+	// The insert of the committed key conflicts and fails on retry
+	_, err = c2.Query("COMMIT")
+	thelper.AssertBool(t, "No error for the committed primary key", true, err != nil)
+	exec(t, c2, "ROLLBACK")
+
+	res := exec(t, c2, "SELECT * FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"id": "1", "message": "first"}})
+}
+
+func TestConnection_Query_SetStaleRead(t *testing.T) {
+	_, c := newEmptyConnection(t, &wal.Memory{})
+
+	exec(t, c, "SET ddb_stale_read = 1")
+	if !c.staleRead {
+		t.Error("Stale read is not allowed")
+	}
+	exec(t, c, "SET @@session.ddb_stale_read = OFF")
+	if c.staleRead {
+		t.Error("Stale read is still allowed")
+	}
+
+	_, err := c.Query("SET ddb_stale_read = 'maybe'")
+	if err == nil {
+		t.Error("No error for an invalid value")
+	}
+	_, err = c.Query("SET unknown_variable = 1")
+	if err == nil {
+		t.Error("No error for an unknown variable")
+	}
+}
+
 func newEmptyConnection(t *testing.T, f io.ReadWriteCloser) (*Server, *Connection) {
 	s, err := NewTestServer(f)
 	if err != nil {
@@ -417,7 +763,7 @@ func exec(t *testing.T, c *Connection, sql string) *structs.Result {
 	return r
 }
 
-func readWal(t *testing.T, wal *wal.Wal, eSize int) []structs.ChangeSet {
+func readWal(t *testing.T, wal *wal.Wal, eSize int) []*pbs.ChangeSet {
 	css, err := wal.Read()
 	if err != nil {
 		t.Error(err)
@@ -0,0 +1,467 @@
+package data
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mrasu/ddb/server/data/types"
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/mrasu/ddb/server/structs"
+	"github.com/pkg/errors"
+	"github.com/xwb1989/sqlparser"
+)
+
+// Actions of AlterTable
+const (
+	AddColumnStr    = "add column"
+	DropColumnStr   = "drop column"
+	RenameColumnStr = "rename column"
+	ModifyColumnStr = "modify column"
+	AddIndexStr     = "add index"
+	DropIndexStr    = "drop index"
+)
+
+// AlterTable is a change of a column or an index by ALTER TABLE, CREATE INDEX or DROP INDEX.
+// sqlparser parses only the table name of them, and fails at `RENAME COLUMN`.
+type AlterTable struct {
+	Action string
+	Table  sqlparser.TableName
+	// Column is the definition given to ADD and MODIFY
+	Column *sqlparser.ColumnDefinition
+	// Index is the definition given to ADD INDEX and CREATE INDEX
+	Index *sqlparser.IndexDefinition
+	// Name is the column or the index dropped or renamed
+	Name    string
+	NewName string
+}
+
+var alterTableRegexp = regexp.MustCompile(`(?is)^\s*alter\s+table\s+(\S+)\s+(add|drop|modify|rename)\s+(column\s+)?(.*?)\s*;?\s*$`)
+var renameColumnRegexp = regexp.MustCompile(`(?is)^(\S+)\s+to\s+(\S+)$`)
+var identifierRegexp = regexp.MustCompile(`^\S+$`)
+var addIndexRegexp = regexp.MustCompile(`(?is)^(index|key|unique)\b`)
+var dropIndexRegexp = regexp.MustCompile(`(?is)^(?:index|key)\s+(\S+)$`)
+var createIndexRegexp = regexp.MustCompile(`(?is)^\s*create\s+(unique\s+)?index\s+(\S+)\s+on\s+([^\s(]+)\s*(\(.*\))\s*;?\s*$`)
+var dropIndexOnRegexp = regexp.MustCompile(`(?is)^\s*drop\s+index\s+(\S+)\s+on\s+(\S+?)\s*;?\s*$`)
+
+// ParseAlterTable parses ALTER TABLE changing one column or one index, CREATE INDEX and DROP INDEX.
+func ParseAlterTable(sql string) (*AlterTable, error) {
+	if m := createIndexRegexp.FindStringSubmatch(sql); m != nil {
+		def, err := parseIndexDefinition(m[1] + "INDEX " + m[2] + " " + m[4])
+		if err != nil {
+			return nil, err
+		}
+		return &AlterTable{Action: AddIndexStr, Table: parseTableName(m[3]), Index: def}, nil
+	}
+	if m := dropIndexOnRegexp.FindStringSubmatch(sql); m != nil {
+		return &AlterTable{Action: DropIndexStr, Table: parseTableName(m[2]), Name: unquote(m[1])}, nil
+	}
+
+	m := alterTableRegexp.FindStringSubmatch(sql)
+	if m == nil {
+		return nil, errors.Errorf("Not supported ALTER TABLE: %s", sql)
+	}
+
+	alt := &AlterTable{Table: parseTableName(m[1])}
+	rest := m[4]
+	switch strings.ToLower(m[2]) {
+	case "add", "modify":
+		if m[3] == "" && strings.ToLower(m[2]) == "add" && addIndexRegexp.MatchString(rest) {
+			def, err := parseIndexDefinition(rest)
+			if err != nil {
+				return nil, err
+			}
+			alt.Action = AddIndexStr
+			alt.Index = def
+			break
+		}
+		c, err := parseColumnDefinition(rest)
+		if err != nil {
+			return nil, err
+		}
+		alt.Column = c
+		if strings.ToLower(m[2]) == "add" {
+			alt.Action = AddColumnStr
+		} else {
+			alt.Action = ModifyColumnStr
+		}
+	case "drop":
+		if im := dropIndexRegexp.FindStringSubmatch(rest); m[3] == "" && im != nil {
+			alt.Action = DropIndexStr
+			alt.Name = unquote(im[1])
+			break
+		}
+		if !identifierRegexp.MatchString(rest) {
+			return nil, errors.Errorf("Not supported ALTER TABLE: %s", sql)
+		}
+		alt.Action = DropColumnStr
+		alt.Name = unquote(rest)
+	case "rename":
+		// `RENAME TO` is parsed by sqlparser
+		rm := renameColumnRegexp.FindStringSubmatch(rest)
+		if m[3] == "" || rm == nil {
+			return nil, errors.Errorf("Not supported ALTER TABLE: %s", sql)
+		}
+		alt.Action = RenameColumnStr
+		alt.Name = unquote(rm[1])
+		alt.NewName = unquote(rm[2])
+	}
+	return alt, nil
+}
+
+func parseTableName(name string) sqlparser.TableName {
+	names := strings.SplitN(name, ".", 2)
+	if len(names) == 1 {
+		return sqlparser.TableName{Name: sqlparser.NewTableIdent(unquote(names[0]))}
+	}
+	return sqlparser.TableName{
+		Qualifier: sqlparser.NewTableIdent(unquote(names[0])),
+		Name:      sqlparser.NewTableIdent(unquote(names[1])),
+	}
+}
+
+func unquote(name string) string {
+	return strings.Trim(name, "`")
+}
+
+// parseColumnDefinition parses the column definition by sqlparser as the one of CREATE TABLE.
+func parseColumnDefinition(def string) (*sqlparser.ColumnDefinition, error) {
+	spec, err := parseTableSpec(def)
+	if err != nil || len(spec.Columns) != 1 || len(spec.Indexes) != 0 {
+		return nil, errors.Errorf("Not supported column definition: %s", def)
+	}
+	return spec.Columns[0], nil
+}
+
+// parseIndexDefinition parses the index definition by sqlparser as the one of CREATE TABLE.
+func parseIndexDefinition(def string) (*sqlparser.IndexDefinition, error) {
+	// sqlparser requires a column in CREATE TABLE
+	spec, err := parseTableSpec("`_` int, " + def)
+	if err != nil || len(spec.Columns) != 1 || len(spec.Indexes) != 1 {
+		return nil, errors.Errorf("Not supported index definition: %s", def)
+	}
+	return spec.Indexes[0], nil
+}
+
+func parseTableSpec(def string) (*sqlparser.TableSpec, error) {
+	stmt, err := sqlparser.ParseStrictDDL("CREATE TABLE t (" + def + ")")
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid definition")
+	}
+	ddl, ok := stmt.(*sqlparser.DDL)
+	if !ok || ddl.TableSpec == nil {
+		return nil, errors.Errorf("Not supported definition: %s", def)
+	}
+	return ddl.TableSpec, nil
+}
+
+func (t *Table) findRowMeta(name string) (int, *structs.RowMeta) {
+	for i, m := range t.rowMetas {
+		if m.Name == name {
+			return i, m
+		}
+	}
+	return -1, nil
+}
+
+// committedRows returns rows committed by transactions, which are changed by ALTER TABLE.
+func (t *Table) committedRows() []*Row {
+	var rows []*Row
+	for _, r := range t.rows {
+		if len(r.columns) > 0 {
+			rows = append(rows, r)
+		}
+	}
+	return rows
+}
+
+// validateValue returns error when the value cannot be stored in the column. exists is false for NULL.
+func validateValue(m *structs.RowMeta, value string, exists bool) error {
+	if !exists {
+		if !m.AllowsNull {
+			return errors.Errorf("NULL is not allowed at %s", m.Name)
+		}
+		return nil
+	}
+
+	switch m.ColumnType {
+	case types.Int, types.AutoIncrementInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return errors.Errorf("Invalid value for %s: %s", m.String(), value)
+		}
+	case types.VarChar:
+		if int64(utf8.RuneCountInString(value)) > m.Length {
+			return errors.Errorf("Too long value for %s: %s", m.String(), value)
+		}
+	}
+	return nil
+}
+
+func (t *Table) makeAddColumnChangeSet(c *sqlparser.ColumnDefinition) (*pbs.AddColumnChangeSet, error) {
+	m, err := toRowMeta(c)
+	if err != nil {
+		return nil, err
+	}
+	if _, existing := t.findRowMeta(m.Name); existing != nil {
+		return nil, errors.Errorf("Column already exists: %s", m.Name)
+	}
+
+	rows := t.committedRows()
+	if m.ColumnType == types.AutoIncrementInt && len(rows) > 0 {
+		return nil, errors.Errorf("AUTO_INCREMENT column cannot be added to a table having rows: %s", m.Name)
+	}
+
+	cs := &pbs.AddColumnChangeSet{
+		TableName: t.Name,
+		Column:    ToPbRowMetas([]*structs.RowMeta{m})[0],
+	}
+	if d := c.Type.Default; d != nil && !(d.Type == sqlparser.ValArg && strings.ToLower(string(d.Val)) == "null") {
+		cs.HasDefault = true
+		cs.Default = string(d.Val)
+	} else if !m.AllowsNull {
+		// Same with MySQL, existing rows get the implicit default
+		cs.HasDefault = true
+		if m.ColumnType != types.VarChar {
+			cs.Default = "0"
+		}
+	}
+
+	if len(rows) > 0 {
+		err = validateValue(m, cs.Default, cs.HasDefault)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cs, nil
+}
+
+func (t *Table) applyAddColumnChangeSet(cs *pbs.AddColumnChangeSet) {
+	m := ToRowMetas([]*pbs.RowMeta{cs.Column})[0]
+	if cs.HasDefault {
+		for _, r := range t.committedRows() {
+			r.saveForSnapshot()
+			r.columns[m.Name] = cs.Default
+		}
+	}
+	t.rowMetas = append(t.rowMetas, m)
+	t.schemaVersion += 1
+}
+
+func (t *Table) makeDropColumnChangeSet(name string) (*pbs.DropColumnChangeSet, error) {
+	if _, m := t.findRowMeta(name); m == nil {
+		return nil, errors.Errorf("Column doesn't exist: %s", name)
+	}
+	if t.isPrimaryKeyColumn(name) {
+		return nil, errors.Errorf("Primary key cannot be dropped: %s", name)
+	}
+	if len(t.rowMetas) == 1 {
+		return nil, errors.Errorf("All columns cannot be dropped: %s", name)
+	}
+	for _, idx := range t.indexes {
+		if idx.hasColumn(name) {
+			return nil, errors.Errorf("Column used by index %s cannot be dropped: %s", idx.Name, name)
+		}
+	}
+
+	return &pbs.DropColumnChangeSet{
+		TableName: t.Name,
+		Name:      name,
+	}, nil
+}
+
+func (t *Table) applyDropColumnChangeSet(cs *pbs.DropColumnChangeSet) {
+	i, _ := t.findRowMeta(cs.Name)
+	if i < 0 {
+		return
+	}
+	for _, r := range t.committedRows() {
+		r.saveForSnapshot()
+		delete(r.columns, cs.Name)
+	}
+	t.rowMetas = append(t.rowMetas[:i:i], t.rowMetas[i+1:]...)
+
+	t.autoIncrementMu.Lock()
+	delete(t.autoIncrements, cs.Name)
+	t.autoIncrementMu.Unlock()
+	t.schemaVersion += 1
+}
+
+func (t *Table) makeRenameColumnChangeSet(name, newName string) (*pbs.RenameColumnChangeSet, error) {
+	if _, m := t.findRowMeta(name); m == nil {
+		return nil, errors.Errorf("Column doesn't exist: %s", name)
+	}
+	if _, m := t.findRowMeta(newName); m != nil {
+		return nil, errors.Errorf("Column already exists: %s", newName)
+	}
+	if t.isPrimaryKeyColumn(name) {
+		return nil, errors.Errorf("Primary key cannot be renamed: %s", name)
+	}
+
+	return &pbs.RenameColumnChangeSet{
+		TableName: t.Name,
+		Name:      name,
+		NewName:   newName,
+	}, nil
+}
+
+func (t *Table) applyRenameColumnChangeSet(cs *pbs.RenameColumnChangeSet) {
+	i, m := t.findRowMeta(cs.Name)
+	if i < 0 {
+		return
+	}
+	for _, r := range t.committedRows() {
+		if v, ok := r.columns[cs.Name]; ok {
+			r.saveForSnapshot()
+			r.columns[cs.NewName] = v
+			delete(r.columns, cs.Name)
+		}
+	}
+	renamed := *m
+	renamed.Name = cs.NewName
+	t.rowMetas[i] = &renamed
+	for _, idx := range t.indexes {
+		for j, c := range idx.columns {
+			if c == cs.Name {
+				idx.columns[j] = cs.NewName
+			}
+		}
+	}
+
+	t.autoIncrementMu.Lock()
+	if v, ok := t.autoIncrements[cs.Name]; ok {
+		t.autoIncrements[cs.NewName] = v
+		delete(t.autoIncrements, cs.Name)
+	}
+	t.autoIncrementMu.Unlock()
+	t.schemaVersion += 1
+}
+
+func (t *Table) makeModifyColumnChangeSet(c *sqlparser.ColumnDefinition) (*pbs.ModifyColumnChangeSet, error) {
+	m, err := toRowMeta(c)
+	if err != nil {
+		return nil, err
+	}
+	if _, existing := t.findRowMeta(m.Name); existing == nil {
+		return nil, errors.Errorf("Column doesn't exist: %s", m.Name)
+	}
+	err = t.validateModifiedColumn(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pbs.ModifyColumnChangeSet{
+		TableName: t.Name,
+		Column:    ToPbRowMetas([]*structs.RowMeta{m})[0],
+	}, nil
+}
+
+// validateModifiedColumn returns error when committed rows have values which the column of m cannot store.
+func (t *Table) validateModifiedColumn(m *structs.RowMeta) error {
+	rows := t.committedRows()
+	for _, r := range rows {
+		v, ok := r.columns[m.Name]
+		err := validateValue(m, v, ok)
+		if err != nil {
+			return err
+		}
+	}
+	if t.isPrimaryKeyColumn(m.Name) {
+		return t.validateModifiedPrimaryKey(m, rows)
+	}
+	return nil
+}
+
+// validateModifiedPrimaryKey returns error when rows have the same primary key after the type is changed, like '1' and '01' of INT.
+func (t *Table) validateModifiedPrimaryKey(m *structs.RowMeta, rows []*Row) error {
+	metas := make([]*structs.RowMeta, len(t.rowMetas))
+	for i, meta := range t.rowMetas {
+		if meta.Name == m.Name {
+			metas[i] = m
+		} else {
+			metas[i] = meta
+		}
+	}
+	idx, err := newIndex(primaryIndexName, t.primaryKey, metas)
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		key := idx.keyOf(r.columns)
+		if idx.tree.get(key) != nil {
+			var values []string
+			for _, c := range t.primaryKey {
+				values = append(values, r.columns[c])
+			}
+			return errors.Errorf("Duplicate entry '%s' for key 'PRIMARY'", primaryKeyString(values))
+		}
+		idx.add(r)
+	}
+	return nil
+}
+
+// applyModifyColumnChangeSet validates rows again, because rows can be changed by ChangeSets applied after cs is made.
+func (t *Table) applyModifyColumnChangeSet(cs *pbs.ModifyColumnChangeSet) error {
+	m := ToRowMetas([]*pbs.RowMeta{cs.Column})[0]
+	i, _ := t.findRowMeta(m.Name)
+	if i < 0 {
+		return nil
+	}
+	err := t.validateModifiedColumn(m)
+	if err != nil {
+		return err
+	}
+	t.rowMetas[i] = m
+	if m.ColumnType == types.AutoIncrementInt {
+		for _, r := range t.committedRows() {
+			t.observeAutoIncrements(r.columns)
+		}
+	}
+	// The order of values depends on the type
+	t.rebuildIndexes()
+	t.schemaVersion += 1
+	return nil
+}
+
+func (t *Table) makeCreateIndexChangeSet(def *sqlparser.IndexDefinition) (*pbs.CreateIndexChangeSet, error) {
+	idx, err := toIndex(def, t.rowMetas)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := t.indexes[idx.Name]; ok {
+		return nil, errors.Errorf("Index already exists: %s", idx.Name)
+	}
+
+	return &pbs.CreateIndexChangeSet{
+		TableName: t.Name,
+		Index:     ToPbIndexMetas([]*structs.IndexMeta{idx.toMeta()})[0],
+	}, nil
+}
+
+func (t *Table) applyCreateIndexChangeSet(cs *pbs.CreateIndexChangeSet) error {
+	m := ToIndexMetas([]*pbs.IndexMeta{cs.Index})[0]
+	idx, err := newIndex(m.Name, m.Columns, t.rowMetas)
+	if err != nil {
+		return err
+	}
+	for _, r := range t.committedRows() {
+		idx.add(r)
+	}
+	t.indexes[idx.Name] = idx
+	return nil
+}
+
+func (t *Table) makeDropIndexChangeSet(name string) (*pbs.DropIndexChangeSet, error) {
+	if _, ok := t.indexes[name]; !ok {
+		return nil, errors.Errorf("Index doesn't exist: %s", name)
+	}
+
+	return &pbs.DropIndexChangeSet{
+		TableName: t.Name,
+		Name:      name,
+	}, nil
+}
+
+func (t *Table) applyDropIndexChangeSet(cs *pbs.DropIndexChangeSet) {
+	delete(t.indexes, cs.Name)
+}
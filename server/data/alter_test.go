@@ -0,0 +1,178 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/mrasu/ddb/server/data/types"
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/mrasu/ddb/thelper"
+)
+
+func TestParseAlterTable(t *testing.T) {
+	alt, err := ParseAlterTable("ALTER TABLE hello.world ADD COLUMN age int NOT NULL DEFAULT 3")
+	thelper.AssertNoError(t, err)
+	thelper.AssertString(t, "Invalid action", AddColumnStr, alt.Action)
+	thelper.AssertString(t, "Invalid database", "hello", alt.Table.Qualifier.String())
+	thelper.AssertString(t, "Invalid table", "world", alt.Table.Name.String())
+	thelper.AssertString(t, "Invalid column", "age", alt.Column.Name.String())
+	thelper.AssertString(t, "Invalid default", "3", string(alt.Column.Type.Default.Val))
+
+	alt, err = ParseAlterTable("alter table `hello`.`world` modify `text` varchar(3)")
+	thelper.AssertNoError(t, err)
+	thelper.AssertString(t, "Invalid action", ModifyColumnStr, alt.Action)
+	thelper.AssertString(t, "Invalid table", "world", alt.Table.Name.String())
+	thelper.AssertString(t, "Invalid column", "text", alt.Column.Name.String())
+
+	alt, err = ParseAlterTable("ALTER TABLE hello.world DROP num")
+	thelper.AssertNoError(t, err)
+	thelper.AssertString(t, "Invalid action", DropColumnStr, alt.Action)
+	thelper.AssertString(t, "Invalid column", "num", alt.Name)
+
+	alt, err = ParseAlterTable("ALTER TABLE hello.world RENAME COLUMN num TO number;")
+	thelper.AssertNoError(t, err)
+	thelper.AssertString(t, "Invalid action", RenameColumnStr, alt.Action)
+	thelper.AssertString(t, "Invalid column", "num", alt.Name)
+	thelper.AssertString(t, "Invalid new column", "number", alt.NewName)
+
+	alt, err = ParseAlterTable("ALTER TABLE hello.world ADD KEY idx (num, `text`)")
+	thelper.AssertNoError(t, err)
+	thelper.AssertString(t, "Invalid action", AddIndexStr, alt.Action)
+	thelper.AssertString(t, "Invalid index", "idx", alt.Index.Info.Name.String())
+	thelper.AssertInt(t, "Invalid index columns", 2, len(alt.Index.Columns))
+
+	alt, err = ParseAlterTable("CREATE INDEX idx ON hello.world(num);")
+	thelper.AssertNoError(t, err)
+	thelper.AssertString(t, "Invalid action", AddIndexStr, alt.Action)
+	thelper.AssertString(t, "Invalid table", "world", alt.Table.Name.String())
+	thelper.AssertString(t, "Invalid index column", "num", alt.Index.Columns[0].Column.String())
+
+	alt, err = ParseAlterTable("ALTER TABLE hello.world DROP INDEX idx")
+	thelper.AssertNoError(t, err)
+	thelper.AssertString(t, "Invalid action", DropIndexStr, alt.Action)
+	thelper.AssertString(t, "Invalid index", "idx", alt.Name)
+
+	alt, err = ParseAlterTable("DROP INDEX `idx` ON hello.world")
+	thelper.AssertNoError(t, err)
+	thelper.AssertString(t, "Invalid action", DropIndexStr, alt.Action)
+	thelper.AssertString(t, "Invalid index", "idx", alt.Name)
+	thelper.AssertString(t, "Invalid database", "hello", alt.Table.Qualifier.String())
+
+	for _, sql := range []string{
+		"ALTER TABLE hello.world RENAME INDEX a TO b",
+		"ALTER TABLE hello.world DROP PRIMARY KEY",
+		"ALTER TABLE hello.world ADD a int, ADD b int",
+		"ALTER TABLE hello.world ADD INDEX idx (num), ADD INDEX idx2 (num)",
+	} {
+		_, err = ParseAlterTable(sql)
+		thelper.AssertBool(t, "No error for "+sql, true, err != nil)
+	}
+}
+
+func TestTable_AddColumn(t *testing.T) {
+	table := createDefaultTable()
+	alt, err := ParseAlterTable("ALTER TABLE world ADD COLUMN flag int NOT NULL")
+	thelper.AssertNoError(t, err)
+
+	cs, err := table.makeAddColumnChangeSet(alt.Column)
+	thelper.AssertNoError(t, err)
+	thelper.AssertBool(t, "Implicit default is not set", true, cs.HasDefault)
+	table.applyAddColumnChangeSet(cs)
+
+	thelper.AssertInt(t, "Column is not added", 4, len(table.rowMetas))
+	for _, r := range table.rows {
+		thelper.AssertString(t, "Existing row is not backfilled", "0", r.columns["flag"])
+	}
+
+	alt, err = ParseAlterTable("ALTER TABLE world ADD COLUMN code varchar(2) DEFAULT 'long'")
+	thelper.AssertNoError(t, err)
+	_, err = table.makeAddColumnChangeSet(alt.Column)
+	thelper.AssertBool(t, "Too long default is allowed", true, err != nil)
+}
+
+func TestTable_ModifyColumn(t *testing.T) {
+	table := createDefaultTable()
+
+	alt, err := ParseAlterTable("ALTER TABLE world MODIFY `text` int")
+	thelper.AssertNoError(t, err)
+	_, err = table.makeModifyColumnChangeSet(alt.Column)
+	thelper.AssertBool(t, "Not numeric value is allowed", true, err != nil)
+
+	alt, err = ParseAlterTable("ALTER TABLE world MODIFY `text` varchar(1)")
+	thelper.AssertNoError(t, err)
+	_, err = table.makeModifyColumnChangeSet(alt.Column)
+	thelper.AssertBool(t, "Too long value is allowed", true, err != nil)
+
+	alt, err = ParseAlterTable("ALTER TABLE world MODIFY num varchar(5) NOT NULL")
+	thelper.AssertNoError(t, err)
+	cs, err := table.makeModifyColumnChangeSet(alt.Column)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, table.applyModifyColumnChangeSet(cs))
+	_, m := table.findRowMeta("num")
+	thelper.AssertInt(t, "Type is not changed", int(types.VarChar), int(m.ColumnType))
+	thelper.AssertInt64(t, "Length is not changed", 5, m.Length)
+}
+
+func TestTable_ModifyColumn_RowsChangedAfterMade(t *testing.T) {
+	alt, err := ParseAlterTable("ALTER TABLE world MODIFY `text` varchar(2) NOT NULL")
+	thelper.AssertNoError(t, err)
+
+	// Rows inserted after the ChangeSet is made are validated when it is applied
+	for _, columns := range []map[string]string{{"id": "3", "num": "30"}, {"id": "4", "num": "40", "text": "long"}} {
+		table := createDefaultTable()
+		cs, err := table.makeModifyColumnChangeSet(alt.Column)
+		thelper.AssertNoError(t, err)
+		thelper.AssertNoError(t, table.ApplyInsertChangeSets(CreateImmediateTransaction(), []*pbs.InsertRow{{Columns: columns}}))
+
+		err = table.applyModifyColumnChangeSet(cs)
+		thelper.AssertBool(t, "Invalid value is allowed", true, err != nil)
+		_, m := table.findRowMeta("text")
+		thelper.AssertInt64(t, "Column is modified", 10, m.Length)
+	}
+}
+
+func TestTable_RenameColumn(t *testing.T) {
+	table := createDefaultTable()
+
+	_, err := table.makeRenameColumnChangeSet("id", "key")
+	thelper.AssertBool(t, "Primary key is renamed", true, err != nil)
+	_, err = table.makeRenameColumnChangeSet("num", "text")
+	thelper.AssertBool(t, "Duplicated name is allowed", true, err != nil)
+
+	cs, err := table.makeRenameColumnChangeSet("num", "number")
+	thelper.AssertNoError(t, err)
+	table.applyRenameColumnChangeSet(cs)
+	thelper.AssertString(t, "Column is not renamed", "number", table.rowMetas[1].Name)
+	thelper.AssertString(t, "Value is not moved", "10", table.rows[0].columns["number"])
+	_, ok := table.rows[0].columns["num"]
+	thelper.AssertBool(t, "Old value remains", false, ok)
+}
+
+func TestTable_DropColumn(t *testing.T) {
+	table := createDefaultTable()
+
+	_, err := table.makeDropColumnChangeSet("id")
+	thelper.AssertBool(t, "Primary key is dropped", true, err != nil)
+
+	cs, err := table.makeDropColumnChangeSet("num")
+	thelper.AssertNoError(t, err)
+	table.applyDropColumnChangeSet(cs)
+	thelper.AssertInt(t, "Column is not dropped", 2, len(table.rowMetas))
+	_, ok := table.rows[0].columns["num"]
+	thelper.AssertBool(t, "Value remains", false, ok)
+}
+
+func TestTransaction_ExpandLock_AlteredTable(t *testing.T) {
+	table := createDefaultTable()
+	trx := StartNewTransaction()
+	err := table.rows[0].Update(trx, map[string]string{"text": "foo"})
+	thelper.AssertNoError(t, err)
+
+	cs, err := table.makeDropColumnChangeSet("num")
+	thelper.AssertNoError(t, err)
+	table.applyDropColumnChangeSet(cs)
+
+	err = trx.expandLock()
+	if _, ok := err.(*TransactionConflictError); !ok {
+		t.Errorf("Change on the former columns doesn't conflict: %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+package data
+
+import "sort"
+
+// btreeDegree is the minimum degree of btree. Nodes except the root have btreeDegree-1 to 2*btreeDegree-1 entries.
+const btreeDegree = 16
+
+// btree is a B-tree of indexEntry ordered by keys.
+type btree struct {
+	root    *btreeNode
+	compare func(a, b indexKey) int
+	length  int
+}
+
+type btreeNode struct {
+	entries  []*indexEntry
+	children []*btreeNode
+}
+
+// btreeBound is the end of a range. Keys shorter than entries' ones bound entries by the prefix.
+type btreeBound struct {
+	key       indexKey
+	inclusive bool
+}
+
+func newBtree(compare func(a, b indexKey) int) *btree {
+	return &btree{compare: compare}
+}
+
+func (t *btree) Len() int {
+	return t.length
+}
+
+func (t *btree) get(key indexKey) *indexEntry {
+	n := t.root
+	for n != nil {
+		i, found := n.find(key, t.compare)
+		if found {
+			return n.entries[i]
+		}
+		if n.isLeaf() {
+			return nil
+		}
+		n = n.children[i]
+	}
+	return nil
+}
+
+// insert adds e, or returns the entry having the same key without adding e.
+func (t *btree) insert(e *indexEntry) *indexEntry {
+	if t.root == nil {
+		t.root = &btreeNode{entries: []*indexEntry{e}}
+		t.length++
+		return nil
+	}
+	if existing := t.get(e.key); existing != nil {
+		return existing
+	}
+
+	if len(t.root.entries) == 2*btreeDegree-1 {
+		root := &btreeNode{children: []*btreeNode{t.root}}
+		root.splitChild(0)
+		t.root = root
+	}
+	t.root.insertNonFull(e, t.compare)
+	t.length++
+	return nil
+}
+
+// remove removes the entry of the key and returns it, or nil when it is not found.
+func (t *btree) remove(key indexKey) *indexEntry {
+	if t.root == nil {
+		return nil
+	}
+	e := t.root.remove(key, t.compare)
+	if len(t.root.entries) == 0 {
+		if t.root.isLeaf() {
+			t.root = nil
+		} else {
+			t.root = t.root.children[0]
+		}
+	}
+	if e != nil {
+		t.length--
+	}
+	return e
+}
+
+// ascend calls fn with entries between from and to in order until fn returns false. nil bound is unbounded.
+func (t *btree) ascend(from, to *btreeBound, fn func(*indexEntry) bool) {
+	if t.root == nil {
+		return
+	}
+	t.root.ascend(from, to, t.compare, fn)
+}
+
+func (n *btreeNode) isLeaf() bool {
+	return len(n.children) == 0
+}
+
+// find returns the position of the first entry not less than key.
+func (n *btreeNode) find(key indexKey, compare func(a, b indexKey) int) (int, bool) {
+	i := sort.Search(len(n.entries), func(i int) bool {
+		return compare(n.entries[i].key, key) >= 0
+	})
+	return i, i < len(n.entries) && compare(n.entries[i].key, key) == 0
+}
+
+// splitChild splits the full child at i into two and moves the middle entry to n.
+func (n *btreeNode) splitChild(i int) {
+	child := n.children[i]
+	mid := child.entries[btreeDegree-1]
+
+	right := &btreeNode{
+		entries: append([]*indexEntry{}, child.entries[btreeDegree:]...),
+	}
+	if !child.isLeaf() {
+		right.children = append([]*btreeNode{}, child.children[btreeDegree:]...)
+		child.children = child.children[:btreeDegree:btreeDegree]
+	}
+	child.entries = child.entries[: btreeDegree-1 : btreeDegree-1]
+
+	n.entries = append(n.entries, nil)
+	copy(n.entries[i+1:], n.entries[i:])
+	n.entries[i] = mid
+	n.children = append(n.children, nil)
+	copy(n.children[i+2:], n.children[i+1:])
+	n.children[i+1] = right
+}
+
+func (n *btreeNode) insertNonFull(e *indexEntry, compare func(a, b indexKey) int) {
+	i, _ := n.find(e.key, compare)
+	if n.isLeaf() {
+		n.entries = append(n.entries, nil)
+		copy(n.entries[i+1:], n.entries[i:])
+		n.entries[i] = e
+		return
+	}
+
+	if len(n.children[i].entries) == 2*btreeDegree-1 {
+		n.splitChild(i)
+		if compare(e.key, n.entries[i].key) > 0 {
+			i++
+		}
+	}
+	n.children[i].insertNonFull(e, compare)
+}
+
+func (n *btreeNode) remove(key indexKey, compare func(a, b indexKey) int) *indexEntry {
+	i, found := n.find(key, compare)
+	if n.isLeaf() {
+		if !found {
+			return nil
+		}
+		e := n.entries[i]
+		n.entries = append(n.entries[:i], n.entries[i+1:]...)
+		return e
+	}
+
+	if found {
+		e := n.entries[i]
+		if len(n.children[i].entries) >= btreeDegree {
+			pred := n.children[i].max()
+			n.entries[i] = pred
+			n.children[i].remove(pred.key, compare)
+		} else if len(n.children[i+1].entries) >= btreeDegree {
+			succ := n.children[i+1].min()
+			n.entries[i] = succ
+			n.children[i+1].remove(succ.key, compare)
+		} else {
+			n.merge(i)
+			n.children[i].remove(key, compare)
+		}
+		return e
+	}
+
+	// Keep the child having enough entries to remove one
+	if len(n.children[i].entries) < btreeDegree {
+		if i > 0 && len(n.children[i-1].entries) >= btreeDegree {
+			n.rotateRight(i)
+		} else if i < len(n.children)-1 && len(n.children[i+1].entries) >= btreeDegree {
+			n.rotateLeft(i)
+		} else if i < len(n.children)-1 {
+			n.merge(i)
+		} else {
+			n.merge(i - 1)
+			i--
+		}
+	}
+	return n.children[i].remove(key, compare)
+}
+
+func (n *btreeNode) min() *indexEntry {
+	for !n.isLeaf() {
+		n = n.children[0]
+	}
+	return n.entries[0]
+}
+
+func (n *btreeNode) max() *indexEntry {
+	for !n.isLeaf() {
+		n = n.children[len(n.children)-1]
+	}
+	return n.entries[len(n.entries)-1]
+}
+
+// merge merges the child at i+1 and the entry at i into the child at i.
+func (n *btreeNode) merge(i int) {
+	left, right := n.children[i], n.children[i+1]
+	left.entries = append(left.entries, n.entries[i])
+	left.entries = append(left.entries, right.entries...)
+	left.children = append(left.children, right.children...)
+
+	n.entries = append(n.entries[:i], n.entries[i+1:]...)
+	n.children = append(n.children[:i+1], n.children[i+2:]...)
+}
+
+// rotateRight moves the last entry of the child at i-1 to the child at i through n.
+func (n *btreeNode) rotateRight(i int) {
+	left, child := n.children[i-1], n.children[i]
+
+	child.entries = append([]*indexEntry{n.entries[i-1]}, child.entries...)
+	n.entries[i-1] = left.entries[len(left.entries)-1]
+	left.entries = left.entries[:len(left.entries)-1]
+	if !left.isLeaf() {
+		child.children = append([]*btreeNode{left.children[len(left.children)-1]}, child.children...)
+		left.children = left.children[:len(left.children)-1]
+	}
+}
+
+// rotateLeft moves the first entry of the child at i+1 to the child at i through n.
+func (n *btreeNode) rotateLeft(i int) {
+	child, right := n.children[i], n.children[i+1]
+
+	child.entries = append(child.entries, n.entries[i])
+	n.entries[i] = right.entries[0]
+	right.entries = append([]*indexEntry{}, right.entries[1:]...)
+	if !right.isLeaf() {
+		child.children = append(child.children, right.children[0])
+		right.children = append([]*btreeNode{}, right.children[1:]...)
+	}
+}
+
+func (n *btreeNode) ascend(from, to *btreeBound, compare func(a, b indexKey) int, fn func(*indexEntry) bool) bool {
+	start := 0
+	if from != nil {
+		start, _ = n.find(from.key, compare)
+	}
+	for i := start; i < len(n.entries); i++ {
+		if !n.isLeaf() {
+			if !n.children[i].ascend(from, to, compare, fn) {
+				return false
+			}
+		}
+
+		e := n.entries[i]
+		if to != nil {
+			c := compare(e.key, to.key)
+			if c > 0 || (c == 0 && !to.inclusive) {
+				return false
+			}
+		}
+		if from != nil && !from.inclusive && compare(e.key, from.key) == 0 {
+			continue
+		}
+		if !fn(e) {
+			return false
+		}
+	}
+	if !n.isLeaf() {
+		return n.children[len(n.entries)].ascend(from, to, compare, fn)
+	}
+	return true
+}
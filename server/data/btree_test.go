@@ -0,0 +1,77 @@
+package data
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/mrasu/ddb/thelper"
+)
+
+func intKey(v int64) indexKey {
+	return indexKey{{rank: numberRank, num: v}}
+}
+
+func ascendNums(tree *btree, from, to *btreeBound) []int64 {
+	var nums []int64
+	tree.ascend(from, to, func(e *indexEntry) bool {
+		nums = append(nums, e.key[0].num)
+		return true
+	})
+	return nums
+}
+
+func TestBtree_InsertRemove(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	tree := newBtree(compareIndexKeys)
+	expected := map[int64]bool{}
+
+	for i := 0; i < 5000; i++ {
+		v := rnd.Int63n(1000)
+		if rnd.Intn(3) == 0 {
+			e := tree.remove(intKey(v))
+			thelper.AssertBool(t, "Invalid removal", expected[v], e != nil)
+			delete(expected, v)
+		} else {
+			existing := tree.insert(&indexEntry{key: intKey(v)})
+			thelper.AssertBool(t, "Invalid insertion", expected[v], existing != nil)
+			expected[v] = true
+		}
+	}
+
+	var nums []int64
+	for v := range expected {
+		nums = append(nums, v)
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+
+	thelper.AssertInt(t, "Invalid length", len(nums), tree.Len())
+	actual := ascendNums(tree, nil, nil)
+	thelper.AssertInt(t, "Invalid ascend length", len(nums), len(actual))
+	for i, v := range actual {
+		thelper.AssertInt64(t, "Not ordered", nums[i], v)
+	}
+	for _, v := range nums {
+		tree.remove(intKey(v))
+	}
+	thelper.AssertInt(t, "Entries remain", 0, tree.Len())
+}
+
+func TestBtree_Ascend(t *testing.T) {
+	tree := newBtree(compareIndexKeys)
+	for i := int64(0); i < 100; i++ {
+		tree.insert(&indexEntry{key: intKey(i)})
+	}
+
+	nums := ascendNums(tree, &btreeBound{key: intKey(10), inclusive: true}, &btreeBound{key: intKey(20)})
+	thelper.AssertInt(t, "Invalid length", 10, len(nums))
+	thelper.AssertInt64(t, "Invalid first", 10, nums[0])
+	thelper.AssertInt64(t, "Invalid last", 19, nums[9])
+
+	nums = ascendNums(tree, &btreeBound{key: intKey(95)}, nil)
+	thelper.AssertInt(t, "Invalid length", 4, len(nums))
+	thelper.AssertInt64(t, "Invalid first", 96, nums[0])
+
+	nums = ascendNums(tree, nil, &btreeBound{key: intKey(2), inclusive: true})
+	thelper.AssertInt(t, "Invalid length", 3, len(nums))
+}
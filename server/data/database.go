@@ -2,7 +2,9 @@ package data
 
 import (
 	"fmt"
+	"strconv"
 
+	"github.com/mrasu/ddb/server/data/types"
 	"github.com/mrasu/ddb/server/pbs"
 
 	"github.com/mrasu/ddb/server/structs"
@@ -41,9 +43,11 @@ func (db *Database) MakeCreateTableChangeSet(ddl *sqlparser.DDL) (*structs.Creat
 	}
 
 	cs := &structs.CreateTableChangeSet{
-		DBName:   db.Name,
-		Name:     t.Name,
-		RowMetas: t.rowMetas,
+		DBName:     db.Name,
+		Name:       t.Name,
+		RowMetas:   t.rowMetas,
+		Indexes:    t.indexMetas(),
+		PrimaryKey: t.primaryKey,
 	}
 
 	return cs, nil
@@ -53,11 +57,219 @@ func (db *Database) ApplyCreateTableChangeSet(cs *pbs.CreateTableChangeSet) erro
 	if db.Name != cs.DBName {
 		return errors.Errorf("Database doesn't exist: %s", cs.DBName)
 	}
-	t := NewTableFromChangeSet(cs)
+	t, err := NewTableFromChangeSet(cs)
+	if err != nil {
+		return err
+	}
 	db.tables[t.Name] = t
 	return nil
 }
 
+func (db *Database) MakeDropTableChangeSet(ddl *sqlparser.DDL) (*structs.DropTableChangeSet, error) {
+	t, err := db.getTable(ddl.Table.Name.String())
+	if err != nil {
+		return nil, err
+	}
+
+	cs := &structs.DropTableChangeSet{
+		DBName: db.Name,
+		Name:   t.Name,
+	}
+	return cs, nil
+}
+
+func (db *Database) ApplyDropTableChangeSet(cs *pbs.DropTableChangeSet) error {
+	t, err := db.getTable(cs.Name)
+	if err != nil {
+		return err
+	}
+	t.drop()
+	delete(db.tables, t.Name)
+	return nil
+}
+
+func (db *Database) MakeTruncateTableChangeSet(ddl *sqlparser.DDL) (*structs.TruncateTableChangeSet, error) {
+	t, err := db.getTable(ddl.Table.Name.String())
+	if err != nil {
+		return nil, err
+	}
+
+	cs := &structs.TruncateTableChangeSet{
+		DBName: db.Name,
+		Name:   t.Name,
+	}
+	return cs, nil
+}
+
+func (db *Database) ApplyTruncateTableChangeSet(cs *pbs.TruncateTableChangeSet) error {
+	t, err := db.getTable(cs.Name)
+	if err != nil {
+		return err
+	}
+	t.truncate()
+	return nil
+}
+
+// MakeAlterTableChangeSet makes the ChangeSet changing the column, which is validated with existing rows.
+func (db *Database) MakeAlterTableChangeSet(alt *AlterTable) (*pbs.ChangeSet, error) {
+	t, err := db.getTable(alt.Table.Name.String())
+	if err != nil {
+		return nil, err
+	}
+
+	switch alt.Action {
+	case AddColumnStr:
+		cs, err := t.makeAddColumnChangeSet(alt.Column)
+		if err != nil {
+			return nil, err
+		}
+		cs.DBName = db.Name
+		return &pbs.ChangeSet{Data: &pbs.ChangeSet_AddColumn{AddColumn: cs}}, nil
+	case DropColumnStr:
+		cs, err := t.makeDropColumnChangeSet(alt.Name)
+		if err != nil {
+			return nil, err
+		}
+		cs.DBName = db.Name
+		return &pbs.ChangeSet{Data: &pbs.ChangeSet_DropColumn{DropColumn: cs}}, nil
+	case RenameColumnStr:
+		cs, err := t.makeRenameColumnChangeSet(alt.Name, alt.NewName)
+		if err != nil {
+			return nil, err
+		}
+		cs.DBName = db.Name
+		return &pbs.ChangeSet{Data: &pbs.ChangeSet_RenameColumn{RenameColumn: cs}}, nil
+	case ModifyColumnStr:
+		cs, err := t.makeModifyColumnChangeSet(alt.Column)
+		if err != nil {
+			return nil, err
+		}
+		cs.DBName = db.Name
+		return &pbs.ChangeSet{Data: &pbs.ChangeSet_ModifyColumn{ModifyColumn: cs}}, nil
+	case AddIndexStr:
+		cs, err := t.makeCreateIndexChangeSet(alt.Index)
+		if err != nil {
+			return nil, err
+		}
+		cs.DBName = db.Name
+		return &pbs.ChangeSet{Data: &pbs.ChangeSet_CreateIndex{CreateIndex: cs}}, nil
+	case DropIndexStr:
+		cs, err := t.makeDropIndexChangeSet(alt.Name)
+		if err != nil {
+			return nil, err
+		}
+		cs.DBName = db.Name
+		return &pbs.ChangeSet{Data: &pbs.ChangeSet_DropIndex{DropIndex: cs}}, nil
+	default:
+		return nil, errors.Errorf("Not supported ALTER TABLE: %s", alt.Action)
+	}
+}
+
+func (db *Database) ApplyAddColumnChangeSet(cs *pbs.AddColumnChangeSet) error {
+	t, err := db.getTable(cs.TableName)
+	if err != nil {
+		return err
+	}
+	t.applyAddColumnChangeSet(cs)
+	return nil
+}
+
+func (db *Database) ApplyDropColumnChangeSet(cs *pbs.DropColumnChangeSet) error {
+	t, err := db.getTable(cs.TableName)
+	if err != nil {
+		return err
+	}
+	t.applyDropColumnChangeSet(cs)
+	return nil
+}
+
+func (db *Database) ApplyRenameColumnChangeSet(cs *pbs.RenameColumnChangeSet) error {
+	t, err := db.getTable(cs.TableName)
+	if err != nil {
+		return err
+	}
+	t.applyRenameColumnChangeSet(cs)
+	return nil
+}
+
+func (db *Database) ApplyModifyColumnChangeSet(cs *pbs.ModifyColumnChangeSet) error {
+	t, err := db.getTable(cs.TableName)
+	if err != nil {
+		return err
+	}
+	return t.applyModifyColumnChangeSet(cs)
+}
+
+func (db *Database) ApplyCreateIndexChangeSet(cs *pbs.CreateIndexChangeSet) error {
+	t, err := db.getTable(cs.TableName)
+	if err != nil {
+		return err
+	}
+	return t.applyCreateIndexChangeSet(cs)
+}
+
+func (db *Database) ApplyDropIndexChangeSet(cs *pbs.DropIndexChangeSet) error {
+	t, err := db.getTable(cs.TableName)
+	if err != nil {
+		return err
+	}
+	t.applyDropIndexChangeSet(cs)
+	return nil
+}
+
+func (db *Database) MakeRenameTableChangeSet(ddl *sqlparser.DDL) (*pbs.RenameTableChangeSet, error) {
+	t, err := db.getTable(ddl.Table.Name.String())
+	if err != nil {
+		return nil, err
+	}
+	if q := ddl.NewName.Qualifier.String(); q != "" && q != db.Name {
+		return nil, errors.Errorf("Table cannot be moved to another database: %s", q)
+	}
+	newName := ddl.NewName.Name.String()
+	if _, ok := db.tables[newName]; ok {
+		return nil, errors.Errorf("table already exists: %s.%s", db.Name, newName)
+	}
+
+	return &pbs.RenameTableChangeSet{
+		DBName:  db.Name,
+		Name:    t.Name,
+		NewName: newName,
+	}, nil
+}
+
+func (db *Database) ApplyRenameTableChangeSet(cs *pbs.RenameTableChangeSet) error {
+	t, err := db.getTable(cs.Name)
+	if err != nil {
+		return err
+	}
+	delete(db.tables, t.Name)
+	t.Name = cs.NewName
+	db.tables[t.Name] = t
+	return nil
+}
+
+// Drop drops all tables of the database.
+func (db *Database) Drop() {
+	for _, t := range db.tables {
+		t.drop()
+	}
+}
+
+// IsReadRowChanged tells whether the row read by a transaction has been changed or removed after the read.
+func (db *Database) IsReadRowChanged(rr *pbs.ReadRow) bool {
+	t, ok := db.tables[rr.TableName]
+	if !ok {
+		return true
+	}
+	return t.isReadRowChanged(rr)
+}
+
+// HasTable tells whether the table exists.
+func (db *Database) HasTable(tName string) bool {
+	_, ok := db.tables[tName]
+	return ok
+}
+
 func (db *Database) JoinRows(trx *Transaction, j sqlparser.JoinCondition, leftRows []*JoinRow, newTableName, alias string) ([]*JoinRow, error) {
 	var res []*JoinRow
 
@@ -68,6 +280,9 @@ func (db *Database) JoinRows(trx *Transaction, j sqlparser.JoinCondition, leftRo
 	}
 	for _, lRow := range leftRows {
 		for _, rRow := range t.rows {
+			if !rRow.isVisible(trx) {
+				continue
+			}
 			ok, err := eev.evaluateAliasJoin(trx, j.On, lRow, rRow, alias)
 			if err != nil {
 				return nil, err
@@ -95,6 +310,28 @@ func (db *Database) CreateInsertChangeSets(trx *Transaction, q *sqlparser.Insert
 	return cs, err
 }
 
+// InsertIdOf returns the first value given by AUTO_INCREMENT in cs made from q, or 0 when every value is given by q.
+func (db *Database) InsertIdOf(q *sqlparser.Insert, cs *pbs.InsertChangeSets) int64 {
+	t, err := db.getTable(q.Table.Name.String())
+	if err != nil || len(cs.Rows) == 0 {
+		return 0
+	}
+	given := map[string]bool{}
+	for _, c := range q.Columns {
+		given[c.String()] = true
+	}
+	for _, m := range t.rowMetas {
+		if m.ColumnType != types.AutoIncrementInt || given[m.Name] {
+			continue
+		}
+		id, err := strconv.ParseInt(cs.Rows[0].Columns[m.Name], 10, 64)
+		if err == nil {
+			return id
+		}
+	}
+	return 0
+}
+
 func (db *Database) ApplyInsertChangeSets(trx *Transaction, cs *pbs.InsertChangeSets) error {
 	if len(cs.Rows) == 0 {
 		return nil
@@ -109,6 +346,17 @@ func (db *Database) ApplyInsertChangeSets(trx *Transaction, cs *pbs.InsertChange
 	return t.ApplyInsertChangeSets(trx, cs.Rows)
 }
 
+// ObserveInsertChangeSets keeps AUTO_INCREMENT from giving values in cs even when cs is not applied.
+func (db *Database) ObserveInsertChangeSets(cs *pbs.InsertChangeSets) {
+	t, ok := db.tables[cs.TableName]
+	if !ok {
+		return
+	}
+	for _, row := range cs.Rows {
+		t.observeAutoIncrements(row.Columns)
+	}
+}
+
 func (db *Database) CreateUpdateChangeSets(trx *Transaction, q *sqlparser.Update, tName string) (*pbs.UpdateChangeSets, error) {
 	t, err := db.getTable(tName)
 	if err != nil {
@@ -137,6 +385,33 @@ func (db *Database) ApplyUpdateChangeSets(trx *Transaction, cs *pbs.UpdateChange
 	return t.ApplyUpdateChangeSets(trx, cs)
 }
 
+func (db *Database) CreateDeleteChangeSets(trx *Transaction, q *sqlparser.Delete, tName string) (*pbs.DeleteChangeSets, error) {
+	t, err := db.getTable(tName)
+	if err != nil {
+		return nil, err
+	}
+
+	cs, err := t.CreateDeleteChangeSets(trx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	cs.DBName = db.Name
+	return cs, nil
+}
+
+func (db *Database) ApplyDeleteChangeSets(trx *Transaction, cs *pbs.DeleteChangeSets) error {
+	if len(cs.Rows) == 0 {
+		return nil
+	}
+
+	t, err := db.getTable(cs.TableName)
+	if err != nil {
+		return err
+	}
+	return t.ApplyDeleteChangeSets(trx, cs)
+}
+
 func (db *Database) getTable(tName string) (*Table, error) {
 	t, ok := db.tables[tName]
 	if !ok {
@@ -2,6 +2,8 @@ package data
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/xwb1989/sqlparser"
@@ -38,13 +40,7 @@ func (eev *ExprEvaluator) evaluateJoinRow(trx *Transaction, expr sqlparser.Expr,
 			return false, err
 		}
 
-		if e.Operator == "=" {
-			return lVal == rVal, nil
-		} else if e.Operator == "!=" {
-			return lVal != rVal, nil
-		} else {
-			panic(fmt.Sprintf("not supported operator in WHERE: %s", e.Operator))
-		}
+		return evaluateOperator(e.Operator, lVal, rVal), nil
 	case *sqlparser.AndExpr:
 		left, err := eev.evaluateJoinRow(trx, e.Left, jRow)
 		if err != nil {
@@ -88,13 +84,7 @@ func (eev *ExprEvaluator) evaluateAliasJoin(trx *Transaction, expr sqlparser.Exp
 			return false, err
 		}
 
-		if e.Operator == "=" {
-			return lVal == rVal, nil
-		} else if e.Operator == "!=" {
-			return lVal != rVal, nil
-		} else {
-			panic(fmt.Sprintf("not supported operator in WHERE: %s", e.Operator))
-		}
+		return evaluateOperator(e.Operator, lVal, rVal), nil
 	case *sqlparser.AndExpr:
 		left, err := eev.evaluateAliasJoin(trx, e.Left, lRow, rRow, rAlias)
 		if err != nil {
@@ -134,6 +124,7 @@ func (eev *ExprEvaluator) evaluateAliasRow(trx *Transaction, alias string, expr
 	case *sqlparser.ComparisonExpr:
 		var lVal string
 		var rVal string
+		op := e.Operator
 		switch colE := e.Left.(type) {
 		case *sqlparser.ColName:
 			qName := colE.Qualifier.Name.String()
@@ -145,6 +136,8 @@ func (eev *ExprEvaluator) evaluateAliasRow(trx *Transaction, alias string, expr
 			lVal = r.Get(trx, colE.Name.String())
 		case *sqlparser.SQLVal:
 			rVal = string(colE.Val)
+			// The column is compared as the left operand
+			op = flipOperator(op)
 		}
 
 		switch colE := e.Right.(type) {
@@ -160,13 +153,7 @@ func (eev *ExprEvaluator) evaluateAliasRow(trx *Transaction, alias string, expr
 			rVal = string(colE.Val)
 		}
 
-		if e.Operator == "=" {
-			return lVal == rVal, nil
-		} else if e.Operator == "!=" {
-			return lVal != rVal, nil
-		} else {
-			panic(fmt.Sprintf("not supported operator in WHERE: %s", e.Operator))
-		}
+		return evaluateOperator(op, lVal, rVal), nil
 	case *sqlparser.AndExpr:
 		left, err := eev.evaluateAliasRow(trx, alias, e.Left, r)
 		if err != nil {
@@ -184,3 +171,57 @@ func (eev *ExprEvaluator) evaluateAliasRow(trx *Transaction, alias string, expr
 		panic("Not supported expression")
 	}
 }
+
+// evaluateOperator compares values by the operator of WHERE. Values are compared as numbers when both are integers.
+func evaluateOperator(op, lVal, rVal string) bool {
+	switch op {
+	case sqlparser.EqualStr:
+		return lVal == rVal
+	case sqlparser.NotEqualStr:
+		return lVal != rVal
+	}
+
+	c := compareValues(lVal, rVal)
+	switch op {
+	case sqlparser.LessThanStr:
+		return c < 0
+	case sqlparser.LessEqualStr:
+		return c <= 0
+	case sqlparser.GreaterThanStr:
+		return c > 0
+	case sqlparser.GreaterEqualStr:
+		return c >= 0
+	default:
+		panic(fmt.Sprintf("not supported operator in WHERE: %s", op))
+	}
+}
+
+func compareValues(lVal, rVal string) int {
+	lNum, lErr := strconv.ParseInt(lVal, 10, 64)
+	rNum, rErr := strconv.ParseInt(rVal, 10, 64)
+	if lErr != nil || rErr != nil {
+		return strings.Compare(lVal, rVal)
+	}
+	if lNum < rNum {
+		return -1
+	} else if lNum > rNum {
+		return 1
+	}
+	return 0
+}
+
+// flipOperator returns the operator used when operands are swapped.
+func flipOperator(op string) string {
+	switch op {
+	case sqlparser.LessThanStr:
+		return sqlparser.GreaterThanStr
+	case sqlparser.LessEqualStr:
+		return sqlparser.GreaterEqualStr
+	case sqlparser.GreaterThanStr:
+		return sqlparser.LessThanStr
+	case sqlparser.GreaterEqualStr:
+		return sqlparser.LessEqualStr
+	default:
+		return op
+	}
+}
@@ -1,5 +1,457 @@
 package data
 
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mrasu/ddb/server/data/types"
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/mrasu/ddb/server/structs"
+	"github.com/pkg/errors"
+	"github.com/xwb1989/sqlparser"
+)
+
+// Index is a secondary index ordering committed rows by values of columns.
+// Values in transactions are not indexed, so rows changed by the transaction are checked in addition to the found rows.
 type Index struct {
-	tree map[string]int64
+	Name    string
+	columns []string
+	// columnTypes decide the order of values
+	columnTypes []types.ColumnType
+	tree        *btree
+}
+
+// Ranks of indexValue. NULL comes first, and strings come after numbers in INT columns.
+const (
+	nullRank = iota
+	numberRank
+	stringRank
+)
+
+type indexValue struct {
+	rank int
+	num  int64
+	str  string
+}
+
+type indexKey []indexValue
+
+type indexEntry struct {
+	key  indexKey
+	rows []*Row
+}
+
+func newIndex(name string, columns []string, metas []*structs.RowMeta) (*Index, error) {
+	idx := &Index{
+		Name:    name,
+		columns: append([]string{}, columns...),
+	}
+	err := idx.reset(metas)
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// reset removes all rows and follows changes of columns.
+func (idx *Index) reset(metas []*structs.RowMeta) error {
+	var columnTypes []types.ColumnType
+	for _, c := range idx.columns {
+		var meta *structs.RowMeta
+		for _, m := range metas {
+			if m.Name == c {
+				meta = m
+				break
+			}
+		}
+		if meta == nil {
+			return errors.Errorf("Column doesn't exist: %s", c)
+		}
+		columnTypes = append(columnTypes, meta.ColumnType)
+	}
+
+	idx.columnTypes = columnTypes
+	idx.tree = newBtree(compareIndexKeys)
+	return nil
+}
+
+func (idx *Index) toMeta() *structs.IndexMeta {
+	return &structs.IndexMeta{
+		Name:    idx.Name,
+		Columns: append([]string{}, idx.columns...),
+	}
+}
+
+func (idx *Index) hasColumn(name string) bool {
+	for _, c := range idx.columns {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func newIndexValue(t types.ColumnType, value string, exists bool) indexValue {
+	if !exists {
+		return indexValue{rank: nullRank}
+	}
+	if t == types.Int || t == types.AutoIncrementInt {
+		if num, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return indexValue{rank: numberRank, num: num}
+		}
+	}
+	return indexValue{rank: stringRank, str: value}
+}
+
+// compareIndexKeys compares keys by the length of the shorter one so that a prefix bounds keys.
+func compareIndexKeys(a, b indexKey) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareIndexValues(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareIndexValues(a, b indexValue) int {
+	if a.rank != b.rank {
+		if a.rank < b.rank {
+			return -1
+		}
+		return 1
+	}
+	switch a.rank {
+	case numberRank:
+		if a.num < b.num {
+			return -1
+		} else if a.num > b.num {
+			return 1
+		}
+		return 0
+	case stringRank:
+		return strings.Compare(a.str, b.str)
+	default:
+		return 0
+	}
+}
+
+func (idx *Index) keyOf(columns map[string]string) indexKey {
+	key := make(indexKey, len(idx.columns))
+	for i, c := range idx.columns {
+		v, ok := columns[c]
+		key[i] = newIndexValue(idx.columnTypes[i], v, ok)
+	}
+	return key
+}
+
+func (idx *Index) add(r *Row) {
+	key := idx.keyOf(r.columns)
+	e := &indexEntry{key: key, rows: []*Row{r}}
+	if existing := idx.tree.insert(e); existing != nil {
+		existing.rows = append(existing.rows, r)
+	}
+}
+
+func (idx *Index) remove(r *Row) {
+	key := idx.keyOf(r.columns)
+	e := idx.tree.get(key)
+	if e == nil {
+		return
+	}
+	for i, row := range e.rows {
+		if row == r {
+			e.rows = append(e.rows[:i], e.rows[i+1:]...)
+			break
+		}
+	}
+	if len(e.rows) == 0 {
+		idx.tree.remove(key)
+	}
+}
+
+// scan calls fn with rows whose keys are between from and to.
+func (idx *Index) scan(from, to *btreeBound, fn func(*Row)) {
+	idx.tree.ascend(from, to, func(e *indexEntry) bool {
+		for _, r := range e.rows {
+			fn(r)
+		}
+		return true
+	})
+}
+
+// indexMetas returns definitions of indexes ordered by the name.
+func (t *Table) indexMetas() []*structs.IndexMeta {
+	var metas []*structs.IndexMeta
+	for _, name := range t.sortedIndexNames() {
+		metas = append(metas, t.indexes[name].toMeta())
+	}
+	return metas
+}
+
+// indexRow adds the row to indexes. Rows not committed yet don't have values and are not indexed.
+func (t *Table) indexRow(r *Row) {
+	if len(r.columns) == 0 {
+		return
+	}
+	for _, idx := range t.allIndexes() {
+		idx.add(r)
+	}
+}
+
+func (t *Table) unindexRow(r *Row) {
+	if len(r.columns) == 0 {
+		return
+	}
+	for _, idx := range t.allIndexes() {
+		idx.remove(r)
+	}
+}
+
+// allIndexes returns the index of the primary key and secondary indexes ordered by the name.
+func (t *Table) allIndexes() []*Index {
+	var indexes []*Index
+	if t.primaryIndex != nil {
+		indexes = append(indexes, t.primaryIndex)
+	}
+	for _, name := range t.sortedIndexNames() {
+		indexes = append(indexes, t.indexes[name])
+	}
+	return indexes
+}
+
+// rebuildIndexes indexes all rows again, which is used when columns or rows are changed at once.
+func (t *Table) rebuildIndexes() {
+	for _, idx := range t.allIndexes() {
+		err := idx.reset(t.rowMetas)
+		if err != nil {
+			// Columns used by indexes are not dropped
+			panic(fmt.Sprintf("index has unknown column: %v", err))
+		}
+		for _, r := range t.rows {
+			if len(r.columns) > 0 {
+				idx.add(r)
+			}
+		}
+	}
+}
+
+// indexPredicate is a comparison between a column and a constant in WHERE.
+type indexPredicate struct {
+	column   string
+	operator string
+	value    string
+}
+
+// collectIndexPredicates returns comparisons combined by AND. ok is false when expr has a condition combined by others like OR.
+func collectIndexPredicates(alias string, expr sqlparser.Expr) ([]*indexPredicate, bool) {
+	switch e := expr.(type) {
+	case *sqlparser.AndExpr:
+		left, ok := collectIndexPredicates(alias, e.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := collectIndexPredicates(alias, e.Right)
+		if !ok {
+			return nil, false
+		}
+		return append(left, right...), true
+	case *sqlparser.ParenExpr:
+		return collectIndexPredicates(alias, e.Expr)
+	case *sqlparser.ComparisonExpr:
+		col, lOk := e.Left.(*sqlparser.ColName)
+		val, rOk := e.Right.(*sqlparser.SQLVal)
+		op := e.Operator
+		if !lOk || !rOk {
+			col, lOk = e.Right.(*sqlparser.ColName)
+			val, rOk = e.Left.(*sqlparser.SQLVal)
+			op = flipOperator(op)
+		}
+		if !lOk || !rOk {
+			// Other conditions don't narrow rows
+			return nil, true
+		}
+		if q := col.Qualifier.Name.String(); q != "" && q != alias {
+			return nil, true
+		}
+		return []*indexPredicate{{column: col.Name.String(), operator: op, value: string(val.Val)}}, true
+	default:
+		return nil, false
+	}
+}
+
+// candidateRows returns rows which possibly match expr by using an index, or all rows when no index is usable.
+// Callers must check visibility and expr for each row.
+func (t *Table) candidateRows(trx *Transaction, alias string, expr sqlparser.Expr) []*Row {
+	preds, ok := collectIndexPredicates(alias, expr)
+	if !ok || len(preds) == 0 {
+		return t.rows
+	}
+
+	var rows []*Row
+	found := map[*Row]bool{}
+	collect := func(r *Row) {
+		if !found[r] {
+			found[r] = true
+			rows = append(rows, r)
+		}
+	}
+	if !t.scanIndex(preds, collect) {
+		return t.rows
+	}
+
+	// Values changed by transactions are not in indexes
+	var changed []*Row
+	for r := range trx.valueChangedRows {
+		if r.table == t && !found[r] {
+			changed = append(changed, r)
+		}
+	}
+	if len(changed) == 0 {
+		return rows
+	}
+	if t.primaryIndex == nil {
+		// Keep the order of rows without the primary key
+		changed = changed[:0]
+		for _, r := range t.rows {
+			if _, ok := trx.valueChangedRows[r]; ok && !found[r] {
+				changed = append(changed, r)
+			}
+		}
+		return append(rows, changed...)
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		ki := t.primaryIndex.keyOf(trx.getValueChangedRow(changed[i]).columns)
+		kj := t.primaryIndex.keyOf(trx.getValueChangedRow(changed[j]).columns)
+		return compareIndexKeys(ki, kj) < 0
+	})
+	return append(rows, changed...)
+}
+
+// scanIndex calls fn with rows of the index fitting preds best. It returns false when no index is usable.
+func (t *Table) scanIndex(preds []*indexPredicate, fn func(*Row)) bool {
+	equals := map[string]string{}
+	for _, p := range preds {
+		// NULL is read as an empty string, so empty strings cannot be searched by indexes
+		if p.operator == sqlparser.EqualStr && p.value != "" {
+			equals[p.column] = p.value
+		}
+	}
+
+	var best *Index
+	var bestKey indexKey
+	for _, idx := range t.allIndexes() {
+		var key indexKey
+		for i, c := range idx.columns {
+			v, ok := equals[c]
+			if !ok {
+				break
+			}
+			key = append(key, newIndexValue(idx.columnTypes[i], v, true))
+		}
+		if len(key) > len(bestKey) {
+			best, bestKey = idx, key
+		}
+	}
+	if best != nil {
+		bound := &btreeBound{key: bestKey, inclusive: true}
+		best.scan(bound, bound, fn)
+		return true
+	}
+
+	for _, idx := range t.allIndexes() {
+		if idx.scanRange(preds, fn) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Table) sortedIndexNames() []string {
+	var names []string
+	for name := range t.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// scanRange calls fn with rows in the range of the leading column given by preds.
+// It returns false when preds has no range of the column.
+func (idx *Index) scanRange(preds []*indexPredicate, fn func(*Row)) bool {
+	column := idx.columns[0]
+	isInt := idx.columnTypes[0] == types.Int || idx.columnTypes[0] == types.AutoIncrementInt
+
+	var from, to *btreeBound
+	for _, p := range preds {
+		if p.column != column || p.value == "" {
+			continue
+		}
+		// Values are compared as numbers only when both are integers
+		if _, err := strconv.ParseInt(p.value, 10, 64); (err == nil) != isInt {
+			continue
+		}
+
+		bound := &btreeBound{key: indexKey{newIndexValue(idx.columnTypes[0], p.value, true)}}
+		switch p.operator {
+		case sqlparser.GreaterThanStr, sqlparser.GreaterEqualStr:
+			bound.inclusive = p.operator == sqlparser.GreaterEqualStr
+			if from == nil || compareIndexKeys(bound.key, from.key) > 0 {
+				from = bound
+			}
+		case sqlparser.LessThanStr, sqlparser.LessEqualStr:
+			bound.inclusive = p.operator == sqlparser.LessEqualStr
+			if to == nil || compareIndexKeys(bound.key, to.key) < 0 {
+				to = bound
+			}
+		}
+	}
+	if from == nil && to == nil {
+		return false
+	}
+
+	idx.scan(from, to, fn)
+	if isInt && to != nil {
+		// Not numeric values are compared as strings, so they are checked by callers
+		idx.scan(&btreeBound{key: indexKey{{rank: stringRank}}, inclusive: true}, nil, fn)
+	}
+	return true
+}
+
+// toIndex builds the index defined by INDEX or KEY of CREATE TABLE and ALTER TABLE.
+func toIndex(def *sqlparser.IndexDefinition, metas []*structs.RowMeta) (*Index, error) {
+	if def.Info.Primary || def.Info.Unique || def.Info.Spatial {
+		return nil, errors.Errorf("Not supported index: %s", def.Info.Type)
+	}
+	var columns []string
+	for _, c := range def.Columns {
+		if c.Length != nil {
+			return nil, errors.Errorf("Prefix index is not supported: %s", c.Column.String())
+		}
+		columns = append(columns, c.Column.String())
+	}
+	return newIndex(def.Info.Name.String(), columns, metas)
+}
+
+func ToIndexMetas(metas []*pbs.IndexMeta) []*structs.IndexMeta {
+	var res []*structs.IndexMeta
+	for _, m := range metas {
+		res = append(res, &structs.IndexMeta{
+			Name:    m.Name,
+			Columns: m.Columns,
+		})
+	}
+	return res
+}
+
+func ToPbIndexMetas(metas []*structs.IndexMeta) []*pbs.IndexMeta {
+	var res []*pbs.IndexMeta
+	for _, m := range metas {
+		res = append(res, &pbs.IndexMeta{
+			Name:    m.Name,
+			Columns: m.Columns,
+		})
+	}
+	return res
 }
@@ -0,0 +1,98 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/mrasu/ddb/thelper"
+	"github.com/xwb1989/sqlparser"
+)
+
+func createIndexedTable(t *testing.T) *Table {
+	table := createDefaultTable()
+	alt, err := ParseAlterTable("CREATE INDEX idx_num ON hello.world (num, `text`)")
+	thelper.AssertNoError(t, err)
+	cs, err := table.makeCreateIndexChangeSet(alt.Index)
+	thelper.AssertNoError(t, err)
+	err = table.applyCreateIndexChangeSet(cs)
+	thelper.AssertNoError(t, err)
+	return table
+}
+
+func candidateIds(t *testing.T, table *Table, trx *Transaction, where string) []string {
+	stmt := ParseSQL(t, "SELECT * FROM world WHERE "+where).(*sqlparser.Select)
+	var ids []string
+	for _, r := range table.candidateRows(trx, "world", stmt.Where.Expr) {
+		ids = append(ids, r.Get(trx, "id"))
+	}
+	return ids
+}
+
+func TestTable_CandidateRows(t *testing.T) {
+	table := createIndexedTable(t)
+	trx := CreateImmediateTransaction()
+
+	for _, c := range []struct {
+		where string
+		ids   []string
+	}{
+		{"num = 10", []string{"1"}},
+		{"20 = num AND `text` = 't2'", []string{"2"}},
+		{"num = 10 AND `text` = 't2'", nil},
+		{"num > 10", []string{"2"}},
+		{"num >= 5 AND num < 20", []string{"1"}},
+		{"world.num <= 20", []string{"1", "2"}},
+	} {
+		ids := candidateIds(t, table, trx, c.where)
+		thelper.AssertInt(t, "Invalid candidates for "+c.where, len(c.ids), len(ids))
+		for i, id := range ids {
+			thelper.AssertString(t, "Invalid candidate for "+c.where, c.ids[i], id)
+		}
+	}
+
+	// Index is not used
+	for _, where := range []string{"num = 10 OR num = 20", "`text` = 't1'", "num != 10", "num > 'a'"} {
+		ids := candidateIds(t, table, trx, where)
+		thelper.AssertInt(t, "Not all rows for "+where, 2, len(ids))
+	}
+}
+
+func TestTable_CandidateRows_Transaction(t *testing.T) {
+	table := createIndexedTable(t)
+	trx := StartNewTransaction()
+
+	err := table.rows[0].Update(trx, map[string]string{"num": "30"})
+	thelper.AssertNoError(t, err)
+
+	// The value changed by trx is not indexed but found
+	ids := candidateIds(t, table, trx, "num = 30")
+	thelper.AssertInt(t, "Changed row is not found", 1, len(ids))
+	thelper.AssertString(t, "Invalid row", "1", ids[0])
+
+	err = trx.ApplyCommitChangeSet(trx.CreateCommitChangeSet(), func(*pbs.CommitChangeSet) error { return nil })
+	thelper.AssertNoError(t, err)
+
+	ids = candidateIds(t, table, CreateImmediateTransaction(), "num = 30")
+	thelper.AssertInt(t, "Committed row is not indexed", 1, len(ids))
+	ids = candidateIds(t, table, CreateImmediateTransaction(), "num = 10")
+	thelper.AssertInt(t, "Former value remains", 0, len(ids))
+}
+
+func TestTable_Index_AlterTable(t *testing.T) {
+	table := createIndexedTable(t)
+
+	_, err := table.makeDropColumnChangeSet("num")
+	thelper.AssertBool(t, "Indexed column is dropped", true, err != nil)
+
+	cs, err := table.makeRenameColumnChangeSet("num", "number")
+	thelper.AssertNoError(t, err)
+	table.applyRenameColumnChangeSet(cs)
+	thelper.AssertString(t, "Index column is not renamed", "number", CopyIndexMetas(table)[0].Columns[0])
+	ids := candidateIds(t, table, CreateImmediateTransaction(), "number = 20")
+	thelper.AssertInt(t, "Row is not found by renamed column", 1, len(ids))
+
+	dcs, err := table.makeDropIndexChangeSet("idx_num")
+	thelper.AssertNoError(t, err)
+	table.applyDropIndexChangeSet(dcs)
+	thelper.AssertInt(t, "Index is not dropped", 0, len(CopyIndexMetas(table)))
+}
@@ -0,0 +1,239 @@
+package data
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/mrasu/ddb/server/data/types"
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/mrasu/ddb/server/structs"
+	"github.com/pkg/errors"
+	"github.com/xwb1989/sqlparser"
+)
+
+const primaryIndexName = "PRIMARY"
+
+// colKeyPrimary is the ColumnKeyOption of `PRIMARY KEY` in a column definition, which is not exported by sqlparser
+const colKeyPrimary = sqlparser.ColumnKeyOption(1)
+
+// defaultPrimaryKey returns the primary key of tables defined without PRIMARY KEY, which is `id` when it exists.
+func defaultPrimaryKey(metas []*structs.RowMeta) []string {
+	for _, m := range metas {
+		if m.Name == PrimaryKeyName {
+			return []string{PrimaryKeyName}
+		}
+	}
+	return nil
+}
+
+// primaryKeyFromSpec returns columns given by PRIMARY KEY of CREATE TABLE.
+func primaryKeyFromSpec(spec *sqlparser.TableSpec) ([]string, error) {
+	var columns []string
+	for _, c := range spec.Columns {
+		if c.Type.KeyOpt == colKeyPrimary {
+			if columns != nil {
+				return nil, errors.New("Multiple primary key defined")
+			}
+			columns = []string{c.Name.String()}
+		}
+	}
+	for _, def := range spec.Indexes {
+		if !def.Info.Primary {
+			continue
+		}
+		if columns != nil {
+			return nil, errors.New("Multiple primary key defined")
+		}
+		for _, c := range def.Columns {
+			if c.Length != nil {
+				return nil, errors.Errorf("Prefix index is not supported: %s", c.Column.String())
+			}
+			columns = append(columns, c.Column.String())
+		}
+	}
+	return columns, nil
+}
+
+// setPrimaryKey makes the index of the primary key. Tables without primary key cannot be updated nor deleted.
+func (t *Table) setPrimaryKey(columns []string) error {
+	if len(columns) == 0 {
+		t.primaryKey = nil
+		t.primaryIndex = nil
+		return nil
+	}
+
+	idx, err := newIndex(primaryIndexName, columns, t.rowMetas)
+	if err != nil {
+		return err
+	}
+	t.primaryKey = append([]string{}, columns...)
+	t.primaryIndex = idx
+	return nil
+}
+
+func (t *Table) isPrimaryKeyColumn(name string) bool {
+	for _, c := range t.primaryKey {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Table) requirePrimaryKey() error {
+	if t.primaryIndex == nil {
+		return errors.Errorf("Table doesn't have primary key: %s", t.Name)
+	}
+	return nil
+}
+
+// primaryKeyValues returns values of the primary key seen by trx.
+func (r *Row) primaryKeyValues(trx *Transaction) []string {
+	var values []string
+	for _, c := range r.table.primaryKey {
+		values = append(values, r.Get(trx, c))
+	}
+	return values
+}
+
+// primaryKeyString is the text of values shown in errors, e.g. `1-foo`.
+func primaryKeyString(values []string) string {
+	return strings.Join(values, "-")
+}
+
+// legacyPrimaryId returns the value of PrimaryKeyId, which is written only when the primary key is a single INT column.
+func (t *Table) legacyPrimaryId(values []string) int64 {
+	if len(values) != 1 {
+		return 0
+	}
+	if t := t.primaryIndex.columnTypes[0]; t != types.Int && t != types.AutoIncrementInt {
+		return 0
+	}
+	id, _ := strconv.ParseInt(values[0], 10, 64)
+	return id
+}
+
+// primaryKeyOf returns values of the primary key in a ChangeSet. Records written by older versions have only the id.
+func (t *Table) primaryKeyOf(values []string, id int64) []string {
+	if len(values) > 0 {
+		return values
+	}
+	return []string{strconv.FormatInt(id, 10)}
+}
+
+func (idx *Index) keyOfValues(values []string) indexKey {
+	key := make(indexKey, len(idx.columns))
+	for i := range idx.columns {
+		if i < len(values) {
+			key[i] = newIndexValue(idx.columnTypes[i], values[i], true)
+		} else {
+			key[i] = newIndexValue(idx.columnTypes[i], "", false)
+		}
+	}
+	return key
+}
+
+// findRowByPrimaryKey returns the row seen by trx which has the primary key, or nil.
+func (t *Table) findRowByPrimaryKey(trx *Transaction, values []string) *Row {
+	if t.primaryIndex == nil {
+		return nil
+	}
+	key := t.primaryIndex.keyOfValues(values)
+
+	found := t.findRowByPrimaryIndexKey(trx, key)
+	if found != nil {
+		// Apply of the change requires the version read by trx
+		trx.addValueReadRow(found, found.version)
+	}
+	return found
+}
+
+func (t *Table) findRowByPrimaryIndexKey(trx *Transaction, key indexKey) *Row {
+	if e := t.primaryIndex.tree.get(key); e != nil {
+		for _, r := range e.rows {
+			if _, ok := r.changedTransactions[trx]; !ok && r.isVisible(trx) {
+				return r
+			}
+		}
+	}
+
+	// Values changed by transactions are not in the index
+	for r, valueChangedRow := range trx.valueChangedRows {
+		if r.table != t || valueChangedRow.deleted {
+			continue
+		}
+		if compareIndexKeys(t.primaryIndex.keyOf(valueChangedRow.columns), key) == 0 {
+			return r
+		}
+	}
+	return nil
+}
+
+// isReadRowChanged tells whether the committed row of the primary key in rr has another value than the one read.
+func (t *Table) isReadRowChanged(rr *pbs.ReadRow) bool {
+	if t.primaryIndex == nil {
+		return true
+	}
+	if e := t.primaryIndex.tree.get(t.primaryIndex.keyOfValues(rr.PrimaryKey)); e != nil {
+		for _, r := range e.rows {
+			if r.lsn == rr.Lsn {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validatePrimaryKeys returns error when rows of keys have the same primary key, or another row seen by trx has it.
+// excluded is rows whose keys are changed to keys.
+func (t *Table) validatePrimaryKeys(trx *Transaction, keys []map[string]string, excluded map[*Row]bool) error {
+	if t.primaryIndex == nil {
+		return nil
+	}
+
+	given := newBtree(compareIndexKeys)
+	for _, columns := range keys {
+		var values []string
+		for _, c := range t.primaryKey {
+			v, ok := columns[c]
+			if !ok {
+				return errors.Errorf("Primary key cannot be NULL: %s", c)
+			}
+			values = append(values, v)
+		}
+
+		key := t.primaryIndex.keyOfValues(values)
+		if given.insert(&indexEntry{key: key}) != nil {
+			return errors.Errorf("Duplicate entry '%s' for key 'PRIMARY'", primaryKeyString(values))
+		}
+		if r := t.findRowByPrimaryIndexKey(trx, key); r != nil && !excluded[r] {
+			return errors.Errorf("Duplicate entry '%s' for key 'PRIMARY'", primaryKeyString(values))
+		}
+	}
+	return nil
+}
+
+// duplicatesPrimaryKey tells whether a row changed by trx has the primary key of a row committed by another transaction.
+func (trx *Transaction) duplicatesPrimaryKey() bool {
+	for r, valueChangedRow := range trx.valueChangedRows {
+		idx := r.table.primaryIndex
+		if idx == nil || valueChangedRow.deleted {
+			continue
+		}
+		e := idx.tree.get(idx.keyOf(valueChangedRow.columns))
+		if e == nil {
+			continue
+		}
+		for _, other := range e.rows {
+			if other == r {
+				continue
+			}
+			// The row loses the key on the commit
+			if v := trx.getValueChangedRow(other); v != nil && (v.deleted || compareIndexKeys(idx.keyOf(v.columns), e.key) != 0) {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
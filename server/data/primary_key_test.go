@@ -0,0 +1,135 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/mrasu/ddb/thelper"
+	"github.com/xwb1989/sqlparser"
+)
+
+func createScoreTable(t *testing.T) *Table {
+	ddl := ParseSQL(t, "CREATE TABLE scores(name varchar(10), game int, score int, PRIMARY KEY (name, game))").(*sqlparser.DDL)
+	table, err := buildTable(ddl)
+	thelper.AssertNoError(t, err)
+
+	stmt := ParseSQL(t, "INSERT INTO scores(name, game, score) VALUES ('alice', 1, 10), ('alice', 2, 20), ('bob', 1, 30)").(*sqlparser.Insert)
+	trx := CreateImmediateTransaction()
+	cs, err := table.CreateInsertChangeSets(trx, stmt)
+	thelper.AssertNoError(t, err)
+	err = table.ApplyInsertChangeSets(trx, cs.Rows)
+	thelper.AssertNoError(t, err)
+	return table
+}
+
+func TestBuildTable_PrimaryKey(t *testing.T) {
+	table := createScoreTable(t)
+	thelper.AssertInt(t, "Invalid primary key", 2, len(table.primaryKey))
+	thelper.AssertString(t, "Invalid primary key", "game", table.primaryKey[1])
+
+	ddl := ParseSQL(t, "CREATE TABLE t(code varchar(3) PRIMARY KEY, id int)").(*sqlparser.DDL)
+	table, err := buildTable(ddl)
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Invalid primary key", 1, len(table.primaryKey))
+	thelper.AssertString(t, "id is used as the primary key", "code", table.primaryKey[0])
+
+	ddl = ParseSQL(t, "CREATE TABLE t(id int, message varchar(3))").(*sqlparser.DDL)
+	table, err = buildTable(ddl)
+	thelper.AssertNoError(t, err)
+	thelper.AssertString(t, "id is not the primary key", "id", table.primaryKey[0])
+
+	for _, sql := range []string{
+		"CREATE TABLE t(a int PRIMARY KEY, b int, PRIMARY KEY (b))",
+		"CREATE TABLE t(a int, PRIMARY KEY (b))",
+	} {
+		_, err = buildTable(ParseSQL(t, sql).(*sqlparser.DDL))
+		thelper.AssertBool(t, "No error for "+sql, true, err != nil)
+	}
+}
+
+func TestTable_CreateInsertChangeSets_DuplicatedPrimaryKey(t *testing.T) {
+	table := createScoreTable(t)
+	trx := CreateImmediateTransaction()
+
+	for _, sql := range []string{
+		"INSERT INTO scores(name, game) VALUES ('alice', 1)",
+		"INSERT INTO scores(name, game) VALUES ('carol', 1), ('carol', 1)",
+		"INSERT INTO scores(name, score) VALUES ('carol', 1)",
+	} {
+		_, err := table.CreateInsertChangeSets(trx, ParseSQL(t, sql).(*sqlparser.Insert))
+		thelper.AssertBool(t, "No error for "+sql, true, err != nil)
+	}
+
+	_, err := table.CreateInsertChangeSets(trx, ParseSQL(t, "INSERT INTO scores(name, game) VALUES ('alice', 3)").(*sqlparser.Insert))
+	thelper.AssertNoError(t, err)
+}
+
+func TestTable_ApplyUpdateChangeSets_CompositePrimaryKey(t *testing.T) {
+	table := createScoreTable(t)
+	trx := CreateImmediateTransaction()
+
+	stmt := ParseSQL(t, "UPDATE scores SET game = 3, score = 25 WHERE name = 'alice' AND game = 2").(*sqlparser.Update)
+	cs, err := table.CreateUpdateChangeSets(trx, stmt)
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Invalid changeset size", 1, len(cs.Rows))
+	thelper.AssertString(t, "Invalid primary key", "alice", cs.Rows[0].PrimaryKey[0])
+	thelper.AssertString(t, "Invalid primary key", "2", cs.Rows[0].PrimaryKey[1])
+	thelper.AssertInt64(t, "PrimaryKeyId is given to composite key", 0, cs.Rows[0].PrimaryKeyId)
+
+	err = table.ApplyUpdateChangeSets(trx, cs)
+	thelper.AssertNoError(t, err)
+	thelper.AssertBool(t, "Former key remains", true, table.findRowByPrimaryKey(trx, []string{"alice", "2"}) == nil)
+	r := table.findRowByPrimaryKey(trx, []string{"alice", "3"})
+	thelper.AssertString(t, "Row is not found by the new key", "25", r.Get(trx, "score"))
+
+	stmt = ParseSQL(t, "UPDATE scores SET game = 1 WHERE name = 'alice' AND game = 3").(*sqlparser.Update)
+	_, err = table.CreateUpdateChangeSets(trx, stmt)
+	thelper.AssertBool(t, "Primary key is updated to the existing one", true, err != nil)
+}
+
+func TestTable_isReadRowChanged(t *testing.T) {
+	table := createScoreTable(t)
+	table.dbName = "hello"
+
+	trx := StartNewTransaction()
+	r := table.findRowByPrimaryKey(trx, []string{"alice", "1"})
+	thelper.AssertString(t, "Invalid score", "10", r.Get(trx, "score"))
+
+	cs := trx.CreateCommitChangeSet()
+	thelper.AssertInt(t, "Invalid read rows", 1, len(cs.ReadRows))
+	rr := cs.ReadRows[0]
+	thelper.AssertString(t, "Invalid database", "hello", rr.DBName)
+	thelper.AssertString(t, "Invalid table", "scores", rr.TableName)
+	thelper.AssertString(t, "Invalid primary key", "alice-1", primaryKeyString(rr.PrimaryKey))
+	thelper.AssertBool(t, "Row is changed without update", false, table.isReadRowChanged(rr))
+
+	imm := CreateImmediateTransaction()
+	imm.CommitLsn = 5
+	thelper.AssertNoError(t, r.Update(imm, map[string]string{"score": "11"}))
+	thelper.AssertBool(t, "Updated row is not changed", true, table.isReadRowChanged(rr))
+
+	rr = &pbs.ReadRow{DBName: "hello", TableName: "scores", PrimaryKey: []string{"carol", "1"}}
+	thelper.AssertBool(t, "Missing row is not changed", true, table.isReadRowChanged(rr))
+}
+
+func TestTransaction_ExpandLock_DuplicatedPrimaryKey(t *testing.T) {
+	table := createScoreTable(t)
+	stmt := ParseSQL(t, "INSERT INTO scores(name, game) VALUES ('carol', 1)").(*sqlparser.Insert)
+
+	trx1 := StartNewTransaction()
+	trx2 := StartNewTransaction()
+	for _, trx := range []*Transaction{trx1, trx2} {
+		cs, err := table.CreateInsertChangeSets(trx, stmt)
+		thelper.AssertNoError(t, err)
+		err = table.ApplyInsertChangeSets(trx, cs.Rows)
+		thelper.AssertNoError(t, err)
+	}
+
+	err := trx1.ApplyCommitChangeSet(trx1.CreateCommitChangeSet(), func(*pbs.CommitChangeSet) error { return nil })
+	thelper.AssertNoError(t, err)
+
+	err = trx2.expandLock()
+	if _, ok := err.(*TransactionConflictError); !ok {
+		t.Errorf("Duplicated primary key doesn't conflict: %v", err)
+	}
+}
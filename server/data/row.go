@@ -13,9 +13,16 @@ type Row struct {
 
 	isCommittedRow bool
 	version        int
+	// lsn is the LSN of the ChangeSet which committed the value.
+	// Nodes compare it with ReadRows of COMMIT to find rows changed after they are read.
+	lsn int64
+	// deleted marks the valueChangedRow of a row deleted by the transaction
+	deleted bool
+	// schemaVersion is the one of the table when the row is made
+	schemaVersion int
 }
 
-// TODO: dynamic name
+// PrimaryKeyName is the primary key of tables defined without PRIMARY KEY
 const PrimaryKeyName = "id"
 
 func newEmptyRow(table *Table) *Row {
@@ -26,6 +33,7 @@ func newEmptyRow(table *Table) *Row {
 
 		version:        0,
 		isCommittedRow: true,
+		schemaVersion:  table.schemaVersion,
 	}
 }
 
@@ -33,6 +41,7 @@ func CreateRow(trx *Transaction, t *Table, columns map[string]string) *Row {
 	r := newEmptyRow(t)
 	if trx.IsImmediate() {
 		r.columns = columns
+		r.lsn = trx.CommitLsn
 		return r
 	}
 
@@ -63,10 +72,24 @@ func (r *Row) Get(trx *Transaction, name string) string {
 	}
 }
 
+// isVisible tells whether trx can see the row.
+// Rows inserted by other transactions in progress and rows deleted by trx are hidden.
+func (r *Row) isVisible(trx *Transaction) bool {
+	if _, ok := r.changedTransactions[trx]; ok {
+		return !trx.getValueChangedRow(r).deleted
+	}
+	return len(r.columns) > 0
+}
+
+// GetPrimaryId returns the value of the primary key of a single INT column.
 func (r *Row) GetPrimaryId(trx *Transaction) int64 {
-	num, err := strconv.Atoi(r.Get(trx, PrimaryKeyName))
+	name := PrimaryKeyName
+	if len(r.table.primaryKey) == 1 {
+		name = r.table.primaryKey[0]
+	}
+	num, err := strconv.Atoi(r.Get(trx, name))
 	if err != nil {
-		panic(fmt.Sprintf("Cannot convert PrimaryKey to Number: %s", r.columns[PrimaryKeyName]))
+		panic(fmt.Sprintf("Cannot convert PrimaryKey to Number: %s", r.columns[name]))
 	}
 	return int64(num)
 }
@@ -114,25 +137,72 @@ func (r *Row) update(trx *Transaction, values map[string]string) {
 		if r.version != trx.valueReadRows[r] {
 			panic("row version mismatch")
 		}
+		r.saveForSnapshot()
+		r.table.unindexRow(r)
+		defer r.table.indexRow(r)
 	}
 
 	for name, value := range values {
 		r.columns[name] = value
 	}
 	r.version += 1
+	r.lsn = trx.CommitLsn
+}
+
+// Delete removes the row. Other transactions still see it until trx is committed.
+func (r *Row) Delete(trx *Transaction) error {
+	if trx.IsImmediate() {
+		err := trx.expandLock()
+		if err != nil {
+			return err
+		}
+		defer trx.shrinkLock()
+		r.delete(trx)
+		return nil
+	}
+
+	valueChangedRow := r.ensureValueChangedRow(trx, r.table)
+	valueChangedRow.deleted = true
+	return nil
+}
+
+// delete removes the row from the table.
+// Transactions which read the row conflict on commit because its version changes.
+func (r *Row) delete(trx *Transaction) {
+	if r.isCommittedRow == true {
+		if r.version != trx.valueReadRows[r] {
+			panic("row version mismatch")
+		}
+		r.saveForSnapshot()
+	}
+
+	r.version += 1
+	r.lsn = trx.CommitLsn
+	r.table.remove(r)
+}
+
+// saveForSnapshot gives the committed value to the snapshot being copied before it is changed.
+func (r *Row) saveForSnapshot() {
+	if c := r.table.copier; c != nil {
+		c.save(r)
+	}
 }
 
 func (r *Row) commitValueChangedRow(trx *Transaction, valueChangedRow *Row) {
-	if r.GetPrimaryId(trx) != valueChangedRow.GetPrimaryId(trx) {
+	if trx.getValueChangedRow(r) != valueChangedRow {
 		panic("row has invalid valueChangedRow")
 	}
 
-	r.update(trx, valueChangedRow.columns)
+	if valueChangedRow.deleted {
+		r.delete(trx)
+	} else {
+		r.update(trx, valueChangedRow.columns)
+	}
 	delete(r.changedTransactions, trx)
 }
 
 func (r *Row) abortValueChangedRow(trx *Transaction, valueChangedRow *Row) {
-	if r.GetPrimaryId(trx) != valueChangedRow.GetPrimaryId(trx) {
+	if trx.getValueChangedRow(r) != valueChangedRow {
 		panic("row has invalid valueChangedRow")
 	}
 	delete(r.changedTransactions, trx)
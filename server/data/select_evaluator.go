@@ -8,13 +8,22 @@ import (
 	"github.com/xwb1989/sqlparser"
 )
 
-type SelectEvaluator struct{}
+type SelectEvaluator struct {
+	// emptyRow joins empty rows of tables in FROM, which gives column names when no row is selected.
+	emptyRow *JoinRow
+}
 
 func (sev *SelectEvaluator) ToResult(trx *Transaction, root *sqlparser.Select, joinRows []*JoinRow) *structs.Result {
+	if len(joinRows) == 0 && sev.emptyRow == nil {
+		return structs.NewEmptyResult()
+	}
+	columnRow := sev.emptyRow
+	if len(joinRows) > 0 {
+		columnRow = joinRows[0]
+	}
 	sev2 := SelectExprEvaluator{}
-	// TODO: Work when zero row
-	qCols := sev2.GetColumns(root.SelectExprs, joinRows[0])
-	var values [][]string
+	qCols := sev2.GetColumns(root.SelectExprs, columnRow)
+	values := [][]string{}
 	for _, r := range joinRows {
 		var val []string
 		for _, col := range qCols {
@@ -52,9 +61,17 @@ func (sev *SelectEvaluator) SelectTable(trx *Transaction, root *sqlparser.Select
 		if err != nil {
 			return nil, err
 		}
+		sev.emptyRow = NewJoinedRow(tAlias, newEmptyRow(t))
 		var rows []*Row
 		eev := ExprEvaluator{}
-		for _, r := range t.rows {
+		cands := t.rows
+		if root.Where != nil {
+			cands = t.candidateRows(trx, tAlias, root.Where.Expr)
+		}
+		for _, r := range cands {
+			if !r.isVisible(trx) {
+				continue
+			}
 			if root.Where != nil {
 				ok, err := eev.evaluateAliasRow(trx, tAlias, root.Where.Expr, r)
 
@@ -93,14 +110,21 @@ func (sev *SelectEvaluator) SelectTable(trx *Transaction, root *sqlparser.Select
 		if err != nil {
 			return nil, err
 		}
+		rt, err := db.getTable(rTable.Name.String())
+		if err != nil {
+			return nil, err
+		}
+		sev.emptyRow = sev.emptyRow.AddRow(right.As.String(), newEmptyRow(rt))
 
 		ev := ExprEvaluator{}
 		joinRows, err = ev.FilterJoinRows(trx, root.Where.Expr, joinRows)
 		if err != nil {
 			return nil, err
 		}
+		if len(joinRows) == 0 {
+			return joinRows, nil
+		}
 		sev := SelectExprEvaluator{}
-		// TODO: Work when zero row
 		qCols := sev.GetColumns(root.SelectExprs, joinRows[0])
 		var values [][]string
 		for _, r := range joinRows {
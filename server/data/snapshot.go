@@ -1,113 +1,281 @@
 package data
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/mrasu/ddb/server/structs"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 )
 
+const (
+	snapshotPrefix = "snapshot_"
+	snapshotSuffix = ".snap"
+
+	legacySnapshotFileName = "snapshot.log"
+)
+
+// A snapshot file starts with crc32c of the rest
+const snapshotHeaderSize = 4
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// DefaultSnapshotRetention is the number of snapshots kept by default.
+const DefaultSnapshotRetention = 3
+
 type Snapshot struct {
 	data *structs.SData
 }
 
-func TakeSnapshot(lsn int64, dbs []*Database) *Snapshot {
-	var databases []*structs.SDatabase
-	for _, db := range dbs {
-		var tables []*structs.STable
-		for _, t := range db.tables {
-			var rows []*structs.SRow
-			for _, r := range t.rows {
-				rows = append(rows, &structs.SRow{
-					Columns: r.columns,
-				})
-			}
+// TakeSnapshot copies committed rows of dbs. Callers must keep dbs unchanged until it returns.
+// The copy is not shared with dbs so that it can be saved while dbs are changed.
+// replayLsn is where recovery starts reading the WAL to find transactions in progress.
+func TakeSnapshot(lsn, replayLsn int64, dbs []*Database) *Snapshot {
+	return BeginSnapshot(lsn, replayLsn, dbs).Copy()
+}
 
-			var indexes []*structs.SIndex
-			for name, i := range t.indexes {
-				indexes = append(indexes, &structs.SIndex{
-					Name: name,
-					Tree: i.tree,
-				})
-			}
+// RecoverSnapshot reads the newest valid snapshot under dir.
+// Broken snapshots, e.g. written partially by a crash, are skipped to use older ones.
+// The error satisfies os.IsNotExist when dir has no snapshot.
+func RecoverSnapshot(dir string) (*Snapshot, error) {
+	return RecoverSnapshotIf(dir, nil)
+}
 
-			t := &structs.STable{
-				Name:     t.Name,
-				RowMetas: t.rowMetas,
-				Rows:     rows,
-				Indexes:  indexes,
-			}
-			tables = append(tables, t)
+// RecoverSnapshotIf reads the newest valid snapshot under dir accepted by fn.
+// The error satisfies os.IsNotExist when no snapshot is accepted.
+func RecoverSnapshotIf(dir string, fn func(*Snapshot) bool) (*Snapshot, error) {
+	lsns, err := ListSnapshotLsns(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(lsns) == 0 {
+		ss, err := recoverLegacySnapshot(dir)
+		if err != nil {
+			return nil, err
 		}
+		if fn != nil && !fn(ss) {
+			return nil, &os.PathError{Op: "recover", Path: dir, Err: os.ErrNotExist}
+		}
+		return ss, nil
+	}
 
-		databases = append(databases, &structs.SDatabase{
-			Name:   db.Name,
-			Tables: tables,
-		})
+	broken := 0
+	for i := len(lsns) - 1; i >= 0; i-- {
+		ss, err := readSnapshotFile(snapshotFileName(dir, lsns[i]))
+		if err != nil {
+			log.Warn().Err(err).Int64("lsn", lsns[i]).Msg("skipping broken snapshot")
+			broken++
+			continue
+		}
+		if fn != nil && !fn(ss) {
+			continue
+		}
+		return ss, nil
+	}
+	if broken == len(lsns) {
+		return nil, errors.Errorf("no valid snapshot in %s", dir)
 	}
+	return nil, &os.PathError{Op: "recover", Path: dir, Err: os.ErrNotExist}
+}
 
-	return &Snapshot{
-		data: &structs.SData{
-			Lsn:       lsn,
-			Databases: databases,
-		},
+// ReadSnapshot reads the snapshot at lsn under dir and verifies its checksum.
+func ReadSnapshot(dir string, lsn int64) (*Snapshot, error) {
+	return readSnapshotFile(snapshotFileName(dir, lsn))
+}
+
+// OldestReplayLsn returns the smallest ReplayLsn of valid snapshots under dir, which is the one of the oldest snapshot.
+// It returns -1 when there is no valid snapshot.
+func OldestReplayLsn(dir string) (int64, error) {
+	lsns, err := ListSnapshotLsns(dir)
+	if err != nil {
+		return 0, err
+	}
+	for _, lsn := range lsns {
+		ss, err := readSnapshotFile(snapshotFileName(dir, lsn))
+		if err != nil {
+			continue
+		}
+		return ss.ReplayLsn(), nil
 	}
+	return -1, nil
 }
 
-func RecoverSnapshot(dir string) (*Snapshot, error) {
+// ArchiveSnapshotsAfter moves snapshots whose LSN is larger than lsn to archiveDir.
+func ArchiveSnapshotsAfter(dir string, lsn int64, archiveDir string) error {
+	lsns, err := ListSnapshotLsns(dir)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(archiveDir, 0700)
+	if err != nil {
+		return err
+	}
+	for _, l := range lsns {
+		if l <= lsn {
+			continue
+		}
+		path := snapshotFileName(dir, l)
+		err = os.Rename(path, filepath.Join(archiveDir, filepath.Base(path)))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to archive snapshot")
+		}
+	}
+	return nil
+}
+
+// ListSnapshotLsns returns LSNs of snapshots under dir in ascending order.
+func ListSnapshotLsns(dir string) ([]int64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var lsns []int64
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotSuffix) {
+			continue
+		}
+		lsn, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		lsns = append(lsns, lsn)
+	}
+	sort.Slice(lsns, func(i, j int) bool { return lsns[i] < lsns[j] })
+	return lsns, nil
+}
+
+func copyColumns(columns map[string]string) map[string]string {
+	c := map[string]string{}
+	for k, v := range columns {
+		c[k] = v
+	}
+	return c
+}
+
+// UnmarshalSnapshot restores the Snapshot encoded by Marshal.
+func UnmarshalSnapshot(bs []byte) (*Snapshot, error) {
 	ss := &Snapshot{data: &structs.SData{}}
-	err := ss.buildSnapshotFromFile(dir)
+	err := json.Unmarshal(bs, ss.data)
 	if err != nil {
 		return nil, err
 	}
 	return ss, nil
 }
 
-func (ss *Snapshot) Save(dir string) error {
-	bs, err := json.Marshal(ss.data)
+func (ss *Snapshot) Marshal() ([]byte, error) {
+	return json.Marshal(ss.data)
+}
 
-	file, err := os.OpenFile(ss.fileName(dir), os.O_WRONLY|os.O_CREATE, 0600)
+// Save writes the snapshot to `snapshot_<lsn>.snap` under dir and removes old snapshots except the newest keep ones.
+// The file is renamed after it is written so that a crash never leaves a partial snapshot with the name.
+func (ss *Snapshot) Save(dir string, keep int) error {
+	bs, err := ss.Marshal()
 	if err != nil {
-		return errors.Wrap(err, "failed to open file")
+		return err
 	}
+	header := make([]byte, snapshotHeaderSize)
+	binary.BigEndian.PutUint32(header, crc32.Checksum(bs, crcTable))
 
-	_, err = file.Write(bs)
+	fName := snapshotFileName(dir, ss.Lsn())
+	tmpName := fName + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open file")
+	}
+	_, err = file.Write(append(header, bs...))
+	if err == nil {
+		err = file.Sync()
+	}
 	if err != nil {
+		file.Close()
+		os.Remove(tmpName)
 		return errors.Wrap(err, "failed to write file")
 	}
-	return nil
-}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpName, fName)
+	if err != nil {
+		return errors.Wrap(err, "failed to rename snapshot")
+	}
+	err = syncDir(dir)
+	if err != nil {
+		return err
+	}
 
-func (ss *Snapshot) fileName(dir string) string {
-	return dir + "/snapshot.log"
+	return removeOldSnapshots(dir, keep)
 }
 
-func (ss *Snapshot) buildSnapshotFromFile(dir string) error {
-	fName := ss.fileName(dir)
-	if _, err := os.Stat(fName); err != nil {
-		if os.IsNotExist(err) {
-			return err
+func removeOldSnapshots(dir string, keep int) error {
+	lsns, err := ListSnapshotLsns(dir)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(lsns)-keep; i++ {
+		err = os.Remove(snapshotFileName(dir, lsns[i]))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to remove old snapshot")
 		}
-		return errors.Wrap(err, fmt.Sprintf("Invalid directory: %s", fName))
 	}
+	return nil
+}
 
-	bs, err := ioutil.ReadFile(fName)
+// syncDir makes the renamed file durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return err
-		}
 		return err
 	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func snapshotFileName(dir string, lsn int64) string {
+	return filepath.Join(dir, snapshotPrefix+strconv.FormatInt(lsn, 10)+snapshotSuffix)
+}
 
-	err = json.Unmarshal(bs, ss.data)
+func readSnapshotFile(fName string) (*Snapshot, error) {
+	bs, err := ioutil.ReadFile(fName)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if len(bs) < snapshotHeaderSize {
+		return nil, errors.Errorf("too short snapshot: %s", fName)
 	}
+	payload := bs[snapshotHeaderSize:]
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(bs[:snapshotHeaderSize]) {
+		return nil, errors.Errorf("checksum mismatch: %s", fName)
+	}
+	return UnmarshalSnapshot(payload)
+}
 
-	return nil
+// recoverLegacySnapshot reads `snapshot.log`, which was written before snapshots were named by LSN.
+func recoverLegacySnapshot(dir string) (*Snapshot, error) {
+	fName := filepath.Join(dir, legacySnapshotFileName)
+	if _, err := os.Stat(fName); err != nil {
+		if os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, fmt.Sprintf("Invalid directory: %s", fName))
+	}
+
+	bs, err := ioutil.ReadFile(fName)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalSnapshot(bs)
 }
 
 func (ss *Snapshot) ToDatabases() []*Database {
@@ -116,27 +284,47 @@ func (ss *Snapshot) ToDatabases() []*Database {
 		tables := map[string]*Table{}
 
 		for _, st := range sdb.Tables {
-			indexes := map[string]*Index{}
-			for _, i := range st.Indexes {
-				indexes[i.Name] = &Index{
-					tree: i.Tree,
-				}
-			}
-
 			t := &Table{
 				Name:     st.Name,
+				dbName:   sdb.Name,
 				rowMetas: st.RowMetas,
-				indexes:  indexes,
+				indexes:  map[string]*Index{},
+
+				autoIncrements: map[string]int64{},
+			}
+			for k, v := range st.AutoIncrements {
+				t.autoIncrements[k] = v
 			}
 
 			var rows []*Row
 			for _, r := range st.Rows {
 				newRow := newEmptyRow(t)
 				newRow.columns = r.Columns
+				newRow.lsn = r.Lsn
 				rows = append(rows, newRow)
+				// Old snapshots don't have AutoIncrements
+				t.observeAutoIncrements(r.Columns)
 			}
 			t.rows = rows
 
+			for _, i := range st.Indexes {
+				idx, err := newIndex(i.Name, i.Columns, t.rowMetas)
+				if err != nil {
+					log.Warn().Err(err).Str("table", st.Name).Str("index", i.Name).Msg("skipping broken index")
+					continue
+				}
+				t.indexes[idx.Name] = idx
+			}
+			pk := st.PrimaryKey
+			if len(pk) == 0 {
+				pk = defaultPrimaryKey(t.rowMetas)
+			}
+			err := t.setPrimaryKey(pk)
+			if err != nil {
+				log.Warn().Err(err).Str("table", st.Name).Msg("skipping broken primary key")
+			}
+			t.rebuildIndexes()
+
 			tables[st.Name] = t
 		}
 
@@ -152,3 +340,23 @@ func (ss *Snapshot) ToDatabases() []*Database {
 func (ss *Snapshot) Lsn() int64 {
 	return ss.data.Lsn
 }
+
+// Timestamp returns Unix time in nanoseconds when the snapshot is taken. It is 0 for old snapshots.
+func (ss *Snapshot) Timestamp() int64 {
+	return ss.data.Timestamp
+}
+
+// ReplayLsn returns the LSN to start reading the WAL to recover transactions in progress at the snapshot.
+func (ss *Snapshot) ReplayLsn() int64 {
+	return ss.data.ReplayLsn
+}
+
+// LastTransactionNumber returns the largest number given to transactions when the snapshot is taken.
+func (ss *Snapshot) LastTransactionNumber() int64 {
+	return ss.data.LastTransactionNumber
+}
+
+// Data returns the content of the snapshot.
+func (ss *Snapshot) Data() *structs.SData {
+	return ss.data
+}
@@ -0,0 +1,141 @@
+package data
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mrasu/ddb/server/structs"
+)
+
+// SnapshotCopier copies committed rows of databases at a LSN without blocking writers.
+// A row changed before it is copied gives its value at the LSN to the copier first. (copy-on-write)
+type SnapshotCopier struct {
+	data   *structs.SData
+	tables []*copierTable
+
+	// mu guards saved, copied and done, and is held while a row is copied
+	mu sync.Mutex
+	// saved holds values of rows changed before they are copied
+	saved map[*Row]rowImage
+	// copied is rows already copied, whose changes are not saved
+	copied map[*Row]bool
+	done   bool
+}
+
+// copierTable is rows of a table at the LSN, whose definition is copied to st
+type copierTable struct {
+	st   *structs.STable
+	rows []*Row
+}
+
+// rowImage is the committed value of a row
+type rowImage struct {
+	columns map[string]string
+	lsn     int64
+}
+
+// BeginSnapshot starts copying dbs at lsn. Callers must keep dbs unchanged until it returns,
+// which takes time proportional to the number of tables, not rows.
+// replayLsn is where recovery starts reading the WAL to find transactions in progress.
+func BeginSnapshot(lsn, replayLsn int64, dbs []*Database) *SnapshotCopier {
+	c := &SnapshotCopier{
+		saved:  map[*Row]rowImage{},
+		copied: map[*Row]bool{},
+	}
+
+	var databases []*structs.SDatabase
+	for _, db := range dbs {
+		var tables []*structs.STable
+		for _, t := range db.tables {
+			var indexes []*structs.SIndex
+			for _, m := range t.indexMetas() {
+				indexes = append(indexes, &structs.SIndex{
+					Name:    m.Name,
+					Columns: m.Columns,
+				})
+			}
+
+			var metas []*structs.RowMeta
+			for _, m := range t.rowMetas {
+				meta := *m
+				metas = append(metas, &meta)
+			}
+
+			st := &structs.STable{
+				Name:           t.Name,
+				RowMetas:       metas,
+				Indexes:        indexes,
+				PrimaryKey:     append([]string{}, t.primaryKey...),
+				AutoIncrements: t.copyAutoIncrements(),
+			}
+			tables = append(tables, st)
+			// t.rows is never changed in place, so that the slice keeps rows at the LSN.
+			c.tables = append(c.tables, &copierTable{st: st, rows: t.rows})
+			t.copier = c
+		}
+
+		databases = append(databases, &structs.SDatabase{
+			Name:   db.Name,
+			Tables: tables,
+		})
+	}
+
+	c.data = &structs.SData{
+		Lsn:                   lsn,
+		Timestamp:             time.Now().UnixNano(),
+		ReplayLsn:             replayLsn,
+		LastTransactionNumber: LastTransactionNumber(),
+		Databases:             databases,
+	}
+	return c
+}
+
+// Copy copies rows while dbs are changed, and returns the snapshot at the LSN given to BeginSnapshot.
+// The copy is not shared with dbs so that it can be saved while dbs are changed.
+func (c *SnapshotCopier) Copy() *Snapshot {
+	for _, ct := range c.tables {
+		for _, r := range ct.rows {
+			img := c.copy(r)
+			// rows inserted by transactions in progress have no committed value
+			if len(img.columns) == 0 {
+				continue
+			}
+			ct.st.Rows = append(ct.st.Rows, &structs.SRow{
+				Columns: img.columns,
+				Lsn:     img.lsn,
+			})
+		}
+	}
+
+	c.mu.Lock()
+	c.done = true
+	c.saved = nil
+	c.copied = nil
+	c.mu.Unlock()
+	return &Snapshot{data: c.data}
+}
+
+func (c *SnapshotCopier) copy(r *Row) rowImage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if img, ok := c.saved[r]; ok {
+		return img
+	}
+	c.copied[r] = true
+	return rowImage{columns: copyColumns(r.columns), lsn: r.lsn}
+}
+
+// save keeps the value of r before it is changed when r is not copied yet.
+func (c *SnapshotCopier) save(r *Row) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done || c.copied[r] {
+		return
+	}
+	if _, ok := c.saved[r]; ok {
+		return
+	}
+	c.saved[r] = rowImage{columns: copyColumns(r.columns), lsn: r.lsn}
+}
@@ -2,36 +2,193 @@ package data
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 
+	"github.com/mrasu/ddb/server/pbs"
 	"github.com/mrasu/ddb/thelper"
 )
 
 func TestTakeSnapshot(t *testing.T) {
 	db := createDefaultDB()
 
-	s := TakeSnapshot(100, []*Database{db})
+	s := TakeSnapshot(100, 100, []*Database{db})
 	thelper.AssertInt64(t, "Invalid lsn", 100, s.data.Lsn)
 	assertSnapshot(t, s, db, "world")
 }
 
+func TestTakeSnapshot_CopiesRows(t *testing.T) {
+	db := createDefaultDB()
+	table := db.tables["world"]
+	eCount := len(table.rows)
+	msg := table.rows[0].columns["message"]
+
+	s := TakeSnapshot(100, 100, []*Database{db})
+	table.rows[0].columns["message"] = "changed"
+	// a row inserted by a transaction in progress
+	table.rows = append(table.rows, newEmptyRow(table))
+
+	rows := s.data.Databases[0].Tables[0].Rows
+	thelper.AssertInt(t, "Invalid row size", eCount, len(rows))
+	thelper.AssertString(t, "Row is shared", msg, rows[0].Columns["message"])
+
+	s = TakeSnapshot(100, 100, []*Database{db})
+	thelper.AssertInt(t, "Uncommitted row is included", eCount, len(s.data.Databases[0].Tables[0].Rows))
+}
+
+func TestBeginSnapshot_ChangedWhileCopied(t *testing.T) {
+	db := createDefaultDB()
+	table := db.tables["world"]
+	var rows []*pbs.InsertRow
+	for i := 3; i <= 200; i++ {
+		rows = append(rows, &pbs.InsertRow{Columns: map[string]string{"id": strconv.Itoa(i), "num": strconv.Itoa(i * 10), "text": "t"}})
+	}
+	thelper.AssertNoError(t, table.ApplyInsertChangeSets(CreateImmediateTransaction(), rows))
+
+	c := BeginSnapshot(100, 100, []*Database{db})
+	ssc := make(chan *Snapshot)
+	go func() {
+		ssc <- c.Copy()
+	}()
+	// Writers don't wait for the copy
+	for i := 1; i <= 200; i++ {
+		pk := []string{strconv.Itoa(i)}
+		if i%2 == 0 {
+			cs := &pbs.DeleteChangeSets{TableName: "world", Rows: []*pbs.DeleteRow{{PrimaryKey: pk}}}
+			thelper.AssertNoError(t, table.ApplyDeleteChangeSets(CreateImmediateTransaction(), cs))
+		} else {
+			cs := &pbs.UpdateChangeSets{TableName: "world", Rows: []*pbs.UpdateRow{{PrimaryKey: pk, Columns: map[string]string{"num": "0"}}}}
+			thelper.AssertNoError(t, table.ApplyUpdateChangeSets(CreateImmediateTransaction(), cs))
+		}
+	}
+	thelper.AssertNoError(t, table.ApplyInsertChangeSets(CreateImmediateTransaction(), []*pbs.InsertRow{{Columns: map[string]string{"id": "201", "num": "0", "text": "t"}}}))
+	ss := <-ssc
+
+	// The snapshot has rows at the LSN
+	copied := ss.data.Databases[0].Tables[0].Rows
+	thelper.AssertInt(t, "Invalid row size", 200, len(copied))
+	for i, r := range copied {
+		thelper.AssertString(t, "Invalid id", strconv.Itoa(i+1), r.Columns["id"])
+		thelper.AssertString(t, "Changed value is copied", strconv.Itoa((i+1)*10), r.Columns["num"])
+	}
+}
+
 func TestRecoverSnapshot(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
 	db := createDefaultDB()
+	db.tables["world"].rows[0].lsn = 42
 
-	s1 := TakeSnapshot(100, []*Database{db})
-	err := s1.Save("/tmp")
+	s1 := TakeSnapshot(100, 100, []*Database{db})
+	err := s1.Save(dir, DefaultSnapshotRetention)
 	thelper.AssertNoError(t, err)
 
-	s2, err := RecoverSnapshot("/tmp")
+	s2, err := RecoverSnapshot(dir)
 	thelper.AssertNoError(t, err)
 
 	thelper.AssertInt64(t, "Invalid lsn", 100, s2.data.Lsn)
 	assertSnapshot(t, s2, db, "world")
+	// Nodes restoring the snapshot compare the LSN with rows read by transactions
+	restored := s2.ToDatabases()[0].tables["world"]
+	thelper.AssertInt64(t, "Invalid lsn of the row", 42, restored.rows[0].lsn)
+	thelper.AssertString(t, "Invalid database of the table", db.Name, restored.dbName)
+}
+
+func TestRecoverSnapshot_NotExist(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	_, err := RecoverSnapshot(dir)
+	thelper.AssertBool(t, "Not IsNotExist error", true, os.IsNotExist(err))
+}
+
+func TestRecoverSnapshot_FallsBackToOlder(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	db := createDefaultDB()
+
+	for _, lsn := range []int64{100, 200} {
+		err := TakeSnapshot(lsn, lsn, []*Database{db}).Save(dir, DefaultSnapshotRetention)
+		thelper.AssertNoError(t, err)
+	}
+	f, err := os.OpenFile(snapshotFileName(dir, 200), os.O_WRONLY, 0600)
+	thelper.AssertNoError(t, err)
+	_, err = f.WriteAt([]byte("x"), snapshotHeaderSize+1)
+	thelper.AssertNoError(t, err)
+	f.Close()
+
+	ss, err := RecoverSnapshot(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt64(t, "Invalid lsn", 100, ss.Lsn())
+	assertSnapshot(t, ss, db, "world")
+
+	err = os.Truncate(snapshotFileName(dir, 100), 2)
+	thelper.AssertNoError(t, err)
+	_, err = RecoverSnapshot(dir)
+	if err == nil {
+		t.Errorf("No error when every snapshot is broken")
+	}
+}
+
+func TestRecoverSnapshot_Legacy(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	db := createDefaultDB()
+
+	bs, err := TakeSnapshot(100, 100, []*Database{db}).Marshal()
+	thelper.AssertNoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, legacySnapshotFileName), bs, 0600)
+	thelper.AssertNoError(t, err)
+
+	ss, err := RecoverSnapshot(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt64(t, "Invalid lsn", 100, ss.Lsn())
+	assertSnapshot(t, ss, db, "world")
+}
+
+func TestSnapshot_Save_KeepsLastOnes(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	db := createDefaultDB()
+
+	for _, lsn := range []int64{100, 200, 300, 400} {
+		err := TakeSnapshot(lsn, lsn, []*Database{db}).Save(dir, 2)
+		thelper.AssertNoError(t, err)
+	}
+
+	lsns, err := ListSnapshotLsns(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Invalid snapshot count", 2, len(lsns))
+	thelper.AssertInt64(t, "Invalid oldest lsn", 300, lsns[0])
+	thelper.AssertInt64(t, "Invalid newest lsn", 400, lsns[1])
+
+	files, err := ioutil.ReadDir(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Temporary files remain", 2, len(files))
+
+	ss, err := RecoverSnapshot(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt64(t, "Invalid lsn", 400, ss.Lsn())
+}
+
+func TestUnmarshalSnapshot(t *testing.T) {
+	db := createDefaultDB()
+
+	bs, err := TakeSnapshot(100, 100, []*Database{db}).Marshal()
+	thelper.AssertNoError(t, err)
+	s, err := UnmarshalSnapshot(bs)
+	thelper.AssertNoError(t, err)
+
+	thelper.AssertInt64(t, "Invalid lsn", 100, s.Lsn())
+	assertSnapshot(t, s, db, "world")
 }
 
 func TestSnapshot_ToDatabases(t *testing.T) {
 	dbOrig := createDefaultDB()
-	s := TakeSnapshot(100, []*Database{dbOrig})
+	s := TakeSnapshot(100, 100, []*Database{dbOrig})
 
 	dbsRecovered := s.ToDatabases()
 	thelper.AssertInt(t, "Invalid db size", 1, len(dbsRecovered))
@@ -68,6 +225,22 @@ func TestSnapshot_ToDatabases(t *testing.T) {
 	thelper.AssertInt(t, "Invalid Indexes size", len(tableRecovered.indexes), len(tableOrig.indexes))
 }
 
+func TestSnapshot_ToDatabases_AutoIncrements(t *testing.T) {
+	db := createDefaultDB()
+	// Values given to rows rolled back are kept
+	db.tables["world"].autoIncrements["id"] = 10
+	s := TakeSnapshot(100, 100, []*Database{db})
+	thelper.AssertInt64(t, "Invalid LastTransactionNumber", LastTransactionNumber(), s.LastTransactionNumber())
+
+	table := s.ToDatabases()[0].tables["world"]
+	thelper.AssertInt64(t, "Invalid next value", 11, table.nextAutoIncrement("id"))
+
+	// Snapshots without AutoIncrements continue from the rows
+	s.data.Databases[0].Tables[0].AutoIncrements = nil
+	table = s.ToDatabases()[0].tables["world"]
+	thelper.AssertInt64(t, "Invalid next value", 3, table.nextAutoIncrement("id"))
+}
+
 func assertSnapshot(t *testing.T, s *Snapshot, db *Database, tName string) {
 	table := db.tables[tName]
 
@@ -101,3 +274,11 @@ func assertSnapshot(t *testing.T, s *Snapshot, db *Database, tName string) {
 
 	thelper.AssertInt(t, "Invalid Indexes size", len(table.indexes), len(stable.Indexes))
 }
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
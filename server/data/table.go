@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/mrasu/ddb/server/pbs"
 
@@ -14,10 +15,29 @@ import (
 )
 
 type Table struct {
-	Name     string
+	Name string
+	// dbName is the name of the database having the table
+	dbName   string
 	rowMetas []*structs.RowMeta
 	rows     []*Row
 	indexes  map[string]*Index
+
+	// primaryKey is columns of PRIMARY KEY, and primaryIndex finds rows by them
+	primaryKey   []string
+	primaryIndex *Index
+
+	// autoIncrementMu guards autoIncrements
+	autoIncrementMu sync.Mutex
+	// autoIncrements holds the last value given to each AUTO_INCREMENT column
+	autoIncrements map[string]int64
+
+	// dropped makes transactions changing the table conflict on commit
+	dropped bool
+	// schemaVersion is incremented by ALTER TABLE to make changes on the former columns conflict on commit
+	schemaVersion int
+
+	// copier is the last SnapshotCopier began with the table, which committed rows give their values to before they are changed
+	copier *SnapshotCopier
 }
 
 func (t *Table) Inspect() {
@@ -25,16 +45,7 @@ func (t *Table) Inspect() {
 	var txts []string
 
 	for _, m := range t.rowMetas {
-		txt := m.Name
-		switch m.ColumnType {
-		case types.Int:
-			txt += " INT"
-		case types.AutoIncrementInt:
-			txt += " INT AUTO_INCREMENT"
-		case types.VarChar:
-			txt += fmt.Sprintf(" VARCHAR(%d)", m.Length)
-		}
-		txts = append(txts, txt)
+		txts = append(txts, m.String())
 	}
 	fmt.Printf("(%s)\n", strings.Join(txts, ", "))
 
@@ -47,37 +58,87 @@ func buildTable(ddl *sqlparser.DDL) (*Table, error) {
 	nn := ddl.NewName
 	var ms []*structs.RowMeta
 	for _, c := range ddl.TableSpec.Columns {
-		m := &structs.RowMeta{
-			Name: c.Name.String(),
-		}
-		if c.Type.Type == "int" {
-			if c.Type.Autoincrement {
-				m.ColumnType = types.AutoIncrementInt
-			} else {
-				m.ColumnType = types.Int
-			}
-		} else if c.Type.Type == "varchar" {
-			m.ColumnType = types.VarChar
-			length, err := strconv.Atoi(string(c.Type.Length.Val))
-			if err != nil {
-				return nil, err
-			}
-			m.Length = int64(length)
-		} else {
-			return nil, errors.Errorf("Not supported column type: %v", c.Type)
+		m, err := toRowMeta(c)
+		if err != nil {
+			return nil, err
 		}
-		m.AllowsNull = !bool(c.Type.NotNull)
 		ms = append(ms, m)
 	}
 	t := newEmtpyTable(nn.Name.String())
 	t.rowMetas = ms
+
+	pk, err := primaryKeyFromSpec(ddl.TableSpec)
+	if err != nil {
+		return nil, err
+	}
+	if pk == nil {
+		pk = defaultPrimaryKey(t.rowMetas)
+	}
+	err = t.setPrimaryKey(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, def := range ddl.TableSpec.Indexes {
+		if def.Info.Primary {
+			continue
+		}
+		idx, err := toIndex(def, t.rowMetas)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := t.indexes[idx.Name]; ok {
+			return nil, errors.Errorf("Duplicate index name: %s", idx.Name)
+		}
+		t.indexes[idx.Name] = idx
+	}
 	return t, nil
 }
 
-func NewTableFromChangeSet(cs *pbs.CreateTableChangeSet) *Table {
+func toRowMeta(c *sqlparser.ColumnDefinition) (*structs.RowMeta, error) {
+	m := &structs.RowMeta{
+		Name: c.Name.String(),
+	}
+	if c.Type.Type == "int" {
+		if c.Type.Autoincrement {
+			m.ColumnType = types.AutoIncrementInt
+		} else {
+			m.ColumnType = types.Int
+		}
+	} else if c.Type.Type == "varchar" {
+		m.ColumnType = types.VarChar
+		length, err := strconv.Atoi(string(c.Type.Length.Val))
+		if err != nil {
+			return nil, err
+		}
+		m.Length = int64(length)
+	} else {
+		return nil, errors.Errorf("Not supported column type: %v", c.Type)
+	}
+	m.AllowsNull = !bool(c.Type.NotNull)
+	return m, nil
+}
+
+func NewTableFromChangeSet(cs *pbs.CreateTableChangeSet) (*Table, error) {
 	t := newEmtpyTable(cs.Name)
+	t.dbName = cs.DBName
 	t.rowMetas = ToRowMetas(cs.RowMetas)
-	return t
+	pk := cs.PrimaryKey
+	if len(pk) == 0 {
+		pk = defaultPrimaryKey(t.rowMetas)
+	}
+	err := t.setPrimaryKey(pk)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range ToIndexMetas(cs.Indexes) {
+		idx, err := newIndex(m.Name, m.Columns, t.rowMetas)
+		if err != nil {
+			return nil, err
+		}
+		t.indexes[idx.Name] = idx
+	}
+	return t, nil
 }
 
 func ToRowMetas(metas []*pbs.RowMeta) []*structs.RowMeta {
@@ -114,9 +175,51 @@ func newEmtpyTable(name string) *Table {
 		rowMetas: []*structs.RowMeta{},
 		rows:     []*Row{},
 		indexes:  map[string]*Index{},
+
+		autoIncrements: map[string]int64{},
+	}
+}
+
+// nextAutoIncrement gives the value of the AUTO_INCREMENT column, which is never given again.
+func (t *Table) nextAutoIncrement(name string) int64 {
+	t.autoIncrementMu.Lock()
+	defer t.autoIncrementMu.Unlock()
+
+	v := t.autoIncrements[name] + 1
+	t.autoIncrements[name] = v
+	return v
+}
+
+// observeAutoIncrements keeps values given to AUTO_INCREMENT columns larger than ones in columns.
+func (t *Table) observeAutoIncrements(columns map[string]string) {
+	t.autoIncrementMu.Lock()
+	defer t.autoIncrementMu.Unlock()
+
+	for _, m := range t.rowMetas {
+		if m.ColumnType != types.AutoIncrementInt {
+			continue
+		}
+		v, err := strconv.ParseInt(columns[m.Name], 10, 64)
+		if err != nil {
+			continue
+		}
+		if v > t.autoIncrements[m.Name] {
+			t.autoIncrements[m.Name] = v
+		}
 	}
 }
 
+func (t *Table) copyAutoIncrements() map[string]int64 {
+	t.autoIncrementMu.Lock()
+	defer t.autoIncrementMu.Unlock()
+
+	vals := map[string]int64{}
+	for k, v := range t.autoIncrements {
+		vals[k] = v
+	}
+	return vals
+}
+
 func (t *Table) containsColumn(colName string) bool {
 	for _, m := range t.rowMetas {
 		if colName == m.Name {
@@ -150,8 +253,8 @@ func (t *Table) makeInsertChangeSet(trx *Transaction, iColumns sqlparser.Columns
 		Rows:              []*pbs.InsertRow{},
 		TransactionNumber: trx.Number,
 	}
-	lastAutoIncVals := map[string]int64{}
 
+	var datas []map[string]string
 	for _, rowValues := range values {
 		data := map[string]string{}
 		for i, rowVal := range rowValues {
@@ -171,63 +274,98 @@ func (t *Table) makeInsertChangeSet(trx *Transaction, iColumns sqlparser.Columns
 				continue
 			}
 			if c.ColumnType == types.AutoIncrementInt {
-				var v int64
-				if val, ok := lastAutoIncVals[c.Name]; ok {
-					v = val + 1
-				} else {
-					if len(t.rows) != 0 {
-						lastV, err := strconv.Atoi(t.rows[len(t.rows)-1].Get(trx, c.Name))
-						if err != nil {
-							panic("unexpected behavior: int column holds a not int value")
-						}
-						v = int64(lastV + 1)
-					} else {
-						v = 1
-					}
-				}
-				data[c.Name] = strconv.FormatInt(v, 10)
-				lastAutoIncVals[c.Name] = v
+				data[c.Name] = strconv.FormatInt(t.nextAutoIncrement(c.Name), 10)
 			}
 		}
+		// Values given explicitly are not given by AUTO_INCREMENT later.
+		t.observeAutoIncrements(data)
 		r := &pbs.InsertRow{
 			Columns: data,
 		}
 		cs.Rows = append(cs.Rows, r)
+		datas = append(datas, data)
 	}
 
+	err := t.validatePrimaryKeys(trx, datas, nil)
+	if err != nil {
+		return nil, err
+	}
 	return cs, nil
 }
 
+// ApplyInsertChangeSets inserts rows after checking their primary keys again,
+// because another ChangeSet having the same key can be applied after the ChangeSet is made.
 func (t *Table) ApplyInsertChangeSets(trx *Transaction, iRows []*pbs.InsertRow) error {
+	var keys []map[string]string
+	for _, row := range iRows {
+		keys = append(keys, row.Columns)
+	}
+	err := t.validatePrimaryKeys(trx, keys, nil)
+	if err != nil {
+		return err
+	}
+
 	var rows []*Row
 	for _, row := range iRows {
+		t.observeAutoIncrements(row.Columns)
 		r := CreateRow(trx, t, row.Columns)
 		rows = append(rows, r)
+		t.indexRow(r)
 	}
 
 	t.rows = append(t.rows, rows...)
 	return nil
 }
 
-func (t *Table) CreateUpdateChangeSets(trx *Transaction, q *sqlparser.Update) (*pbs.UpdateChangeSets, error) {
+// findRows returns rows seen by trx which match where.
+func (t *Table) findRows(trx *Transaction, where *sqlparser.Where) ([]*Row, error) {
 	var rows []*Row
-	if q.Where == nil {
-		rows = t.rows
-	} else {
-		eev := ExprEvaluator{}
-		for _, r := range t.rows {
+	eev := ExprEvaluator{}
+	cands := t.rows
+	if where != nil {
+		cands = t.candidateRows(trx, "", where.Expr)
+	}
+	for _, r := range cands {
+		if !r.isVisible(trx) {
+			continue
+		}
+		if where != nil {
 			// TODO: Allow Alias
-			ok, err := eev.evaluateAliasRow(trx, "", q.Where.Expr, r)
+			ok, err := eev.evaluateAliasRow(trx, "", where.Expr, r)
 			if err != nil {
 				return nil, err
 			}
-			if ok {
-				rows = append(rows, r)
+			if !ok {
+				continue
 			}
 		}
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
+
+func (t *Table) CreateUpdateChangeSets(trx *Transaction, q *sqlparser.Update) (*pbs.UpdateChangeSets, error) {
+	err := t.requirePrimaryKey()
+	if err != nil {
+		return nil, err
+	}
+	changesPrimaryKey := false
+	for _, expr := range q.Exprs {
+		if !t.containsColumn(expr.Name.Name.String()) {
+			return nil, errors.Errorf("Invalid column: %s", expr.Name.Name.String())
+		}
+		if t.isPrimaryKeyColumn(expr.Name.Name.String()) {
+			changesPrimaryKey = true
+		}
+	}
+
+	rows, err := t.findRows(trx, q.Where)
+	if err != nil {
+		return nil, err
 	}
 
 	var updateRows []*pbs.UpdateRow
+	var afters []map[string]string
 	for _, row := range rows {
 		cols := map[string]string{}
 		for _, expr := range q.Exprs {
@@ -246,12 +384,37 @@ func (t *Table) CreateUpdateChangeSets(trx *Transaction, q *sqlparser.Update) (*
 			}
 		}
 
+		before := map[string]string{}
+		after := map[string]string{}
+		for _, meta := range t.rowMetas {
+			before[meta.Name] = row.Get(trx, meta.Name)
+			after[meta.Name] = before[meta.Name]
+		}
+		for name, value := range cols {
+			after[name] = value
+		}
+		afters = append(afters, after)
+
+		pk := row.primaryKeyValues(trx)
 		updateRows = append(updateRows, &pbs.UpdateRow{
-			PrimaryKeyId: row.GetPrimaryId(trx),
+			PrimaryKeyId: t.legacyPrimaryId(pk),
+			PrimaryKey:   pk,
 			Columns:      cols,
+			Before:       before,
 		})
 	}
 
+	if changesPrimaryKey {
+		excluded := map[*Row]bool{}
+		for _, r := range rows {
+			excluded[r] = true
+		}
+		err = t.validatePrimaryKeys(trx, afters, excluded)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	cs := &pbs.UpdateChangeSets{
 		TableName:         t.Name,
 		TransactionNumber: trx.Number,
@@ -316,32 +479,135 @@ func (t *Table) calcBinaryUpdate(trx *Transaction, colName string, r *Row, q *sq
 }
 
 func (t *Table) ApplyUpdateChangeSets(trx *Transaction, cs *pbs.UpdateChangeSets) error {
-	// TODO: O(N*M)
+	err := t.requirePrimaryKey()
+	if err != nil {
+		return err
+	}
+	var keys [][]string
 	for _, row := range cs.Rows {
-		found := false
-		for _, r := range t.rows {
-			if r.GetPrimaryId(trx) == row.PrimaryKeyId {
-				err := r.Update(trx, row.Columns)
-				if err != nil {
-					return err
-				}
-				found = true
-				break
-			}
+		keys = append(keys, t.primaryKeyOf(row.PrimaryKey, row.PrimaryKeyId))
+	}
+	rows, err := t.findChangedRows(trx, keys, "UPDATE", cs.DBName)
+	if err != nil {
+		return err
+	}
+	for i, row := range cs.Rows {
+		// Apply of the change requires the version read by trx
+		trx.addValueReadRow(rows[i], rows[i].version)
+		err := rows[i].Update(trx, row.Columns)
+		if err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+func (t *Table) CreateDeleteChangeSets(trx *Transaction, q *sqlparser.Delete) (*pbs.DeleteChangeSets, error) {
+	err := t.requirePrimaryKey()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := t.findRows(trx, q.Where)
+	if err != nil {
+		return nil, err
+	}
 
-		if !found {
-			return errors.Errorf("no row found for UPDATE: %s.%s(PK: %d)", cs.DBName, cs.TableName, row.PrimaryKeyId)
+	var deleteRows []*pbs.DeleteRow
+	for _, row := range rows {
+		before := map[string]string{}
+		for _, meta := range t.rowMetas {
+			before[meta.Name] = row.Get(trx, meta.Name)
+		}
+		pk := row.primaryKeyValues(trx)
+		deleteRows = append(deleteRows, &pbs.DeleteRow{
+			PrimaryKeyId: t.legacyPrimaryId(pk),
+			PrimaryKey:   pk,
+			Before:       before,
+		})
+	}
+
+	cs := &pbs.DeleteChangeSets{
+		TableName:         t.Name,
+		TransactionNumber: trx.Number,
+		Rows:              deleteRows,
+	}
+	return cs, nil
+}
+
+func (t *Table) ApplyDeleteChangeSets(trx *Transaction, cs *pbs.DeleteChangeSets) error {
+	err := t.requirePrimaryKey()
+	if err != nil {
+		return err
+	}
+	var keys [][]string
+	for _, row := range cs.Rows {
+		keys = append(keys, t.primaryKeyOf(row.PrimaryKey, row.PrimaryKeyId))
+	}
+	rows, err := t.findChangedRows(trx, keys, "DELETE", cs.DBName)
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		// Apply of the change requires the version read by trx
+		trx.addValueReadRow(r, r.version)
+		err := r.Delete(trx)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// findChangedRows returns rows of keys changed by a ChangeSet of op.
+// Every row is found before any of them is changed so that a ChangeSet missing a row changes nothing.
+func (t *Table) findChangedRows(trx *Transaction, keys [][]string, op, dbName string) ([]*Row, error) {
+	var rows []*Row
+	for _, pk := range keys {
+		r := t.findRowByPrimaryIndexKey(trx, t.primaryIndex.keyOfValues(pk))
+		if r == nil {
+			return nil, errors.Errorf("no row found for %s: %s.%s(PK: %s)", op, dbName, t.Name, primaryKeyString(pk))
+		}
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
+
+// truncate removes committed rows. Rows inserted by transactions in progress are kept.
+// AUTO_INCREMENT counters are not reset so that removed values are not given again.
+func (t *Table) truncate() {
+	var rows []*Row
+	for _, r := range t.rows {
+		if len(r.columns) == 0 {
+			rows = append(rows, r)
+			continue
+		}
+		// Transactions which read the row conflict on commit.
+		r.version += 1
+	}
+	if rows == nil {
+		rows = []*Row{}
+	}
+	t.rows = rows
+	t.rebuildIndexes()
+}
+
+// drop makes transactions which read or changed the table conflict on commit.
+func (t *Table) drop() {
+	t.dropped = true
+	for _, r := range t.rows {
+		r.version += 1
+	}
+}
+
 func (t *Table) remove(target *Row) {
 	// TODO: optimise
+	t.unindexRow(target)
 	for i, r := range t.rows {
 		if r == target {
-			t.rows = append(t.rows[:i], t.rows[i+1:]...)
+			// A new slice is made because SnapshotCopier may be reading the current one
+			rows := make([]*Row, 0, len(t.rows)-1)
+			rows = append(rows, t.rows[:i]...)
+			t.rows = append(rows, t.rows[i+1:]...)
 			return
 		}
 	}
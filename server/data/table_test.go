@@ -9,6 +9,7 @@ import (
 	"github.com/xwb1989/sqlparser"
 
 	"github.com/mrasu/ddb/server/data/types"
+	"github.com/mrasu/ddb/thelper"
 )
 
 func TestNewTableFromChangeSet(t *testing.T) {
@@ -20,7 +21,8 @@ func TestNewTableFromChangeSet(t *testing.T) {
 			{Name: "c2", ColumnType: types.VarChar, Length: 10, AllowsNull: false},
 		},
 	}
-	table := NewTableFromChangeSet(cs)
+	table, err := NewTableFromChangeSet(cs)
+	thelper.AssertNoError(t, err)
 
 	if table.Name != cs.Name {
 		t.Errorf("Invalid table name: %s", table.Name)
@@ -102,6 +104,30 @@ func TestTable_ApplyInsertChangeSets(t *testing.T) {
 	AssertResult(t, res, eRowValues)
 }
 
+func TestTable_ApplyInsertChangeSets_DuplicatedPrimaryKey(t *testing.T) {
+	db := createDefaultDB()
+	table := db.tables["world"]
+
+	// Both ChangeSets are made before either is applied
+	stmt := ParseSQL(t, "INSERT INTO world(id, num, text) VALUES(3, 333, 'foo')").(*sqlparser.Insert)
+	cs1, err := table.CreateInsertChangeSets(CreateImmediateTransaction(), stmt)
+	thelper.AssertNoError(t, err)
+	cs2, err := table.CreateInsertChangeSets(CreateImmediateTransaction(), stmt)
+	thelper.AssertNoError(t, err)
+
+	thelper.AssertNoError(t, table.ApplyInsertChangeSets(CreateImmediateTransaction(), cs1.Rows))
+	err = table.ApplyInsertChangeSets(CreateImmediateTransaction(), cs2.Rows)
+	thelper.AssertBool(t, "Duplicated primary key is inserted", true, err != nil)
+
+	// A transaction fails to insert the key too
+	trx := StartNewTransaction()
+	err = table.ApplyInsertChangeSets(trx, cs2.Rows)
+	thelper.AssertBool(t, "Duplicated primary key is inserted by the transaction", true, err != nil)
+
+	res := GetAll(t, "SELECT * FROM hello.world WHERE id = 3", map[string]*Database{"hello": db})
+	AssertResult(t, res, []map[string]string{{"id": "3", "num": "333", "text": "foo"}})
+}
+
 func TestTable_CreateUpdateChangeSets(t *testing.T) {
 	table := createDefaultTable()
 	stmt := ParseSQL(t, "UPDATE world SET text = 'foo'").(*sqlparser.Update)
@@ -164,6 +190,18 @@ func TestTable_ApplyUpdateChangeSets(t *testing.T) {
 	AssertResult(t, res, eRowValues)
 }
 
+func TestTable_CreateInsertChangeSets_ExplicitAutoIncrement(t *testing.T) {
+	table := createDefaultTable()
+	stmt := ParseSQL(t, "INSERT INTO world(id, num, text) VALUES(10, 111, 'foo')").(*sqlparser.Insert)
+	_, err := table.CreateInsertChangeSets(CreateImmediateTransaction(), stmt)
+	thelper.AssertNoError(t, err)
+
+	stmt = ParseSQL(t, "INSERT INTO world(num, text) VALUES(222, 'bar')").(*sqlparser.Insert)
+	cs, err := table.CreateInsertChangeSets(CreateImmediateTransaction(), stmt)
+	thelper.AssertNoError(t, err)
+	thelper.AssertString(t, "Invalid id", "11", cs.Rows[0].Columns["id"])
+}
+
 func createDefaultTable() *Table {
 	cs := &pbs.CreateTableChangeSet{
 		DBName: "hello",
@@ -174,7 +212,7 @@ func createDefaultTable() *Table {
 			{Name: "text", ColumnType: types.VarChar, Length: 10, AllowsNull: false},
 		},
 	}
-	table := NewTableFromChangeSet(cs)
+	table, _ := NewTableFromChangeSet(cs)
 	row1 := newEmptyRow(table)
 	row1.columns["id"] = "1"
 	row1.columns["num"] = "10"
@@ -184,6 +222,8 @@ func createDefaultTable() *Table {
 	row2.columns["num"] = "20"
 	row2.columns["text"] = "t2"
 	table.rows = []*Row{row1, row2}
+	table.rebuildIndexes()
+	table.autoIncrements["id"] = 2
 
 	return table
 }
@@ -23,6 +23,9 @@ func CopyTable(t *Table) *Table {
 		Name:     t.Name,
 		rowMetas: CopyRowMetas(t),
 		rows:     CopyRows(t),
+		indexes:  t.indexes,
+
+		autoIncrements: t.copyAutoIncrements(),
 	}
 }
 
@@ -114,3 +117,7 @@ func GetAll(t *testing.T, selectSQL string, dbs map[string]*Database) *structs.R
 
 	return sev.ToResult(trx, stmt, joinRows)
 }
+
+func CopyIndexMetas(t *Table) []*structs.IndexMeta {
+	return t.indexMetas()
+}
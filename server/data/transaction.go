@@ -2,6 +2,7 @@ package data
 
 import (
 	"sync"
+	"time"
 
 	"github.com/mrasu/ddb/server/pbs"
 )
@@ -9,33 +10,87 @@ import (
 const ImmediateTransactionNumber = -1
 
 type Transaction struct {
-	Number           int64
+	Number int64
+	// BeginLsn is the LSN of the BEGIN ChangeSet
+	BeginLsn int64
+	// CommitLsn is the LSN of the ChangeSet committing changes of the transaction, which changed rows keep
+	CommitLsn        int64
 	valueChangedRows map[*Row]*Row
 	valueReadRows    map[*Row]int
+	// readLsns are LSNs of rows when the transaction reads them
+	readLsns map[*Row]int64
 
 	queryHistory []string
 	locking      bool
+
+	// changeSets are ChangeSets applied for the transaction, which raft snapshots keep until it finishes
+	changeSets []*pbs.ChangeSet
 }
 
+// Numbers of transactions started by a node of the raft group hold the node id in the upper bits,
+// so that nodes never give the same number to different transactions.
+const (
+	transactionNodeIDShift = 40
+	transactionCounterMask = 1<<transactionNodeIDShift - 1
+	// MaxTransactionNodeID is the largest node id which fits in transaction numbers.
+	MaxTransactionNodeID = 1<<(63-transactionNodeIDShift) - 1
+)
+
 var lastTransactionNumber int64 = 1
 var mu sync.Mutex
 
 func StartNewTransaction() *Transaction {
+	return StartNewTransactionOf(0)
+}
+
+// StartNewTransactionOf starts the transaction on the node of nodeID, which is 0 when the Server is not replicated.
+func StartNewTransactionOf(nodeID uint64) *Transaction {
 	mu.Lock()
 	defer mu.Unlock()
 
-	t := newTransaction(lastTransactionNumber)
+	t := newTransaction(int64(nodeID)<<transactionNodeIDShift | lastTransactionNumber)
 
 	// TODO: overflow
 	lastTransactionNumber += 1
 	return t
 }
 
+// StartTransactionWithNumber starts the transaction numbered by another Server or by the WAL.
+// Numbers given by StartNewTransaction are kept larger than num.
+func StartTransactionWithNumber(num int64) *Transaction {
+	AdvanceTransactionNumber(num)
+	return newTransaction(num)
+}
+
+// AdvanceTransactionNumber keeps numbers given by StartNewTransaction larger than num,
+// which is used by a transaction found in the WAL or the snapshot.
+func AdvanceTransactionNumber(num int64) {
+	if num < 0 {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	counter := num & transactionCounterMask
+	if counter >= lastTransactionNumber {
+		lastTransactionNumber = counter + 1
+	}
+}
+
+// LastTransactionNumber returns the largest number given to transactions, without the node id.
+func LastTransactionNumber() int64 {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return lastTransactionNumber - 1
+}
+
 func newTransaction(num int64) *Transaction {
 	return &Transaction{
 		Number:           num,
 		valueChangedRows: map[*Row]*Row{},
 		valueReadRows:    map[*Row]int{},
+		readLsns:         map[*Row]int64{},
 
 		queryHistory: []string{},
 		locking:      false,
@@ -62,6 +117,16 @@ func (trx *Transaction) QueryHistory() []string {
 	return trx.queryHistory
 }
 
+// AddChangeSet keeps cs applied for the transaction.
+func (trx *Transaction) AddChangeSet(cs *pbs.ChangeSet) {
+	trx.changeSets = append(trx.changeSets, cs)
+}
+
+// ChangeSets returns ChangeSets applied for the transaction in order.
+func (trx *Transaction) ChangeSets() []*pbs.ChangeSet {
+	return trx.changeSets
+}
+
 func (trx *Transaction) getValueChangedRow(r *Row) *Row {
 	valueChangedRow, ok := trx.valueChangedRows[r]
 	if ok {
@@ -83,9 +148,14 @@ func (trx *Transaction) addValueReadRow(r *Row, v int) {
 		return
 	}
 	trx.valueReadRows[r] = v
+	trx.readLsns[r] = r.lsn
 }
 
 func (trx *Transaction) expandLock() error {
+	if trx.changesStaleTable() || trx.duplicatesPrimaryKey() {
+		return NewTransactionConflictError()
+	}
+
 	var lockedRows []*Row
 	// TODO: sort to avoid deadlock
 	for r, versionUsed := range trx.valueReadRows {
@@ -106,6 +176,16 @@ func (trx *Transaction) expandLock() error {
 	return nil
 }
 
+// changesStaleTable tells whether trx has changed a table dropped or altered after the change.
+func (trx *Transaction) changesStaleTable() bool {
+	for r, valueChangedRow := range trx.valueChangedRows {
+		if r.table.dropped || valueChangedRow.schemaVersion != r.table.schemaVersion {
+			return true
+		}
+	}
+	return false
+}
+
 func (trx *Transaction) shrinkLock() {
 	for r := range trx.valueReadRows {
 		if r.isCommittedRow == false {
@@ -114,7 +194,12 @@ func (trx *Transaction) shrinkLock() {
 		GlobalLocker.Unlock(r, trx)
 	}
 	trx.locking = false
+	trx.forgetReadRows()
+}
+
+func (trx *Transaction) forgetReadRows() {
 	trx.valueReadRows = map[*Row]int{}
+	trx.readLsns = map[*Row]int64{}
 }
 
 func (trx *Transaction) CreateBeginChangeSet() *pbs.BeginChangeSet {
@@ -144,18 +229,44 @@ func (trx *Transaction) ApplyRollbackChangeSet(_ *pbs.RollbackChangeSet) {
 
 func (trx *Transaction) CreateCommitChangeSet() *pbs.CommitChangeSet {
 	return &pbs.CommitChangeSet{
-		Number: trx.Number,
+		Number:    trx.Number,
+		Timestamp: time.Now().UnixNano(),
+		ReadRows:  trx.readRows(),
+	}
+}
+
+// readRows returns committed rows read by trx with LSNs at the read.
+// Rows of tables without primary key are not included because other nodes cannot find them, and they are never updated.
+func (trx *Transaction) readRows() []*pbs.ReadRow {
+	var rows []*pbs.ReadRow
+	for r, lsn := range trx.readLsns {
+		t := r.table
+		if t.primaryIndex == nil || len(r.columns) == 0 {
+			continue
+		}
+		var pk []string
+		for _, c := range t.primaryKey {
+			pk = append(pk, r.columns[c])
+		}
+		rows = append(rows, &pbs.ReadRow{
+			DBName:     t.dbName,
+			TableName:  t.Name,
+			PrimaryKey: pk,
+			Lsn:        lsn,
+		})
 	}
+	return rows
 }
 
+// ApplyCommitChangeSet commits changes of trx.
+// Rows read by trx must be checked with ReadRows of cs before, because only the node running trx has read them.
 func (trx *Transaction) ApplyCommitChangeSet(cs *pbs.CommitChangeSet, afterLockFn func(*pbs.CommitChangeSet) error) error {
-	err := trx.expandLock()
-	if err != nil {
-		return err
+	if trx.changesStaleTable() || trx.duplicatesPrimaryKey() {
+		return NewTransactionConflictError()
 	}
-	defer trx.shrinkLock()
+	defer trx.forgetReadRows()
 
-	err = afterLockFn(cs)
+	err := afterLockFn(cs)
 	if err != nil {
 		return err
 	}
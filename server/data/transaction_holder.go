@@ -1,6 +1,12 @@
 package data
 
+import (
+	"sort"
+	"sync"
+)
+
 type TransactionHolder struct {
+	mu             sync.Mutex
 	transactionMap map[int64]*Transaction
 }
 
@@ -9,6 +15,9 @@ func NewTransactionHolder() *TransactionHolder {
 }
 
 func (h *TransactionHolder) Add(trx *Transaction) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
 	if _, ok := h.transactionMap[trx.Number]; ok {
 		return false
 	}
@@ -20,9 +29,49 @@ func (h *TransactionHolder) Get(num int64) *Transaction {
 	if num == -1 {
 		return CreateImmediateTransaction()
 	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	trx, ok := h.transactionMap[num]
 	if !ok {
 		return nil
 	}
 	return trx
 }
+
+// Remove forgets the finished transaction.
+func (h *TransactionHolder) Remove(num int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.transactionMap, num)
+}
+
+// Clear forgets every transaction.
+func (h *TransactionHolder) Clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.transactionMap = map[int64]*Transaction{}
+}
+
+// Numbers returns numbers of transactions not finished yet in ascending order.
+func (h *TransactionHolder) Numbers() []int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var nums []int64
+	for num := range h.transactionMap {
+		nums = append(nums, num)
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+	return nums
+}
+
+// Count returns the number of transactions not finished yet.
+func (h *TransactionHolder) Count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.transactionMap)
+}
@@ -34,3 +34,20 @@ func TestTransactionHolder_Get_NotRegisteredNumber(t *testing.T) {
 		t.Errorf("Get returns transaction")
 	}
 }
+
+func TestTransactionHolder_Remove(t *testing.T) {
+	holder := NewTransactionHolder()
+	trx := StartNewTransaction()
+	holder.Add(trx)
+	if holder.Count() != 1 {
+		t.Errorf("Invalid count: %d", holder.Count())
+	}
+
+	holder.Remove(trx.Number)
+	if holder.Get(trx.Number) != nil {
+		t.Errorf("Removed transaction is returned")
+	}
+	if holder.Count() != 0 {
+		t.Errorf("Invalid count: %d", holder.Count())
+	}
+}
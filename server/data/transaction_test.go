@@ -23,6 +23,37 @@ func TestStartNewTransaction(t *testing.T) {
 	}
 }
 
+func TestStartTransactionWithNumber(t *testing.T) {
+	num := StartNewTransaction().Number + 10
+	trx := StartTransactionWithNumber(num)
+	if trx.Number != num {
+		t.Errorf("Invalid transaction number: %d", trx.Number)
+	}
+
+	next := StartNewTransaction()
+	if next.Number <= num {
+		t.Errorf("Transaction number is not increased: %d", next.Number)
+	}
+}
+
+func TestStartNewTransactionOf(t *testing.T) {
+	trx1 := StartNewTransactionOf(1)
+	trx2 := StartNewTransactionOf(2)
+	if trx1.Number>>transactionNodeIDShift != 1 || trx2.Number>>transactionNodeIDShift != 2 {
+		t.Errorf("Numbers don't hold node ids: %d, %d", trx1.Number, trx2.Number)
+	}
+	if trx1.Number&transactionCounterMask >= trx2.Number&transactionCounterMask {
+		t.Errorf("Transaction number is not increased: %d, %d", trx1.Number, trx2.Number)
+	}
+
+	// Numbers of other nodes advance only the counter
+	StartTransactionWithNumber(trx2.Number + 10)
+	next := StartNewTransaction()
+	if next.Number != trx2.Number&transactionCounterMask+11 {
+		t.Errorf("Invalid transaction number: %d", next.Number)
+	}
+}
+
 func TestCreateImmediateTransaction(t *testing.T) {
 	trx := CreateImmediateTransaction()
 	if !trx.IsImmediate() {
@@ -174,6 +205,9 @@ func TestTransaction_CreateCommitChangeSet(t *testing.T) {
 	if cs2.Number != trx2.Number {
 		t.Errorf("Invalid transaction number: %d", cs2.Number)
 	}
+	if cs1.Timestamp == 0 || cs2.Timestamp < cs1.Timestamp {
+		t.Errorf("Invalid commit timestamp: %d, %d", cs1.Timestamp, cs2.Timestamp)
+	}
 }
 
 func TestTransaction_ApplyCommitChangeSet(t *testing.T) {
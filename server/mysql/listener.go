@@ -0,0 +1,86 @@
+package mysql
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mrasu/ddb/server"
+	"github.com/rs/zerolog/log"
+)
+
+// Listener accepts clients speaking the MySQL client/server protocol
+// and maps every socket to one server.Connection.
+type Listener struct {
+	server   *server.Server
+	listener net.Listener
+
+	lastConnectionId uint32
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+func Listen(s *server.Server, address string) (*Listener, error) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Listener{
+		server:   s,
+		listener: l,
+		conns:    map[net.Conn]bool{},
+	}, nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Serve blocks until the Listener is closed.
+func (l *Listener) Serve() error {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+
+		id := atomic.AddUint32(&l.lastConnectionId, 1)
+		s := newSession(id, conn, l.server.StartNewConnection())
+		l.track(conn, true)
+		go func() {
+			defer l.track(conn, false)
+			err := s.run()
+			if err != nil {
+				log.Error().Stack().Err(err).Uint32("id", id).Msg("mysql session is closed by error")
+			}
+		}()
+	}
+}
+
+func (l *Listener) track(conn net.Conn, add bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if add {
+		l.conns[conn] = true
+	} else {
+		delete(l.conns, conn)
+	}
+}
+
+// Close stops accepting clients and disconnects the connected ones.
+func (l *Listener) Close() error {
+	err := l.listener.Close()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+	return err
+}
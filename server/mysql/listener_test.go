@@ -0,0 +1,290 @@
+package mysql
+
+import (
+	"net"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/mrasu/ddb/server"
+	"github.com/mrasu/ddb/server/wal"
+	"github.com/mrasu/ddb/thelper"
+	"github.com/rs/zerolog"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestListener_Query(t *testing.T) {
+	l, c := startTestListener(t)
+	defer l.Close()
+	defer c.close()
+
+	thelper.AssertInt(t, "Invalid CREATE DATABASE response", okHeader, int(c.query(t, "CREATE DATABASE hello")[0][0]))
+	c.query(t, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	c.query(t, "INSERT INTO hello.world(message) VALUES ('foo'), ('bar')")
+
+	columns, rows := c.selectRows(t, "SELECT * FROM hello.world")
+	thelper.AssertInt(t, "Invalid column size", 2, len(columns))
+	thelper.AssertString(t, "Invalid column", "id", columns[0])
+	thelper.AssertString(t, "Invalid column", "message", columns[1])
+
+	eRows := [][]string{{"1", "foo"}, {"2", "bar"}}
+	thelper.AssertInt(t, "Invalid row size", len(eRows), len(rows))
+	for i, row := range rows {
+		for j, v := range row {
+			thelper.AssertString(t, "Invalid value", eRows[i][j], v)
+		}
+	}
+}
+
+func TestListener_Query_NoRow(t *testing.T) {
+	l, c := startTestListener(t)
+	defer l.Close()
+	defer c.close()
+
+	c.query(t, "CREATE DATABASE hello")
+	c.query(t, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+
+	columns, rows := c.selectRows(t, "SELECT * FROM hello.world")
+	thelper.AssertInt(t, "Invalid column size", 2, len(columns))
+	thelper.AssertInt(t, "Invalid row size", 0, len(rows))
+}
+
+func TestListener_Query_AffectedRows(t *testing.T) {
+	l, c := startTestListener(t)
+	defer l.Close()
+	defer c.close()
+
+	c.query(t, "CREATE DATABASE hello")
+	c.query(t, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+
+	packet := c.query(t, "INSERT INTO hello.world(message) VALUES ('foo'), ('bar')")[0]
+	affected, insertId := okCounts(t, packet)
+	thelper.AssertInt64(t, "Invalid affected rows", 2, affected)
+	thelper.AssertInt64(t, "Invalid insert id", 1, insertId)
+
+	packet = c.query(t, "DELETE FROM hello.world WHERE id = 2")[0]
+	affected, _ = okCounts(t, packet)
+	thelper.AssertInt64(t, "Invalid affected rows", 1, affected)
+}
+
+func TestListener_Query_Concurrent(t *testing.T) {
+	l, c := startTestListener(t)
+	defer l.Close()
+	defer c.close()
+
+	c.query(t, "CREATE DATABASE hello")
+	c.query(t, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		c2 := connectTestClient(t, l)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer c2.close()
+			for j := 0; j < 10; j++ {
+				c2.command(t, comQuery, []byte("INSERT INTO hello.world(message) VALUES ('foo')"))
+				packet, err := c2.pw.readPacket()
+				thelper.AssertNoError(t, err)
+				if err == nil {
+					thelper.AssertInt(t, "Insert failed", okHeader, int(packet[0]))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, rows := c.selectRows(t, "SELECT * FROM hello.world")
+	thelper.AssertInt(t, "Invalid row size", 50, len(rows))
+}
+
+func TestListener_Query_Error(t *testing.T) {
+	l, c := startTestListener(t)
+	defer l.Close()
+	defer c.close()
+
+	packets := c.query(t, "SELECT * FROM nothing.world")
+	thelper.AssertInt(t, "Error is not returned", errHeader, int(packets[0][0]))
+
+	packets = c.query(t, "NOT A SQL")
+	thelper.AssertInt(t, "Error is not returned for invalid sql", errHeader, int(packets[0][0]))
+
+	// the session is still alive after errors
+	c.command(t, comPing, nil)
+	packet := c.read(t)
+	thelper.AssertInt(t, "Invalid PING response", okHeader, int(packet[0]))
+}
+
+func TestListener_Query_TransactionStatus(t *testing.T) {
+	l, c := startTestListener(t)
+	defer l.Close()
+	defer c.close()
+
+	packets := c.query(t, "BEGIN")
+	thelper.AssertInt(t, "Transaction is not reported", int(serverStatusInTrans), int(okStatus(packets[0])))
+
+	packets = c.query(t, "COMMIT")
+	thelper.AssertInt(t, "Autocommit is not reported", int(serverStatusAutocommit), int(okStatus(packets[0])))
+}
+
+func TestListener_Quit(t *testing.T) {
+	l, c := startTestListener(t)
+	defer l.Close()
+
+	c.command(t, comQuit, nil)
+	_, err := c.pw.readPacket()
+	if err == nil {
+		t.Error("Connection is not closed by COM_QUIT")
+	}
+}
+
+type testClient struct {
+	conn net.Conn
+	pw   *packetReadWriter
+}
+
+func startTestListener(t *testing.T) (*Listener, *testClient) {
+	s, err := server.NewTestServer(&wal.Memory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen(s, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = l.Serve() }()
+
+	return l, connectTestClient(t, l)
+}
+
+func connectTestClient(t *testing.T, l *Listener) *testClient {
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{conn: conn, pw: newPacketReadWriter(conn)}
+
+	handshake := c.read(t)
+	thelper.AssertInt(t, "Invalid protocol version", protocolVersion, int(handshake[0]))
+
+	response := appendUint32(nil, clientProtocol41|clientSecureConnection|clientPluginAuth)
+	response = appendUint32(response, maxPacketSize)
+	response = append(response, defaultCharset)
+	response = append(response, make([]byte, 23)...)
+	response = append(response, "root"...)
+	response = append(response, 0, 0)
+	response = append(response, authPluginName...)
+	response = append(response, 0)
+	err = c.pw.writePacket(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thelper.AssertNoError(t, c.pw.flush())
+
+	thelper.AssertInt(t, "Handshake failed", okHeader, int(c.read(t)[0]))
+
+	return c
+}
+
+func (c *testClient) close() {
+	_ = c.conn.Close()
+}
+
+func (c *testClient) read(t *testing.T) []byte {
+	t.Helper()
+	packet, err := c.pw.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+func (c *testClient) command(t *testing.T, command byte, body []byte) {
+	t.Helper()
+	c.pw.resetSequence()
+	err := c.pw.writePacket(append([]byte{command}, body...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	thelper.AssertNoError(t, c.pw.flush())
+}
+
+// query returns every packet of the response.
+func (c *testClient) query(t *testing.T, sql string) [][]byte {
+	t.Helper()
+	c.command(t, comQuery, []byte(sql))
+
+	first := c.read(t)
+	if first[0] == okHeader || first[0] == errHeader {
+		return [][]byte{first}
+	}
+
+	packets := [][]byte{first}
+	eofCount := 0
+	for eofCount < 2 {
+		packet := c.read(t)
+		if packet[0] == eofHeader && len(packet) < 9 {
+			eofCount++
+		}
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+func (c *testClient) selectRows(t *testing.T, sql string) ([]string, [][]string) {
+	t.Helper()
+	packets := c.query(t, sql)
+	if packets[0][0] == errHeader {
+		t.Fatalf("Query failed: %s", packets[0][9:])
+	}
+
+	count, _, err := readLengthEncodedInt(packets[0])
+	thelper.AssertNoError(t, err)
+
+	var columns []string
+	for _, p := range packets[1 : 1+count] {
+		// catalog, schema, table, org_table and then name
+		pos := 0
+		var name string
+		for i := 0; i < 5; i++ {
+			l, n, err := readLengthEncodedInt(p[pos:])
+			thelper.AssertNoError(t, err)
+			name = string(p[pos+n : pos+n+int(l)])
+			pos += n + int(l)
+		}
+		columns = append(columns, name)
+	}
+
+	var rows [][]string
+	for _, p := range packets[2+count : len(packets)-1] {
+		var row []string
+		for pos := 0; pos < len(p); {
+			l, n, err := readLengthEncodedInt(p[pos:])
+			thelper.AssertNoError(t, err)
+			row = append(row, string(p[pos+n:pos+n+int(l)]))
+			pos += n + int(l)
+		}
+		rows = append(rows, row)
+	}
+	return columns, rows
+}
+
+func okCounts(t *testing.T, packet []byte) (int64, int64) {
+	t.Helper()
+	thelper.AssertInt(t, "OK is not returned", okHeader, int(packet[0]))
+	affected, n, err := readLengthEncodedInt(packet[1:])
+	thelper.AssertNoError(t, err)
+	insertId, _, err := readLengthEncodedInt(packet[1+n:])
+	thelper.AssertNoError(t, err)
+	return int64(affected), int64(insertId)
+}
+
+func okStatus(packet []byte) uint16 {
+	// header, affected rows(1) and last insert id(1) precede the status
+	return uint16(packet[3]) | uint16(packet[4])<<8
+}
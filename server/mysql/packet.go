@@ -0,0 +1,147 @@
+package mysql
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const maxPacketSize = 1<<24 - 1
+
+type packetReadWriter struct {
+	reader   *bufio.Reader
+	writer   *bufio.Writer
+	sequence uint8
+}
+
+func newPacketReadWriter(rw io.ReadWriter) *packetReadWriter {
+	return &packetReadWriter{
+		reader: bufio.NewReader(rw),
+		writer: bufio.NewWriter(rw),
+	}
+}
+
+func (p *packetReadWriter) resetSequence() {
+	p.sequence = 0
+}
+
+func (p *packetReadWriter) readPacket() ([]byte, error) {
+	var payload []byte
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(p.reader, header); err != nil {
+			return nil, err
+		}
+		length := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+		if header[3] != p.sequence {
+			return nil, errors.Errorf("invalid packet sequence. expected: %d, actual: %d", p.sequence, header[3])
+		}
+		p.sequence++
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(p.reader, body); err != nil {
+			return nil, err
+		}
+		payload = append(payload, body...)
+
+		// A payload of exactly maxPacketSize bytes is continued by the next packet.
+		if length < maxPacketSize {
+			return payload, nil
+		}
+	}
+}
+
+func (p *packetReadWriter) writePacket(payload []byte) error {
+	for {
+		length := len(payload)
+		if length > maxPacketSize {
+			length = maxPacketSize
+		}
+		header := []byte{byte(length), byte(length >> 8), byte(length >> 16), p.sequence}
+		p.sequence++
+
+		if _, err := p.writer.Write(header); err != nil {
+			return err
+		}
+		if _, err := p.writer.Write(payload[:length]); err != nil {
+			return err
+		}
+		payload = payload[length:]
+
+		if length < maxPacketSize {
+			return nil
+		}
+	}
+}
+
+func (p *packetReadWriter) flush() error {
+	return p.writer.Flush()
+}
+
+func appendLengthEncodedInt(bs []byte, n uint64) []byte {
+	switch {
+	case n < 251:
+		return append(bs, byte(n))
+	case n < 1<<16:
+		return append(bs, 0xfc, byte(n), byte(n>>8))
+	case n < 1<<24:
+		return append(bs, 0xfd, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, n)
+		return append(append(bs, 0xfe), b...)
+	}
+}
+
+func appendLengthEncodedString(bs []byte, s string) []byte {
+	bs = appendLengthEncodedInt(bs, uint64(len(s)))
+	return append(bs, s...)
+}
+
+func appendUint16(bs []byte, n uint16) []byte {
+	return append(bs, byte(n), byte(n>>8))
+}
+
+func appendUint32(bs []byte, n uint32) []byte {
+	return append(bs, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+}
+
+// readLengthEncodedInt returns the value and the number of bytes consumed.
+func readLengthEncodedInt(bs []byte) (uint64, int, error) {
+	if len(bs) == 0 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+
+	var size int
+	switch bs[0] {
+	case 0xfc:
+		size = 2
+	case 0xfd:
+		size = 3
+	case 0xfe:
+		size = 8
+	default:
+		return uint64(bs[0]), 1, nil
+	}
+	if len(bs) < size+1 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+
+	var n uint64
+	for i := 0; i < size; i++ {
+		n |= uint64(bs[i+1]) << (8 * uint(i))
+	}
+	return n, size + 1, nil
+}
+
+// readNullTerminatedString returns the string and the number of bytes consumed including the terminator.
+func readNullTerminatedString(bs []byte) (string, int, error) {
+	for i, b := range bs {
+		if b == 0 {
+			return string(bs[:i]), i + 1, nil
+		}
+	}
+	return "", 0, io.ErrUnexpectedEOF
+}
@@ -0,0 +1,69 @@
+package mysql
+
+const protocolVersion = 10
+
+// ServerVersion is reported to clients in the initial handshake.
+// Clients and ORMs inspect it to decide which features to use, so it mimics a MySQL 5.7 server.
+const ServerVersion = "5.7.0-ddb"
+
+const authPluginName = "mysql_native_password"
+
+// utf8_general_ci
+const defaultCharset = 33
+
+const (
+	clientLongPassword               uint32 = 0x00000001
+	clientFoundRows                  uint32 = 0x00000002
+	clientLongFlag                   uint32 = 0x00000004
+	clientConnectWithDB              uint32 = 0x00000008
+	clientProtocol41                 uint32 = 0x00000200
+	clientTransactions               uint32 = 0x00002000
+	clientSecureConnection           uint32 = 0x00008000
+	clientMultiResults               uint32 = 0x00020000
+	clientPluginAuth                 uint32 = 0x00080000
+	clientConnectAttrs               uint32 = 0x00100000
+	clientPluginAuthLenencClientData uint32 = 0x00200000
+
+	serverCapabilities = clientLongPassword |
+		clientFoundRows |
+		clientLongFlag |
+		clientConnectWithDB |
+		clientProtocol41 |
+		clientTransactions |
+		clientSecureConnection |
+		clientMultiResults |
+		clientPluginAuth |
+		clientConnectAttrs |
+		clientPluginAuthLenencClientData
+)
+
+const (
+	serverStatusInTrans    uint16 = 0x0001
+	serverStatusAutocommit uint16 = 0x0002
+)
+
+const (
+	comQuit   = 0x01
+	comInitDB = 0x02
+	comQuery  = 0x03
+	comPing   = 0x0e
+)
+
+const (
+	okHeader  = 0x00
+	eofHeader = 0xfe
+	errHeader = 0xff
+)
+
+const (
+	typeVarString = 0xfd
+)
+
+const (
+	errHandshake      uint16 = 1043
+	errUnknownCommand uint16 = 1047
+	errUnknownError   uint16 = 1105
+
+	sqlStateGeneral = "HY000"
+	sqlStateConnect = "08S01"
+)
@@ -0,0 +1,269 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/mrasu/ddb/server"
+	"github.com/mrasu/ddb/server/structs"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/xwb1989/sqlparser"
+)
+
+type session struct {
+	id   uint32
+	conn net.Conn
+	pw   *packetReadWriter
+
+	connection *server.Connection
+}
+
+func newSession(id uint32, conn net.Conn, connection *server.Connection) *session {
+	return &session{
+		id:   id,
+		conn: conn,
+		pw:   newPacketReadWriter(conn),
+
+		connection: connection,
+	}
+}
+
+func (s *session) run() error {
+	defer s.conn.Close()
+	defer func() {
+		// Transactions left by disconnected clients are never committed.
+		err := s.connection.Close()
+		if err != nil {
+			log.Error().Stack().Err(err).Uint32("id", s.id).Msg("failed to roll back the transaction of the closed session")
+		}
+	}()
+
+	err := s.handshake()
+	if err != nil {
+		return err
+	}
+
+	for {
+		s.pw.resetSequence()
+		packet, err := s.pw.readPacket()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if len(packet) == 0 {
+			return errors.New("empty command packet")
+		}
+
+		quit, err := s.dispatch(packet[0], packet[1:])
+		if err != nil {
+			return err
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+func (s *session) handshake() error {
+	salt := make([]byte, 20)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	// The scramble must not contain NUL because clients read it as a null terminated string.
+	for i := range salt {
+		salt[i] = salt[i]&0x7f | 0x01
+	}
+
+	err := s.writePacketAndFlush(s.buildHandshakePacket(salt))
+	if err != nil {
+		return err
+	}
+
+	packet, err := s.pw.readPacket()
+	if err != nil {
+		return err
+	}
+	user, err := parseHandshakeResponse(packet)
+	if err != nil {
+		_ = s.writePacketAndFlush(buildErrPacket(errHandshake, sqlStateConnect, "Bad handshake"))
+		return err
+	}
+
+	// ddb doesn't have users yet, so any user and password is accepted.
+	log.Debug().Str("user", user).Uint32("id", s.id).Msg("mysql client connected")
+	return s.writePacketAndFlush(s.buildOKPacket(structs.NewEmptyResult()))
+}
+
+func (s *session) buildHandshakePacket(salt []byte) []byte {
+	bs := []byte{protocolVersion}
+	bs = append(bs, ServerVersion...)
+	bs = append(bs, 0)
+	bs = appendUint32(bs, s.id)
+	bs = append(bs, salt[:8]...)
+	bs = append(bs, 0)
+	bs = appendUint16(bs, uint16(serverCapabilities&0xffff))
+	bs = append(bs, defaultCharset)
+	bs = appendUint16(bs, s.statusFlags())
+	bs = appendUint16(bs, uint16(serverCapabilities>>16))
+	bs = append(bs, byte(len(salt)+1))
+	bs = append(bs, make([]byte, 10)...)
+	bs = append(bs, salt[8:]...)
+	bs = append(bs, 0)
+	bs = append(bs, authPluginName...)
+	bs = append(bs, 0)
+	return bs
+}
+
+func parseHandshakeResponse(packet []byte) (string, error) {
+	// capability flags(4), max packet size(4), charset(1) and filler(23)
+	if len(packet) < 32 {
+		return "", errors.New("handshake response is too short")
+	}
+	capabilities := uint32(packet[0]) | uint32(packet[1])<<8 | uint32(packet[2])<<16 | uint32(packet[3])<<24
+	if capabilities&clientProtocol41 == 0 {
+		return "", errors.New("client doesn't support protocol 4.1")
+	}
+
+	user, _, err := readNullTerminatedString(packet[32:])
+	if err != nil {
+		return "", errors.Wrap(err, "invalid user in handshake response")
+	}
+	return user, nil
+}
+
+func (s *session) dispatch(command byte, body []byte) (bool, error) {
+	switch command {
+	case comQuit:
+		return true, nil
+	case comPing, comInitDB:
+		// Every table is referred with the database name, so the default database is not used.
+		return false, s.writePacketAndFlush(s.buildOKPacket(structs.NewEmptyResult()))
+	case comQuery:
+		return false, s.query(string(body))
+	default:
+		msg := fmt.Sprintf("Unknown command: %d", command)
+		return false, s.writePacketAndFlush(buildErrPacket(errUnknownCommand, sqlStateGeneral, msg))
+	}
+}
+
+func (s *session) query(sql string) error {
+	result, err := s.runQuery(sql)
+	if err != nil {
+		return s.writePacketAndFlush(buildErrPacket(errUnknownError, sqlStateGeneral, err.Error()))
+	}
+
+	// SELECT returns columns even when no row is found, which clients need to read the result set.
+	if sqlparser.Preview(sql) != sqlparser.StmtSelect {
+		return s.writePacketAndFlush(s.buildOKPacket(result))
+	}
+	return s.writeResultSet(result)
+}
+
+func (s *session) runQuery(sql string) (result *structs.Result, err error) {
+	// ddb still panics for unsupported queries. Keep the session alive and report it to the client instead.
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Str("SQL", sql).Interface("panic", r).Msg("Query panicked")
+			err = errors.Errorf("%v", r)
+		}
+	}()
+
+	return s.connection.Query(sql)
+}
+
+func (s *session) writeResultSet(result *structs.Result) error {
+	err := s.pw.writePacket(appendLengthEncodedInt(nil, uint64(len(result.Columns))))
+	if err != nil {
+		return err
+	}
+	for _, c := range result.Columns {
+		err = s.pw.writePacket(buildColumnDefinitionPacket(c))
+		if err != nil {
+			return err
+		}
+	}
+	err = s.pw.writePacket(s.buildEOFPacket())
+	if err != nil {
+		return err
+	}
+
+	for _, vals := range result.Values {
+		var bs []byte
+		for _, v := range vals {
+			bs = appendLengthEncodedString(bs, v)
+		}
+		err = s.pw.writePacket(bs)
+		if err != nil {
+			return err
+		}
+	}
+	return s.writePacketAndFlush(s.buildEOFPacket())
+}
+
+func buildColumnDefinitionPacket(name string) []byte {
+	bs := appendLengthEncodedString(nil, "def")
+	// schema, table and org_table
+	bs = appendLengthEncodedString(bs, "")
+	bs = appendLengthEncodedString(bs, "")
+	bs = appendLengthEncodedString(bs, "")
+	bs = appendLengthEncodedString(bs, name)
+	bs = appendLengthEncodedString(bs, name)
+	// length of the fixed length fields
+	bs = append(bs, 0x0c)
+	bs = appendUint16(bs, defaultCharset)
+	bs = appendUint32(bs, 0xffff)
+	// Result only holds strings, so every column is sent as VARCHAR.
+	bs = append(bs, typeVarString)
+	// flags, decimals and filler
+	bs = appendUint16(bs, 0)
+	bs = append(bs, 0)
+	bs = appendUint16(bs, 0)
+	return bs
+}
+
+func (s *session) buildOKPacket(result *structs.Result) []byte {
+	bs := []byte{okHeader}
+	bs = appendLengthEncodedInt(bs, uint64(result.AffectedRows))
+	bs = appendLengthEncodedInt(bs, uint64(result.InsertId))
+	bs = appendUint16(bs, s.statusFlags())
+	// warnings
+	bs = appendUint16(bs, 0)
+	return bs
+}
+
+func (s *session) buildEOFPacket() []byte {
+	bs := []byte{eofHeader}
+	// warnings
+	bs = appendUint16(bs, 0)
+	bs = appendUint16(bs, s.statusFlags())
+	return bs
+}
+
+func buildErrPacket(code uint16, state, message string) []byte {
+	bs := []byte{errHeader}
+	bs = appendUint16(bs, code)
+	bs = append(bs, '#')
+	bs = append(bs, state...)
+	bs = append(bs, message...)
+	return bs
+}
+
+func (s *session) statusFlags() uint16 {
+	if s.connection.InTransaction() {
+		return serverStatusInTrans
+	}
+	return serverStatusAutocommit
+}
+
+func (s *session) writePacketAndFlush(payload []byte) error {
+	err := s.pw.writePacket(payload)
+	if err != nil {
+		return err
+	}
+	return s.pw.flush()
+}
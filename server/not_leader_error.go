@@ -0,0 +1,25 @@
+package server
+
+import "fmt"
+
+// NotLeaderError is returned when a write is sent to a node which is not the leader.
+// Clients can send the write again to the leader.
+type NotLeaderError struct {
+	// LeaderID is 0 when the leader is not known, e.g. during an election.
+	LeaderID      uint64
+	LeaderAddress string
+}
+
+func NewNotLeaderError(leaderID uint64, leaderAddress string) *NotLeaderError {
+	return &NotLeaderError{
+		LeaderID:      leaderID,
+		LeaderAddress: leaderAddress,
+	}
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.LeaderID == 0 {
+		return "this node is not the leader, leader is unknown"
+	}
+	return fmt.Sprintf("this node is not the leader, leader is %d (%s)", e.LeaderID, e.LeaderAddress)
+}
@@ -1,31 +1,13 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // source: raft.proto
 
-/*
-Package pbs is a generated protocol buffer package.
-
-It is generated from these files:
-	raft.proto
-
-It has these top-level messages:
-	ChangeSet
-	CreateDBChangeSet
-	CreateTableChangeSet
-	RowMeta
-	InsertChangeSets
-	InsertRow
-	UpdateChangeSets
-	UpdateRow
-	BeginChangeSet
-	CommitChangeSet
-	RollbackChangeSet
-	AbortChangeSet
-*/
 package pbs
 
-import proto "github.com/golang/protobuf/proto"
-import fmt "fmt"
-import math "math"
+import (
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	math "math"
+)
 
 // Reference imports to suppress errors if they are not otherwise used.
 var _ = proto.Marshal
@@ -36,7 +18,7 @@ var _ = math.Inf
 // is compatible with the proto package it is being compiled against.
 // A compilation error at this line likely means your copy of the
 // proto package needs to be updated.
-const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
 // Must be same with the types.ColumnType
 type ColumnType int32
@@ -52,6 +34,7 @@ var ColumnType_name = map[int32]string{
 	1:  "AutoIncrementInt",
 	10: "VarChar",
 }
+
 var ColumnType_value = map[string]int32{
 	"Int":              0,
 	"AutoIncrementInt": 1,
@@ -61,64 +44,206 @@ var ColumnType_value = map[string]int32{
 func (x ColumnType) String() string {
 	return proto.EnumName(ColumnType_name, int32(x))
 }
-func (ColumnType) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+func (ColumnType) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{0}
+}
 
 type ChangeSet struct {
-	Lsn int64 `protobuf:"varint,1,opt,name=Lsn,json=lsn" json:"Lsn,omitempty"`
+	Lsn int64 `protobuf:"varint,1,opt,name=Lsn,proto3" json:"Lsn,omitempty"`
+	// Set by the node proposing the ChangeSet to raft to know when it is applied.
+	RequestId uint64 `protobuf:"varint,2,opt,name=RequestId,proto3" json:"RequestId,omitempty"`
+	// Unix time in nanoseconds when the ChangeSet is written to the WAL, which is 0 in records written by older versions
+	Timestamp int64 `protobuf:"varint,3,opt,name=Timestamp,proto3" json:"Timestamp,omitempty"`
 	// Types that are valid to be assigned to Data:
 	//	*ChangeSet_CreateDB
+	//	*ChangeSet_DropDB
 	//	*ChangeSet_CreateTable
+	//	*ChangeSet_DropTable
+	//	*ChangeSet_TruncateTable
+	//	*ChangeSet_AddColumn
+	//	*ChangeSet_DropColumn
+	//	*ChangeSet_RenameColumn
+	//	*ChangeSet_ModifyColumn
+	//	*ChangeSet_RenameTable
+	//	*ChangeSet_CreateIndex
+	//	*ChangeSet_DropIndex
 	//	*ChangeSet_InsertSets
 	//	*ChangeSet_UpdateSets
+	//	*ChangeSet_DeleteSets
 	//	*ChangeSet_Begin
 	//	*ChangeSet_Commit
 	//	*ChangeSet_Rollback
 	//	*ChangeSet_Abort
-	Data isChangeSet_Data `protobuf_oneof:"Data"`
+	Data                 isChangeSet_Data `protobuf_oneof:"Data"`
+	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
+	XXX_unrecognized     []byte           `json:"-"`
+	XXX_sizecache        int32            `json:"-"`
+}
+
+func (m *ChangeSet) Reset()         { *m = ChangeSet{} }
+func (m *ChangeSet) String() string { return proto.CompactTextString(m) }
+func (*ChangeSet) ProtoMessage()    {}
+func (*ChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{0}
+}
+
+func (m *ChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ChangeSet.Unmarshal(m, b)
+}
+func (m *ChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ChangeSet.Marshal(b, m, deterministic)
+}
+func (m *ChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ChangeSet.Merge(m, src)
+}
+func (m *ChangeSet) XXX_Size() int {
+	return xxx_messageInfo_ChangeSet.Size(m)
+}
+func (m *ChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_ChangeSet.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ChangeSet proto.InternalMessageInfo
+
+func (m *ChangeSet) GetLsn() int64 {
+	if m != nil {
+		return m.Lsn
+	}
+	return 0
+}
+
+func (m *ChangeSet) GetRequestId() uint64 {
+	if m != nil {
+		return m.RequestId
+	}
+	return 0
 }
 
-func (m *ChangeSet) Reset()                    { *m = ChangeSet{} }
-func (m *ChangeSet) String() string            { return proto.CompactTextString(m) }
-func (*ChangeSet) ProtoMessage()               {}
-func (*ChangeSet) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+func (m *ChangeSet) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
 
 type isChangeSet_Data interface {
 	isChangeSet_Data()
 }
 
 type ChangeSet_CreateDB struct {
-	CreateDB *CreateDBChangeSet `protobuf:"bytes,11,opt,name=CreateDB,json=createDB,oneof"`
+	CreateDB *CreateDBChangeSet `protobuf:"bytes,11,opt,name=CreateDB,proto3,oneof"`
+}
+
+type ChangeSet_DropDB struct {
+	DropDB *DropDBChangeSet `protobuf:"bytes,12,opt,name=DropDB,proto3,oneof"`
 }
+
 type ChangeSet_CreateTable struct {
-	CreateTable *CreateTableChangeSet `protobuf:"bytes,100,opt,name=CreateTable,json=createTable,oneof"`
+	CreateTable *CreateTableChangeSet `protobuf:"bytes,100,opt,name=CreateTable,proto3,oneof"`
 }
+
+type ChangeSet_DropTable struct {
+	DropTable *DropTableChangeSet `protobuf:"bytes,110,opt,name=DropTable,proto3,oneof"`
+}
+
+type ChangeSet_TruncateTable struct {
+	TruncateTable *TruncateTableChangeSet `protobuf:"bytes,120,opt,name=TruncateTable,proto3,oneof"`
+}
+
+type ChangeSet_AddColumn struct {
+	AddColumn *AddColumnChangeSet `protobuf:"bytes,130,opt,name=AddColumn,proto3,oneof"`
+}
+
+type ChangeSet_DropColumn struct {
+	DropColumn *DropColumnChangeSet `protobuf:"bytes,131,opt,name=DropColumn,proto3,oneof"`
+}
+
+type ChangeSet_RenameColumn struct {
+	RenameColumn *RenameColumnChangeSet `protobuf:"bytes,132,opt,name=RenameColumn,proto3,oneof"`
+}
+
+type ChangeSet_ModifyColumn struct {
+	ModifyColumn *ModifyColumnChangeSet `protobuf:"bytes,133,opt,name=ModifyColumn,proto3,oneof"`
+}
+
+type ChangeSet_RenameTable struct {
+	RenameTable *RenameTableChangeSet `protobuf:"bytes,140,opt,name=RenameTable,proto3,oneof"`
+}
+
+type ChangeSet_CreateIndex struct {
+	CreateIndex *CreateIndexChangeSet `protobuf:"bytes,150,opt,name=CreateIndex,proto3,oneof"`
+}
+
+type ChangeSet_DropIndex struct {
+	DropIndex *DropIndexChangeSet `protobuf:"bytes,151,opt,name=DropIndex,proto3,oneof"`
+}
+
 type ChangeSet_InsertSets struct {
-	InsertSets *InsertChangeSets `protobuf:"bytes,200,opt,name=InsertSets,json=insertSets,oneof"`
+	InsertSets *InsertChangeSets `protobuf:"bytes,200,opt,name=InsertSets,proto3,oneof"`
 }
+
 type ChangeSet_UpdateSets struct {
-	UpdateSets *UpdateChangeSets `protobuf:"bytes,210,opt,name=UpdateSets,json=updateSets,oneof"`
+	UpdateSets *UpdateChangeSets `protobuf:"bytes,210,opt,name=UpdateSets,proto3,oneof"`
 }
+
+type ChangeSet_DeleteSets struct {
+	DeleteSets *DeleteChangeSets `protobuf:"bytes,220,opt,name=DeleteSets,proto3,oneof"`
+}
+
 type ChangeSet_Begin struct {
-	Begin *BeginChangeSet `protobuf:"bytes,900,opt,name=Begin,json=begin,oneof"`
+	Begin *BeginChangeSet `protobuf:"bytes,900,opt,name=Begin,proto3,oneof"`
 }
+
 type ChangeSet_Commit struct {
-	Commit *CommitChangeSet `protobuf:"bytes,910,opt,name=Commit,json=commit,oneof"`
+	Commit *CommitChangeSet `protobuf:"bytes,910,opt,name=Commit,proto3,oneof"`
 }
+
 type ChangeSet_Rollback struct {
-	Rollback *RollbackChangeSet `protobuf:"bytes,920,opt,name=Rollback,json=rollback,oneof"`
+	Rollback *RollbackChangeSet `protobuf:"bytes,920,opt,name=Rollback,proto3,oneof"`
 }
+
 type ChangeSet_Abort struct {
-	Abort *AbortChangeSet `protobuf:"bytes,930,opt,name=Abort,json=abort,oneof"`
+	Abort *AbortChangeSet `protobuf:"bytes,930,opt,name=Abort,proto3,oneof"`
 }
 
-func (*ChangeSet_CreateDB) isChangeSet_Data()    {}
+func (*ChangeSet_CreateDB) isChangeSet_Data() {}
+
+func (*ChangeSet_DropDB) isChangeSet_Data() {}
+
 func (*ChangeSet_CreateTable) isChangeSet_Data() {}
-func (*ChangeSet_InsertSets) isChangeSet_Data()  {}
-func (*ChangeSet_UpdateSets) isChangeSet_Data()  {}
-func (*ChangeSet_Begin) isChangeSet_Data()       {}
-func (*ChangeSet_Commit) isChangeSet_Data()      {}
-func (*ChangeSet_Rollback) isChangeSet_Data()    {}
-func (*ChangeSet_Abort) isChangeSet_Data()       {}
+
+func (*ChangeSet_DropTable) isChangeSet_Data() {}
+
+func (*ChangeSet_TruncateTable) isChangeSet_Data() {}
+
+func (*ChangeSet_AddColumn) isChangeSet_Data() {}
+
+func (*ChangeSet_DropColumn) isChangeSet_Data() {}
+
+func (*ChangeSet_RenameColumn) isChangeSet_Data() {}
+
+func (*ChangeSet_ModifyColumn) isChangeSet_Data() {}
+
+func (*ChangeSet_RenameTable) isChangeSet_Data() {}
+
+func (*ChangeSet_CreateIndex) isChangeSet_Data() {}
+
+func (*ChangeSet_DropIndex) isChangeSet_Data() {}
+
+func (*ChangeSet_InsertSets) isChangeSet_Data() {}
+
+func (*ChangeSet_UpdateSets) isChangeSet_Data() {}
+
+func (*ChangeSet_DeleteSets) isChangeSet_Data() {}
+
+func (*ChangeSet_Begin) isChangeSet_Data() {}
+
+func (*ChangeSet_Commit) isChangeSet_Data() {}
+
+func (*ChangeSet_Rollback) isChangeSet_Data() {}
+
+func (*ChangeSet_Abort) isChangeSet_Data() {}
 
 func (m *ChangeSet) GetData() isChangeSet_Data {
 	if m != nil {
@@ -127,13 +252,6 @@ func (m *ChangeSet) GetData() isChangeSet_Data {
 	return nil
 }
 
-func (m *ChangeSet) GetLsn() int64 {
-	if m != nil {
-		return m.Lsn
-	}
-	return 0
-}
-
 func (m *ChangeSet) GetCreateDB() *CreateDBChangeSet {
 	if x, ok := m.GetData().(*ChangeSet_CreateDB); ok {
 		return x.CreateDB
@@ -141,6 +259,13 @@ func (m *ChangeSet) GetCreateDB() *CreateDBChangeSet {
 	return nil
 }
 
+func (m *ChangeSet) GetDropDB() *DropDBChangeSet {
+	if x, ok := m.GetData().(*ChangeSet_DropDB); ok {
+		return x.DropDB
+	}
+	return nil
+}
+
 func (m *ChangeSet) GetCreateTable() *CreateTableChangeSet {
 	if x, ok := m.GetData().(*ChangeSet_CreateTable); ok {
 		return x.CreateTable
@@ -148,6 +273,69 @@ func (m *ChangeSet) GetCreateTable() *CreateTableChangeSet {
 	return nil
 }
 
+func (m *ChangeSet) GetDropTable() *DropTableChangeSet {
+	if x, ok := m.GetData().(*ChangeSet_DropTable); ok {
+		return x.DropTable
+	}
+	return nil
+}
+
+func (m *ChangeSet) GetTruncateTable() *TruncateTableChangeSet {
+	if x, ok := m.GetData().(*ChangeSet_TruncateTable); ok {
+		return x.TruncateTable
+	}
+	return nil
+}
+
+func (m *ChangeSet) GetAddColumn() *AddColumnChangeSet {
+	if x, ok := m.GetData().(*ChangeSet_AddColumn); ok {
+		return x.AddColumn
+	}
+	return nil
+}
+
+func (m *ChangeSet) GetDropColumn() *DropColumnChangeSet {
+	if x, ok := m.GetData().(*ChangeSet_DropColumn); ok {
+		return x.DropColumn
+	}
+	return nil
+}
+
+func (m *ChangeSet) GetRenameColumn() *RenameColumnChangeSet {
+	if x, ok := m.GetData().(*ChangeSet_RenameColumn); ok {
+		return x.RenameColumn
+	}
+	return nil
+}
+
+func (m *ChangeSet) GetModifyColumn() *ModifyColumnChangeSet {
+	if x, ok := m.GetData().(*ChangeSet_ModifyColumn); ok {
+		return x.ModifyColumn
+	}
+	return nil
+}
+
+func (m *ChangeSet) GetRenameTable() *RenameTableChangeSet {
+	if x, ok := m.GetData().(*ChangeSet_RenameTable); ok {
+		return x.RenameTable
+	}
+	return nil
+}
+
+func (m *ChangeSet) GetCreateIndex() *CreateIndexChangeSet {
+	if x, ok := m.GetData().(*ChangeSet_CreateIndex); ok {
+		return x.CreateIndex
+	}
+	return nil
+}
+
+func (m *ChangeSet) GetDropIndex() *DropIndexChangeSet {
+	if x, ok := m.GetData().(*ChangeSet_DropIndex); ok {
+		return x.DropIndex
+	}
+	return nil
+}
+
 func (m *ChangeSet) GetInsertSets() *InsertChangeSets {
 	if x, ok := m.GetData().(*ChangeSet_InsertSets); ok {
 		return x.InsertSets
@@ -162,6 +350,13 @@ func (m *ChangeSet) GetUpdateSets() *UpdateChangeSets {
 	return nil
 }
 
+func (m *ChangeSet) GetDeleteSets() *DeleteChangeSets {
+	if x, ok := m.GetData().(*ChangeSet_DeleteSets); ok {
+		return x.DeleteSets
+	}
+	return nil
+}
+
 func (m *ChangeSet) GetBegin() *BeginChangeSet {
 	if x, ok := m.GetData().(*ChangeSet_Begin); ok {
 		return x.Begin
@@ -190,13 +385,24 @@ func (m *ChangeSet) GetAbort() *AbortChangeSet {
 	return nil
 }
 
-// XXX_OneofFuncs is for the internal use of the proto package.
-func (*ChangeSet) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), func(msg proto.Message) (n int), []interface{}) {
-	return _ChangeSet_OneofMarshaler, _ChangeSet_OneofUnmarshaler, _ChangeSet_OneofSizer, []interface{}{
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*ChangeSet) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
 		(*ChangeSet_CreateDB)(nil),
+		(*ChangeSet_DropDB)(nil),
 		(*ChangeSet_CreateTable)(nil),
+		(*ChangeSet_DropTable)(nil),
+		(*ChangeSet_TruncateTable)(nil),
+		(*ChangeSet_AddColumn)(nil),
+		(*ChangeSet_DropColumn)(nil),
+		(*ChangeSet_RenameColumn)(nil),
+		(*ChangeSet_ModifyColumn)(nil),
+		(*ChangeSet_RenameTable)(nil),
+		(*ChangeSet_CreateIndex)(nil),
+		(*ChangeSet_DropIndex)(nil),
 		(*ChangeSet_InsertSets)(nil),
 		(*ChangeSet_UpdateSets)(nil),
+		(*ChangeSet_DeleteSets)(nil),
 		(*ChangeSet_Begin)(nil),
 		(*ChangeSet_Commit)(nil),
 		(*ChangeSet_Rollback)(nil),
@@ -204,188 +410,37 @@ func (*ChangeSet) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) err
 	}
 }
 
-func _ChangeSet_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
-	m := msg.(*ChangeSet)
-	// Data
-	switch x := m.Data.(type) {
-	case *ChangeSet_CreateDB:
-		b.EncodeVarint(11<<3 | proto.WireBytes)
-		if err := b.EncodeMessage(x.CreateDB); err != nil {
-			return err
-		}
-	case *ChangeSet_CreateTable:
-		b.EncodeVarint(100<<3 | proto.WireBytes)
-		if err := b.EncodeMessage(x.CreateTable); err != nil {
-			return err
-		}
-	case *ChangeSet_InsertSets:
-		b.EncodeVarint(200<<3 | proto.WireBytes)
-		if err := b.EncodeMessage(x.InsertSets); err != nil {
-			return err
-		}
-	case *ChangeSet_UpdateSets:
-		b.EncodeVarint(210<<3 | proto.WireBytes)
-		if err := b.EncodeMessage(x.UpdateSets); err != nil {
-			return err
-		}
-	case *ChangeSet_Begin:
-		b.EncodeVarint(900<<3 | proto.WireBytes)
-		if err := b.EncodeMessage(x.Begin); err != nil {
-			return err
-		}
-	case *ChangeSet_Commit:
-		b.EncodeVarint(910<<3 | proto.WireBytes)
-		if err := b.EncodeMessage(x.Commit); err != nil {
-			return err
-		}
-	case *ChangeSet_Rollback:
-		b.EncodeVarint(920<<3 | proto.WireBytes)
-		if err := b.EncodeMessage(x.Rollback); err != nil {
-			return err
-		}
-	case *ChangeSet_Abort:
-		b.EncodeVarint(930<<3 | proto.WireBytes)
-		if err := b.EncodeMessage(x.Abort); err != nil {
-			return err
-		}
-	case nil:
-	default:
-		return fmt.Errorf("ChangeSet.Data has unexpected type %T", x)
-	}
-	return nil
-}
-
-func _ChangeSet_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error) {
-	m := msg.(*ChangeSet)
-	switch tag {
-	case 11: // Data.CreateDB
-		if wire != proto.WireBytes {
-			return true, proto.ErrInternalBadWireType
-		}
-		msg := new(CreateDBChangeSet)
-		err := b.DecodeMessage(msg)
-		m.Data = &ChangeSet_CreateDB{msg}
-		return true, err
-	case 100: // Data.CreateTable
-		if wire != proto.WireBytes {
-			return true, proto.ErrInternalBadWireType
-		}
-		msg := new(CreateTableChangeSet)
-		err := b.DecodeMessage(msg)
-		m.Data = &ChangeSet_CreateTable{msg}
-		return true, err
-	case 200: // Data.InsertSets
-		if wire != proto.WireBytes {
-			return true, proto.ErrInternalBadWireType
-		}
-		msg := new(InsertChangeSets)
-		err := b.DecodeMessage(msg)
-		m.Data = &ChangeSet_InsertSets{msg}
-		return true, err
-	case 210: // Data.UpdateSets
-		if wire != proto.WireBytes {
-			return true, proto.ErrInternalBadWireType
-		}
-		msg := new(UpdateChangeSets)
-		err := b.DecodeMessage(msg)
-		m.Data = &ChangeSet_UpdateSets{msg}
-		return true, err
-	case 900: // Data.Begin
-		if wire != proto.WireBytes {
-			return true, proto.ErrInternalBadWireType
-		}
-		msg := new(BeginChangeSet)
-		err := b.DecodeMessage(msg)
-		m.Data = &ChangeSet_Begin{msg}
-		return true, err
-	case 910: // Data.Commit
-		if wire != proto.WireBytes {
-			return true, proto.ErrInternalBadWireType
-		}
-		msg := new(CommitChangeSet)
-		err := b.DecodeMessage(msg)
-		m.Data = &ChangeSet_Commit{msg}
-		return true, err
-	case 920: // Data.Rollback
-		if wire != proto.WireBytes {
-			return true, proto.ErrInternalBadWireType
-		}
-		msg := new(RollbackChangeSet)
-		err := b.DecodeMessage(msg)
-		m.Data = &ChangeSet_Rollback{msg}
-		return true, err
-	case 930: // Data.Abort
-		if wire != proto.WireBytes {
-			return true, proto.ErrInternalBadWireType
-		}
-		msg := new(AbortChangeSet)
-		err := b.DecodeMessage(msg)
-		m.Data = &ChangeSet_Abort{msg}
-		return true, err
-	default:
-		return false, nil
-	}
-}
-
-func _ChangeSet_OneofSizer(msg proto.Message) (n int) {
-	m := msg.(*ChangeSet)
-	// Data
-	switch x := m.Data.(type) {
-	case *ChangeSet_CreateDB:
-		s := proto.Size(x.CreateDB)
-		n += proto.SizeVarint(11<<3 | proto.WireBytes)
-		n += proto.SizeVarint(uint64(s))
-		n += s
-	case *ChangeSet_CreateTable:
-		s := proto.Size(x.CreateTable)
-		n += proto.SizeVarint(100<<3 | proto.WireBytes)
-		n += proto.SizeVarint(uint64(s))
-		n += s
-	case *ChangeSet_InsertSets:
-		s := proto.Size(x.InsertSets)
-		n += proto.SizeVarint(200<<3 | proto.WireBytes)
-		n += proto.SizeVarint(uint64(s))
-		n += s
-	case *ChangeSet_UpdateSets:
-		s := proto.Size(x.UpdateSets)
-		n += proto.SizeVarint(210<<3 | proto.WireBytes)
-		n += proto.SizeVarint(uint64(s))
-		n += s
-	case *ChangeSet_Begin:
-		s := proto.Size(x.Begin)
-		n += proto.SizeVarint(900<<3 | proto.WireBytes)
-		n += proto.SizeVarint(uint64(s))
-		n += s
-	case *ChangeSet_Commit:
-		s := proto.Size(x.Commit)
-		n += proto.SizeVarint(910<<3 | proto.WireBytes)
-		n += proto.SizeVarint(uint64(s))
-		n += s
-	case *ChangeSet_Rollback:
-		s := proto.Size(x.Rollback)
-		n += proto.SizeVarint(920<<3 | proto.WireBytes)
-		n += proto.SizeVarint(uint64(s))
-		n += s
-	case *ChangeSet_Abort:
-		s := proto.Size(x.Abort)
-		n += proto.SizeVarint(930<<3 | proto.WireBytes)
-		n += proto.SizeVarint(uint64(s))
-		n += s
-	case nil:
-	default:
-		panic(fmt.Sprintf("proto: unexpected type %T in oneof", x))
-	}
-	return n
+type CreateDBChangeSet struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CreateDBChangeSet) Reset()         { *m = CreateDBChangeSet{} }
+func (m *CreateDBChangeSet) String() string { return proto.CompactTextString(m) }
+func (*CreateDBChangeSet) ProtoMessage()    {}
+func (*CreateDBChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{1}
 }
 
-type CreateDBChangeSet struct {
-	Name string `protobuf:"bytes,1,opt,name=Name,json=name" json:"Name,omitempty"`
+func (m *CreateDBChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CreateDBChangeSet.Unmarshal(m, b)
+}
+func (m *CreateDBChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CreateDBChangeSet.Marshal(b, m, deterministic)
+}
+func (m *CreateDBChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CreateDBChangeSet.Merge(m, src)
+}
+func (m *CreateDBChangeSet) XXX_Size() int {
+	return xxx_messageInfo_CreateDBChangeSet.Size(m)
+}
+func (m *CreateDBChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_CreateDBChangeSet.DiscardUnknown(m)
 }
 
-func (m *CreateDBChangeSet) Reset()                    { *m = CreateDBChangeSet{} }
-func (m *CreateDBChangeSet) String() string            { return proto.CompactTextString(m) }
-func (*CreateDBChangeSet) ProtoMessage()               {}
-func (*CreateDBChangeSet) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+var xxx_messageInfo_CreateDBChangeSet proto.InternalMessageInfo
 
 func (m *CreateDBChangeSet) GetName() string {
 	if m != nil {
@@ -395,15 +450,41 @@ func (m *CreateDBChangeSet) GetName() string {
 }
 
 type CreateTableChangeSet struct {
-	DBName   string     `protobuf:"bytes,1,opt,name=DBName,json=dBName" json:"DBName,omitempty"`
-	Name     string     `protobuf:"bytes,2,opt,name=Name,json=name" json:"Name,omitempty"`
-	RowMetas []*RowMeta `protobuf:"bytes,3,rep,name=RowMetas,json=rowMetas" json:"RowMetas,omitempty"`
+	DBName   string       `protobuf:"bytes,1,opt,name=DBName,proto3" json:"DBName,omitempty"`
+	Name     string       `protobuf:"bytes,2,opt,name=Name,proto3" json:"Name,omitempty"`
+	RowMetas []*RowMeta   `protobuf:"bytes,3,rep,name=RowMetas,proto3" json:"RowMetas,omitempty"`
+	Indexes  []*IndexMeta `protobuf:"bytes,4,rep,name=Indexes,proto3" json:"Indexes,omitempty"`
+	// Columns of PRIMARY KEY, which is empty in records written by older versions
+	PrimaryKey           []string `protobuf:"bytes,5,rep,name=PrimaryKey,proto3" json:"PrimaryKey,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CreateTableChangeSet) Reset()         { *m = CreateTableChangeSet{} }
+func (m *CreateTableChangeSet) String() string { return proto.CompactTextString(m) }
+func (*CreateTableChangeSet) ProtoMessage()    {}
+func (*CreateTableChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{2}
+}
+
+func (m *CreateTableChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CreateTableChangeSet.Unmarshal(m, b)
+}
+func (m *CreateTableChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CreateTableChangeSet.Marshal(b, m, deterministic)
+}
+func (m *CreateTableChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CreateTableChangeSet.Merge(m, src)
+}
+func (m *CreateTableChangeSet) XXX_Size() int {
+	return xxx_messageInfo_CreateTableChangeSet.Size(m)
+}
+func (m *CreateTableChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_CreateTableChangeSet.DiscardUnknown(m)
 }
 
-func (m *CreateTableChangeSet) Reset()                    { *m = CreateTableChangeSet{} }
-func (m *CreateTableChangeSet) String() string            { return proto.CompactTextString(m) }
-func (*CreateTableChangeSet) ProtoMessage()               {}
-func (*CreateTableChangeSet) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+var xxx_messageInfo_CreateTableChangeSet proto.InternalMessageInfo
 
 func (m *CreateTableChangeSet) GetDBName() string {
 	if m != nil {
@@ -426,206 +507,1217 @@ func (m *CreateTableChangeSet) GetRowMetas() []*RowMeta {
 	return nil
 }
 
-type RowMeta struct {
-	Name       string     `protobuf:"bytes,1,opt,name=Name,json=name" json:"Name,omitempty"`
-	ColumnType ColumnType `protobuf:"varint,2,opt,name=ColumnType,json=columnType,enum=pbs.ColumnType" json:"ColumnType,omitempty"`
-	Length     int64      `protobuf:"varint,3,opt,name=Length,json=length" json:"Length,omitempty"`
-	AllowsNull bool       `protobuf:"varint,4,opt,name=AllowsNull,json=allowsNull" json:"AllowsNull,omitempty"`
-}
-
-func (m *RowMeta) Reset()                    { *m = RowMeta{} }
-func (m *RowMeta) String() string            { return proto.CompactTextString(m) }
-func (*RowMeta) ProtoMessage()               {}
-func (*RowMeta) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
-
-func (m *RowMeta) GetName() string {
+func (m *CreateTableChangeSet) GetIndexes() []*IndexMeta {
 	if m != nil {
-		return m.Name
+		return m.Indexes
 	}
-	return ""
+	return nil
 }
 
-func (m *RowMeta) GetColumnType() ColumnType {
+func (m *CreateTableChangeSet) GetPrimaryKey() []string {
 	if m != nil {
-		return m.ColumnType
+		return m.PrimaryKey
 	}
-	return ColumnType_Int
+	return nil
 }
 
-func (m *RowMeta) GetLength() int64 {
-	if m != nil {
-		return m.Length
-	}
-	return 0
+type DropDBChangeSet struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *RowMeta) GetAllowsNull() bool {
+func (m *DropDBChangeSet) Reset()         { *m = DropDBChangeSet{} }
+func (m *DropDBChangeSet) String() string { return proto.CompactTextString(m) }
+func (*DropDBChangeSet) ProtoMessage()    {}
+func (*DropDBChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{3}
+}
+
+func (m *DropDBChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DropDBChangeSet.Unmarshal(m, b)
+}
+func (m *DropDBChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DropDBChangeSet.Marshal(b, m, deterministic)
+}
+func (m *DropDBChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DropDBChangeSet.Merge(m, src)
+}
+func (m *DropDBChangeSet) XXX_Size() int {
+	return xxx_messageInfo_DropDBChangeSet.Size(m)
+}
+func (m *DropDBChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_DropDBChangeSet.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DropDBChangeSet proto.InternalMessageInfo
+
+func (m *DropDBChangeSet) GetName() string {
 	if m != nil {
-		return m.AllowsNull
+		return m.Name
 	}
-	return false
+	return ""
 }
 
-type InsertChangeSets struct {
-	DBName            string       `protobuf:"bytes,1,opt,name=DBName,json=dBName" json:"DBName,omitempty"`
-	TableName         string       `protobuf:"bytes,2,opt,name=TableName,json=tableName" json:"TableName,omitempty"`
-	Rows              []*InsertRow `protobuf:"bytes,3,rep,name=Rows,json=rows" json:"Rows,omitempty"`
-	TransactionNumber int64        `protobuf:"varint,4,opt,name=TransactionNumber,json=transactionNumber" json:"TransactionNumber,omitempty"`
+type DropTableChangeSet struct {
+	DBName               string   `protobuf:"bytes,1,opt,name=DBName,proto3" json:"DBName,omitempty"`
+	Name                 string   `protobuf:"bytes,2,opt,name=Name,proto3" json:"Name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DropTableChangeSet) Reset()         { *m = DropTableChangeSet{} }
+func (m *DropTableChangeSet) String() string { return proto.CompactTextString(m) }
+func (*DropTableChangeSet) ProtoMessage()    {}
+func (*DropTableChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{4}
+}
+
+func (m *DropTableChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DropTableChangeSet.Unmarshal(m, b)
+}
+func (m *DropTableChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DropTableChangeSet.Marshal(b, m, deterministic)
+}
+func (m *DropTableChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DropTableChangeSet.Merge(m, src)
+}
+func (m *DropTableChangeSet) XXX_Size() int {
+	return xxx_messageInfo_DropTableChangeSet.Size(m)
+}
+func (m *DropTableChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_DropTableChangeSet.DiscardUnknown(m)
 }
 
-func (m *InsertChangeSets) Reset()                    { *m = InsertChangeSets{} }
-func (m *InsertChangeSets) String() string            { return proto.CompactTextString(m) }
-func (*InsertChangeSets) ProtoMessage()               {}
-func (*InsertChangeSets) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+var xxx_messageInfo_DropTableChangeSet proto.InternalMessageInfo
 
-func (m *InsertChangeSets) GetDBName() string {
+func (m *DropTableChangeSet) GetDBName() string {
 	if m != nil {
 		return m.DBName
 	}
 	return ""
 }
 
-func (m *InsertChangeSets) GetTableName() string {
+func (m *DropTableChangeSet) GetName() string {
 	if m != nil {
-		return m.TableName
+		return m.Name
 	}
 	return ""
 }
 
-func (m *InsertChangeSets) GetRows() []*InsertRow {
+type TruncateTableChangeSet struct {
+	DBName               string   `protobuf:"bytes,1,opt,name=DBName,proto3" json:"DBName,omitempty"`
+	Name                 string   `protobuf:"bytes,2,opt,name=Name,proto3" json:"Name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TruncateTableChangeSet) Reset()         { *m = TruncateTableChangeSet{} }
+func (m *TruncateTableChangeSet) String() string { return proto.CompactTextString(m) }
+func (*TruncateTableChangeSet) ProtoMessage()    {}
+func (*TruncateTableChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{5}
+}
+
+func (m *TruncateTableChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TruncateTableChangeSet.Unmarshal(m, b)
+}
+func (m *TruncateTableChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TruncateTableChangeSet.Marshal(b, m, deterministic)
+}
+func (m *TruncateTableChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TruncateTableChangeSet.Merge(m, src)
+}
+func (m *TruncateTableChangeSet) XXX_Size() int {
+	return xxx_messageInfo_TruncateTableChangeSet.Size(m)
+}
+func (m *TruncateTableChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_TruncateTableChangeSet.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TruncateTableChangeSet proto.InternalMessageInfo
+
+func (m *TruncateTableChangeSet) GetDBName() string {
 	if m != nil {
-		return m.Rows
+		return m.DBName
 	}
-	return nil
+	return ""
 }
 
-func (m *InsertChangeSets) GetTransactionNumber() int64 {
+func (m *TruncateTableChangeSet) GetName() string {
 	if m != nil {
-		return m.TransactionNumber
+		return m.Name
 	}
-	return 0
+	return ""
 }
 
-type InsertRow struct {
-	Columns map[string]string `protobuf:"bytes,1,rep,name=Columns,json=columns" json:"Columns,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+type AddColumnChangeSet struct {
+	DBName    string   `protobuf:"bytes,1,opt,name=DBName,proto3" json:"DBName,omitempty"`
+	TableName string   `protobuf:"bytes,2,opt,name=TableName,proto3" json:"TableName,omitempty"`
+	Column    *RowMeta `protobuf:"bytes,3,opt,name=Column,proto3" json:"Column,omitempty"`
+	// Default is set to existing rows when HasDefault is true. Otherwise they have NULL.
+	HasDefault           bool     `protobuf:"varint,4,opt,name=HasDefault,proto3" json:"HasDefault,omitempty"`
+	Default              string   `protobuf:"bytes,5,opt,name=Default,proto3" json:"Default,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *InsertRow) Reset()                    { *m = InsertRow{} }
-func (m *InsertRow) String() string            { return proto.CompactTextString(m) }
-func (*InsertRow) ProtoMessage()               {}
-func (*InsertRow) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
-
-func (m *InsertRow) GetColumns() map[string]string {
-	if m != nil {
-		return m.Columns
-	}
-	return nil
+func (m *AddColumnChangeSet) Reset()         { *m = AddColumnChangeSet{} }
+func (m *AddColumnChangeSet) String() string { return proto.CompactTextString(m) }
+func (*AddColumnChangeSet) ProtoMessage()    {}
+func (*AddColumnChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{6}
 }
 
-type UpdateChangeSets struct {
-	DBName            string       `protobuf:"bytes,1,opt,name=DBName,json=dBName" json:"DBName,omitempty"`
-	TableName         string       `protobuf:"bytes,2,opt,name=TableName,json=tableName" json:"TableName,omitempty"`
-	Rows              []*UpdateRow `protobuf:"bytes,3,rep,name=Rows,json=rows" json:"Rows,omitempty"`
-	TransactionNumber int64        `protobuf:"varint,4,opt,name=TransactionNumber,json=transactionNumber" json:"TransactionNumber,omitempty"`
+func (m *AddColumnChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddColumnChangeSet.Unmarshal(m, b)
+}
+func (m *AddColumnChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddColumnChangeSet.Marshal(b, m, deterministic)
+}
+func (m *AddColumnChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddColumnChangeSet.Merge(m, src)
+}
+func (m *AddColumnChangeSet) XXX_Size() int {
+	return xxx_messageInfo_AddColumnChangeSet.Size(m)
+}
+func (m *AddColumnChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddColumnChangeSet.DiscardUnknown(m)
 }
 
-func (m *UpdateChangeSets) Reset()                    { *m = UpdateChangeSets{} }
-func (m *UpdateChangeSets) String() string            { return proto.CompactTextString(m) }
-func (*UpdateChangeSets) ProtoMessage()               {}
-func (*UpdateChangeSets) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+var xxx_messageInfo_AddColumnChangeSet proto.InternalMessageInfo
 
-func (m *UpdateChangeSets) GetDBName() string {
+func (m *AddColumnChangeSet) GetDBName() string {
 	if m != nil {
 		return m.DBName
 	}
 	return ""
 }
 
-func (m *UpdateChangeSets) GetTableName() string {
+func (m *AddColumnChangeSet) GetTableName() string {
 	if m != nil {
 		return m.TableName
 	}
 	return ""
 }
 
-func (m *UpdateChangeSets) GetRows() []*UpdateRow {
+func (m *AddColumnChangeSet) GetColumn() *RowMeta {
 	if m != nil {
-		return m.Rows
+		return m.Column
 	}
 	return nil
 }
 
-func (m *UpdateChangeSets) GetTransactionNumber() int64 {
+func (m *AddColumnChangeSet) GetHasDefault() bool {
 	if m != nil {
-		return m.TransactionNumber
+		return m.HasDefault
 	}
-	return 0
-}
-
-type UpdateRow struct {
-	PrimaryKeyId int64             `protobuf:"varint,1,opt,name=PrimaryKeyId,json=primaryKeyId" json:"PrimaryKeyId,omitempty"`
-	Columns      map[string]string `protobuf:"bytes,2,rep,name=Columns,json=columns" json:"Columns,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	return false
 }
 
-func (m *UpdateRow) Reset()                    { *m = UpdateRow{} }
-func (m *UpdateRow) String() string            { return proto.CompactTextString(m) }
-func (*UpdateRow) ProtoMessage()               {}
-func (*UpdateRow) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
-
-func (m *UpdateRow) GetPrimaryKeyId() int64 {
+func (m *AddColumnChangeSet) GetDefault() string {
 	if m != nil {
-		return m.PrimaryKeyId
+		return m.Default
 	}
-	return 0
+	return ""
 }
 
-func (m *UpdateRow) GetColumns() map[string]string {
-	if m != nil {
-		return m.Columns
-	}
-	return nil
+type DropColumnChangeSet struct {
+	DBName               string   `protobuf:"bytes,1,opt,name=DBName,proto3" json:"DBName,omitempty"`
+	TableName            string   `protobuf:"bytes,2,opt,name=TableName,proto3" json:"TableName,omitempty"`
+	Name                 string   `protobuf:"bytes,3,opt,name=Name,proto3" json:"Name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
-type BeginChangeSet struct {
-	Number int64 `protobuf:"varint,1,opt,name=Number,json=number" json:"Number,omitempty"`
+func (m *DropColumnChangeSet) Reset()         { *m = DropColumnChangeSet{} }
+func (m *DropColumnChangeSet) String() string { return proto.CompactTextString(m) }
+func (*DropColumnChangeSet) ProtoMessage()    {}
+func (*DropColumnChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{7}
 }
 
-func (m *BeginChangeSet) Reset()                    { *m = BeginChangeSet{} }
-func (m *BeginChangeSet) String() string            { return proto.CompactTextString(m) }
-func (*BeginChangeSet) ProtoMessage()               {}
-func (*BeginChangeSet) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
-
-func (m *BeginChangeSet) GetNumber() int64 {
-	if m != nil {
-		return m.Number
-	}
-	return 0
+func (m *DropColumnChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DropColumnChangeSet.Unmarshal(m, b)
 }
-
-type CommitChangeSet struct {
-	Number int64 `protobuf:"varint,1,opt,name=Number,json=number" json:"Number,omitempty"`
+func (m *DropColumnChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DropColumnChangeSet.Marshal(b, m, deterministic)
+}
+func (m *DropColumnChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DropColumnChangeSet.Merge(m, src)
+}
+func (m *DropColumnChangeSet) XXX_Size() int {
+	return xxx_messageInfo_DropColumnChangeSet.Size(m)
+}
+func (m *DropColumnChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_DropColumnChangeSet.DiscardUnknown(m)
 }
 
-func (m *CommitChangeSet) Reset()                    { *m = CommitChangeSet{} }
-func (m *CommitChangeSet) String() string            { return proto.CompactTextString(m) }
-func (*CommitChangeSet) ProtoMessage()               {}
-func (*CommitChangeSet) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+var xxx_messageInfo_DropColumnChangeSet proto.InternalMessageInfo
 
-func (m *CommitChangeSet) GetNumber() int64 {
+func (m *DropColumnChangeSet) GetDBName() string {
 	if m != nil {
-		return m.Number
+		return m.DBName
+	}
+	return ""
+}
+
+func (m *DropColumnChangeSet) GetTableName() string {
+	if m != nil {
+		return m.TableName
+	}
+	return ""
+}
+
+func (m *DropColumnChangeSet) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+type RenameColumnChangeSet struct {
+	DBName               string   `protobuf:"bytes,1,opt,name=DBName,proto3" json:"DBName,omitempty"`
+	TableName            string   `protobuf:"bytes,2,opt,name=TableName,proto3" json:"TableName,omitempty"`
+	Name                 string   `protobuf:"bytes,3,opt,name=Name,proto3" json:"Name,omitempty"`
+	NewName              string   `protobuf:"bytes,4,opt,name=NewName,proto3" json:"NewName,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RenameColumnChangeSet) Reset()         { *m = RenameColumnChangeSet{} }
+func (m *RenameColumnChangeSet) String() string { return proto.CompactTextString(m) }
+func (*RenameColumnChangeSet) ProtoMessage()    {}
+func (*RenameColumnChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{8}
+}
+
+func (m *RenameColumnChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RenameColumnChangeSet.Unmarshal(m, b)
+}
+func (m *RenameColumnChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RenameColumnChangeSet.Marshal(b, m, deterministic)
+}
+func (m *RenameColumnChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RenameColumnChangeSet.Merge(m, src)
+}
+func (m *RenameColumnChangeSet) XXX_Size() int {
+	return xxx_messageInfo_RenameColumnChangeSet.Size(m)
+}
+func (m *RenameColumnChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_RenameColumnChangeSet.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RenameColumnChangeSet proto.InternalMessageInfo
+
+func (m *RenameColumnChangeSet) GetDBName() string {
+	if m != nil {
+		return m.DBName
+	}
+	return ""
+}
+
+func (m *RenameColumnChangeSet) GetTableName() string {
+	if m != nil {
+		return m.TableName
+	}
+	return ""
+}
+
+func (m *RenameColumnChangeSet) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *RenameColumnChangeSet) GetNewName() string {
+	if m != nil {
+		return m.NewName
+	}
+	return ""
+}
+
+type ModifyColumnChangeSet struct {
+	DBName               string   `protobuf:"bytes,1,opt,name=DBName,proto3" json:"DBName,omitempty"`
+	TableName            string   `protobuf:"bytes,2,opt,name=TableName,proto3" json:"TableName,omitempty"`
+	Column               *RowMeta `protobuf:"bytes,3,opt,name=Column,proto3" json:"Column,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ModifyColumnChangeSet) Reset()         { *m = ModifyColumnChangeSet{} }
+func (m *ModifyColumnChangeSet) String() string { return proto.CompactTextString(m) }
+func (*ModifyColumnChangeSet) ProtoMessage()    {}
+func (*ModifyColumnChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{9}
+}
+
+func (m *ModifyColumnChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ModifyColumnChangeSet.Unmarshal(m, b)
+}
+func (m *ModifyColumnChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ModifyColumnChangeSet.Marshal(b, m, deterministic)
+}
+func (m *ModifyColumnChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ModifyColumnChangeSet.Merge(m, src)
+}
+func (m *ModifyColumnChangeSet) XXX_Size() int {
+	return xxx_messageInfo_ModifyColumnChangeSet.Size(m)
+}
+func (m *ModifyColumnChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_ModifyColumnChangeSet.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ModifyColumnChangeSet proto.InternalMessageInfo
+
+func (m *ModifyColumnChangeSet) GetDBName() string {
+	if m != nil {
+		return m.DBName
+	}
+	return ""
+}
+
+func (m *ModifyColumnChangeSet) GetTableName() string {
+	if m != nil {
+		return m.TableName
+	}
+	return ""
+}
+
+func (m *ModifyColumnChangeSet) GetColumn() *RowMeta {
+	if m != nil {
+		return m.Column
+	}
+	return nil
+}
+
+type RenameTableChangeSet struct {
+	DBName               string   `protobuf:"bytes,1,opt,name=DBName,proto3" json:"DBName,omitempty"`
+	Name                 string   `protobuf:"bytes,2,opt,name=Name,proto3" json:"Name,omitempty"`
+	NewName              string   `protobuf:"bytes,3,opt,name=NewName,proto3" json:"NewName,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RenameTableChangeSet) Reset()         { *m = RenameTableChangeSet{} }
+func (m *RenameTableChangeSet) String() string { return proto.CompactTextString(m) }
+func (*RenameTableChangeSet) ProtoMessage()    {}
+func (*RenameTableChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{10}
+}
+
+func (m *RenameTableChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RenameTableChangeSet.Unmarshal(m, b)
+}
+func (m *RenameTableChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RenameTableChangeSet.Marshal(b, m, deterministic)
+}
+func (m *RenameTableChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RenameTableChangeSet.Merge(m, src)
+}
+func (m *RenameTableChangeSet) XXX_Size() int {
+	return xxx_messageInfo_RenameTableChangeSet.Size(m)
+}
+func (m *RenameTableChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_RenameTableChangeSet.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RenameTableChangeSet proto.InternalMessageInfo
+
+func (m *RenameTableChangeSet) GetDBName() string {
+	if m != nil {
+		return m.DBName
+	}
+	return ""
+}
+
+func (m *RenameTableChangeSet) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *RenameTableChangeSet) GetNewName() string {
+	if m != nil {
+		return m.NewName
+	}
+	return ""
+}
+
+type CreateIndexChangeSet struct {
+	DBName               string     `protobuf:"bytes,1,opt,name=DBName,proto3" json:"DBName,omitempty"`
+	TableName            string     `protobuf:"bytes,2,opt,name=TableName,proto3" json:"TableName,omitempty"`
+	Index                *IndexMeta `protobuf:"bytes,3,opt,name=Index,proto3" json:"Index,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *CreateIndexChangeSet) Reset()         { *m = CreateIndexChangeSet{} }
+func (m *CreateIndexChangeSet) String() string { return proto.CompactTextString(m) }
+func (*CreateIndexChangeSet) ProtoMessage()    {}
+func (*CreateIndexChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{11}
+}
+
+func (m *CreateIndexChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CreateIndexChangeSet.Unmarshal(m, b)
+}
+func (m *CreateIndexChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CreateIndexChangeSet.Marshal(b, m, deterministic)
+}
+func (m *CreateIndexChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CreateIndexChangeSet.Merge(m, src)
+}
+func (m *CreateIndexChangeSet) XXX_Size() int {
+	return xxx_messageInfo_CreateIndexChangeSet.Size(m)
+}
+func (m *CreateIndexChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_CreateIndexChangeSet.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CreateIndexChangeSet proto.InternalMessageInfo
+
+func (m *CreateIndexChangeSet) GetDBName() string {
+	if m != nil {
+		return m.DBName
+	}
+	return ""
+}
+
+func (m *CreateIndexChangeSet) GetTableName() string {
+	if m != nil {
+		return m.TableName
+	}
+	return ""
+}
+
+func (m *CreateIndexChangeSet) GetIndex() *IndexMeta {
+	if m != nil {
+		return m.Index
+	}
+	return nil
+}
+
+type DropIndexChangeSet struct {
+	DBName               string   `protobuf:"bytes,1,opt,name=DBName,proto3" json:"DBName,omitempty"`
+	TableName            string   `protobuf:"bytes,2,opt,name=TableName,proto3" json:"TableName,omitempty"`
+	Name                 string   `protobuf:"bytes,3,opt,name=Name,proto3" json:"Name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DropIndexChangeSet) Reset()         { *m = DropIndexChangeSet{} }
+func (m *DropIndexChangeSet) String() string { return proto.CompactTextString(m) }
+func (*DropIndexChangeSet) ProtoMessage()    {}
+func (*DropIndexChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{12}
+}
+
+func (m *DropIndexChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DropIndexChangeSet.Unmarshal(m, b)
+}
+func (m *DropIndexChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DropIndexChangeSet.Marshal(b, m, deterministic)
+}
+func (m *DropIndexChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DropIndexChangeSet.Merge(m, src)
+}
+func (m *DropIndexChangeSet) XXX_Size() int {
+	return xxx_messageInfo_DropIndexChangeSet.Size(m)
+}
+func (m *DropIndexChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_DropIndexChangeSet.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DropIndexChangeSet proto.InternalMessageInfo
+
+func (m *DropIndexChangeSet) GetDBName() string {
+	if m != nil {
+		return m.DBName
+	}
+	return ""
+}
+
+func (m *DropIndexChangeSet) GetTableName() string {
+	if m != nil {
+		return m.TableName
+	}
+	return ""
+}
+
+func (m *DropIndexChangeSet) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+type RowMeta struct {
+	Name                 string     `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
+	ColumnType           ColumnType `protobuf:"varint,2,opt,name=ColumnType,proto3,enum=pbs.ColumnType" json:"ColumnType,omitempty"`
+	Length               int64      `protobuf:"varint,3,opt,name=Length,proto3" json:"Length,omitempty"`
+	AllowsNull           bool       `protobuf:"varint,4,opt,name=AllowsNull,proto3" json:"AllowsNull,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *RowMeta) Reset()         { *m = RowMeta{} }
+func (m *RowMeta) String() string { return proto.CompactTextString(m) }
+func (*RowMeta) ProtoMessage()    {}
+func (*RowMeta) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{13}
+}
+
+func (m *RowMeta) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RowMeta.Unmarshal(m, b)
+}
+func (m *RowMeta) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RowMeta.Marshal(b, m, deterministic)
+}
+func (m *RowMeta) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RowMeta.Merge(m, src)
+}
+func (m *RowMeta) XXX_Size() int {
+	return xxx_messageInfo_RowMeta.Size(m)
+}
+func (m *RowMeta) XXX_DiscardUnknown() {
+	xxx_messageInfo_RowMeta.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RowMeta proto.InternalMessageInfo
+
+func (m *RowMeta) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *RowMeta) GetColumnType() ColumnType {
+	if m != nil {
+		return m.ColumnType
+	}
+	return ColumnType_Int
+}
+
+func (m *RowMeta) GetLength() int64 {
+	if m != nil {
+		return m.Length
+	}
+	return 0
+}
+
+func (m *RowMeta) GetAllowsNull() bool {
+	if m != nil {
+		return m.AllowsNull
+	}
+	return false
+}
+
+type IndexMeta struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
+	Columns              []string `protobuf:"bytes,2,rep,name=Columns,proto3" json:"Columns,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *IndexMeta) Reset()         { *m = IndexMeta{} }
+func (m *IndexMeta) String() string { return proto.CompactTextString(m) }
+func (*IndexMeta) ProtoMessage()    {}
+func (*IndexMeta) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{14}
+}
+
+func (m *IndexMeta) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_IndexMeta.Unmarshal(m, b)
+}
+func (m *IndexMeta) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_IndexMeta.Marshal(b, m, deterministic)
+}
+func (m *IndexMeta) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_IndexMeta.Merge(m, src)
+}
+func (m *IndexMeta) XXX_Size() int {
+	return xxx_messageInfo_IndexMeta.Size(m)
+}
+func (m *IndexMeta) XXX_DiscardUnknown() {
+	xxx_messageInfo_IndexMeta.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_IndexMeta proto.InternalMessageInfo
+
+func (m *IndexMeta) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *IndexMeta) GetColumns() []string {
+	if m != nil {
+		return m.Columns
+	}
+	return nil
+}
+
+type InsertChangeSets struct {
+	DBName               string       `protobuf:"bytes,1,opt,name=DBName,proto3" json:"DBName,omitempty"`
+	TableName            string       `protobuf:"bytes,2,opt,name=TableName,proto3" json:"TableName,omitempty"`
+	Rows                 []*InsertRow `protobuf:"bytes,3,rep,name=Rows,proto3" json:"Rows,omitempty"`
+	TransactionNumber    int64        `protobuf:"varint,4,opt,name=TransactionNumber,proto3" json:"TransactionNumber,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *InsertChangeSets) Reset()         { *m = InsertChangeSets{} }
+func (m *InsertChangeSets) String() string { return proto.CompactTextString(m) }
+func (*InsertChangeSets) ProtoMessage()    {}
+func (*InsertChangeSets) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{15}
+}
+
+func (m *InsertChangeSets) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_InsertChangeSets.Unmarshal(m, b)
+}
+func (m *InsertChangeSets) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_InsertChangeSets.Marshal(b, m, deterministic)
+}
+func (m *InsertChangeSets) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_InsertChangeSets.Merge(m, src)
+}
+func (m *InsertChangeSets) XXX_Size() int {
+	return xxx_messageInfo_InsertChangeSets.Size(m)
+}
+func (m *InsertChangeSets) XXX_DiscardUnknown() {
+	xxx_messageInfo_InsertChangeSets.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_InsertChangeSets proto.InternalMessageInfo
+
+func (m *InsertChangeSets) GetDBName() string {
+	if m != nil {
+		return m.DBName
+	}
+	return ""
+}
+
+func (m *InsertChangeSets) GetTableName() string {
+	if m != nil {
+		return m.TableName
+	}
+	return ""
+}
+
+func (m *InsertChangeSets) GetRows() []*InsertRow {
+	if m != nil {
+		return m.Rows
+	}
+	return nil
+}
+
+func (m *InsertChangeSets) GetTransactionNumber() int64 {
+	if m != nil {
+		return m.TransactionNumber
+	}
+	return 0
+}
+
+type InsertRow struct {
+	Columns              map[string]string `protobuf:"bytes,1,rep,name=Columns,proto3" json:"Columns,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *InsertRow) Reset()         { *m = InsertRow{} }
+func (m *InsertRow) String() string { return proto.CompactTextString(m) }
+func (*InsertRow) ProtoMessage()    {}
+func (*InsertRow) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{16}
+}
+
+func (m *InsertRow) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_InsertRow.Unmarshal(m, b)
+}
+func (m *InsertRow) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_InsertRow.Marshal(b, m, deterministic)
+}
+func (m *InsertRow) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_InsertRow.Merge(m, src)
+}
+func (m *InsertRow) XXX_Size() int {
+	return xxx_messageInfo_InsertRow.Size(m)
+}
+func (m *InsertRow) XXX_DiscardUnknown() {
+	xxx_messageInfo_InsertRow.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_InsertRow proto.InternalMessageInfo
+
+func (m *InsertRow) GetColumns() map[string]string {
+	if m != nil {
+		return m.Columns
+	}
+	return nil
+}
+
+type UpdateChangeSets struct {
+	DBName               string       `protobuf:"bytes,1,opt,name=DBName,proto3" json:"DBName,omitempty"`
+	TableName            string       `protobuf:"bytes,2,opt,name=TableName,proto3" json:"TableName,omitempty"`
+	Rows                 []*UpdateRow `protobuf:"bytes,3,rep,name=Rows,proto3" json:"Rows,omitempty"`
+	TransactionNumber    int64        `protobuf:"varint,4,opt,name=TransactionNumber,proto3" json:"TransactionNumber,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *UpdateChangeSets) Reset()         { *m = UpdateChangeSets{} }
+func (m *UpdateChangeSets) String() string { return proto.CompactTextString(m) }
+func (*UpdateChangeSets) ProtoMessage()    {}
+func (*UpdateChangeSets) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{17}
+}
+
+func (m *UpdateChangeSets) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UpdateChangeSets.Unmarshal(m, b)
+}
+func (m *UpdateChangeSets) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UpdateChangeSets.Marshal(b, m, deterministic)
+}
+func (m *UpdateChangeSets) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UpdateChangeSets.Merge(m, src)
+}
+func (m *UpdateChangeSets) XXX_Size() int {
+	return xxx_messageInfo_UpdateChangeSets.Size(m)
+}
+func (m *UpdateChangeSets) XXX_DiscardUnknown() {
+	xxx_messageInfo_UpdateChangeSets.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UpdateChangeSets proto.InternalMessageInfo
+
+func (m *UpdateChangeSets) GetDBName() string {
+	if m != nil {
+		return m.DBName
+	}
+	return ""
+}
+
+func (m *UpdateChangeSets) GetTableName() string {
+	if m != nil {
+		return m.TableName
+	}
+	return ""
+}
+
+func (m *UpdateChangeSets) GetRows() []*UpdateRow {
+	if m != nil {
+		return m.Rows
+	}
+	return nil
+}
+
+func (m *UpdateChangeSets) GetTransactionNumber() int64 {
+	if m != nil {
+		return m.TransactionNumber
+	}
+	return 0
+}
+
+type UpdateRow struct {
+	// Value of the primary key when it is a single INT column
+	PrimaryKeyId int64             `protobuf:"varint,1,opt,name=PrimaryKeyId,proto3" json:"PrimaryKeyId,omitempty"`
+	Columns      map[string]string `protobuf:"bytes,2,rep,name=Columns,proto3" json:"Columns,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Every column of the row before the update, which is empty in records written by older versions
+	Before map[string]string `protobuf:"bytes,3,rep,name=Before,proto3" json:"Before,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Values of the primary key in the order of columns, which is empty in records written by older versions
+	PrimaryKey           []string `protobuf:"bytes,4,rep,name=PrimaryKey,proto3" json:"PrimaryKey,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UpdateRow) Reset()         { *m = UpdateRow{} }
+func (m *UpdateRow) String() string { return proto.CompactTextString(m) }
+func (*UpdateRow) ProtoMessage()    {}
+func (*UpdateRow) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{18}
+}
+
+func (m *UpdateRow) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UpdateRow.Unmarshal(m, b)
+}
+func (m *UpdateRow) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UpdateRow.Marshal(b, m, deterministic)
+}
+func (m *UpdateRow) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UpdateRow.Merge(m, src)
+}
+func (m *UpdateRow) XXX_Size() int {
+	return xxx_messageInfo_UpdateRow.Size(m)
+}
+func (m *UpdateRow) XXX_DiscardUnknown() {
+	xxx_messageInfo_UpdateRow.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UpdateRow proto.InternalMessageInfo
+
+func (m *UpdateRow) GetPrimaryKeyId() int64 {
+	if m != nil {
+		return m.PrimaryKeyId
+	}
+	return 0
+}
+
+func (m *UpdateRow) GetColumns() map[string]string {
+	if m != nil {
+		return m.Columns
+	}
+	return nil
+}
+
+func (m *UpdateRow) GetBefore() map[string]string {
+	if m != nil {
+		return m.Before
+	}
+	return nil
+}
+
+func (m *UpdateRow) GetPrimaryKey() []string {
+	if m != nil {
+		return m.PrimaryKey
+	}
+	return nil
+}
+
+type DeleteChangeSets struct {
+	DBName               string       `protobuf:"bytes,1,opt,name=DBName,proto3" json:"DBName,omitempty"`
+	TableName            string       `protobuf:"bytes,2,opt,name=TableName,proto3" json:"TableName,omitempty"`
+	Rows                 []*DeleteRow `protobuf:"bytes,3,rep,name=Rows,proto3" json:"Rows,omitempty"`
+	TransactionNumber    int64        `protobuf:"varint,4,opt,name=TransactionNumber,proto3" json:"TransactionNumber,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *DeleteChangeSets) Reset()         { *m = DeleteChangeSets{} }
+func (m *DeleteChangeSets) String() string { return proto.CompactTextString(m) }
+func (*DeleteChangeSets) ProtoMessage()    {}
+func (*DeleteChangeSets) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{19}
+}
+
+func (m *DeleteChangeSets) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteChangeSets.Unmarshal(m, b)
+}
+func (m *DeleteChangeSets) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteChangeSets.Marshal(b, m, deterministic)
+}
+func (m *DeleteChangeSets) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteChangeSets.Merge(m, src)
+}
+func (m *DeleteChangeSets) XXX_Size() int {
+	return xxx_messageInfo_DeleteChangeSets.Size(m)
+}
+func (m *DeleteChangeSets) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteChangeSets.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteChangeSets proto.InternalMessageInfo
+
+func (m *DeleteChangeSets) GetDBName() string {
+	if m != nil {
+		return m.DBName
+	}
+	return ""
+}
+
+func (m *DeleteChangeSets) GetTableName() string {
+	if m != nil {
+		return m.TableName
+	}
+	return ""
+}
+
+func (m *DeleteChangeSets) GetRows() []*DeleteRow {
+	if m != nil {
+		return m.Rows
+	}
+	return nil
+}
+
+func (m *DeleteChangeSets) GetTransactionNumber() int64 {
+	if m != nil {
+		return m.TransactionNumber
+	}
+	return 0
+}
+
+type DeleteRow struct {
+	// Value of the primary key when it is a single INT column
+	PrimaryKeyId int64 `protobuf:"varint,1,opt,name=PrimaryKeyId,proto3" json:"PrimaryKeyId,omitempty"`
+	// Every column of the row before the delete
+	Before map[string]string `protobuf:"bytes,2,rep,name=Before,proto3" json:"Before,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Values of the primary key in the order of columns, which is empty in records written by older versions
+	PrimaryKey           []string `protobuf:"bytes,3,rep,name=PrimaryKey,proto3" json:"PrimaryKey,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeleteRow) Reset()         { *m = DeleteRow{} }
+func (m *DeleteRow) String() string { return proto.CompactTextString(m) }
+func (*DeleteRow) ProtoMessage()    {}
+func (*DeleteRow) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{20}
+}
+
+func (m *DeleteRow) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteRow.Unmarshal(m, b)
+}
+func (m *DeleteRow) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteRow.Marshal(b, m, deterministic)
+}
+func (m *DeleteRow) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteRow.Merge(m, src)
+}
+func (m *DeleteRow) XXX_Size() int {
+	return xxx_messageInfo_DeleteRow.Size(m)
+}
+func (m *DeleteRow) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteRow.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteRow proto.InternalMessageInfo
+
+func (m *DeleteRow) GetPrimaryKeyId() int64 {
+	if m != nil {
+		return m.PrimaryKeyId
+	}
+	return 0
+}
+
+func (m *DeleteRow) GetBefore() map[string]string {
+	if m != nil {
+		return m.Before
+	}
+	return nil
+}
+
+func (m *DeleteRow) GetPrimaryKey() []string {
+	if m != nil {
+		return m.PrimaryKey
+	}
+	return nil
+}
+
+type BeginChangeSet struct {
+	Number               int64    `protobuf:"varint,1,opt,name=Number,proto3" json:"Number,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BeginChangeSet) Reset()         { *m = BeginChangeSet{} }
+func (m *BeginChangeSet) String() string { return proto.CompactTextString(m) }
+func (*BeginChangeSet) ProtoMessage()    {}
+func (*BeginChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{21}
+}
+
+func (m *BeginChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BeginChangeSet.Unmarshal(m, b)
+}
+func (m *BeginChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BeginChangeSet.Marshal(b, m, deterministic)
+}
+func (m *BeginChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BeginChangeSet.Merge(m, src)
+}
+func (m *BeginChangeSet) XXX_Size() int {
+	return xxx_messageInfo_BeginChangeSet.Size(m)
+}
+func (m *BeginChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_BeginChangeSet.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BeginChangeSet proto.InternalMessageInfo
+
+func (m *BeginChangeSet) GetNumber() int64 {
+	if m != nil {
+		return m.Number
+	}
+	return 0
+}
+
+type CommitChangeSet struct {
+	Number int64 `protobuf:"varint,1,opt,name=Number,proto3" json:"Number,omitempty"`
+	// Unix time in nanoseconds when the transaction is committed
+	Timestamp int64 `protobuf:"varint,2,opt,name=Timestamp,proto3" json:"Timestamp,omitempty"`
+	// Rows read by the transaction, which are checked on every node to decide whether it conflicts
+	ReadRows             []*ReadRow `protobuf:"bytes,3,rep,name=ReadRows,proto3" json:"ReadRows,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *CommitChangeSet) Reset()         { *m = CommitChangeSet{} }
+func (m *CommitChangeSet) String() string { return proto.CompactTextString(m) }
+func (*CommitChangeSet) ProtoMessage()    {}
+func (*CommitChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{22}
+}
+
+func (m *CommitChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CommitChangeSet.Unmarshal(m, b)
+}
+func (m *CommitChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CommitChangeSet.Marshal(b, m, deterministic)
+}
+func (m *CommitChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CommitChangeSet.Merge(m, src)
+}
+func (m *CommitChangeSet) XXX_Size() int {
+	return xxx_messageInfo_CommitChangeSet.Size(m)
+}
+func (m *CommitChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_CommitChangeSet.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CommitChangeSet proto.InternalMessageInfo
+
+func (m *CommitChangeSet) GetNumber() int64 {
+	if m != nil {
+		return m.Number
+	}
+	return 0
+}
+
+func (m *CommitChangeSet) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *CommitChangeSet) GetReadRows() []*ReadRow {
+	if m != nil {
+		return m.ReadRows
+	}
+	return nil
+}
+
+type ReadRow struct {
+	DBName    string `protobuf:"bytes,1,opt,name=DBName,proto3" json:"DBName,omitempty"`
+	TableName string `protobuf:"bytes,2,opt,name=TableName,proto3" json:"TableName,omitempty"`
+	// Values of the primary key in the order of columns
+	PrimaryKey []string `protobuf:"bytes,3,rep,name=PrimaryKey,proto3" json:"PrimaryKey,omitempty"`
+	// LSN of the ChangeSet which committed the value read by the transaction
+	Lsn                  int64    `protobuf:"varint,4,opt,name=Lsn,proto3" json:"Lsn,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReadRow) Reset()         { *m = ReadRow{} }
+func (m *ReadRow) String() string { return proto.CompactTextString(m) }
+func (*ReadRow) ProtoMessage()    {}
+func (*ReadRow) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{23}
+}
+
+func (m *ReadRow) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReadRow.Unmarshal(m, b)
+}
+func (m *ReadRow) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReadRow.Marshal(b, m, deterministic)
+}
+func (m *ReadRow) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReadRow.Merge(m, src)
+}
+func (m *ReadRow) XXX_Size() int {
+	return xxx_messageInfo_ReadRow.Size(m)
+}
+func (m *ReadRow) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReadRow.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReadRow proto.InternalMessageInfo
+
+func (m *ReadRow) GetDBName() string {
+	if m != nil {
+		return m.DBName
+	}
+	return ""
+}
+
+func (m *ReadRow) GetTableName() string {
+	if m != nil {
+		return m.TableName
+	}
+	return ""
+}
+
+func (m *ReadRow) GetPrimaryKey() []string {
+	if m != nil {
+		return m.PrimaryKey
+	}
+	return nil
+}
+
+func (m *ReadRow) GetLsn() int64 {
+	if m != nil {
+		return m.Lsn
 	}
 	return 0
 }
 
 type RollbackChangeSet struct {
-	Number int64 `protobuf:"varint,1,opt,name=Number,json=number" json:"Number,omitempty"`
+	Number               int64    `protobuf:"varint,1,opt,name=Number,proto3" json:"Number,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RollbackChangeSet) Reset()         { *m = RollbackChangeSet{} }
+func (m *RollbackChangeSet) String() string { return proto.CompactTextString(m) }
+func (*RollbackChangeSet) ProtoMessage()    {}
+func (*RollbackChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{24}
 }
 
-func (m *RollbackChangeSet) Reset()                    { *m = RollbackChangeSet{} }
-func (m *RollbackChangeSet) String() string            { return proto.CompactTextString(m) }
-func (*RollbackChangeSet) ProtoMessage()               {}
-func (*RollbackChangeSet) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+func (m *RollbackChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RollbackChangeSet.Unmarshal(m, b)
+}
+func (m *RollbackChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RollbackChangeSet.Marshal(b, m, deterministic)
+}
+func (m *RollbackChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RollbackChangeSet.Merge(m, src)
+}
+func (m *RollbackChangeSet) XXX_Size() int {
+	return xxx_messageInfo_RollbackChangeSet.Size(m)
+}
+func (m *RollbackChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_RollbackChangeSet.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RollbackChangeSet proto.InternalMessageInfo
 
 func (m *RollbackChangeSet) GetNumber() int64 {
 	if m != nil {
@@ -635,13 +1727,36 @@ func (m *RollbackChangeSet) GetNumber() int64 {
 }
 
 type AbortChangeSet struct {
-	Number int64 `protobuf:"varint,1,opt,name=Number,json=number" json:"Number,omitempty"`
+	Number               int64    `protobuf:"varint,1,opt,name=Number,proto3" json:"Number,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AbortChangeSet) Reset()         { *m = AbortChangeSet{} }
+func (m *AbortChangeSet) String() string { return proto.CompactTextString(m) }
+func (*AbortChangeSet) ProtoMessage()    {}
+func (*AbortChangeSet) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b042552c306ae59b, []int{25}
 }
 
-func (m *AbortChangeSet) Reset()                    { *m = AbortChangeSet{} }
-func (m *AbortChangeSet) String() string            { return proto.CompactTextString(m) }
-func (*AbortChangeSet) ProtoMessage()               {}
-func (*AbortChangeSet) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+func (m *AbortChangeSet) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AbortChangeSet.Unmarshal(m, b)
+}
+func (m *AbortChangeSet) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AbortChangeSet.Marshal(b, m, deterministic)
+}
+func (m *AbortChangeSet) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AbortChangeSet.Merge(m, src)
+}
+func (m *AbortChangeSet) XXX_Size() int {
+	return xxx_messageInfo_AbortChangeSet.Size(m)
+}
+func (m *AbortChangeSet) XXX_DiscardUnknown() {
+	xxx_messageInfo_AbortChangeSet.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AbortChangeSet proto.InternalMessageInfo
 
 func (m *AbortChangeSet) GetNumber() int64 {
 	if m != nil {
@@ -651,65 +1766,115 @@ func (m *AbortChangeSet) GetNumber() int64 {
 }
 
 func init() {
+	proto.RegisterEnum("pbs.ColumnType", ColumnType_name, ColumnType_value)
 	proto.RegisterType((*ChangeSet)(nil), "pbs.ChangeSet")
 	proto.RegisterType((*CreateDBChangeSet)(nil), "pbs.CreateDBChangeSet")
 	proto.RegisterType((*CreateTableChangeSet)(nil), "pbs.CreateTableChangeSet")
+	proto.RegisterType((*DropDBChangeSet)(nil), "pbs.DropDBChangeSet")
+	proto.RegisterType((*DropTableChangeSet)(nil), "pbs.DropTableChangeSet")
+	proto.RegisterType((*TruncateTableChangeSet)(nil), "pbs.TruncateTableChangeSet")
+	proto.RegisterType((*AddColumnChangeSet)(nil), "pbs.AddColumnChangeSet")
+	proto.RegisterType((*DropColumnChangeSet)(nil), "pbs.DropColumnChangeSet")
+	proto.RegisterType((*RenameColumnChangeSet)(nil), "pbs.RenameColumnChangeSet")
+	proto.RegisterType((*ModifyColumnChangeSet)(nil), "pbs.ModifyColumnChangeSet")
+	proto.RegisterType((*RenameTableChangeSet)(nil), "pbs.RenameTableChangeSet")
+	proto.RegisterType((*CreateIndexChangeSet)(nil), "pbs.CreateIndexChangeSet")
+	proto.RegisterType((*DropIndexChangeSet)(nil), "pbs.DropIndexChangeSet")
 	proto.RegisterType((*RowMeta)(nil), "pbs.RowMeta")
+	proto.RegisterType((*IndexMeta)(nil), "pbs.IndexMeta")
 	proto.RegisterType((*InsertChangeSets)(nil), "pbs.InsertChangeSets")
 	proto.RegisterType((*InsertRow)(nil), "pbs.InsertRow")
+	proto.RegisterMapType((map[string]string)(nil), "pbs.InsertRow.ColumnsEntry")
 	proto.RegisterType((*UpdateChangeSets)(nil), "pbs.UpdateChangeSets")
 	proto.RegisterType((*UpdateRow)(nil), "pbs.UpdateRow")
+	proto.RegisterMapType((map[string]string)(nil), "pbs.UpdateRow.BeforeEntry")
+	proto.RegisterMapType((map[string]string)(nil), "pbs.UpdateRow.ColumnsEntry")
+	proto.RegisterType((*DeleteChangeSets)(nil), "pbs.DeleteChangeSets")
+	proto.RegisterType((*DeleteRow)(nil), "pbs.DeleteRow")
+	proto.RegisterMapType((map[string]string)(nil), "pbs.DeleteRow.BeforeEntry")
 	proto.RegisterType((*BeginChangeSet)(nil), "pbs.BeginChangeSet")
 	proto.RegisterType((*CommitChangeSet)(nil), "pbs.CommitChangeSet")
+	proto.RegisterType((*ReadRow)(nil), "pbs.ReadRow")
 	proto.RegisterType((*RollbackChangeSet)(nil), "pbs.RollbackChangeSet")
 	proto.RegisterType((*AbortChangeSet)(nil), "pbs.AbortChangeSet")
-	proto.RegisterEnum("pbs.ColumnType", ColumnType_name, ColumnType_value)
 }
 
-func init() { proto.RegisterFile("raft.proto", fileDescriptor0) }
-
-var fileDescriptor0 = []byte{
-	// 663 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x95, 0x4f, 0x6f, 0xd3, 0x30,
-	0x18, 0xc6, 0x97, 0xa5, 0x4b, 0xda, 0xb7, 0x55, 0xd7, 0x9a, 0x32, 0x99, 0x3f, 0x42, 0x55, 0x2e,
-	0x84, 0x3f, 0xda, 0xa4, 0xc1, 0xa4, 0x69, 0x12, 0x87, 0xb5, 0x43, 0x5a, 0xc5, 0x98, 0x50, 0x36,
-	0xb8, 0x3b, 0xa9, 0x59, 0xab, 0x25, 0x4e, 0x65, 0xbb, 0x54, 0xbd, 0x70, 0x42, 0x1c, 0x39, 0x23,
-	0x8e, 0xfb, 0x34, 0x9c, 0xf7, 0x89, 0x50, 0xec, 0x34, 0x73, 0xba, 0xf5, 0x82, 0xe0, 0xd6, 0xfc,
-	0xfc, 0x3e, 0x6f, 0xde, 0xe7, 0x89, 0xed, 0x02, 0x70, 0xf2, 0x59, 0x6e, 0x4f, 0x78, 0x2a, 0x53,
-	0x64, 0x4f, 0x42, 0xe1, 0x5d, 0xdb, 0x50, 0xeb, 0x8f, 0x08, 0xbb, 0xa0, 0x67, 0x54, 0xa2, 0x16,
-	0xd8, 0x27, 0x82, 0x61, 0xab, 0x6b, 0xf9, 0x76, 0x60, 0xc7, 0x82, 0xa1, 0xd7, 0x50, 0xed, 0x73,
-	0x4a, 0x24, 0x3d, 0xea, 0xe1, 0x7a, 0xd7, 0xf2, 0xeb, 0xbb, 0x5b, 0xdb, 0x93, 0x50, 0x6c, 0x2f,
-	0x60, 0xa1, 0x3d, 0x5e, 0x0b, 0xaa, 0x51, 0x0e, 0xd1, 0x1b, 0xa8, 0xeb, 0x82, 0x73, 0x12, 0xc6,
-	0x14, 0x0f, 0x95, 0xf0, 0x81, 0x21, 0x54, 0xdc, 0xd4, 0xd6, 0xa3, 0x1b, 0x8e, 0xf6, 0x01, 0x06,
-	0x4c, 0x50, 0x2e, 0xcf, 0xa8, 0x14, 0xf8, 0xb7, 0xa5, 0xe4, 0xf7, 0x95, 0x5c, 0xf3, 0x42, 0x29,
-	0x8e, 0xd7, 0x02, 0x18, 0x17, 0xb5, 0x99, 0xf2, 0xe3, 0x64, 0x48, 0xa4, 0x5a, 0xc3, 0xd7, 0xa6,
-	0x52, 0xf3, 0xb2, 0x72, 0x5a, 0xd4, 0xa2, 0x97, 0xb0, 0xd1, 0xa3, 0x17, 0x63, 0x86, 0xbf, 0xb9,
-	0x4a, 0x74, 0x4f, 0x89, 0x14, 0x32, 0xe7, 0xdc, 0x08, 0x33, 0x82, 0x76, 0xc0, 0xe9, 0xa7, 0x49,
-	0x32, 0x96, 0xf8, 0x87, 0x2e, 0xef, 0x68, 0x73, 0x8a, 0x99, 0xf5, 0x4e, 0xa4, 0x10, 0xda, 0x83,
-	0x6a, 0x90, 0xc6, 0x71, 0x48, 0xa2, 0x4b, 0xfc, 0xd3, 0x35, 0x82, 0x5c, 0xd0, 0x52, 0x90, 0x3c,
-	0x87, 0xd9, 0x54, 0x87, 0x61, 0xca, 0x25, 0xbe, 0x32, 0xa7, 0x52, 0xa8, 0x34, 0x15, 0xc9, 0x48,
-	0xcf, 0x81, 0xca, 0x11, 0x91, 0xc4, 0x7b, 0x0a, 0xed, 0x5b, 0xdf, 0x07, 0x21, 0xa8, 0x9c, 0x92,
-	0x84, 0xaa, 0x8f, 0x5b, 0x0b, 0x2a, 0x8c, 0x24, 0xd4, 0x8b, 0xa1, 0x73, 0xd7, 0xf7, 0x40, 0x5b,
-	0xe0, 0x1c, 0xf5, 0x8c, 0x6a, 0x67, 0xa8, 0x9e, 0x8a, 0x1e, 0xeb, 0x37, 0x3d, 0x90, 0x9f, 0x39,
-	0x9b, 0xbd, 0xa7, 0x92, 0x08, 0x6c, 0x77, 0x6d, 0xbf, 0xbe, 0xdb, 0xc8, 0x8d, 0x29, 0x98, 0x99,
-	0xd1, 0xab, 0xde, 0x77, 0x0b, 0xdc, 0x9c, 0xde, 0x35, 0x0d, 0xda, 0x01, 0xe8, 0xa7, 0xf1, 0x34,
-	0x61, 0xe7, 0xf3, 0x89, 0x7e, 0x47, 0x73, 0x77, 0x33, 0xcf, 0x75, 0x81, 0x03, 0x88, 0x8a, 0xdf,
-	0xd9, 0x98, 0x27, 0x94, 0x5d, 0xc8, 0x11, 0xb6, 0xd5, 0x8e, 0x75, 0x62, 0xf5, 0x84, 0x9e, 0x00,
-	0x1c, 0xc6, 0x71, 0x3a, 0x13, 0xa7, 0xd3, 0x38, 0xc6, 0x95, 0xae, 0xe5, 0x57, 0x03, 0x20, 0x05,
-	0xf1, 0x7e, 0x59, 0xd0, 0x5a, 0xde, 0x48, 0x2b, 0x3d, 0x3f, 0x86, 0x9a, 0x4a, 0xc7, 0x30, 0x5e,
-	0x93, 0x0b, 0x80, 0x3c, 0xa8, 0x04, 0xe9, 0x6c, 0xe1, 0xbc, 0x69, 0xec, 0xd1, 0x20, 0x9d, 0x05,
-	0x15, 0x9e, 0xce, 0xb2, 0xad, 0xd5, 0x3e, 0xe7, 0x84, 0x09, 0x12, 0xc9, 0x71, 0xca, 0x4e, 0xa7,
-	0x49, 0x48, 0xb9, 0x9a, 0xca, 0x0e, 0xda, 0x72, 0x79, 0xc1, 0xfb, 0x0a, 0xb5, 0xa2, 0x01, 0xda,
-	0x03, 0x57, 0x7b, 0x17, 0xd8, 0x52, 0x6f, 0x78, 0x54, 0x7e, 0x43, 0x9e, 0x8c, 0x78, 0xcb, 0x24,
-	0x9f, 0x07, 0xae, 0xce, 0x46, 0x3c, 0x3c, 0x80, 0x86, 0xb9, 0x90, 0x9d, 0xeb, 0x4b, 0x3a, 0xcf,
-	0x8d, 0x65, 0x3f, 0x51, 0x07, 0x36, 0xbe, 0x90, 0x78, 0xba, 0x70, 0xa4, 0x1f, 0x0e, 0xd6, 0xf7,
-	0x2d, 0x15, 0xce, 0xf2, 0x59, 0xf9, 0x87, 0xe1, 0xe8, 0xd6, 0x7f, 0x1b, 0xce, 0x95, 0x05, 0xb5,
-	0xa2, 0x03, 0xf2, 0xa0, 0xf1, 0x81, 0x8f, 0x13, 0xc2, 0xe7, 0xef, 0xe8, 0x7c, 0x30, 0xcc, 0xef,
-	0xad, 0xc6, 0xc4, 0x60, 0x66, 0x82, 0xeb, 0x46, 0x82, 0x45, 0x93, 0xff, 0x90, 0xa0, 0x0f, 0xcd,
-	0xf2, 0xbd, 0x91, 0xc5, 0x97, 0x3b, 0xd3, 0x23, 0x3a, 0x4c, 0xdb, 0x79, 0x06, 0x9b, 0x4b, 0x57,
-	0xc6, 0xca, 0xd2, 0x17, 0xd0, 0xbe, 0x75, 0x55, 0xac, 0x2c, 0xf6, 0xa1, 0x59, 0xbe, 0x23, 0x56,
-	0x55, 0x3e, 0xdf, 0x37, 0xcf, 0x1c, 0x72, 0xc1, 0x1e, 0x30, 0xd9, 0x5a, 0x43, 0x1d, 0x68, 0x1d,
-	0x4e, 0x65, 0x3a, 0x60, 0x11, 0xa7, 0x09, 0x65, 0x32, 0xa3, 0x16, 0xaa, 0x83, 0xfb, 0x89, 0xf0,
-	0xfe, 0x88, 0xf0, 0x16, 0x84, 0x8e, 0xfa, 0x17, 0x79, 0xf5, 0x27, 0x00, 0x00, 0xff, 0xff, 0xc3,
-	0x33, 0xb7, 0xc6, 0x53, 0x06, 0x00, 0x00,
+func init() { proto.RegisterFile("raft.proto", fileDescriptor_b042552c306ae59b) }
+
+var fileDescriptor_b042552c306ae59b = []byte{
+	// 1170 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x58, 0x4b, 0x6f, 0x23, 0x45,
+	0x10, 0xde, 0xf1, 0x38, 0x76, 0xa6, 0x1c, 0xf2, 0xe8, 0x4d, 0x42, 0x13, 0x10, 0xb2, 0x46, 0x20,
+	0x2c, 0x58, 0x65, 0xa5, 0x40, 0x44, 0x76, 0x11, 0x88, 0xd8, 0x46, 0x5a, 0x8b, 0xdd, 0x08, 0xcd,
+	0x06, 0x4e, 0x08, 0xd4, 0x8e, 0x3b, 0x89, 0xb5, 0xf3, 0x70, 0x66, 0xda, 0x24, 0x16, 0x12, 0x07,
+	0x58, 0x38, 0x21, 0x8e, 0x80, 0x38, 0x72, 0x44, 0xe2, 0xc0, 0x85, 0x23, 0x67, 0xce, 0x9c, 0xf9,
+	0x31, 0xa8, 0x1f, 0xd3, 0xd3, 0xf3, 0x88, 0xd8, 0x78, 0xb3, 0xb7, 0xe9, 0xaf, 0xea, 0xab, 0xea,
+	0xaa, 0x9a, 0xaa, 0xee, 0x19, 0x80, 0x98, 0x1c, 0xb3, 0xed, 0x49, 0x1c, 0xb1, 0x08, 0xd9, 0x93,
+	0x61, 0xe2, 0xfe, 0xee, 0x80, 0xd3, 0x3b, 0x25, 0xe1, 0x09, 0x7d, 0x48, 0x19, 0x5a, 0x05, 0xfb,
+	0x7e, 0x12, 0x62, 0xab, 0x6d, 0x75, 0x6c, 0x8f, 0x3f, 0xa2, 0x97, 0xc0, 0xf1, 0xe8, 0xd9, 0x94,
+	0x26, 0x6c, 0x30, 0xc2, 0xb5, 0xb6, 0xd5, 0xa9, 0x7b, 0x19, 0xc0, 0xa5, 0x87, 0xe3, 0x80, 0x26,
+	0x8c, 0x04, 0x13, 0x6c, 0x0b, 0x56, 0x06, 0xa0, 0xb7, 0x60, 0xb1, 0x17, 0x53, 0xc2, 0x68, 0xbf,
+	0x8b, 0x5b, 0x6d, 0xab, 0xd3, 0xda, 0xd9, 0xdc, 0x9e, 0x0c, 0x93, 0xed, 0x14, 0xd4, 0x7e, 0xef,
+	0xdd, 0xf0, 0xb4, 0x26, 0xda, 0x86, 0x46, 0x3f, 0x8e, 0x26, 0xfd, 0x2e, 0x5e, 0x12, 0x9c, 0x75,
+	0xc1, 0x91, 0x90, 0xc9, 0x50, 0x5a, 0xe8, 0x5d, 0x68, 0x49, 0xee, 0x21, 0x19, 0xfa, 0x14, 0x8f,
+	0x04, 0xe9, 0x05, 0xc3, 0x91, 0xc0, 0x4d, 0xa6, 0xa9, 0x8f, 0xde, 0x06, 0x87, 0x1b, 0x92, 0xe4,
+	0x50, 0x90, 0x9f, 0xd7, 0x1e, 0x4b, 0xd4, 0x4c, 0x17, 0xf5, 0xe0, 0xb9, 0xc3, 0x78, 0x1a, 0x1e,
+	0x69, 0xcf, 0x17, 0x82, 0xfc, 0xa2, 0x20, 0xe7, 0x24, 0xa6, 0x81, 0x3c, 0x07, 0xed, 0x81, 0xb3,
+	0x3f, 0x1a, 0xf5, 0x22, 0x7f, 0x1a, 0x84, 0xf8, 0x6b, 0xcb, 0x70, 0xaf, 0xe1, 0x9c, 0x7b, 0x8d,
+	0xa2, 0x77, 0x00, 0xf8, 0x5e, 0x14, 0xf5, 0x1b, 0x49, 0xc5, 0x7a, 0xe7, 0x65, 0xae, 0xa1, 0x8e,
+	0xf6, 0x61, 0xc9, 0xa3, 0x21, 0x09, 0xa8, 0xa2, 0x3f, 0x96, 0xf4, 0x2d, 0x41, 0x37, 0x25, 0xa6,
+	0x81, 0x1c, 0x85, 0x9b, 0x78, 0x10, 0x8d, 0xc6, 0xc7, 0x33, 0x65, 0xe2, 0x5b, 0xd3, 0x84, 0x29,
+	0xc9, 0x99, 0x30, 0x05, 0xe8, 0x3d, 0x68, 0x49, 0x93, 0x32, 0x7f, 0xdf, 0x5b, 0x46, 0xe9, 0x0c,
+	0x41, 0xae, 0x74, 0x06, 0xce, 0xf9, 0xb2, 0x92, 0x83, 0x70, 0x44, 0x2f, 0xf0, 0x8f, 0x56, 0xa9,
+	0xf4, 0x42, 0x50, 0x51, 0x7a, 0x81, 0xf3, 0xe4, 0xf3, 0x9c, 0x48, 0xf6, 0x4f, 0x56, 0xa1, 0xf6,
+	0x25, 0x6e, 0xa6, 0x8c, 0xf6, 0x00, 0x06, 0x61, 0x42, 0x63, 0xf6, 0x90, 0xb2, 0x04, 0xff, 0x2d,
+	0xa9, 0x1b, 0x82, 0x2a, 0x71, 0xcd, 0x4b, 0x78, 0xe6, 0x33, 0x5d, 0xce, 0xfc, 0x78, 0x32, 0x22,
+	0x4c, 0xc8, 0xf0, 0x3f, 0x26, 0x53, 0xe2, 0x79, 0x66, 0xa6, 0xcb, 0x99, 0x7d, 0xea, 0x53, 0xc5,
+	0xfc, 0xd7, 0x64, 0x4a, 0x3c, 0xcf, 0xcc, 0x74, 0xd1, 0x2d, 0x58, 0xe8, 0xd2, 0x93, 0x71, 0x88,
+	0x1f, 0x37, 0x05, 0xe9, 0xa6, 0x20, 0x09, 0xc8, 0x8c, 0x4f, 0x2a, 0xa1, 0xdb, 0xd0, 0xe8, 0x45,
+	0x41, 0x30, 0x66, 0xf8, 0x87, 0xa6, 0xd1, 0x80, 0x12, 0xcb, 0x35, 0xa0, 0x84, 0xd0, 0x2e, 0x2c,
+	0x7a, 0x91, 0xef, 0x0f, 0xc9, 0xd1, 0x23, 0xfc, 0x73, 0xd3, 0xe8, 0xf3, 0x14, 0xcd, 0xf5, 0x79,
+	0x0a, 0xf2, 0x5d, 0xed, 0x0f, 0xa3, 0x98, 0xe1, 0x5f, 0xcd, 0x5d, 0x09, 0x28, 0xb7, 0x2b, 0x81,
+	0x74, 0x1b, 0x50, 0xef, 0x13, 0x46, 0xdc, 0xd7, 0x60, 0xad, 0x34, 0x3e, 0x10, 0x82, 0xfa, 0x01,
+	0x09, 0xa8, 0x98, 0x5b, 0x8e, 0x27, 0x9e, 0xdd, 0x3f, 0x2d, 0x58, 0xaf, 0xea, 0x7f, 0xb4, 0x09,
+	0x8d, 0x7e, 0xd7, 0x50, 0x57, 0x2b, 0x6d, 0xa4, 0x96, 0x19, 0x41, 0x1d, 0x1e, 0xda, 0xf9, 0x03,
+	0xca, 0x48, 0x82, 0xed, 0xb6, 0xdd, 0x69, 0xed, 0x2c, 0xa9, 0xc8, 0x04, 0xe8, 0x69, 0x29, 0xea,
+	0x40, 0x53, 0xbc, 0x1a, 0x34, 0xc1, 0x75, 0xa1, 0xb8, 0xac, 0xde, 0x86, 0x11, 0xbd, 0x10, 0xaa,
+	0xa9, 0x18, 0xbd, 0x0c, 0xf0, 0x51, 0x3c, 0x0e, 0x48, 0x3c, 0xfb, 0x90, 0xce, 0xf0, 0x42, 0xdb,
+	0xee, 0x38, 0x9e, 0x81, 0xb8, 0xaf, 0xc2, 0x4a, 0x61, 0xd8, 0x55, 0xc6, 0xf7, 0x3e, 0xa0, 0xf2,
+	0x84, 0xba, 0x4a, 0x70, 0x6e, 0x1f, 0x36, 0xab, 0xc7, 0xd4, 0x95, 0xac, 0xfc, 0x66, 0x01, 0x2a,
+	0xcf, 0xaa, 0x4b, 0x4d, 0xf0, 0x13, 0x83, 0x3b, 0x33, 0xec, 0x64, 0x00, 0x7a, 0x05, 0x1a, 0xd2,
+	0x90, 0x38, 0x4c, 0x8a, 0xd9, 0x56, 0x32, 0x9e, 0xc1, 0x7b, 0x24, 0xe9, 0xd3, 0x63, 0x32, 0xf5,
+	0x19, 0xae, 0xb7, 0xad, 0xce, 0xa2, 0x67, 0x20, 0x08, 0x43, 0x33, 0x15, 0x2e, 0x08, 0x0f, 0xe9,
+	0xd2, 0xfd, 0x1c, 0x6e, 0x56, 0x0c, 0xc7, 0x39, 0x37, 0x9b, 0x66, 0xc3, 0x36, 0xb2, 0xf1, 0x25,
+	0x6c, 0x54, 0x8e, 0xcf, 0xeb, 0x73, 0xc1, 0xa3, 0x3b, 0xa0, 0xe7, 0x02, 0xae, 0xcb, 0xe8, 0xd4,
+	0xd2, 0x4d, 0x60, 0xa3, 0x72, 0xf0, 0x3e, 0xcb, 0x62, 0xb8, 0x9f, 0xc2, 0x7a, 0xd5, 0xac, 0xbe,
+	0x52, 0x9b, 0x19, 0x21, 0xd9, 0xf9, 0x90, 0xe2, 0xb4, 0x89, 0xf3, 0xd3, 0x78, 0xee, 0x88, 0x16,
+	0xe4, 0xb0, 0x97, 0x01, 0x15, 0x5b, 0x54, 0x0a, 0xdd, 0xcf, 0x64, 0x67, 0x5d, 0x8b, 0xc7, 0xaa,
+	0x77, 0xe4, 0x3b, 0x0b, 0x9a, 0x2a, 0x8b, 0x55, 0x9d, 0x8d, 0x6e, 0x03, 0xc8, 0xdc, 0x1e, 0xce,
+	0x26, 0xd2, 0xe4, 0xf2, 0xce, 0x8a, 0x9a, 0xc1, 0x29, 0xec, 0x19, 0x2a, 0x7c, 0x6b, 0xf7, 0x69,
+	0x78, 0xc2, 0x4e, 0xd5, 0x15, 0x4c, 0xad, 0x78, 0x9f, 0xec, 0xfb, 0x7e, 0x74, 0x9e, 0x1c, 0x4c,
+	0x7d, 0x3f, 0xed, 0x93, 0x0c, 0x71, 0xef, 0x80, 0xa3, 0x83, 0xaf, 0xdc, 0x09, 0x86, 0xa6, 0x74,
+	0x93, 0xe0, 0x9a, 0x98, 0x53, 0xe9, 0xd2, 0xfd, 0xc5, 0x82, 0xd5, 0xe2, 0x49, 0x37, 0x67, 0x8a,
+	0x5c, 0xa8, 0x7b, 0xd1, 0x79, 0x3a, 0x5f, 0x97, 0x8d, 0x43, 0xd4, 0x8b, 0xce, 0x3d, 0x21, 0x43,
+	0xb7, 0x60, 0xed, 0x30, 0x26, 0x61, 0x42, 0x8e, 0xd8, 0x38, 0x0a, 0x0f, 0xa6, 0xc1, 0x90, 0xc6,
+	0x22, 0x20, 0xdb, 0x2b, 0x0b, 0xdc, 0xaf, 0xc0, 0xd1, 0x06, 0xd0, 0x6e, 0x16, 0x83, 0xd5, 0xb6,
+	0xf5, 0x05, 0x4d, 0x2b, 0xa8, 0xa4, 0x26, 0x1f, 0x84, 0x2c, 0x9e, 0xe9, 0x00, 0xb7, 0xee, 0xc2,
+	0x92, 0x29, 0xe0, 0x37, 0xe3, 0x47, 0x74, 0xa6, 0x02, 0xe3, 0x8f, 0x68, 0x1d, 0x16, 0xbe, 0x20,
+	0xfe, 0x34, 0x8d, 0x48, 0x2e, 0xee, 0xd6, 0xf6, 0x2c, 0x91, 0x9c, 0xe2, 0x61, 0x7e, 0x8d, 0xc9,
+	0x91, 0xa6, 0xe7, 0x4d, 0xce, 0x1f, 0x35, 0x70, 0xb4, 0x05, 0xe4, 0xc2, 0x52, 0x76, 0xf4, 0x0c,
+	0x46, 0xea, 0xe6, 0x9f, 0xc3, 0xd0, 0x6e, 0xfe, 0x2d, 0x48, 0x33, 0xa8, 0x8d, 0x54, 0x67, 0x10,
+	0xed, 0x40, 0xa3, 0x4b, 0x8f, 0xa3, 0x98, 0xaa, 0xcd, 0x6f, 0x15, 0x58, 0x52, 0x28, 0x49, 0x4a,
+	0xb3, 0x70, 0x36, 0xd6, 0x8b, 0x67, 0xe3, 0xd3, 0x54, 0x65, 0xeb, 0x0e, 0xb4, 0x0c, 0x97, 0x57,
+	0x2e, 0x68, 0xf1, 0x8e, 0x75, 0x8d, 0x05, 0x95, 0xa6, 0xe7, 0x2d, 0xe8, 0x5f, 0x16, 0x38, 0xda,
+	0xc2, 0x13, 0x15, 0x34, 0xab, 0x4c, 0xcd, 0xa8, 0x8c, 0xb6, 0xf1, 0x04, 0x95, 0xb1, 0x4b, 0x95,
+	0x79, 0x8a, 0xec, 0x76, 0x60, 0x39, 0x7f, 0x17, 0xe5, 0xa9, 0x55, 0x51, 0xcb, 0xed, 0xab, 0x95,
+	0x7b, 0x06, 0x2b, 0x85, 0x6b, 0xe8, 0x65, 0xaa, 0xf9, 0x2f, 0xd3, 0x5a, 0xf1, 0xcb, 0x94, 0xdf,
+	0xeb, 0x28, 0x19, 0x19, 0x95, 0x50, 0x87, 0x9b, 0x04, 0x3d, 0x2d, 0x75, 0xcf, 0xa0, 0xa9, 0x9e,
+	0xe7, 0x2c, 0xf8, 0xff, 0x24, 0x2e, 0xfd, 0xe4, 0xae, 0xeb, 0x4f, 0x6e, 0xf7, 0x0d, 0x58, 0x2b,
+	0xdd, 0x9c, 0x2f, 0x4d, 0x49, 0x07, 0x96, 0xf3, 0x57, 0xe6, 0xcb, 0x34, 0x5f, 0xdf, 0x33, 0x8f,
+	0x15, 0xd4, 0x04, 0x7b, 0x10, 0xb2, 0xd5, 0x1b, 0x68, 0x1d, 0x56, 0xf7, 0xa7, 0x2c, 0x1a, 0x84,
+	0x47, 0x31, 0x0d, 0x68, 0xc8, 0x38, 0x6a, 0xa1, 0x16, 0x34, 0x3f, 0x21, 0x71, 0xef, 0x94, 0xc4,
+	0xab, 0x30, 0x6c, 0x88, 0xff, 0x05, 0x6f, 0xfe, 0x37, 0x00, 0xe9, 0x2d, 0x6f, 0x78, 0x3d, 0x10,
+	0x00, 0x00,
 }
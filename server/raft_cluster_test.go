@@ -0,0 +1,495 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/mrasu/ddb/server/raftstorage"
+	"github.com/mrasu/ddb/server/structs"
+	"github.com/mrasu/ddb/server/transport"
+	"github.com/mrasu/ddb/server/wal"
+	"github.com/mrasu/ddb/thelper"
+	"github.com/pkg/errors"
+)
+
+// How many ticks testCluster waits for a condition.
+const maxTestTicks = 3000
+
+// testFaults is what testNetwork does to messages.
+type testFaults struct {
+	DropRate      float64
+	DuplicateRate float64
+	// MaxDelay is the max number of ticks a message is delayed
+	MaxDelay int
+	// Reorder shuffles messages delivered in the same tick
+	Reorder bool
+}
+
+type testMessage struct {
+	from      string
+	to        string
+	msg       raftpb.Message
+	deliverAt int
+}
+
+// testNetwork delivers raft messages when testCluster ticks.
+// Faults are chosen by a seeded rand so that a failing seed can be replayed, though goroutines are still scheduled differently.
+type testNetwork struct {
+	mu        sync.Mutex
+	rand      *rand.Rand
+	now       int
+	faults    testFaults
+	queue     []*testMessage
+	receivers map[string]transport.Receiver
+	// groups holds the partition of each address. Nodes in different partitions cannot talk.
+	groups map[string]int
+}
+
+func newTestNetwork(seed int64) *testNetwork {
+	return &testNetwork{
+		rand:      rand.New(rand.NewSource(seed)),
+		receivers: map[string]transport.Receiver{},
+		groups:    map[string]int{},
+	}
+}
+
+func (n *testNetwork) setFaults(faults testFaults) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.faults = faults
+}
+
+func (n *testNetwork) partition(groups ...[]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.groups = map[string]int{}
+	for i, g := range groups {
+		for _, address := range g {
+			n.groups[address] = i + 1
+		}
+	}
+}
+
+func (n *testNetwork) heal() {
+	n.partition()
+}
+
+func (n *testNetwork) connected(from, to string) bool {
+	return n.groups[from] == n.groups[to]
+}
+
+func (n *testNetwork) send(from, to string, msg raftpb.Message) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.rand.Float64() < n.faults.DropRate {
+		return
+	}
+	copies := 1
+	if n.rand.Float64() < n.faults.DuplicateRate {
+		copies = 2
+	}
+	for i := 0; i < copies; i++ {
+		delay := 0
+		if n.faults.MaxDelay > 0 {
+			delay = n.rand.Intn(n.faults.MaxDelay + 1)
+		}
+		n.queue = append(n.queue, &testMessage{from: from, to: to, msg: msg, deliverAt: n.now + 1 + delay})
+	}
+}
+
+// deliver passes the messages whose time has come to receivers.
+func (n *testNetwork) deliver() {
+	n.mu.Lock()
+	n.now++
+	var due, rest []*testMessage
+	for _, m := range n.queue {
+		if m.deliverAt <= n.now {
+			due = append(due, m)
+		} else {
+			rest = append(rest, m)
+		}
+	}
+	n.queue = rest
+	if n.faults.Reorder {
+		n.rand.Shuffle(len(due), func(i, j int) { due[i], due[j] = due[j], due[i] })
+	}
+
+	var receivers []transport.Receiver
+	var msgs []raftpb.Message
+	for _, m := range due {
+		r, ok := n.receivers[m.to]
+		if !ok || !n.connected(m.from, m.to) {
+			continue
+		}
+		receivers = append(receivers, r)
+		msgs = append(msgs, m.msg)
+	}
+	n.mu.Unlock()
+
+	for i, r := range receivers {
+		r.ReceiveMessage(msgs[i])
+	}
+}
+
+func (n *testNetwork) newTransport(address string) *testTransport {
+	return &testTransport{network: n, address: address}
+}
+
+type testTransport struct {
+	network  *testNetwork
+	address  string
+	receiver transport.Receiver
+}
+
+func (t *testTransport) Address() string {
+	return t.address
+}
+
+func (t *testTransport) Start(r transport.Receiver) error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	t.network.receivers[t.address] = r
+	t.receiver = r
+	return nil
+}
+
+func (t *testTransport) Stop() error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	delete(t.network.receivers, t.address)
+	return nil
+}
+
+func (t *testTransport) Send(address string, msg raftpb.Message) {
+	t.network.send(t.address, address, msg)
+	if msg.Type == raftpb.MsgSnap {
+		// The network delivers messages later. Raft probes the follower again when the snapshot is lost.
+		t.receiver.ReportSnapshot(msg.To, nil)
+	}
+}
+
+func (t *testTransport) SendConfChange(address string, _ raftpb.ConfChange) (uint64, error) {
+	return 0, errors.Errorf("testCluster starts every member at once: %s", address)
+}
+
+func (t *testTransport) RemovePeer(_ string) {
+}
+
+type testNode struct {
+	id      uint64
+	address string
+	storage *raftstorage.MemoryStorage
+	ticks   chan time.Time
+	// rs is nil while the node is crashed
+	rs *RaftServer
+}
+
+// testCluster runs RaftServers whose clock and network are driven by tests.
+type testCluster struct {
+	t       *testing.T
+	network *testNetwork
+	peers   map[uint64]string
+	nodes   []*testNode
+}
+
+func newTestCluster(t *testing.T, size int, seed int64) *testCluster {
+	c := &testCluster{
+		t:       t,
+		network: newTestNetwork(seed),
+		peers:   map[uint64]string{},
+	}
+	for i := 1; i <= size; i++ {
+		id := uint64(i)
+		c.peers[id] = fmt.Sprintf("node%d", id)
+	}
+	for i := 1; i <= size; i++ {
+		id := uint64(i)
+		n := &testNode{id: id, address: c.peers[id], storage: raftstorage.NewMemoryStorage()}
+		c.nodes = append(c.nodes, n)
+		c.start(n)
+	}
+	return c
+}
+
+func (c *testCluster) node(id uint64) *testNode {
+	return c.nodes[id-1]
+}
+
+// start starts the node with the state in its storage, which is kept when the node crashes.
+func (c *testCluster) start(n *testNode) {
+	s, err := NewTestServer(&wal.Memory{})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	n.ticks = make(chan time.Time)
+	rs, err := StartRaftServer(s, &RaftConfig{
+		ID:             n.id,
+		Transport:      c.network.newTransport(n.address),
+		Storage:        n.storage,
+		Peers:          c.peers,
+		Ticks:          n.ticks,
+		RequestTimeout: 500 * time.Millisecond,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	n.rs = rs
+}
+
+func (c *testCluster) crash(id uint64) {
+	n := c.node(id)
+	thelper.AssertNoError(c.t, n.rs.Stop())
+	n.rs = nil
+}
+
+func (c *testCluster) restart(id uint64) {
+	c.start(c.node(id))
+}
+
+func (c *testCluster) stop() {
+	for _, n := range c.nodes {
+		if n.rs != nil {
+			n.rs.Stop()
+		}
+	}
+}
+
+func (c *testCluster) tick() {
+	for _, n := range c.nodes {
+		if n.rs != nil {
+			n.ticks <- time.Now()
+		}
+	}
+	c.network.deliver()
+	// Give nodes time to handle what they received.
+	time.Sleep(time.Millisecond)
+}
+
+func (c *testCluster) tickUntil(fn func() bool) {
+	c.t.Helper()
+	for i := 0; i < maxTestTicks; i++ {
+		if fn() {
+			return
+		}
+		c.tick()
+	}
+	c.t.Fatal("timeout")
+}
+
+// run ticks the cluster until fn returns because raft doesn't progress without ticks.
+func (c *testCluster) run(fn func() error) error {
+	c.t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	var err error
+	c.tickUntil(func() bool {
+		select {
+		case err = <-done:
+			return true
+		default:
+			return false
+		}
+	})
+	return err
+}
+
+func (c *testCluster) query(id uint64, sql string) (*structs.Result, error) {
+	c.t.Helper()
+	var result *structs.Result
+	err := c.run(func() error {
+		var err error
+		result, err = c.node(id).rs.StartNewConnection().Query(sql)
+		return err
+	})
+	return result, err
+}
+
+// leader waits until one of running nodes becomes the leader and returns its id.
+func (c *testCluster) leader() uint64 {
+	c.t.Helper()
+	var lead uint64
+	c.tickUntil(func() bool {
+		for _, n := range c.nodes {
+			if n.rs != nil && n.rs.IsLeader() {
+				lead = n.id
+				return true
+			}
+		}
+		return false
+	})
+	return lead
+}
+
+// write sends sql to the leader until it succeeds.
+// A write failed by timeout may be applied later, so the workload should not depend on how many times it is applied.
+func (c *testCluster) write(sql string) {
+	c.t.Helper()
+	for i := 0; i < 10; i++ {
+		_, err := c.query(c.leader(), sql)
+		if err == nil {
+			return
+		}
+	}
+	c.t.Fatalf("failed to write: %s", sql)
+}
+
+// assertConverged waits until running nodes apply the same entries and checks they have the same data.
+func (c *testCluster) assertConverged() {
+	c.t.Helper()
+	c.tickUntil(func() bool {
+		var applied uint64
+		for _, n := range c.nodes {
+			if n.rs == nil {
+				continue
+			}
+			st := n.rs.node.Status()
+			if st.Applied != st.Commit || (applied != 0 && st.Applied != applied) {
+				return false
+			}
+			applied = st.Applied
+		}
+		return true
+	})
+
+	var expected string
+	for _, n := range c.nodes {
+		if n.rs == nil {
+			continue
+		}
+		dump := dumpServer(c.t, n.rs.server)
+		if expected == "" {
+			expected = dump
+		}
+		thelper.AssertString(c.t, fmt.Sprintf("Node %d diverges", n.id), expected, dump)
+	}
+}
+
+// dumpServer encodes the data of s in a stable order.
+func dumpServer(t *testing.T, s *Server) string {
+	bs, err := s.takeSnapshot().Marshal()
+	thelper.AssertNoError(t, err)
+	var sdata structs.SData
+	thelper.AssertNoError(t, json.Unmarshal(bs, &sdata))
+
+	// LSN depends on when snapshots are installed
+	sdata.Lsn = 0
+	sdata.Timestamp = 0
+	sort.Slice(sdata.Databases, func(i, j int) bool { return sdata.Databases[i].Name < sdata.Databases[j].Name })
+	for _, db := range sdata.Databases {
+		sort.Slice(db.Tables, func(i, j int) bool { return db.Tables[i].Name < db.Tables[j].Name })
+		for _, table := range db.Tables {
+			sort.Slice(table.Indexes, func(i, j int) bool { return table.Indexes[i].Name < table.Indexes[j].Name })
+		}
+	}
+	bs, err = json.Marshal(&sdata)
+	thelper.AssertNoError(t, err)
+	return string(bs)
+}
+
+func (c *testCluster) createTable() {
+	c.t.Helper()
+	c.write("CREATE DATABASE hello")
+	c.write("CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+}
+
+func (c *testCluster) countRows(id uint64) int {
+	c.t.Helper()
+	res, err := c.query(id, "SELECT message FROM hello.world")
+	thelper.AssertNoError(c.t, err)
+	return len(res.Values)
+}
+
+func TestRaftCluster_ConvergesWithFaults(t *testing.T) {
+	c := newTestCluster(t, 3, 1)
+	defer c.stop()
+	c.network.setFaults(testFaults{DropRate: 0.1, DuplicateRate: 0.1, MaxDelay: 2, Reorder: true})
+
+	c.createTable()
+	for i := 0; i < 20; i++ {
+		c.write(fmt.Sprintf("INSERT INTO hello.world(message) VALUES ('m%d')", i))
+	}
+	c.write("UPDATE hello.world SET message = 'updated' WHERE id = 1")
+
+	c.network.setFaults(testFaults{})
+	c.assertConverged()
+	if c.countRows(c.leader()) < 20 {
+		t.Errorf("Rows are lost")
+	}
+}
+
+func TestRaftCluster_Partition(t *testing.T) {
+	c := newTestCluster(t, 3, 2)
+	defer c.stop()
+	c.createTable()
+	c.write("INSERT INTO hello.world(message) VALUES ('before')")
+
+	oldLeader := c.leader()
+	var others []string
+	for _, n := range c.nodes {
+		if n.id != oldLeader {
+			others = append(others, n.address)
+		}
+	}
+	c.network.partition([]string{c.node(oldLeader).address}, others)
+
+	// The minority cannot commit
+	_, err := c.query(oldLeader, "INSERT INTO hello.world(message) VALUES ('lost')")
+	if err == nil {
+		t.Errorf("Minority accepts a write")
+	}
+
+	var newLeader uint64
+	c.tickUntil(func() bool {
+		for _, n := range c.nodes {
+			if n.id != oldLeader && n.rs.IsLeader() {
+				newLeader = n.id
+				return true
+			}
+		}
+		return false
+	})
+	_, err = c.query(newLeader, "INSERT INTO hello.world(message) VALUES ('after')")
+	thelper.AssertNoError(t, err)
+
+	c.network.heal()
+	c.tickUntil(func() bool {
+		return !c.node(oldLeader).rs.IsLeader()
+	})
+	c.assertConverged()
+	thelper.AssertInt(t, "Write of the minority is not discarded", 2, c.countRows(oldLeader))
+}
+
+func TestRaftCluster_CrashAndRestart(t *testing.T) {
+	c := newTestCluster(t, 3, 3)
+	defer c.stop()
+	c.createTable()
+	c.write("INSERT INTO hello.world(message) VALUES ('a')")
+
+	var follower uint64
+	for _, n := range c.nodes {
+		if n.id != c.leader() {
+			follower = n.id
+			break
+		}
+	}
+	c.crash(follower)
+	c.write("INSERT INTO hello.world(message) VALUES ('b')")
+	c.restart(follower)
+	c.assertConverged()
+
+	leader := c.leader()
+	c.crash(leader)
+	c.write("INSERT INTO hello.world(message) VALUES ('c')")
+	c.restart(leader)
+	c.assertConverged()
+	thelper.AssertInt(t, "Invalid row size", 3, c.countRows(leader))
+}
@@ -2,11 +2,15 @@ package server
 
 import (
 	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	golog "log"
 	"os"
-	"strconv"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -17,8 +21,21 @@ import (
 
 	"github.com/coreos/etcd/raft/raftpb"
 
+	"github.com/coreos/etcd/pkg/idutil"
+	"github.com/coreos/etcd/pkg/wait"
 	"github.com/coreos/etcd/raft"
+	"github.com/mrasu/ddb/server/data"
 	"github.com/mrasu/ddb/server/pbs"
+	"github.com/mrasu/ddb/server/raftstorage"
+	"github.com/mrasu/ddb/server/transport"
+)
+
+// How long writes and reads wait for raft by default.
+const defaultRequestTimeout = 5 * time.Second
+
+const (
+	defaultSnapshotCount          = 10000
+	defaultSnapshotCatchUpEntries = 5000
 )
 
 type RaftServer struct {
@@ -27,19 +44,106 @@ type RaftServer struct {
 	server *Server
 
 	node    raft.Node
-	storage *raft.MemoryStorage
+	storage raftstorage.Storage
+
+	transport transport.Transport
 
 	messageChan    chan raftpb.Message
 	confChangeChan chan *raftpb.ConfChange
 
-	peers map[uint64]int
+	// peersMu guards peers and confState
+	peersMu sync.Mutex
+	peers   map[uint64]string
+
+	requestIDs     *idutil.Generator
+	wait           wait.Wait
+	applyWait      wait.WaitTime
+	requestTimeout time.Duration
+
+	confState raftpb.ConfState
+
+	// lead is the id of the leader known to this node, which is 0 while no leader is elected.
+	// It is accessed atomically.
+	lead          uint64
+	forwardWrites bool
+
+	appliedIndex   uint64
+	snapshotIndex  uint64
+	snapshotCount  uint64
+	catchUpEntries uint64
+
+	stopOnce sync.Once
+	stopc    chan struct{}
+	donec    chan struct{}
+}
+
+// RaftConfig configures a node of the raft group.
+type RaftConfig struct {
+	ID        uint64
+	Transport transport.Transport
+	// Storage persists the raft log. The log is kept only in memory when nil.
+	Storage raftstorage.Storage
+	// Join makes a new node wait to be added by AskJoin instead of bootstrapping a new cluster.
+	Join bool
+	// SnapshotCount is the number of applied entries to take a snapshot and compact the log.
+	SnapshotCount uint64
+	// SnapshotCatchUpEntries is the number of entries kept after compaction for slow followers.
+	SnapshotCatchUpEntries uint64
+	// LeaseRead makes the leader answer reads without asking followers while its lease is valid.
+	// It depends on clocks of nodes not to drift too much.
+	LeaseRead bool
+	// RequestTimeout is how long writes and reads wait for raft.
+	RequestTimeout time.Duration
+	// ForwardWrites makes followers send writes to the leader instead of returning NotLeaderError.
+	ForwardWrites bool
+	// Peers is the ids and addresses of all members when a new cluster is started by several nodes at once.
+	// Only this node is the member of the new cluster when nil.
+	Peers map[uint64]string
+	// Ticks drives the raft clock instead of the ticker of every 100ms. Tests use it to control the time.
+	Ticks <-chan time.Time
 }
 
-// TODO: use gRPC and remove this variable.
-var globalRafts = map[int]*RaftServer{}
+// raftSnapshot is the data of raft snapshots.
+type raftSnapshot struct {
+	// Peers holds addresses because nodes restored from the snapshot don't see ConfChange entries.
+	Peers map[uint64]string
+	Data  []byte
+	// Transactions holds ChangeSets of transactions in progress, which are not in Data.
+	Transactions [][]byte
+}
+
+// StartRaftServer starts a node of the raft group.
+// When the storage holds a previous run, the node is restarted from it and the committed entries are applied to server again,
+// so server must not be recovered from its own WAL beforehand. Committed entries are not written to the WAL of server,
+// so that server cannot have subscriptions.
+func StartRaftServer(server *Server, config *RaftConfig) (*RaftServer, error) {
+	id := config.ID
+	if id > data.MaxTransactionNodeID {
+		return nil, errors.Errorf("ID must not be larger than %d: %d", data.MaxTransactionNodeID, id)
+	}
+	if server.hasSubscriptions() {
+		return nil, errors.New("Server having subscriptions cannot be replicated")
+	}
+	tr := config.Transport
+	storage := config.Storage
+	if storage == nil {
+		storage = raftstorage.NewMemoryStorage()
+	}
+
+	snapshotCount := config.SnapshotCount
+	if snapshotCount == 0 {
+		snapshotCount = defaultSnapshotCount
+	}
+	catchUpEntries := config.SnapshotCatchUpEntries
+	if catchUpEntries == 0 {
+		catchUpEntries = defaultSnapshotCatchUpEntries
+	}
+
+	requestTimeout := config.RequestTimeout
+	if requestTimeout == 0 {
+		requestTimeout = defaultRequestTimeout
+	}
 
-func StartRaftServer(server *Server, id uint64) *RaftServer {
-	storage := raft.NewMemoryStorage()
 	logger := &raft.DefaultLogger{Logger: golog.New(os.Stderr, fmt.Sprintf("[raft%d] ", id), golog.LstdFlags)}
 	logger.EnableDebug()
 	c := &raft.Config{
@@ -51,31 +155,109 @@ func StartRaftServer(server *Server, id uint64) *RaftServer {
 		MaxInflightMsgs: 256,
 		Logger:          logger,
 	}
-	n := raft.StartNode(c, []raft.Peer{{ID: id}})
+	if config.LeaseRead {
+		c.ReadOnlyOption = raft.ReadOnlyLeaseBased
+		c.CheckQuorum = true
+	}
 	rs := &RaftServer{
 		id: id,
 
-		server:  server,
-		node:    n,
-		storage: storage,
+		server:    server,
+		storage:   storage,
+		transport: tr,
 
 		messageChan:    make(chan raftpb.Message),
 		confChangeChan: make(chan *raftpb.ConfChange),
 
-		peers: map[uint64]int{},
+		peers: map[uint64]string{},
+
+		requestIDs:     idutil.NewGenerator(uint16(id), time.Now()),
+		wait:           wait.New(),
+		applyWait:      wait.NewTimeList(),
+		requestTimeout: requestTimeout,
+
+		forwardWrites: config.ForwardWrites,
+
+		snapshotCount:  snapshotCount,
+		catchUpEntries: catchUpEntries,
+
+		stopc: make(chan struct{}),
+		donec: make(chan struct{}),
 	}
-	go rs.startRaft()
+	for peerID, address := range config.Peers {
+		if peerID != id {
+			rs.peers[peerID] = address
+		}
+	}
+	snap, err := storage.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	if !raft.IsEmptySnap(snap) {
+		err = rs.applySnapshot(snap)
+		if err != nil {
+			return nil, err
+		}
+		c.Applied = snap.Metadata.Index
+	}
+	_, rs.confState, err = storage.InitialState()
+	if err != nil {
+		return nil, err
+	}
+
+	err = tr.Start(rs)
+	if err != nil {
+		return nil, err
+	}
+
+	if storage.HasState() {
+		rs.node = raft.RestartNode(c)
+	} else {
+		var peers []raft.Peer
+		if !config.Join {
+			// The address is replicated with the log so that joining nodes can reach this node.
+			peers = []raft.Peer{{ID: id, Context: []byte(tr.Address())}}
+			if config.Peers != nil {
+				peers = toRaftPeers(config.Peers)
+			}
+		}
+		rs.node = raft.StartNode(c, peers)
+	}
+
+	server.raft = rs
+	go rs.startRaft(config.Ticks)
 	go rs.startListening()
 
-	globalRafts[int(id)*-1] = rs
-	return rs
+	return rs, nil
 }
 
-func (rs *RaftServer) startRaft() {
-	t := time.NewTicker(100 * time.Millisecond)
+// toRaftPeers sorts peers by id because every node must write the same entries for them.
+func toRaftPeers(peers map[uint64]string) []raft.Peer {
+	var ids []uint64
+	for id := range peers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var res []raft.Peer
+	for _, id := range ids {
+		res = append(res, raft.Peer{ID: id, Context: []byte(peers[id])})
+	}
+	return res
+}
+
+func (rs *RaftServer) startRaft(ticks <-chan time.Time) {
+	defer close(rs.donec)
+	if ticks == nil {
+		t := time.NewTicker(100 * time.Millisecond)
+		defer t.Stop()
+		ticks = t.C
+	}
 	for {
 		select {
-		case <-t.C:
+		case <-rs.stopc:
+			return
+		case <-ticks:
 			fmt.Print(".")
 			rs.node.Tick()
 		case rd := <-rs.node.Ready():
@@ -83,41 +265,59 @@ func (rs *RaftServer) startRaft() {
 			rs.Printf("Ready!: %v\n", rs.node.Status())
 			s := rd.HardState
 			rs.Printf("Received HardrdState: Term=%d, Vote=%d, Commit=%d\n", s.Term, s.Vote, s.Commit)
+			if rd.SoftState != nil {
+				atomic.StoreUint64(&rs.lead, rd.SoftState.Lead)
+			}
 
 			for _, en := range rd.Entries {
 				rs.Printf("Received entry: Term=%d, Index=%d, Data= %s\n", en.Term, en.Index, strings.Replace(string(en.Data), "\n", "\\n", -1))
 			}
-			rs.storage.Append(rd.Entries)
+
+			if !raft.IsEmptySnap(rd.Snapshot) {
+				rs.Printf("Installing Snapshot... (Index=%v)\n", rd.Snapshot.Metadata.Index)
+				err := rs.storage.SaveSnapshot(rd.Snapshot)
+				if err != nil {
+					panic(err)
+				}
+			}
+			// Persist before sending messages because they may tell peers what is written here.
+			err := rs.storage.Save(rd.HardState, rd.Entries, rd.MustSync)
+			if err != nil {
+				panic(err)
+			}
 
 			for _, msg := range rd.Messages {
-				globalRaftId := rs.peers[msg.To]
-				rs.SendEntries(globalRaftId, msg)
+				rs.send(msg)
+			}
+
+			for _, state := range rd.ReadStates {
+				if len(state.RequestCtx) == 8 {
+					rs.wait.Trigger(binary.BigEndian.Uint64(state.RequestCtx), state.Index)
+				}
 			}
 
 			if !raft.IsEmptySnap(rd.Snapshot) {
-				rs.Printf("Taking Snapshot...\n")
+				err := rs.applySnapshot(rd.Snapshot)
+				if err != nil {
+					panic(err)
+				}
 			}
 
 			for _, entry := range rd.CommittedEntries {
+				if entry.Index <= rs.appliedIndex {
+					continue
+				}
+
 				if entry.Type == raftpb.EntryConfChange {
 					var cc raftpb.ConfChange
 					cc.Unmarshal(entry.Data)
 					rs.Printf("Processing confChange entry. (Term=%v, Index=%v, Data=%v)\n", entry.Term, entry.Index, cc)
 
-					// TODO: record to snapshot
-					rs.node.ApplyConfChange(cc)
-
-					switch cc.Type {
-					case raftpb.ConfChangeAddNode:
-						if cc.NodeID != rs.id {
-							err := rs.addPeer(cc.NodeID, string(cc.Context))
-							if err != nil {
-								panic(err)
-							}
-						}
-					default:
-						panic(fmt.Sprintf("Unsupported ConfChange Type: %v", cc.Type))
+					err := rs.applyConfChange(cc)
+					if err != nil {
+						log.Error().Stack().Err(err).Uint64("index", entry.Index).Msg("Failed to apply ConfChange")
 					}
+					rs.wait.Trigger(cc.ID, err)
 				} else if entry.Type == raftpb.EntryNormal {
 					if len(entry.Data) == 0 {
 						rs.Printf("Processing empty entry. (Term=%v, Index=%v, Data=%v)\n", entry.Term, entry.Index, entry.Data)
@@ -132,35 +332,198 @@ func (rs *RaftServer) startRaft() {
 						}
 
 						rs.Printf("Processing normal entry. (Term=%v, Index=%v, Instance=%v)\n", entry.Term, entry.Index, cs.Data)
-						err = rs.server.ApplyChangeSet(cs, true)
+						// The raft log is the durable log. Writing the WAL would append entries again when they are replayed after restart.
+						cs.Lsn = rs.server.wal.CurrentLsn()
+						err = rs.server.ApplyChangeSet(cs, false)
 						if err != nil {
-							panic(err)
+							// Every node fails in the same way because the same entries are applied in the same order.
+							log.Error().Stack().Err(err).Uint64("index", entry.Index).Msg("Failed to apply ChangeSet")
 						}
+						rs.wait.Trigger(cs.RequestId, err)
 					}
 				} else {
 					rs.Printf("Processing unknown entry. (Term=%v, Index=%v, Data=%v)\n", entry.Term, entry.Index, entry.Data)
 				}
+				rs.appliedIndex = entry.Index
+			}
+			rs.applyWait.Trigger(rs.appliedIndex)
+
+			err = rs.maybeTriggerSnapshot()
+			if err != nil {
+				log.Error().Stack().Err(err).Msg("Failed to take snapshot")
 			}
 			rs.node.Advance()
 		}
 	}
 }
 
+func (rs *RaftServer) applyConfChange(cc raftpb.ConfChange) error {
+	confState := *rs.node.ApplyConfChange(cc)
+	rs.peersMu.Lock()
+	// ApplyConfChange of etcd v3.3 returns learners as voters.
+	confState.Learners = applyToLearners(rs.confState.Learners, cc)
+	confState.Nodes = excludeIDs(confState.Nodes, confState.Learners)
+	rs.confState = confState
+	rs.peersMu.Unlock()
+	err := rs.storage.SaveConfState(confState)
+	if err != nil {
+		return err
+	}
+
+	if cc.NodeID == rs.id {
+		if cc.Type == raftpb.ConfChangeRemoveNode {
+			rs.Printf("This node is removed from the cluster\n")
+		}
+		return nil
+	}
+
+	switch cc.Type {
+	case raftpb.ConfChangeAddNode, raftpb.ConfChangeAddLearnerNode:
+		return rs.addPeer(cc.NodeID, string(cc.Context))
+	case raftpb.ConfChangeRemoveNode:
+		rs.removePeer(cc.NodeID)
+		return nil
+	case raftpb.ConfChangeUpdateNode:
+		rs.removePeer(cc.NodeID)
+		return rs.addPeer(cc.NodeID, string(cc.Context))
+	default:
+		return errors.Errorf("Unsupported ConfChange Type: %v", cc.Type)
+	}
+}
+
+func applyToLearners(learners []uint64, cc raftpb.ConfChange) []uint64 {
+	if cc.Type == raftpb.ConfChangeUpdateNode {
+		return learners
+	}
+
+	res := excludeIDs(learners, []uint64{cc.NodeID})
+	if cc.Type == raftpb.ConfChangeAddLearnerNode {
+		res = append(res, cc.NodeID)
+	}
+	return res
+}
+
+func excludeIDs(ids []uint64, excluded []uint64) []uint64 {
+	var res []uint64
+	for _, id := range ids {
+		found := false
+		for _, e := range excluded {
+			if id == e {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// applySnapshot replaces the state of the server with snap.
+func (rs *RaftServer) applySnapshot(snap raftpb.Snapshot) error {
+	var rss raftSnapshot
+	err := json.Unmarshal(snap.Data, &rss)
+	if err != nil {
+		return errors.Wrap(err, "invalid raft snapshot")
+	}
+	ss, err := data.UnmarshalSnapshot(rss.Data)
+	if err != nil {
+		return err
+	}
+	rs.server.restoreSnapshot(ss)
+	var css []*pbs.ChangeSet
+	for _, bs := range rss.Transactions {
+		cs := &pbs.ChangeSet{}
+		err = proto.Unmarshal(bs, cs)
+		if err != nil {
+			return errors.Wrap(err, "invalid transaction in raft snapshot")
+		}
+		css = append(css, cs)
+	}
+	err = rs.server.restoreTransactions(css)
+	if err != nil {
+		return err
+	}
+
+	rs.peersMu.Lock()
+	for id, address := range rss.Peers {
+		if id != rs.id {
+			rs.peers[id] = address
+		}
+	}
+	rs.confState = snap.Metadata.ConfState
+	rs.peersMu.Unlock()
+
+	rs.appliedIndex = snap.Metadata.Index
+	rs.snapshotIndex = snap.Metadata.Index
+	return nil
+}
+
+// maybeTriggerSnapshot takes a snapshot and compacts the log when enough entries are applied after the last one.
+func (rs *RaftServer) maybeTriggerSnapshot() error {
+	if rs.appliedIndex-rs.snapshotIndex < rs.snapshotCount {
+		return nil
+	}
+	snapshot, css := rs.server.takeSnapshotWithTransactions()
+	ss, err := snapshot.Marshal()
+	if err != nil {
+		return err
+	}
+	var trxs [][]byte
+	for _, cs := range css {
+		bs, err := proto.Marshal(cs)
+		if err != nil {
+			return err
+		}
+		trxs = append(trxs, bs)
+	}
+	rs.peersMu.Lock()
+	peers := map[uint64]string{rs.id: rs.Address()}
+	for id, address := range rs.peers {
+		peers[id] = address
+	}
+	confState := rs.confState
+	rs.peersMu.Unlock()
+	bs, err := json.Marshal(&raftSnapshot{Peers: peers, Data: ss, Transactions: trxs})
+	if err != nil {
+		return err
+	}
+
+	rs.Printf("Taking Snapshot... (Index=%v)\n", rs.appliedIndex)
+	_, err = rs.storage.CreateSnapshot(rs.appliedIndex, &confState, bs)
+	if err != nil {
+		return err
+	}
+	rs.snapshotIndex = rs.appliedIndex
+
+	if rs.appliedIndex <= rs.catchUpEntries {
+		return nil
+	}
+	err = rs.storage.Compact(rs.appliedIndex - rs.catchUpEntries)
+	if err == raft.ErrCompacted {
+		return nil
+	}
+	return err
+}
+
 func (rs *RaftServer) startListening() {
 	for {
 		select {
+		case <-rs.stopc:
+			return
 		case bs := <-rs.messageChan:
 			rs.Printf("Receive msg\n")
-			ctx := context.Background()
-			ctx2, _ := context.WithTimeout(ctx, 1*time.Second)
-			err := rs.node.Step(ctx2, bs)
+			ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+			err := rs.node.Step(ctx, bs)
+			cancel()
 			if err != nil {
 				log.Error().Stack().Err(err).Msg("Failed to propose ChangeSet")
 			}
 		case cc := <-rs.confChangeChan:
-			ctx := context.Background()
-			ctx2, _ := context.WithTimeout(ctx, 1*time.Second)
-			err := rs.ProposeConfChange(ctx2, cc)
+			ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+			err := rs.ProposeConfChange(ctx, cc)
+			cancel()
 			if err != nil {
 				log.Error().Stack().Err(err).Msg("ProposeConfChange failed")
 			}
@@ -168,56 +531,212 @@ func (rs *RaftServer) startListening() {
 	}
 }
 
-func (rs *RaftServer) SendEntries(globalRaftId int, msg raftpb.Message) {
-	rs.Printf("Sending to: %d\n", globalRaftId)
-	if r, ok := globalRafts[globalRaftId]; ok {
-		// use `go` to emulate concurrency
-		go func() {
-			r.messageChan <- msg
-		}()
-	} else {
-		panic("Invalid id for SendMessage.")
+func (rs *RaftServer) ID() uint64 {
+	return rs.id
+}
+
+func (rs *RaftServer) Address() string {
+	return rs.transport.Address()
+}
+
+func (rs *RaftServer) ReceiveMessage(msg raftpb.Message) {
+	select {
+	case rs.messageChan <- msg:
+	case <-rs.stopc:
 	}
 }
 
-func (rs *RaftServer) AskJoin(globalRaftId int, cc *raftpb.ConfChange) {
-	rs.Printf("Asking join...\n")
-	if r, ok := globalRafts[globalRaftId]; ok {
-		// use `go` to emulate concurrency
-		go func() {
-			r.confChangeChan <- cc
-		}()
-		err := rs.addPeer(r.id, strconv.Itoa(globalRaftId))
+func (rs *RaftServer) ReceiveConfChange(cc raftpb.ConfChange) {
+	select {
+	case rs.confChangeChan <- &cc:
+	case <-rs.stopc:
+	}
+}
+
+// Stop stops the node. The RaftServer cannot be used after Stop, but calling Stop again is allowed.
+func (rs *RaftServer) Stop() error {
+	var err error
+	rs.stopOnce.Do(func() {
+		close(rs.stopc)
+		rs.node.Stop()
+		<-rs.donec
+		err = rs.transport.Stop()
 		if err != nil {
-			panic(err)
+			return
+		}
+		err = rs.storage.Close()
+	})
+	return err
+}
+
+func (rs *RaftServer) send(msg raftpb.Message) {
+	address, ok := rs.peerAddress(msg.To)
+	if !ok {
+		log.Error().Uint64("to", msg.To).Msg("Unknown peer for raft message")
+		if msg.Type == raftpb.MsgSnap {
+			rs.node.ReportSnapshot(msg.To, raft.SnapshotFailure)
+		}
+		return
+	}
+	rs.Printf("Sending to: %s\n", address)
+	rs.transport.Send(address, msg)
+}
+
+// ReportSnapshot tells raft whether the snapshot is delivered. The leader sends it again after failures.
+func (rs *RaftServer) ReportSnapshot(to uint64, err error) {
+	status := raft.SnapshotFinish
+	if err != nil {
+		log.Error().Err(err).Uint64("to", to).Msg("Failed to send snapshot")
+		status = raft.SnapshotFailure
+	}
+	rs.node.ReportSnapshot(to, status)
+}
+
+// AskJoin asks the member at address to add this node to the cluster.
+// cc.Context must hold the address of this node.
+func (rs *RaftServer) AskJoin(address string, cc *raftpb.ConfChange) error {
+	rs.Printf("Asking join...\n")
+	id, err := rs.transport.SendConfChange(address, *cc)
+	if err != nil {
+		return err
+	}
+	return rs.addPeer(id, address)
+}
+
+// Member is a node of the raft group.
+type Member struct {
+	ID      uint64
+	Address string
+	// Learner receives the log but doesn't vote.
+	Learner bool
+}
+
+// Members returns the nodes applied to this node.
+func (rs *RaftServer) Members() []*Member {
+	rs.peersMu.Lock()
+	defer rs.peersMu.Unlock()
+
+	var members []*Member
+	address := func(id uint64) string {
+		if id == rs.id {
+			return rs.Address()
+		}
+		return rs.peers[id]
+	}
+	for _, id := range rs.confState.Nodes {
+		members = append(members, &Member{ID: id, Address: address(id)})
+	}
+	for _, id := range rs.confState.Learners {
+		members = append(members, &Member{ID: id, Address: address(id), Learner: true})
+	}
+	return members
+}
+
+// PromoteLearner makes the learner a voting member.
+// The learner should have caught up with the leader not to make the cluster unavailable.
+func (rs *RaftServer) PromoteLearner(ctx context.Context, id uint64) error {
+	address, ok := rs.peerAddress(id)
+	if !ok {
+		return errors.Errorf("Unknown peer: %d", id)
+	}
+	cc := &raftpb.ConfChange{Type: raftpb.ConfChangeAddNode, NodeID: id, Context: []byte(address)}
+	return rs.proposeConfChangeAndWait(ctx, cc)
+}
+
+// RemoveNode removes the node from the cluster. The removed node should be stopped after that.
+func (rs *RaftServer) RemoveNode(ctx context.Context, id uint64) error {
+	cc := &raftpb.ConfChange{Type: raftpb.ConfChangeRemoveNode, NodeID: id}
+	return rs.proposeConfChangeAndWait(ctx, cc)
+}
+
+// UpdateNode changes the address of the node.
+func (rs *RaftServer) UpdateNode(ctx context.Context, id uint64, address string) error {
+	cc := &raftpb.ConfChange{Type: raftpb.ConfChangeUpdateNode, NodeID: id, Context: []byte(address)}
+	return rs.proposeConfChangeAndWait(ctx, cc)
+}
+
+// TransferLeadership makes transferee the leader and waits until it is done.
+func (rs *RaftServer) TransferLeadership(ctx context.Context, transferee uint64) error {
+	rs.node.TransferLeadership(ctx, rs.node.Status().Lead, transferee)
+
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+	for rs.node.Status().Lead != transferee {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "leadership is not transferred")
+		case <-rs.stopc:
+			return errors.New("raft server is stopped")
 		}
-	} else {
-		panic("Invalid id for AskJoin.")
 	}
+	return nil
 }
 
-func (rs *RaftServer) addPeer(id uint64, idStr string) error {
-	peerId, err := strconv.Atoi(idStr)
+func (rs *RaftServer) proposeConfChangeAndWait(ctx context.Context, cc *raftpb.ConfChange) error {
+	cc.ID = rs.requestIDs.Next()
+	ch := rs.wait.Register(cc.ID)
+	err := rs.ProposeConfChange(ctx, cc)
 	if err != nil {
+		rs.wait.Trigger(cc.ID, nil)
 		return err
 	}
 
-	if _, ok := globalRafts[peerId]; !ok {
-		return errors.Errorf("Invalid peerId: %d", peerId)
+	select {
+	case x := <-ch:
+		if x == nil {
+			return nil
+		}
+		return x.(error)
+	case <-ctx.Done():
+		rs.wait.Trigger(cc.ID, nil)
+		return errors.Wrap(ctx.Err(), "ConfChange is not applied")
+	case <-rs.stopc:
+		return errors.New("raft server is stopped")
+	}
+}
+
+func (rs *RaftServer) addPeer(id uint64, address string) error {
+	if address == "" {
+		return errors.Errorf("No address for peer: %d", id)
 	}
 
-	if grId, ok := rs.peers[id]; ok {
-		if grId == peerId {
+	rs.peersMu.Lock()
+	defer rs.peersMu.Unlock()
+
+	if a, ok := rs.peers[id]; ok {
+		if a == address {
 			return nil
 		}
 		return errors.Errorf("Existing peerId: %d", id)
 	}
 
 	rs.Printf("Peer added!\n")
-	rs.peers[id] = peerId
+	rs.peers[id] = address
 	return nil
 }
 
+func (rs *RaftServer) removePeer(id uint64) {
+	rs.peersMu.Lock()
+	defer rs.peersMu.Unlock()
+
+	address, ok := rs.peers[id]
+	if !ok {
+		return
+	}
+	rs.Printf("Peer removed!\n")
+	delete(rs.peers, id)
+	rs.transport.RemovePeer(address)
+}
+
+func (rs *RaftServer) peerAddress(id uint64) (string, bool) {
+	rs.peersMu.Lock()
+	defer rs.peersMu.Unlock()
+
+	a, ok := rs.peers[id]
+	return a, ok
+}
+
 func (rs *RaftServer) Propose(cs *pbs.ChangeSet) error {
 	rs.Printf("Proposing %v\n", cs)
 	out, err := proto.Marshal(cs)
@@ -225,9 +744,104 @@ func (rs *RaftServer) Propose(cs *pbs.ChangeSet) error {
 		panic(err)
 	}
 
-	ctx := context.Background()
-	ctx2, _ := context.WithTimeout(ctx, 1*time.Second)
-	return rs.node.Propose(ctx2, out)
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	return rs.node.Propose(ctx, out)
+}
+
+// Leader returns the id and the address of the leader known to this node.
+// The id is 0 when no leader is known.
+func (rs *RaftServer) Leader() (uint64, string) {
+	lead := atomic.LoadUint64(&rs.lead)
+	if lead == 0 {
+		return 0, ""
+	}
+	if lead == rs.id {
+		return lead, rs.Address()
+	}
+	address, _ := rs.peerAddress(lead)
+	return lead, address
+}
+
+func (rs *RaftServer) IsLeader() bool {
+	return atomic.LoadUint64(&rs.lead) == rs.id
+}
+
+// ProposeAndWait proposes cs and waits until this node applies it.
+// The error of applying cs is returned.
+// Followers return NotLeaderError unless ForwardWrites is set. Then raft forwards cs to the leader.
+func (rs *RaftServer) ProposeAndWait(cs *pbs.ChangeSet) error {
+	if !rs.IsLeader() {
+		lead, address := rs.Leader()
+		if lead == 0 || !rs.forwardWrites {
+			return NewNotLeaderError(lead, address)
+		}
+	}
+
+	cs.RequestId = rs.requestIDs.Next()
+	out, err := proto.Marshal(cs)
+	if err != nil {
+		return err
+	}
+
+	ch := rs.wait.Register(cs.RequestId)
+	ctx, cancel := context.WithTimeout(context.Background(), rs.requestTimeout)
+	defer cancel()
+	err = rs.node.Propose(ctx, out)
+	if err != nil {
+		rs.wait.Trigger(cs.RequestId, nil)
+		return err
+	}
+
+	select {
+	case x := <-ch:
+		if x == nil {
+			return nil
+		}
+		return x.(error)
+	case <-ctx.Done():
+		rs.wait.Trigger(cs.RequestId, nil)
+		return errors.Wrap(ctx.Err(), "ChangeSet is not applied")
+	case <-rs.stopc:
+		return errors.New("raft server is stopped")
+	}
+}
+
+// WaitReadIndex waits until this node applies every entry committed in the cluster when it is called.
+// Reads after that see all writes acknowledged before it.
+func (rs *RaftServer) WaitReadIndex() error {
+	ctx, cancel := context.WithTimeout(context.Background(), rs.requestTimeout)
+	defer cancel()
+
+	id := rs.requestIDs.Next()
+	rctx := make([]byte, 8)
+	binary.BigEndian.PutUint64(rctx, id)
+	ch := rs.wait.Register(id)
+	err := rs.node.ReadIndex(ctx, rctx)
+	if err != nil {
+		rs.wait.Trigger(id, nil)
+		return err
+	}
+
+	var index uint64
+	select {
+	case x := <-ch:
+		index = x.(uint64)
+	case <-ctx.Done():
+		rs.wait.Trigger(id, nil)
+		return errors.Wrap(ctx.Err(), "read index is not confirmed")
+	case <-rs.stopc:
+		return errors.New("raft server is stopped")
+	}
+
+	select {
+	case <-rs.applyWait.Wait(index):
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "read index is not applied")
+	case <-rs.stopc:
+		return errors.New("raft server is stopped")
+	}
 }
 
 func (rs *RaftServer) ProposeConfChange(ctx context.Context, cc *raftpb.ConfChange) error {
@@ -242,6 +856,11 @@ func (rs *RaftServer) Printf(format string, a ...interface{}) {
 	fmt.Printf("[%d] "+format, b...)
 }
 
+// StartNewConnection starts a connection whose writes are replicated by the raft group.
+func (rs *RaftServer) StartNewConnection() *Connection {
+	return rs.server.StartNewConnection()
+}
+
 func (rs *RaftServer) InspectServer() {
 	fmt.Printf("%d**************\n", rs.id)
 	rs.server.Inspect()
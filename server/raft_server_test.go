@@ -0,0 +1,553 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/mrasu/ddb/server/raftstorage"
+	"github.com/mrasu/ddb/server/transport"
+	"github.com/mrasu/ddb/server/wal"
+	"github.com/mrasu/ddb/thelper"
+	"github.com/pkg/errors"
+)
+
+func TestRaftServer_HTTPTransport(t *testing.T) {
+	rs1 := startTestRaftServer(t, 1, transport.NewHTTPTransport("127.0.0.1:0"), true)
+	defer rs1.Stop()
+	rs2 := startTestRaftServer(t, 2, transport.NewHTTPTransport("127.0.0.1:0"), false)
+	defer rs2.Stop()
+
+	waitForLeader(t, rs1)
+	cc := &raftpb.ConfChange{NodeID: 2, Type: raftpb.ConfChangeAddNode, Context: []byte(rs2.Address())}
+	thelper.AssertNoError(t, rs2.AskJoin(rs1.Address(), cc))
+
+	cs := &pbs.ChangeSet{Data: &pbs.ChangeSet_CreateDB{CreateDB: &pbs.CreateDBChangeSet{Name: "hello"}}}
+	thelper.AssertNoError(t, rs1.Propose(cs))
+
+	waitForRaft(t, func() bool {
+		_, ok := rs2.server.databases["hello"]
+		return ok
+	})
+	_, ok := rs1.server.databases["hello"]
+	thelper.AssertBool(t, "Proposed ChangeSet is not applied", true, ok)
+}
+
+func TestRaftServer_Subscribe(t *testing.T) {
+	network := transport.NewMemoryNetwork()
+	s, err := NewTestServer(&wal.Memory{})
+	thelper.AssertNoError(t, err)
+	sub, err := s.Subscribe(0)
+	thelper.AssertNoError(t, err)
+	_, err = StartRaftServer(s, &RaftConfig{ID: 1, Transport: network.NewTransport("raft1")})
+	thelper.AssertBool(t, "Server having subscriptions is replicated", true, err != nil)
+	sub.Close()
+
+	// Raft entries are not written to the WAL, which subscriptions read
+	rs, err := StartRaftServer(s, &RaftConfig{ID: 1, Transport: network.NewTransport("raft1")})
+	thelper.AssertNoError(t, err)
+	defer rs.Stop()
+	_, err = s.Subscribe(0)
+	thelper.AssertBool(t, "Replicated Server is subscribed", true, err != nil)
+}
+
+func TestRaftServer_ReplicatesQueries(t *testing.T) {
+	network := transport.NewMemoryNetwork()
+	rs1 := startTestRaftServer(t, 1, network.NewTransport("raft1"), true)
+	defer rs1.Stop()
+	rs2 := startTestRaftServer(t, 2, network.NewTransport("raft2"), false)
+	defer rs2.Stop()
+
+	waitForLeader(t, rs1)
+	cc := &raftpb.ConfChange{NodeID: 2, Type: raftpb.ConfChangeAddNode, Context: []byte(rs2.Address())}
+	thelper.AssertNoError(t, rs2.AskJoin(rs1.Address(), cc))
+
+	c := rs1.StartNewConnection()
+	queries := []string{
+		"CREATE DATABASE hello",
+		"CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))",
+		"INSERT INTO hello.world(message) VALUES ('foo')",
+		"BEGIN",
+		"INSERT INTO hello.world(message) VALUES ('bar')",
+		"UPDATE hello.world SET message = 'baz' WHERE id = 1",
+		"COMMIT",
+	}
+	for _, q := range queries {
+		_, err := c.Query(q)
+		thelper.AssertNoError(t, err)
+	}
+
+	// Queries are acknowledged after they are applied on the leader.
+	assertReplicatedMessages(t, rs1, []string{"baz", "bar"})
+	waitForRaft(t, func() bool {
+		return rs2.server.wal.CurrentLsn() == rs1.server.wal.CurrentLsn()
+	})
+	assertReplicatedMessages(t, rs2, []string{"baz", "bar"})
+
+	_, err := rs2.StartNewConnection().Query("INSERT INTO hello.world(message) VALUES ('qux')")
+	nle, ok := errors.Cause(err).(*NotLeaderError)
+	if !ok {
+		t.Fatalf("Follower accepts a write: %v", err)
+	}
+	thelper.AssertInt64(t, "Wrong leader id", 1, int64(nle.LeaderID))
+	thelper.AssertString(t, "Wrong leader address", rs1.Address(), nle.LeaderAddress)
+}
+
+func TestRaftServer_ForwardWrites(t *testing.T) {
+	network := transport.NewMemoryNetwork()
+	rs1 := startTestRaftServerWithConfig(t, &RaftConfig{ID: 1, Transport: network.NewTransport("raft1"), ForwardWrites: true})
+	defer rs1.Stop()
+	rs2 := startTestRaftServerWithConfig(t, &RaftConfig{ID: 2, Transport: network.NewTransport("raft2"), Join: true, ForwardWrites: true})
+	defer rs2.Stop()
+
+	waitForLeader(t, rs1)
+	cc := &raftpb.ConfChange{NodeID: 2, Type: raftpb.ConfChangeAddNode, Context: []byte(rs2.Address())}
+	thelper.AssertNoError(t, rs2.AskJoin(rs1.Address(), cc))
+	waitForRaft(t, func() bool {
+		lead, _ := rs2.Leader()
+		return lead == 1
+	})
+	lead, address := rs2.Leader()
+	thelper.AssertInt64(t, "Wrong leader id", 1, int64(lead))
+	thelper.AssertString(t, "Wrong leader address", rs1.Address(), address)
+
+	// Every query is sent to the follower
+	c := rs2.StartNewConnection()
+	queries := []string{
+		"CREATE DATABASE hello",
+		"CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))",
+		"INSERT INTO hello.world(message) VALUES ('foo')",
+		"BEGIN",
+		"INSERT INTO hello.world(message) VALUES ('bar')",
+		"UPDATE hello.world SET message = 'baz' WHERE id = 1",
+		"COMMIT",
+	}
+	for _, q := range queries {
+		_, err := c.Query(q)
+		thelper.AssertNoError(t, err)
+	}
+
+	// Forwarded writes are acknowledged after the follower applies them.
+	assertReplicatedMessages(t, rs2, []string{"baz", "bar"})
+	waitForRaft(t, func() bool {
+		return rs1.server.wal.CurrentLsn() == rs2.server.wal.CurrentLsn()
+	})
+	assertReplicatedMessages(t, rs1, []string{"baz", "bar"})
+}
+
+func TestRaftServer_ConcurrentBegin(t *testing.T) {
+	network := transport.NewMemoryNetwork()
+	rs1 := startTestRaftServerWithConfig(t, &RaftConfig{ID: 1, Transport: network.NewTransport("raft1"), ForwardWrites: true})
+	defer rs1.Stop()
+	rs2 := startTestRaftServerWithConfig(t, &RaftConfig{ID: 2, Transport: network.NewTransport("raft2"), Join: true, ForwardWrites: true})
+	defer rs2.Stop()
+
+	waitForLeader(t, rs1)
+	cc := &raftpb.ConfChange{NodeID: 2, Type: raftpb.ConfChangeAddNode, Context: []byte(rs2.Address())}
+	thelper.AssertNoError(t, rs2.AskJoin(rs1.Address(), cc))
+	waitForRaft(t, func() bool {
+		lead, _ := rs2.Leader()
+		return lead == 1
+	})
+
+	c := rs1.StartNewConnection()
+	for _, q := range []string{"CREATE DATABASE hello", "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))"} {
+		_, err := c.Query(q)
+		thelper.AssertNoError(t, err)
+	}
+
+	// Both nodes start transactions at the same time
+	var wg sync.WaitGroup
+	numbers := make([][]int64, 2)
+	for i, rs := range []*RaftServer{rs1, rs2} {
+		wg.Add(1)
+		go func(i int, rs *RaftServer) {
+			defer wg.Done()
+			c := rs.StartNewConnection()
+			for j := 0; j < 5; j++ {
+				_, err := c.Query("BEGIN")
+				thelper.AssertNoError(t, err)
+				if err != nil {
+					return
+				}
+				numbers[i] = append(numbers[i], c.currentTransaction.Number)
+				_, err = c.Query(fmt.Sprintf("INSERT INTO hello.world(message) VALUES ('%d-%d')", i, j))
+				thelper.AssertNoError(t, err)
+				_, err = c.Query("COMMIT")
+				thelper.AssertNoError(t, err)
+			}
+		}(i, rs)
+	}
+	wg.Wait()
+
+	for _, n1 := range numbers[0] {
+		for _, n2 := range numbers[1] {
+			if n1 == n2 {
+				t.Errorf("Nodes use the same transaction number: %d", n1)
+			}
+		}
+	}
+	waitForRaft(t, func() bool {
+		return rs1.server.wal.CurrentLsn() == rs2.server.wal.CurrentLsn()
+	})
+	for _, rs := range []*RaftServer{rs1, rs2} {
+		res, err := rs.StartNewConnection().Query("SELECT * FROM hello.world")
+		thelper.AssertNoError(t, err)
+		thelper.AssertInt(t, "Invalid row size", 10, len(res.Values))
+	}
+}
+
+func TestRaftServer_LinearizableRead(t *testing.T) {
+	network := transport.NewMemoryNetwork()
+	rs1 := startTestRaftServer(t, 1, network.NewTransport("raft1"), true)
+	defer rs1.Stop()
+	rs2 := startTestRaftServerWithConfig(t, &RaftConfig{
+		ID:             2,
+		Transport:      network.NewTransport("raft2"),
+		Join:           true,
+		RequestTimeout: 500 * time.Millisecond,
+	})
+	defer rs2.Stop()
+
+	waitForLeader(t, rs1)
+	cc := &raftpb.ConfChange{NodeID: 2, Type: raftpb.ConfChangeAddNode, Context: []byte(rs2.Address())}
+	thelper.AssertNoError(t, rs2.AskJoin(rs1.Address(), cc))
+
+	c := rs1.StartNewConnection()
+	_, err := c.Query("CREATE DATABASE hello")
+	thelper.AssertNoError(t, err)
+	_, err = c.Query("CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	thelper.AssertNoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err = c.Query("INSERT INTO hello.world(message) VALUES ('foo')")
+		thelper.AssertNoError(t, err)
+		// The follower sees the write as soon as it is acknowledged by the leader.
+		res, err := rs2.StartNewConnection().Query("SELECT message FROM hello.world")
+		thelper.AssertNoError(t, err)
+		thelper.AssertInt(t, "Invalid row size", i+1, len(res.Values))
+	}
+
+	// The follower cannot confirm the latest data without the leader.
+	thelper.AssertNoError(t, rs1.Stop())
+	follower := rs2.StartNewConnection()
+	_, err = follower.Query("SELECT message FROM hello.world")
+	if err == nil {
+		t.Error("Linearizable read succeeds without the leader")
+	}
+
+	_, err = follower.Query("SET ddb_stale_read = 1")
+	thelper.AssertNoError(t, err)
+	res, err := follower.Query("SELECT message FROM hello.world")
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Invalid row size", 5, len(res.Values))
+}
+
+func TestRaftServer_SnapshotCatchUp(t *testing.T) {
+	network := transport.NewMemoryNetwork()
+	rs1 := startTestRaftServerWithConfig(t, &RaftConfig{
+		ID:                     1,
+		Transport:              network.NewTransport("raft1"),
+		SnapshotCount:          5,
+		SnapshotCatchUpEntries: 2,
+	})
+	defer rs1.Stop()
+	waitForLeader(t, rs1)
+
+	c := rs1.StartNewConnection()
+	_, err := c.Query("CREATE DATABASE hello")
+	thelper.AssertNoError(t, err)
+	_, err = c.Query("CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	thelper.AssertNoError(t, err)
+	var messages []string
+	for i := 0; i < 10; i++ {
+		_, err = c.Query("INSERT INTO hello.world(message) VALUES ('foo')")
+		thelper.AssertNoError(t, err)
+		messages = append(messages, "foo")
+	}
+
+	first, err := rs1.storage.FirstIndex()
+	thelper.AssertNoError(t, err)
+	if first <= 1 {
+		t.Errorf("Raft log is not compacted: %d", first)
+	}
+
+	// The new node receives the snapshot because the leader doesn't have old entries.
+	rs2 := startTestRaftServer(t, 2, network.NewTransport("raft2"), false)
+	defer rs2.Stop()
+	cc := &raftpb.ConfChange{NodeID: 2, Type: raftpb.ConfChangeAddNode, Context: []byte(rs2.Address())}
+	thelper.AssertNoError(t, rs2.AskJoin(rs1.Address(), cc))
+	waitForRaft(t, func() bool {
+		return rs2.server.wal.CurrentLsn() == rs1.server.wal.CurrentLsn()
+	})
+	assertReplicatedMessages(t, rs2, messages)
+}
+
+func TestRaftServer_SnapshotWithTransaction(t *testing.T) {
+	network := transport.NewMemoryNetwork()
+	rs1 := startTestRaftServerWithConfig(t, &RaftConfig{
+		ID:                     1,
+		Transport:              network.NewTransport("raft1"),
+		SnapshotCount:          5,
+		SnapshotCatchUpEntries: 2,
+	})
+	defer rs1.Stop()
+	waitForLeader(t, rs1)
+
+	c := rs1.StartNewConnection()
+	trxc := rs1.StartNewConnection()
+	for _, q := range []string{"CREATE DATABASE hello", "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))"} {
+		_, err := c.Query(q)
+		thelper.AssertNoError(t, err)
+	}
+	for _, q := range []string{"BEGIN", "INSERT INTO hello.world(message) VALUES ('bar')"} {
+		_, err := trxc.Query(q)
+		thelper.AssertNoError(t, err)
+	}
+	for i := 0; i < 10; i++ {
+		_, err := c.Query("INSERT INTO hello.world(message) VALUES ('foo')")
+		thelper.AssertNoError(t, err)
+	}
+
+	// The transaction in progress doesn't stop compaction
+	first, err := rs1.storage.FirstIndex()
+	thelper.AssertNoError(t, err)
+	if first <= 1 {
+		t.Errorf("Raft log is not compacted: %d", first)
+	}
+
+	// The new node receives the transaction with the snapshot and applies its COMMIT.
+	rs2 := startTestRaftServer(t, 2, network.NewTransport("raft2"), false)
+	defer rs2.Stop()
+	cc := &raftpb.ConfChange{NodeID: 2, Type: raftpb.ConfChangeAddNode, Context: []byte(rs2.Address())}
+	thelper.AssertNoError(t, rs2.AskJoin(rs1.Address(), cc))
+	waitForRaft(t, func() bool {
+		return rs2.server.wal.CurrentLsn() == rs1.server.wal.CurrentLsn()
+	})
+	_, err = trxc.Query("COMMIT")
+	thelper.AssertNoError(t, err)
+
+	waitForRaft(t, func() bool {
+		return rs2.server.wal.CurrentLsn() == rs1.server.wal.CurrentLsn()
+	})
+	// Snapshots don't keep the position of rows in progress, so the row is compared by id
+	for _, rs := range []*RaftServer{rs1, rs2} {
+		res, err := rs.StartNewConnection().Query("SELECT id FROM hello.world WHERE message = 'bar'")
+		thelper.AssertNoError(t, err)
+		thelper.AssertInt(t, "Invalid row size", 1, len(res.Values))
+		thelper.AssertString(t, "Invalid id", "1", res.Values[0][0])
+
+		res, err = rs.StartNewConnection().Query("SELECT id FROM hello.world")
+		thelper.AssertNoError(t, err)
+		thelper.AssertInt(t, "Invalid row size", 11, len(res.Values))
+	}
+}
+
+func TestRaftServer_MembershipChanges(t *testing.T) {
+	network := transport.NewMemoryNetwork()
+	rs1 := startTestRaftServer(t, 1, network.NewTransport("raft1"), true)
+	defer rs1.Stop()
+	rs2 := startTestRaftServer(t, 2, network.NewTransport("raft2"), false)
+	defer rs2.Stop()
+	rs3 := startTestRaftServer(t, 3, network.NewTransport("raft3"), false)
+	defer rs3.Stop()
+
+	waitForLeader(t, rs1)
+	cc := &raftpb.ConfChange{NodeID: 2, Type: raftpb.ConfChangeAddNode, Context: []byte(rs2.Address())}
+	thelper.AssertNoError(t, rs2.AskJoin(rs1.Address(), cc))
+	cc = &raftpb.ConfChange{NodeID: 3, Type: raftpb.ConfChangeAddLearnerNode, Context: []byte(rs3.Address())}
+	thelper.AssertNoError(t, rs3.AskJoin(rs1.Address(), cc))
+
+	_, err := rs1.StartNewConnection().Query("CREATE DATABASE hello")
+	thelper.AssertNoError(t, err)
+	waitForRaft(t, func() bool {
+		return len(rs3.Members()) == 3
+	})
+	assertMember(t, rs1, 3, "raft3", true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	thelper.AssertNoError(t, rs1.PromoteLearner(ctx, 3))
+	assertMember(t, rs1, 3, "raft3", false)
+
+	thelper.AssertNoError(t, rs1.TransferLeadership(ctx, 2))
+	waitForLeader(t, rs2)
+
+	thelper.AssertNoError(t, rs2.RemoveNode(ctx, 1))
+	thelper.AssertInt(t, "Removed node is a member", 2, len(rs2.Members()))
+	_, ok := rs2.peerAddress(1)
+	thelper.AssertBool(t, "Address of the removed node is kept", false, ok)
+	waitForRaft(t, func() bool {
+		_, ok := rs3.peerAddress(1)
+		return !ok
+	})
+
+	_, err = rs2.StartNewConnection().Query("CREATE DATABASE world")
+	thelper.AssertNoError(t, err)
+
+	thelper.AssertNoError(t, rs2.UpdateNode(ctx, 3, "raft3b"))
+	assertMember(t, rs2, 3, "raft3b", false)
+}
+
+func assertMember(t *testing.T, rs *RaftServer, id uint64, eAddress string, eLearner bool) {
+	t.Helper()
+	for _, m := range rs.Members() {
+		if m.ID == id {
+			thelper.AssertString(t, "Invalid address", eAddress, m.Address)
+			thelper.AssertBool(t, "Invalid learner", eLearner, m.Learner)
+			return
+		}
+	}
+	t.Errorf("No member: %d", id)
+}
+
+func TestRaftServer_Restart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	network := transport.NewMemoryNetwork()
+
+	rs := startDiskRaftServer(t, dir, network)
+	waitForLeader(t, rs)
+	cs := &pbs.ChangeSet{Data: &pbs.ChangeSet_CreateDB{CreateDB: &pbs.CreateDBChangeSet{Name: "hello"}}}
+	thelper.AssertNoError(t, rs.Propose(cs))
+	waitForRaft(t, func() bool {
+		_, ok := rs.server.databases["hello"]
+		return ok
+	})
+	thelper.AssertNoError(t, rs.Stop())
+
+	rs = startDiskRaftServer(t, dir, network)
+	defer rs.Stop()
+	waitForRaft(t, func() bool {
+		_, ok := rs.server.databases["hello"]
+		return ok
+	})
+
+	// The restarted node keeps being the only member instead of bootstrapping again.
+	waitForLeader(t, rs)
+	cs = &pbs.ChangeSet{Data: &pbs.ChangeSet_CreateDB{CreateDB: &pbs.CreateDBChangeSet{Name: "world"}}}
+	thelper.AssertNoError(t, rs.Propose(cs))
+	waitForRaft(t, func() bool {
+		_, ok := rs.server.databases["world"]
+		return ok
+	})
+
+	// Entries replayed from the raft log are not appended to the WAL of the server again.
+	exists, err := rs.server.WalExists()
+	thelper.AssertNoError(t, err)
+	thelper.AssertBool(t, "Raft entries are written to the WAL", false, exists)
+}
+
+func assertReplicatedMessages(t *testing.T, rs *RaftServer, eMessages []string) {
+	t.Helper()
+	res, err := rs.StartNewConnection().Query("SELECT message FROM hello.world")
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Invalid row size", len(eMessages), len(res.Values))
+	for i, row := range res.Values {
+		thelper.AssertString(t, "Invalid message", eMessages[i], row[0])
+	}
+}
+
+func TestRaftServer_RestartFromSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	network := transport.NewMemoryNetwork()
+
+	rs := startDiskRaftServer(t, dir, network)
+	waitForLeader(t, rs)
+	c := rs.StartNewConnection()
+	_, err = c.Query("CREATE DATABASE hello")
+	thelper.AssertNoError(t, err)
+	_, err = c.Query("CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	thelper.AssertNoError(t, err)
+	var messages []string
+	for i := 0; i < 10; i++ {
+		_, err = c.Query("INSERT INTO hello.world(message) VALUES ('foo')")
+		thelper.AssertNoError(t, err)
+		messages = append(messages, "foo")
+	}
+	snap, err := rs.storage.Snapshot()
+	thelper.AssertNoError(t, err)
+	if snap.Metadata.Index == 0 {
+		t.Fatal("No snapshot is taken")
+	}
+	thelper.AssertNoError(t, rs.Stop())
+
+	rs = startDiskRaftServer(t, dir, network)
+	defer rs.Stop()
+	waitForLeader(t, rs)
+	waitForRaft(t, func() bool {
+		return rs.appliedIndex > snap.Metadata.Index
+	})
+	assertReplicatedMessages(t, rs, messages)
+}
+
+func startDiskRaftServer(t *testing.T, dir string, network *transport.MemoryNetwork) *RaftServer {
+	serverDir := filepath.Join(dir, "server")
+	thelper.AssertNoError(t, os.MkdirAll(serverDir, 0700))
+	s, err := NewServerAt(serverDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := raftstorage.OpenDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := StartRaftServer(s, &RaftConfig{
+		ID:                     1,
+		Transport:              network.NewTransport("raft1"),
+		Storage:                storage,
+		SnapshotCount:          5,
+		SnapshotCatchUpEntries: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+func startTestRaftServer(t *testing.T, id uint64, tr transport.Transport, bootstraps bool) *RaftServer {
+	return startTestRaftServerWithConfig(t, &RaftConfig{ID: id, Transport: tr, Join: !bootstraps})
+}
+
+func startTestRaftServerWithConfig(t *testing.T, config *RaftConfig) *RaftServer {
+	s, err := NewTestServer(&wal.Memory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := StartRaftServer(s, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+func waitForLeader(t *testing.T, rs *RaftServer) {
+	t.Helper()
+	waitForRaft(t, func() bool {
+		return rs.IsLeader()
+	})
+}
+
+func waitForRaft(t *testing.T, fn func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if fn() {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("timeout")
+}
@@ -0,0 +1,354 @@
+package raftstorage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const fileName = "raft.log"
+
+type recordType byte
+
+const (
+	entryRecord     recordType = 1
+	hardStateRecord recordType = 2
+	snapshotRecord  recordType = 3
+	confStateRecord recordType = 4
+)
+
+// length(4) and checksum(4)
+const recordHeaderSize = 8
+
+// A larger length means the header itself is broken.
+const maxRecordSize = 1 << 30
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// DiskStorage appends entries, HardState and snapshots to a file under dir
+// and keeps them in memory to serve raft.Storage.
+type DiskStorage struct {
+	*MemoryStorage
+
+	dir      string
+	file     *os.File
+	hasState bool
+}
+
+// OpenDiskStorage loads the state of a previous run from dir if it exists.
+func OpenDiskStorage(dir string) (*DiskStorage, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &DiskStorage{
+		MemoryStorage: NewMemoryStorage(),
+		dir:           dir,
+	}
+	err = s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(s.path(), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open raft log")
+	}
+	s.file = file
+	return s, nil
+}
+
+func (s *DiskStorage) path() string {
+	return filepath.Join(s.dir, fileName)
+}
+
+func (s *DiskStorage) HasState() bool {
+	return s.hasState
+}
+
+func (s *DiskStorage) load() error {
+	file, err := os.Open(s.path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	var validSize int64
+	reader := bufio.NewReader(file)
+	for {
+		t, payload, size, err := readRecord(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			log.Warn().Err(err).Int64("offset", validSize).Msg("raft log has a torn tail. truncating")
+			return os.Truncate(s.path(), validSize)
+		}
+
+		err = s.replay(t, payload)
+		if err != nil {
+			return err
+		}
+		validSize += size
+		s.hasState = true
+	}
+}
+
+func (s *DiskStorage) replay(t recordType, payload []byte) error {
+	switch t {
+	case entryRecord:
+		var e raftpb.Entry
+		err := e.Unmarshal(payload)
+		if err != nil {
+			return err
+		}
+		return s.MemoryStorage.Append([]raftpb.Entry{e})
+	case hardStateRecord:
+		var hs raftpb.HardState
+		err := hs.Unmarshal(payload)
+		if err != nil {
+			return err
+		}
+		return s.MemoryStorage.SetHardState(hs)
+	case snapshotRecord:
+		var snap raftpb.Snapshot
+		err := snap.Unmarshal(payload)
+		if err != nil {
+			return err
+		}
+		return s.MemoryStorage.SaveSnapshot(snap)
+	case confStateRecord:
+		var cs raftpb.ConfState
+		err := cs.Unmarshal(payload)
+		if err != nil {
+			return err
+		}
+		return s.MemoryStorage.SaveConfState(cs)
+	default:
+		return errors.Errorf("unknown raft log record: %d", t)
+	}
+}
+
+func (s *DiskStorage) Save(hs raftpb.HardState, ents []raftpb.Entry, sync bool) error {
+	var bs []byte
+	for _, e := range ents {
+		payload, err := e.Marshal()
+		if err != nil {
+			return err
+		}
+		bs = appendRecord(bs, entryRecord, payload)
+	}
+	if !raft.IsEmptyHardState(hs) {
+		payload, err := hs.Marshal()
+		if err != nil {
+			return err
+		}
+		bs = appendRecord(bs, hardStateRecord, payload)
+	}
+
+	err := s.write(bs, sync)
+	if err != nil {
+		return err
+	}
+	return s.MemoryStorage.Save(hs, ents, sync)
+}
+
+func (s *DiskStorage) SaveSnapshot(snap raftpb.Snapshot) error {
+	payload, err := snap.Marshal()
+	if err != nil {
+		return err
+	}
+	err = s.write(appendRecord(nil, snapshotRecord, payload), true)
+	if err != nil {
+		return err
+	}
+	return s.MemoryStorage.SaveSnapshot(snap)
+}
+
+func (s *DiskStorage) SaveConfState(cs raftpb.ConfState) error {
+	payload, err := cs.Marshal()
+	if err != nil {
+		return err
+	}
+	err = s.write(appendRecord(nil, confStateRecord, payload), true)
+	if err != nil {
+		return err
+	}
+	return s.MemoryStorage.SaveConfState(cs)
+}
+
+// Compact discards entries before compactIndex and rewrites the file
+// so that it holds only the snapshot and the entries after it.
+func (s *DiskStorage) Compact(compactIndex uint64) error {
+	err := s.MemoryStorage.Compact(compactIndex)
+	if err != nil {
+		return err
+	}
+	return s.rewrite()
+}
+
+func (s *DiskStorage) rewrite() error {
+	bs, err := s.encodeState()
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.path() + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open raft log")
+	}
+	_, err = file.Write(bs)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "failed to write raft log")
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	err = s.file.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, s.path())
+	if err != nil {
+		return errors.Wrap(err, "failed to replace raft log")
+	}
+	s.file, err = os.OpenFile(s.path(), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open raft log")
+	}
+	return nil
+}
+
+// encodeState encodes what the memory holds in the order load can replay.
+func (s *DiskStorage) encodeState() ([]byte, error) {
+	var bs []byte
+	snap, err := s.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	if !raft.IsEmptySnap(snap) {
+		payload, err := snap.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		bs = appendRecord(bs, snapshotRecord, payload)
+	}
+
+	first, err := s.FirstIndex()
+	if err != nil {
+		return nil, err
+	}
+	last, err := s.LastIndex()
+	if err != nil {
+		return nil, err
+	}
+	if first <= last {
+		ents, err := s.Entries(first, last+1, math.MaxUint64)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range ents {
+			payload, err := e.Marshal()
+			if err != nil {
+				return nil, err
+			}
+			bs = appendRecord(bs, entryRecord, payload)
+		}
+	}
+
+	hs, cs, err := s.InitialState()
+	if err != nil {
+		return nil, err
+	}
+	if !raft.IsEmptyHardState(hs) {
+		payload, err := hs.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		bs = appendRecord(bs, hardStateRecord, payload)
+	}
+	payload, err := cs.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return appendRecord(bs, confStateRecord, payload), nil
+}
+
+func (s *DiskStorage) write(bs []byte, sync bool) error {
+	if len(bs) == 0 {
+		return nil
+	}
+	_, err := s.file.Write(bs)
+	if err != nil {
+		return errors.Wrap(err, "failed to write raft log")
+	}
+	s.hasState = true
+	if sync {
+		return s.file.Sync()
+	}
+	return nil
+}
+
+func (s *DiskStorage) Close() error {
+	return s.file.Close()
+}
+
+func appendRecord(bs []byte, t recordType, payload []byte) []byte {
+	body := append([]byte{byte(t)}, payload...)
+	header := make([]byte, recordHeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(body)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.Checksum(body, crcTable))
+
+	bs = append(bs, header...)
+	return append(bs, body...)
+}
+
+// readRecord returns io.EOF only when no byte is left.
+func readRecord(r io.Reader) (recordType, []byte, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil {
+		if err == io.EOF && n == 0 {
+			return 0, nil, 0, io.EOF
+		}
+		return 0, nil, 0, errors.Wrap(err, "incomplete record header")
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxRecordSize {
+		return 0, nil, 0, errors.Errorf("too large record: %d", length)
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return 0, nil, 0, errors.Wrap(err, "incomplete record")
+	}
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return 0, nil, 0, errors.New("checksum mismatch")
+	}
+	if length == 0 {
+		return 0, nil, 0, errors.New("empty record")
+	}
+
+	return recordType(body[0]), body[1:], int64(recordHeaderSize + length), nil
+}
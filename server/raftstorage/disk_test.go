@@ -0,0 +1,165 @@
+package raftstorage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/mrasu/ddb/thelper"
+	"github.com/rs/zerolog"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestDiskStorage_Reopen(t *testing.T) {
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := OpenDiskStorage(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertBool(t, "Empty storage has state", false, s.HasState())
+
+	ents := []raftpb.Entry{{Term: 1, Index: 1}, {Term: 1, Index: 2, Data: []byte("foo")}}
+	thelper.AssertNoError(t, s.Save(raftpb.HardState{Term: 1, Vote: 1, Commit: 2}, ents, true))
+	thelper.AssertNoError(t, s.SaveConfState(raftpb.ConfState{Nodes: []uint64{1, 2}}))
+	thelper.AssertNoError(t, s.Close())
+
+	s, err = OpenDiskStorage(dir)
+	thelper.AssertNoError(t, err)
+	defer s.Close()
+	thelper.AssertBool(t, "Reopened storage has no state", true, s.HasState())
+
+	hs, cs, err := s.InitialState()
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt64(t, "Invalid term", 1, int64(hs.Term))
+	thelper.AssertInt64(t, "Invalid vote", 1, int64(hs.Vote))
+	thelper.AssertInt64(t, "Invalid commit", 2, int64(hs.Commit))
+	thelper.AssertInt(t, "Invalid ConfState", 2, len(cs.Nodes))
+
+	last, err := s.LastIndex()
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt64(t, "Invalid last index", 2, int64(last))
+	loaded, err := s.Entries(2, 3, 1024)
+	thelper.AssertNoError(t, err)
+	thelper.AssertString(t, "Invalid entry", "foo", string(loaded[0].Data))
+}
+
+func TestDiskStorage_Snapshot(t *testing.T) {
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := OpenDiskStorage(dir)
+	thelper.AssertNoError(t, err)
+	snap := raftpb.Snapshot{
+		Data:     []byte("snap"),
+		Metadata: raftpb.SnapshotMetadata{Index: 10, Term: 2, ConfState: raftpb.ConfState{Nodes: []uint64{1}}},
+	}
+	thelper.AssertNoError(t, s.SaveSnapshot(snap))
+	thelper.AssertNoError(t, s.Save(raftpb.HardState{Term: 2, Commit: 11}, []raftpb.Entry{{Term: 2, Index: 11}}, true))
+	thelper.AssertNoError(t, s.Close())
+
+	s, err = OpenDiskStorage(dir)
+	thelper.AssertNoError(t, err)
+	defer s.Close()
+
+	loaded, err := s.Snapshot()
+	thelper.AssertNoError(t, err)
+	thelper.AssertString(t, "Invalid snapshot", "snap", string(loaded.Data))
+	first, err := s.FirstIndex()
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt64(t, "Invalid first index", 11, int64(first))
+	_, cs, err := s.InitialState()
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Invalid ConfState", 1, len(cs.Nodes))
+}
+
+func TestDiskStorage_Compact(t *testing.T) {
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := OpenDiskStorage(dir)
+	thelper.AssertNoError(t, err)
+	var ents []raftpb.Entry
+	for i := uint64(1); i <= 10; i++ {
+		ents = append(ents, raftpb.Entry{Term: 1, Index: i, Data: []byte("foo")})
+	}
+	thelper.AssertNoError(t, s.Save(raftpb.HardState{Term: 1, Commit: 10}, ents, true))
+	info, err := os.Stat(filepath.Join(dir, fileName))
+	thelper.AssertNoError(t, err)
+	sizeBefore := info.Size()
+
+	_, err = s.CreateSnapshot(8, &raftpb.ConfState{Nodes: []uint64{1}}, []byte("snap"))
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, s.Compact(6))
+	thelper.AssertNoError(t, s.Save(raftpb.HardState{}, []raftpb.Entry{{Term: 1, Index: 11}}, true))
+	thelper.AssertNoError(t, s.Close())
+
+	info, err = os.Stat(filepath.Join(dir, fileName))
+	thelper.AssertNoError(t, err)
+	if info.Size() >= sizeBefore {
+		t.Errorf("Raft log is not compacted: %d", info.Size())
+	}
+
+	s, err = OpenDiskStorage(dir)
+	thelper.AssertNoError(t, err)
+	defer s.Close()
+	snap, err := s.Snapshot()
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt64(t, "Invalid snapshot index", 8, int64(snap.Metadata.Index))
+	thelper.AssertString(t, "Invalid snapshot", "snap", string(snap.Data))
+	first, err := s.FirstIndex()
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt64(t, "Invalid first index", 9, int64(first))
+	last, err := s.LastIndex()
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt64(t, "Invalid last index", 11, int64(last))
+	hs, _, err := s.InitialState()
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt64(t, "Invalid commit", 10, int64(hs.Commit))
+}
+
+func TestDiskStorage_TornTail(t *testing.T) {
+	dir := makeTempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := OpenDiskStorage(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, s.Save(raftpb.HardState{Term: 1, Commit: 1}, []raftpb.Entry{{Term: 1, Index: 1}}, true))
+	thelper.AssertNoError(t, s.Save(raftpb.HardState{}, []raftpb.Entry{{Term: 1, Index: 2}}, true))
+	thelper.AssertNoError(t, s.Close())
+
+	path := filepath.Join(dir, fileName)
+	info, err := os.Stat(path)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, os.Truncate(path, info.Size()-1))
+
+	s, err = OpenDiskStorage(dir)
+	thelper.AssertNoError(t, err)
+	last, err := s.LastIndex()
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt64(t, "Torn entry is loaded", 1, int64(last))
+
+	// Records after the truncated point must be readable.
+	thelper.AssertNoError(t, s.Save(raftpb.HardState{}, []raftpb.Entry{{Term: 1, Index: 2}}, true))
+	thelper.AssertNoError(t, s.Close())
+	s, err = OpenDiskStorage(dir)
+	thelper.AssertNoError(t, err)
+	defer s.Close()
+	last, err = s.LastIndex()
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt64(t, "Invalid last index", 2, int64(last))
+}
+
+func makeTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "raftstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
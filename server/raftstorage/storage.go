@@ -0,0 +1,97 @@
+package raftstorage
+
+import (
+	"sync"
+
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+// Storage is a raft.Storage which also persists what raft.Ready requires.
+type Storage interface {
+	raft.Storage
+
+	// HasState returns whether the storage holds a previous run of the node.
+	HasState() bool
+	// Save persists entries and HardState. When sync is true, they are flushed to the disk before returning.
+	Save(hs raftpb.HardState, ents []raftpb.Entry, sync bool) error
+	SaveSnapshot(snap raftpb.Snapshot) error
+	// SaveConfState persists the membership applied after the latest snapshot.
+	SaveConfState(cs raftpb.ConfState) error
+	// CreateSnapshot makes a snapshot of the state machine at i. It is persisted when the log is compacted.
+	CreateSnapshot(i uint64, cs *raftpb.ConfState, data []byte) (raftpb.Snapshot, error)
+	// Compact discards entries before compactIndex.
+	Compact(compactIndex uint64) error
+	Close() error
+}
+
+// MemoryStorage keeps the state only in memory.
+// A node restarted with the same MemoryStorage behaves like one restarted from the disk.
+type MemoryStorage struct {
+	*raft.MemoryStorage
+
+	mu        sync.Mutex
+	confState *raftpb.ConfState
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{MemoryStorage: raft.NewMemoryStorage()}
+}
+
+func (s *MemoryStorage) HasState() bool {
+	hs, _, _ := s.InitialState()
+	if !raft.IsEmptyHardState(hs) {
+		return true
+	}
+	last, _ := s.LastIndex()
+	return last > 0
+}
+
+// InitialState returns the ConfState saved by SaveConfState if it is newer than the snapshot.
+func (s *MemoryStorage) InitialState() (raftpb.HardState, raftpb.ConfState, error) {
+	hs, cs, err := s.MemoryStorage.InitialState()
+	if err != nil {
+		return hs, cs, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.confState != nil {
+		cs = *s.confState
+	}
+	return hs, cs, nil
+}
+
+func (s *MemoryStorage) Save(hs raftpb.HardState, ents []raftpb.Entry, _ bool) error {
+	err := s.Append(ents)
+	if err != nil {
+		return err
+	}
+	if !raft.IsEmptyHardState(hs) {
+		return s.SetHardState(hs)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) SaveSnapshot(snap raftpb.Snapshot) error {
+	err := s.ApplySnapshot(snap)
+	if err == raft.ErrSnapOutOfDate {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.SaveConfState(snap.Metadata.ConfState)
+}
+
+func (s *MemoryStorage) SaveConfState(cs raftpb.ConfState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.confState = &cs
+	return nil
+}
+
+func (s *MemoryStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/mrasu/ddb/server/data"
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// discardedDirName is the directory under the data directory which keeps the WAL and snapshots discarded by recovery.
+// Each recovery moves them to its own subdirectory named by the time.
+const discardedDirName = "discarded"
+
+// RecoverToLsn restores the state just after the ChangeSet at lsn is applied. It is used instead of Recover.
+// ChangeSets after lsn are discarded from the WAL, and transactions not committed by lsn are dropped.
+// The discarded WAL and snapshots are moved under the `discarded` directory.
+func (s *Server) RecoverToLsn(lsn int64) error {
+	return s.recoverTo(
+		func(ss *data.Snapshot) bool { return ss.Lsn() <= lsn+1 },
+		func(cs *pbs.ChangeSet) bool { return cs.Lsn > lsn },
+	)
+}
+
+// RecoverToTime restores ChangeSets written at t or before. It is used instead of Recover.
+// The WAL is discarded from the first ChangeSet written after t, and transactions not committed by then are dropped.
+// The discarded WAL and snapshots are moved under the `discarded` directory.
+func (s *Server) RecoverToTime(t time.Time) error {
+	ts := t.UnixNano()
+	return s.recoverTo(
+		func(ss *data.Snapshot) bool { return ss.Timestamp() != 0 && ss.Timestamp() <= ts },
+		func(cs *pbs.ChangeSet) bool { return writtenAt(cs) > ts },
+	)
+}
+
+// writtenAt returns when cs is written to the WAL.
+// Records of older versions have the time only in COMMIT, so that others are restored until the next COMMIT.
+func writtenAt(cs *pbs.ChangeSet) int64 {
+	if cs.Timestamp != 0 {
+		return cs.Timestamp
+	}
+	return cs.GetCommit().GetTimestamp()
+}
+
+// recoverTo restores the newest snapshot accepted by acceptSnapshot and replays the WAL until stop returns true.
+func (s *Server) recoverTo(acceptSnapshot func(*data.Snapshot) bool, stop func(*pbs.ChangeSet) bool) error {
+	if s.raft != nil {
+		return errors.New("point-in-time recovery is not supported by replicated servers")
+	}
+
+	ss, err := data.RecoverSnapshotIf(s.dir, acceptSnapshot)
+	if err == nil {
+		s.restoreSnapshot(ss)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if s.wal.CurrentLsn() < s.wal.OldestLsn() {
+		return errors.Errorf("WAL before LSN %d is already removed", s.wal.OldestLsn())
+	}
+
+	// Transactions not committed by the target are dropped.
+	stopLsn, err := s.replayWal(stop)
+	if err != nil {
+		return err
+	}
+
+	if stopLsn >= 0 {
+		// The history after the target is kept to recover it by hand
+		archive := filepath.Join(s.dir, discardedDirName, strconv.FormatInt(time.Now().UnixNano(), 10))
+		log.Info().Int64("lsn", stopLsn).Str("archive", archive).Msg("discarding WAL after the recovery target")
+		err = s.wal.ArchiveFrom(stopLsn, archive)
+		if err != nil {
+			return err
+		}
+		err = data.ArchiveSnapshotsAfter(s.dir, stopLsn, archive)
+		if err != nil {
+			return err
+		}
+	}
+	// Dropped transactions are still in the WAL. The snapshot keeps them from being replayed.
+	return s.TakeSnapshot()
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mrasu/ddb/server/data"
+	"github.com/mrasu/ddb/thelper"
+)
+
+func TestServer_RecoverToLsn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('foo')")
+	thelper.AssertNoError(t, s.TakeSnapshot())
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('bar')")
+	target := s.wal.CurrentLsn() - 1
+	exec(t, c, "UPDATE hello.world SET message = 'bad'")
+	thelper.AssertNoError(t, s.TakeSnapshot())
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('baz')")
+	thelper.AssertNoError(t, s.Close())
+
+	recovered, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, recovered.RecoverToLsn(target))
+	// The history after the target is kept
+	for _, pattern := range []string{"wal_*.discarded", "snapshot_*.snap"} {
+		archived, err := filepath.Glob(filepath.Join(dir, discardedDirName, "*", pattern))
+		thelper.AssertNoError(t, err)
+		thelper.AssertInt(t, "Discarded files are not archived: "+pattern, 1, len(archived))
+	}
+	rc := recovered.StartNewConnection()
+	res := exec(t, rc, "SELECT message FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"message": "foo"}, {"message": "bar"}})
+
+	// Writes continue from the target
+	exec(t, rc, "INSERT INTO hello.world(message) VALUES ('qux')")
+	thelper.AssertNoError(t, recovered.Close())
+
+	restarted, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, restarted.Recover())
+	res = exec(t, restarted.StartNewConnection(), "SELECT message FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"message": "foo"}, {"message": "bar"}, {"message": "qux"}})
+}
+
+func TestServer_RecoverToTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	c1 := s.StartNewConnection()
+	c2 := s.StartNewConnection()
+	exec(t, c1, "CREATE DATABASE hello")
+	exec(t, c1, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	exec(t, c2, "BEGIN")
+	exec(t, c2, "INSERT INTO hello.world(message) VALUES ('open')")
+	exec(t, c1, "BEGIN")
+	exec(t, c1, "INSERT INTO hello.world(message) VALUES ('foo')")
+	exec(t, c1, "COMMIT")
+	time.Sleep(time.Millisecond)
+	target := time.Now()
+	time.Sleep(time.Millisecond)
+	// Changes outside transactions after the target are not restored either
+	exec(t, c1, "INSERT INTO hello.world(message) VALUES ('auto')")
+	exec(t, c1, "CREATE TABLE hello.later(id int)")
+	exec(t, c1, "BEGIN")
+	exec(t, c1, "INSERT INTO hello.world(message) VALUES ('bar')")
+	exec(t, c1, "COMMIT")
+	exec(t, c2, "COMMIT")
+	thelper.AssertNoError(t, s.TakeSnapshot())
+	thelper.AssertNoError(t, s.Close())
+
+	recovered, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, recovered.RecoverToTime(target))
+	thelper.AssertInt(t, "Uncommitted transaction remains", 0, recovered.transactionHolder.Count())
+	res := exec(t, recovered.StartNewConnection(), "SELECT message FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"message": "foo"}})
+	_, err = recovered.StartNewConnection().Query("SELECT * FROM hello.later")
+	thelper.AssertBool(t, "Table created after the target exists", true, err != nil)
+	thelper.AssertNoError(t, recovered.Close())
+
+	restarted, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, restarted.Recover())
+	res = exec(t, restarted.StartNewConnection(), "SELECT message FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"message": "foo"}})
+}
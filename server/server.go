@@ -3,6 +3,8 @@ package server
 import (
 	"fmt"
 	"io"
+	"os"
+	"sync"
 
 	"github.com/mrasu/ddb/server/pbs"
 
@@ -16,31 +18,60 @@ import (
 )
 
 type Server struct {
+	dir       string
 	databases map[string]*data.Database
 	wal       *wal.Wal
+	// stateMu is held exclusively by writers and while a snapshot begins at a LSN, and shared by readers
+	stateMu sync.RWMutex
+	// snapshotMu serializes TakeSnapshot
+	snapshotMu sync.Mutex
+	// copyMu serializes copying databases for snapshots, because a table gives changed rows to one SnapshotCopier
+	copyMu sync.Mutex
+	// replayLsn is where RecoverFromWal starts reading, which is set by the restored snapshot
+	replayLsn int64
+	// snapshotRetention is the number of snapshots kept under dir
+	snapshotRetention int
+	scheduler         *snapshotScheduler
+
+	subscriptionMu sync.Mutex
+	subscriptions  map[*Subscription]bool
 
 	transactionHolder *data.TransactionHolder
+	// walErr is the error of writing or flushing a ChangeSet to the WAL after it is applied, which stops further writes
+	walErr error
+
+	// raft is set when the Server is replicated by StartRaftServer.
+	raft *RaftServer
 }
 
 func NewServer() (*Server, error) {
-	w, err := wal.NewWal("./log", "wal_")
+	return NewServerAt("./log")
+}
+
+// NewServerAt creates a Server which stores its WAL and snapshot under dir.
+func NewServerAt(dir string) (*Server, error) {
+	w, err := wal.NewWal(dir, "wal_")
 	if err != nil {
 		return nil, err
 	}
 
 	return &Server{
+		dir:       dir,
 		databases: map[string]*data.Database{},
 		wal:       w,
 
+		snapshotRetention: data.DefaultSnapshotRetention,
 		transactionHolder: data.NewTransactionHolder(),
 	}, nil
 }
 
 func NewTestServer(writer io.ReadWriteCloser) (*Server, error) {
 	return &Server{
+		dir:       "./log",
 		databases: map[string]*data.Database{},
 		wal:       wal.NewTestWal(writer),
 
+		snapshotRetention: data.DefaultSnapshotRetention,
 		transactionHolder: data.NewTransactionHolder(),
 	}, nil
 }
@@ -52,20 +83,53 @@ func (s *Server) Inspect() {
 	}
 }
 
+// nodeID returns the id of this node in the raft group, which is 0 when the Server is not replicated.
+func (s *Server) nodeID() uint64 {
+	if s.raft == nil {
+		return 0
+	}
+	return s.raft.ID()
+}
+
 func (s *Server) StartNewConnection() *Connection {
 	return newConnection(s)
 }
 
+// SetWalSyncPolicy changes when the WAL is flushed to the disk.
+func (s *Server) SetWalSyncPolicy(p wal.SyncPolicy) error {
+	return s.wal.SetSyncPolicy(p)
+}
+
+// SetSnapshotRetention changes the number of snapshots kept by TakeSnapshot.
+func (s *Server) SetSnapshotRetention(n int) error {
+	if n < 1 {
+		return errors.Errorf("snapshot retention must be positive: %d", n)
+	}
+	s.snapshotRetention = n
+	return nil
+}
+
+// Close stops the snapshot scheduler and subscriptions, and closes the WAL.
+func (s *Server) Close() error {
+	s.StopSnapshotScheduler()
+	s.closeSubscriptions()
+	return s.wal.Close()
+}
+
 func (s *Server) WalExists() (bool, error) {
 	return s.wal.Exists()
 }
 
 func (s *Server) UseTemporalWal() error {
-	w, err := wal.NewWal("./log", "wal_tmp_")
+	w, err := wal.NewWal(s.dir, "wal_tmp_")
 	if err != nil {
 		return err
 	}
 	w.Remove()
+	err = s.wal.Close()
+	if err != nil {
+		return err
+	}
 	s.wal = w
 	return nil
 }
@@ -74,135 +138,383 @@ func (s *Server) addDatabase(db *data.Database) {
 	s.databases[db.Name] = db
 }
 
-func (s *Server) RecoverFromWal() error {
-	css, err := s.wal.Read()
+func (s *Server) removeDatabase(name string) {
+	delete(s.databases, name)
+}
+
+func (s *Server) getDatabase(name string) (*data.Database, error) {
+	db, ok := s.databases[name]
+	if !ok {
+		return nil, errors.Errorf("database doesn't exist: %s", name)
+	}
+	return db, nil
+}
+
+// Recover restores the state from the snapshot and the WAL if they exist.
+func (s *Server) Recover() error {
+	exists, err := s.WalExists()
 	if err != nil {
 		return err
 	}
+	if !exists {
+		return nil
+	}
 
+	err = s.RecoverSnapshot()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.RecoverFromWal()
+}
+
+func (s *Server) RecoverFromWal() error {
+	_, err := s.replayWal(nil)
+	return err
+}
+
+// replayWal applies ChangeSets in the WAL from the current LSN until stop returns true.
+// Transactions not committed when it returns are dropped.
+// It returns the LSN of the ChangeSet stopping the replay, or -1 when the whole WAL is applied.
+func (s *Server) replayWal(stop func(*pbs.ChangeSet) bool) (int64, error) {
 	start := s.wal.CurrentLsn()
+	// Transactions in progress at the snapshot started from replayLsn.
+	readLsn := start
+	if s.replayLsn < readLsn {
+		readLsn = s.replayLsn
+	}
+	css, err := s.wal.ReadFrom(readLsn)
+	if err != nil {
+		return 0, err
+	}
+
+	r := newWalReplayer(s, start)
+	defer r.finish()
 	for _, cs := range css {
-		if cs.GetLsn() < start {
+		if cs.Lsn < readLsn {
 			continue
 		}
+		if cs.Lsn >= start && stop != nil && stop(cs) {
+			return cs.Lsn, nil
+		}
 
-		var pbcs *pbs.ChangeSet
-		switch c := cs.(type) {
-		case *structs.CreateDBChangeSet:
-			pbcs = toPbCreateDatabase(c)
-		case *structs.CreateTableChangeSet:
-			pbcs = toPbCreateTable(c)
-		case *structs.InsertChangeSet:
-			pbcs = toPBInsertChangeSets(c)
-		case *structs.UpdateChangeSet:
-			pbcs = toPBUpdateChangeSets(c)
-		case *structs.BeginChangeSet:
-			pbcs = toPBBeginChangeSets(c)
-		case *structs.CommitChangeSet:
-			pbcs = toPBCommitChangeSets(c)
-		case *structs.RollbackChangeSet:
-			pbcs = toPBRollbackChangeSets(c)
-		case *structs.AbortChangeSet:
-			pbcs = toPBAbortChangeSets(c)
-		default:
-			return errors.Errorf("Not supported ChangeSet: %s", c)
-		}
-
-		err = s.ApplyChangeSet(pbcs, false)
+		err = r.replay(cs)
 		if err != nil {
-			return err
+			return 0, err
 		}
 	}
 
-	s.wal.ProceedLsn(int64(len(css)))
+	return -1, nil
+}
 
-	return nil
+// advanceLsn moves the LSN after lsn when it is behind.
+func (s *Server) advanceLsn(lsn int64) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	// Logs of the legacy format may skip LSNs.
+	if lsn >= s.wal.CurrentLsn() {
+		s.wal.SetLsn(lsn + 1)
+	}
 }
 
+// ApplyChangeSet applies cs and writes it to the WAL when writesWal is true.
+// The WAL is flushed after stateMu is released, so that concurrent writers share the flush and readers don't wait for it.
 func (s *Server) ApplyChangeSet(cs *pbs.ChangeSet, writesWal bool) error {
+	s.stateMu.Lock()
+	seq, err := s.applyChangeSetAt(cs, writesWal)
+	if err == nil && writesWal {
+		s.queueToSubscriptions(cs)
+	}
+	s.stateMu.Unlock()
+	if err != nil {
+		return err
+	}
 	if writesWal {
-		if _, ok := cs.Data.(*pbs.ChangeSet_Commit); ok {
-			// write log later
-		} else {
-			css := toStructsChangeSets(cs)
-			err := s.wal.WriteSlice(css)
-			if err != nil {
-				return err
-			}
+		err = s.wal.WaitSync(seq)
+		if err != nil {
+			s.stateMu.Lock()
+			s.walErr = err
+			s.stateMu.Unlock()
+			return err
 		}
-	} else {
+	}
+
+	s.notifySnapshotScheduler()
+	s.notifySubscriptions()
+	return nil
+}
+
+// applyChangeSetAt applies cs at the current LSN. It must be called with stateMu locked.
+// It returns the sequence of the appended record to wait for its flush.
+func (s *Server) applyChangeSetAt(cs *pbs.ChangeSet, writesWal bool) (uint64, error) {
+	if !writesWal {
 		start := s.wal.CurrentLsn()
 		if cs.Lsn < start {
-			return errors.Errorf("received past wal number. current:%d, lsn: %d", start, cs.Lsn)
+			return 0, errors.Errorf("received past wal number. current:%d, lsn: %d", start, cs.Lsn)
+		}
+	}
+
+	seq, err := s.applyChangeSet(cs, writesWal)
+	if err != nil {
+		return 0, err
+	}
+	if !writesWal {
+		s.wal.ProceedLsn(1)
+	}
+	return seq, nil
+}
+
+// applyReplayedChangeSets applies ChangeSets read from the WAL and moves the LSN after lsn.
+func (s *Server) applyReplayedChangeSets(css []*pbs.ChangeSet, lsn int64) error {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	for _, cs := range css {
+		_, err := s.applyChangeSet(cs, false)
+		if err != nil {
+			return err
 		}
 	}
+	if lsn >= s.wal.CurrentLsn() {
+		s.wal.SetLsn(lsn + 1)
+	}
+	return nil
+}
 
+// applyChangeSet must be called with stateMu locked.
+// cs is appended to the WAL only after it is applied, so that the WAL never has ChangeSets which fail on recovery.
+// It returns the sequence of the appended record, which is not flushed yet.
+func (s *Server) applyChangeSet(cs *pbs.ChangeSet, writesWal bool) (uint64, error) {
+	if writesWal {
+		if s.walErr != nil {
+			return 0, errors.Wrap(s.walErr, "WAL is broken")
+		}
+		// Changes are applied with the LSN which the WAL gives to cs
+		cs.Lsn = s.wal.CurrentLsn()
+	}
+
+	err := s.applyChangeSetData(cs)
+	if err != nil || !writesWal {
+		return 0, err
+	}
+	seq, err := s.wal.Append(cs)
+	if err != nil {
+		// The applied change cannot be undone, so further writes fail rather than leave the WAL behind the state.
+		s.walErr = err
+		return 0, err
+	}
+	return seq, nil
+}
+
+// applyChangeSetData changes databases and transactions by cs. It must be called with stateMu locked.
+// A ChangeSet returning error changes nothing.
+func (s *Server) applyChangeSetData(cs *pbs.ChangeSet) error {
 	var err error
 	switch c := cs.Data.(type) {
 	case *pbs.ChangeSet_CreateDB:
 		err = s.applyCreateDBChangeSet(c.CreateDB)
+	case *pbs.ChangeSet_DropDB:
+		err = s.applyDropDBChangeSet(c.DropDB)
 	case *pbs.ChangeSet_CreateTable:
-		db := s.databases[c.CreateTable.DBName]
-		err = db.ApplyCreateTableChangeSet(c.CreateTable)
+		var db *data.Database
+		db, err = s.getDatabase(c.CreateTable.DBName)
+		if err == nil {
+			err = db.ApplyCreateTableChangeSet(c.CreateTable)
+		}
+	case *pbs.ChangeSet_DropTable:
+		var db *data.Database
+		db, err = s.getDatabase(c.DropTable.DBName)
+		if err == nil {
+			err = db.ApplyDropTableChangeSet(c.DropTable)
+		}
+	case *pbs.ChangeSet_TruncateTable:
+		var db *data.Database
+		db, err = s.getDatabase(c.TruncateTable.DBName)
+		if err == nil {
+			err = db.ApplyTruncateTableChangeSet(c.TruncateTable)
+		}
+	case *pbs.ChangeSet_AddColumn:
+		var db *data.Database
+		db, err = s.getDatabase(c.AddColumn.DBName)
+		if err == nil {
+			err = db.ApplyAddColumnChangeSet(c.AddColumn)
+		}
+	case *pbs.ChangeSet_DropColumn:
+		var db *data.Database
+		db, err = s.getDatabase(c.DropColumn.DBName)
+		if err == nil {
+			err = db.ApplyDropColumnChangeSet(c.DropColumn)
+		}
+	case *pbs.ChangeSet_RenameColumn:
+		var db *data.Database
+		db, err = s.getDatabase(c.RenameColumn.DBName)
+		if err == nil {
+			err = db.ApplyRenameColumnChangeSet(c.RenameColumn)
+		}
+	case *pbs.ChangeSet_ModifyColumn:
+		var db *data.Database
+		db, err = s.getDatabase(c.ModifyColumn.DBName)
+		if err == nil {
+			err = db.ApplyModifyColumnChangeSet(c.ModifyColumn)
+		}
+	case *pbs.ChangeSet_RenameTable:
+		var db *data.Database
+		db, err = s.getDatabase(c.RenameTable.DBName)
+		if err == nil {
+			err = db.ApplyRenameTableChangeSet(c.RenameTable)
+		}
+	case *pbs.ChangeSet_CreateIndex:
+		var db *data.Database
+		db, err = s.getDatabase(c.CreateIndex.DBName)
+		if err == nil {
+			err = db.ApplyCreateIndexChangeSet(c.CreateIndex)
+		}
+	case *pbs.ChangeSet_DropIndex:
+		var db *data.Database
+		db, err = s.getDatabase(c.DropIndex.DBName)
+		if err == nil {
+			err = db.ApplyDropIndexChangeSet(c.DropIndex)
+		}
 	case *pbs.ChangeSet_InsertSets:
-		db := s.databases[c.InsertSets.DBName]
-		trx := s.transactionHolder.Get(c.InsertSets.TransactionNumber)
-		if trx == nil {
-			panic(fmt.Sprintf("found not started transaction: %d", c.InsertSets.TransactionNumber))
+		var db *data.Database
+		db, err = s.getDatabase(c.InsertSets.DBName)
+		if err != nil {
+			break
+		}
+		var trx *data.Transaction
+		trx, err = s.transactionOf(cs, c.InsertSets.TransactionNumber)
+		if err != nil {
+			break
 		}
 		err = db.ApplyInsertChangeSets(trx, c.InsertSets)
+		if err == nil && !trx.IsImmediate() {
+			trx.AddChangeSet(cs)
+		}
 	case *pbs.ChangeSet_UpdateSets:
-		db := s.databases[c.UpdateSets.DBName]
-		trx := s.transactionHolder.Get(c.UpdateSets.TransactionNumber)
-		if trx == nil {
-			panic(fmt.Sprintf("found not started transaction: %d", c.UpdateSets.TransactionNumber))
+		var db *data.Database
+		db, err = s.getDatabase(c.UpdateSets.DBName)
+		if err != nil {
+			break
+		}
+		var trx *data.Transaction
+		trx, err = s.transactionOf(cs, c.UpdateSets.TransactionNumber)
+		if err != nil {
+			break
 		}
 		err = db.ApplyUpdateChangeSets(trx, c.UpdateSets)
+		if err == nil && !trx.IsImmediate() {
+			trx.AddChangeSet(cs)
+		}
+	case *pbs.ChangeSet_DeleteSets:
+		var db *data.Database
+		db, err = s.getDatabase(c.DeleteSets.DBName)
+		if err != nil {
+			break
+		}
+		var trx *data.Transaction
+		trx, err = s.transactionOf(cs, c.DeleteSets.TransactionNumber)
+		if err != nil {
+			break
+		}
+		err = db.ApplyDeleteChangeSets(trx, c.DeleteSets)
+		if err == nil && !trx.IsImmediate() {
+			trx.AddChangeSet(cs)
+		}
 	case *pbs.ChangeSet_Begin:
-		trx := data.StartNewTransaction()
-		trx.Number = c.Begin.Number
+		trx := data.StartTransactionWithNumber(c.Begin.Number)
+		trx.BeginLsn = cs.Lsn
 		ok := s.transactionHolder.Add(trx)
 		if !ok {
-			panic("invalid 'BEGIN' change set")
+			return errors.Errorf("transaction is already started: %d", c.Begin.Number)
 		}
 		trx.ApplyBeginChangeSet(c.Begin)
+		trx.AddChangeSet(cs)
 	case *pbs.ChangeSet_Commit:
-		trx := s.transactionHolder.Get(c.Commit.Number)
-		if trx == nil {
-			panic(fmt.Sprintf("found not started transaction: %d", c.Commit.Number))
-		}
-		err = trx.ApplyCommitChangeSet(c.Commit, func(set *pbs.CommitChangeSet) error {
-			if writesWal {
-				scs := &pbs.ChangeSet{Data: &pbs.ChangeSet_Commit{Commit: set}}
-				css := toStructsChangeSets(scs)
-				return s.wal.WriteSlice(css)
-			} else {
-				return nil
-			}
+		var trx *data.Transaction
+		trx, err = s.transactionOf(cs, c.Commit.Number)
+		if err != nil {
+			break
+		}
+		if s.changesReadRows(c.Commit) {
+			err = data.NewTransactionConflictError()
+			break
+		}
+		err = trx.ApplyCommitChangeSet(c.Commit, func(_ *pbs.CommitChangeSet) error {
+			trx.CommitLsn = cs.Lsn
+			return nil
 		})
+		if err == nil {
+			s.transactionHolder.Remove(c.Commit.Number)
+		}
 	case *pbs.ChangeSet_Rollback:
-		trx := s.transactionHolder.Get(c.Rollback.Number)
-		if trx == nil {
-			panic(fmt.Sprintf("found not started transaction: %d", c.Rollback.Number))
+		var trx *data.Transaction
+		trx, err = s.transactionOf(cs, c.Rollback.Number)
+		if err != nil {
+			break
 		}
 		trx.ApplyRollbackChangeSet(c.Rollback)
+		s.transactionHolder.Remove(c.Rollback.Number)
 	case *pbs.ChangeSet_Abort:
-		trx := s.transactionHolder.Get(c.Abort.Number)
-		if trx == nil {
-			panic(fmt.Sprintf("found not started transaction: %d", c.Abort.Number))
+		var trx *data.Transaction
+		trx, err = s.transactionOf(cs, c.Abort.Number)
+		if err != nil {
+			break
 		}
 		trx.ApplyAbortChangeSet(c.Abort)
+		s.transactionHolder.Remove(c.Abort.Number)
 	default:
 		return errors.Errorf("Not supported ChangeSet: %s", c)
 	}
 
-	if err != nil {
-		return err
+	return err
+}
+
+// transactionOf returns the transaction of num applying cs.
+// The immediate transaction commits rows with cs, and others commit them with COMMIT.
+func (s *Server) transactionOf(cs *pbs.ChangeSet, num int64) (*data.Transaction, error) {
+	trx := s.transactionHolder.Get(num)
+	if trx == nil {
+		return nil, errors.Errorf("found not started transaction: %d", num)
+	}
+	if trx.IsImmediate() {
+		trx.CommitLsn = cs.Lsn
 	}
+	return trx, nil
+}
 
-	s.wal.ProceedLsn(1)
+// changesReadRows tells whether a row read by the transaction of cs has been committed by another transaction.
+// It depends only on cs and applied ChangeSets so that every node of the raft group decides the same.
+func (s *Server) changesReadRows(cs *pbs.CommitChangeSet) bool {
+	for _, rr := range cs.ReadRows {
+		db, ok := s.databases[rr.DBName]
+		if !ok || db.IsReadRowChanged(rr) {
+			return true
+		}
+	}
+	return false
+}
 
-	return nil
+// writeChangeSet applies cs made by this Server.
+// When the Server is replicated, cs is applied after the raft group commits it.
+// Followers forward cs to the leader or return NotLeaderError, depending on RaftConfig.ForwardWrites.
+func (s *Server) writeChangeSet(cs *pbs.ChangeSet) error {
+	if s.raft == nil {
+		return s.ApplyChangeSet(cs, true)
+	}
+	// COMMIT conflicts on every node when it is applied, because it holds rows read by the transaction.
+	return s.raft.ProposeAndWait(cs)
+}
+
+// makeAndWriteChangeSet writes the ChangeSet given by makeFn, which reads databases while no ChangeSet is applied.
+// Nothing is written when makeFn returns nil.
+func (s *Server) makeAndWriteChangeSet(makeFn func() (*pbs.ChangeSet, error)) error {
+	s.stateMu.RLock()
+	cs, err := makeFn()
+	s.stateMu.RUnlock()
+	if err != nil || cs == nil {
+		return err
+	}
+	return s.writeChangeSet(cs)
 }
 
 func (s *Server) applyCreateDBChangeSet(cs *pbs.CreateDBChangeSet) error {
@@ -215,67 +527,216 @@ func (s *Server) applyCreateDBChangeSet(cs *pbs.CreateDBChangeSet) error {
 	return nil
 }
 
+// applyDropDBChangeSet removes the database. Transactions which read or changed its tables conflict on commit.
+func (s *Server) applyDropDBChangeSet(cs *pbs.DropDBChangeSet) error {
+	db, ok := s.databases[cs.Name]
+	if !ok {
+		return errors.Errorf("database doesn't exist: %s", cs.Name)
+	}
+
+	db.Drop()
+	s.removeDatabase(cs.Name)
+	return nil
+}
+
 func (s *Server) runDBDDL(t *sqlparser.DBDDL) error {
-	if t.Action == sqlparser.CreateStr {
+	switch t.Action {
+	case sqlparser.CreateStr:
 		return s.createDatabase(t)
-	} else {
+	case sqlparser.DropStr:
+		return s.dropDatabase(t)
+	default:
 		return errors.Errorf("not defined statement: %s", t.Action)
 	}
 }
 
 func (s *Server) createDatabase(dbddl *sqlparser.DBDDL) error {
-	name := dbddl.DBName
-	if _, ok := s.databases[name]; ok {
-		if dbddl.IfExists {
-			// not supported by sqlparser?
-			return nil
-		} else {
-			return errors.Errorf("database already exists: %s", name)
+	return s.makeAndWriteChangeSet(func() (*pbs.ChangeSet, error) {
+		name := dbddl.DBName
+		if _, ok := s.databases[name]; ok {
+			if dbddl.IfExists {
+				// not supported by sqlparser?
+				return nil, nil
+			} else {
+				return nil, errors.Errorf("database already exists: %s", name)
+			}
 		}
-	}
 
-	cs := &structs.CreateDBChangeSet{Name: name}
-	return s.ApplyChangeSet(toPbCreateDatabase(cs), true)
+		cs := &structs.CreateDBChangeSet{Name: name}
+		return toPbCreateDatabase(cs), nil
+	})
+}
+
+func (s *Server) dropDatabase(dbddl *sqlparser.DBDDL) error {
+	return s.makeAndWriteChangeSet(func() (*pbs.ChangeSet, error) {
+		name := dbddl.DBName
+		if _, ok := s.databases[name]; !ok {
+			if dbddl.IfExists {
+				return nil, nil
+			} else {
+				return nil, errors.Errorf("database doesn't exist: %s", name)
+			}
+		}
+
+		cs := &structs.DropDBChangeSet{Name: name}
+		return toPbDropDatabase(cs), nil
+	})
 }
 
 func (s *Server) runDDL(ddl *sqlparser.DDL) error {
-	db, ok := s.databases[ddl.NewName.Qualifier.String()]
-	if !ok {
-		return errors.Errorf("database doesn't exist: %s", ddl.NewName.Qualifier)
-	}
+	return s.makeAndWriteChangeSet(func() (*pbs.ChangeSet, error) {
+		return s.makeDDLChangeSet(ddl)
+	})
+}
 
-	if ddl.Action == sqlparser.CreateStr {
+func (s *Server) makeDDLChangeSet(ddl *sqlparser.DDL) (*pbs.ChangeSet, error) {
+	switch ddl.Action {
+	case sqlparser.CreateStr:
+		db, ok := s.databases[ddl.NewName.Qualifier.String()]
+		if !ok {
+			return nil, errors.Errorf("database doesn't exist: %s", ddl.NewName.Qualifier)
+		}
 		cs, err := db.MakeCreateTableChangeSet(ddl)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		return toPbCreateTable(cs), nil
+	case sqlparser.DropStr:
+		db, ok := s.databases[ddl.Table.Qualifier.String()]
+		if !ok || !db.HasTable(ddl.Table.Name.String()) {
+			if ddl.IfExists {
+				return nil, nil
+			}
+		}
+		if !ok {
+			return nil, errors.Errorf("database doesn't exist: %s", ddl.Table.Qualifier)
+		}
+		cs, err := db.MakeDropTableChangeSet(ddl)
+		if err != nil {
+			return nil, err
+		}
+		return toPbDropTable(cs), nil
+	case sqlparser.TruncateStr:
+		db, ok := s.databases[ddl.Table.Qualifier.String()]
+		if !ok {
+			return nil, errors.Errorf("database doesn't exist: %s", ddl.Table.Qualifier)
+		}
+		cs, err := db.MakeTruncateTableChangeSet(ddl)
+		if err != nil {
+			return nil, err
+		}
+		return toPbTruncateTable(cs), nil
+	case sqlparser.RenameStr:
+		db, err := s.getDatabase(ddl.Table.Qualifier.String())
+		if err != nil {
+			return nil, err
 		}
-		pbcs := toPbCreateTable(cs)
-		return s.ApplyChangeSet(pbcs, true)
-	} else {
-		return errors.Errorf("Not supported query: %s", ddl.Action)
+		cs, err := db.MakeRenameTableChangeSet(ddl)
+		if err != nil {
+			return nil, err
+		}
+		return &pbs.ChangeSet{Data: &pbs.ChangeSet_RenameTable{RenameTable: cs}}, nil
+	default:
+		return nil, errors.Errorf("Not supported query: %s", ddl.Action)
+	}
+}
+
+// alterTable runs ALTER TABLE, CREATE INDEX and DROP INDEX, which are parsed from sql because sqlparser drops how columns and indexes are changed.
+func (s *Server) alterTable(sql string) error {
+	alt, err := data.ParseAlterTable(sql)
+	if err != nil {
+		return err
 	}
+	return s.makeAndWriteChangeSet(func() (*pbs.ChangeSet, error) {
+		db, err := s.getDatabase(alt.Table.Qualifier.String())
+		if err != nil {
+			return nil, err
+		}
+		return db.MakeAlterTableChangeSet(alt)
+	})
 }
 
+// TakeSnapshot saves the snapshot and removes WAL segments covered by every kept snapshot.
+// Writers don't wait for the copy of rows nor the write of the snapshot.
+// Segments after the oldest snapshot are kept so that recovery can fall back to it when newer ones are broken.
 func (s *Server) TakeSnapshot() error {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	ss := s.takeSnapshot()
+	err := ss.Save(s.dir, s.snapshotRetention)
+	if err != nil {
+		return err
+	}
+
+	lsn, err := data.OldestReplayLsn(s.dir)
+	if err != nil {
+		return err
+	}
+	if lsn < 0 {
+		return nil
+	}
+	return s.wal.RemoveBefore(lsn)
+}
+
+// takeSnapshot copies the databases at the current LSN.
+// Every ChangeSet before the LSN has been applied because applying holds stateMu,
+// and rows changed after it keep their values for the copy.
+// Changes by transactions in progress are not copied but replayed from the WAL on recovery.
+func (s *Server) takeSnapshot() *data.Snapshot {
+	s.copyMu.Lock()
+	defer s.copyMu.Unlock()
+
+	s.stateMu.Lock()
+	c := s.beginSnapshot()
+	s.stateMu.Unlock()
+	return c.Copy()
+}
+
+// takeSnapshotWithTransactions copies the databases and returns ChangeSets of transactions in progress at the same time.
+func (s *Server) takeSnapshotWithTransactions() (*data.Snapshot, []*pbs.ChangeSet) {
+	s.copyMu.Lock()
+	defer s.copyMu.Unlock()
+
+	s.stateMu.Lock()
+	var css []*pbs.ChangeSet
+	for _, num := range s.transactionHolder.Numbers() {
+		css = append(css, s.transactionHolder.Get(num).ChangeSets()...)
+	}
+	c := s.beginSnapshot()
+	s.stateMu.Unlock()
+	return c.Copy(), css
+}
+
+// beginSnapshot must be called with stateMu locked.
+func (s *Server) beginSnapshot() *data.SnapshotCopier {
 	lsn := s.wal.CurrentLsn()
+	replayLsn := lsn
+	for _, num := range s.transactionHolder.Numbers() {
+		if trx := s.transactionHolder.Get(num); trx.BeginLsn < replayLsn {
+			replayLsn = trx.BeginLsn
+		}
+	}
 	var dbs []*data.Database
 	for _, db := range s.databases {
 		dbs = append(dbs, db)
 	}
-	ss := data.TakeSnapshot(lsn, dbs)
+	return data.BeginSnapshot(lsn, replayLsn, dbs)
+}
 
-	err := ss.Save("log")
+func (s *Server) RecoverSnapshot() error {
+	ss, err := data.RecoverSnapshot(s.dir)
 	if err != nil {
 		return err
 	}
+
+	s.restoreSnapshot(ss)
 	return nil
 }
 
-func (s *Server) RecoverSnapshot() error {
-	ss, err := data.RecoverSnapshot("log")
-	if err != nil {
-		return err
-	}
+func (s *Server) restoreSnapshot(ss *data.Snapshot) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
 
 	dbs := ss.ToDatabases()
 
@@ -284,6 +745,24 @@ func (s *Server) RecoverSnapshot() error {
 		databases[db.Name] = db
 	}
 	s.databases = databases
+	// Transactions in progress refer rows which are replaced
+	s.transactionHolder.Clear()
 	s.wal.SetLsn(ss.Lsn())
+	s.replayLsn = ss.ReplayLsn()
+	data.AdvanceTransactionNumber(ss.LastTransactionNumber())
+}
+
+// restoreTransactions applies ChangeSets of transactions in progress when the snapshot is taken.
+// The LSN is not moved because they are applied before the snapshot.
+func (s *Server) restoreTransactions(css []*pbs.ChangeSet) error {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	for _, cs := range css {
+		_, err := s.applyChangeSet(cs, false)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -1,8 +1,20 @@
 package server
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/mrasu/ddb/server/data"
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/mrasu/ddb/server/structs"
+	"github.com/mrasu/ddb/thelper"
 	"github.com/xwb1989/sqlparser"
 
 	"github.com/mrasu/ddb/server/wal"
@@ -75,6 +87,328 @@ func TestServer_createDatabase_duplication(t *testing.T) {
 	}
 }
 
+func TestServer_Recover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('foo'), ('bar')")
+	exec(t, c, "BEGIN")
+	exec(t, c, "UPDATE hello.world SET message = 'baz' WHERE id = 1")
+	exec(t, c, "COMMIT")
+
+	recovered, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, recovered.Recover())
+	thelper.AssertInt64(t, "Invalid lsn", s.wal.CurrentLsn(), recovered.wal.CurrentLsn())
+	res := exec(t, recovered.StartNewConnection(), "SELECT message FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"message": "baz"}, {"message": "bar"}})
+}
+
+func TestServer_TakeSnapshot_RemovesWal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	s.wal.SetMaxSegmentSize(1)
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('foo')")
+	thelper.AssertNoError(t, s.TakeSnapshot())
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('bar')")
+
+	css, err := s.wal.Read()
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Segments covered by the snapshot are not removed", 2, len(css))
+
+	recovered, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, recovered.Recover())
+	res := exec(t, recovered.StartNewConnection(), "SELECT message FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"message": "foo"}, {"message": "bar"}})
+}
+
+func TestServer_Recover_FallsBackToOlderSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	s.wal.SetMaxSegmentSize(1)
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('foo')")
+	thelper.AssertNoError(t, s.TakeSnapshot())
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('bar')")
+	thelper.AssertNoError(t, s.TakeSnapshot())
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('baz')")
+	thelper.AssertNoError(t, s.Close())
+
+	lsns, err := data.ListSnapshotLsns(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Invalid snapshot count", 2, len(lsns))
+	err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("snapshot_%d.snap", lsns[1])), []byte("broken"), 0600)
+	thelper.AssertNoError(t, err)
+
+	recovered, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, recovered.Recover())
+	res := exec(t, recovered.StartNewConnection(), "SELECT message FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"message": "foo"}, {"message": "bar"}, {"message": "baz"}})
+}
+
+func TestServer_TakeSnapshot_WhileWriting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, s.SetWalSyncPolicy(wal.SyncPolicy{Mode: wal.SyncNever}))
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	writers, rows := 4, 50
+	for i := 0; i < writers; i++ {
+		exec(t, c, fmt.Sprintf("CREATE TABLE hello.world%d(id int AUTO_INCREMENT, message varchar(10))", i))
+	}
+
+	// Every writer has its own table because concurrent INSERTs to a table are not supported.
+	var wg sync.WaitGroup
+	errc := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			wc := s.StartNewConnection()
+			for j := 0; j < rows; j++ {
+				_, err := wc.Query(fmt.Sprintf("INSERT INTO hello.world%d(message) VALUES ('foo')", i))
+				if err != nil {
+					errc <- err
+					return
+				}
+			}
+		}(i)
+	}
+	donec := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(donec)
+	}()
+	snapshots := 0
+	for done := false; !done; {
+		select {
+		case <-donec:
+			done = true
+		default:
+			thelper.AssertNoError(t, s.TakeSnapshot())
+			snapshots++
+		}
+	}
+	close(errc)
+	for err := range errc {
+		t.Fatal(err)
+	}
+	thelper.AssertNoError(t, s.Close())
+	if snapshots == 0 {
+		t.Fatal("No snapshot is taken")
+	}
+
+	recovered, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, recovered.Recover())
+	rc := recovered.StartNewConnection()
+	for i := 0; i < writers; i++ {
+		res := exec(t, rc, fmt.Sprintf("SELECT message FROM hello.world%d", i))
+		thelper.AssertInt(t, "Invalid recovered rows", rows, len(res.Values))
+	}
+}
+
+func TestServer_StartSnapshotScheduler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, s.StartSnapshotScheduler(3))
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('foo')")
+
+	var lsns []int64
+	for i := 0; i < 100 && len(lsns) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		lsns, err = data.ListSnapshotLsns(dir)
+		thelper.AssertNoError(t, err)
+	}
+	thelper.AssertInt(t, "Snapshot is not taken", 1, len(lsns))
+	thelper.AssertInt64(t, "Invalid snapshot lsn", 3, lsns[0])
+	thelper.AssertNoError(t, s.Close())
+
+	err = s.StartSnapshotScheduler(0)
+	if err == nil {
+		t.Error("No error for non-positive interval")
+	}
+}
+
+func TestServer_ApplyChangeSet_Concurrent(t *testing.T) {
+	s := newDefaultServer(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cs := toPbCreateDatabase(&structs.CreateDBChangeSet{Name: fmt.Sprintf("db%d", i)})
+			thelper.AssertNoError(t, s.ApplyChangeSet(cs, true))
+		}(i)
+	}
+	wg.Wait()
+
+	thelper.AssertInt(t, "databases", 50, len(s.databases))
+}
+
+func TestServer_ApplyChangeSet_CommitConflictsOnReplica(t *testing.T) {
+	origin := newDefaultServer(t)
+	c1 := origin.StartNewConnection()
+	c2 := origin.StartNewConnection()
+	queries := []string{
+		"CREATE DATABASE hello",
+		"CREATE TABLE hello.world(id int, message varchar(10))",
+		"INSERT INTO hello.world(id, message) VALUES (1, 'foo')",
+	}
+	for _, q := range queries {
+		_, err := c1.Query(q)
+		thelper.AssertNoError(t, err)
+	}
+	for _, q := range []string{"BEGIN", "SELECT message FROM hello.world WHERE id = 1", "INSERT INTO hello.world(id, message) VALUES (2, 'bar')"} {
+		_, err := c1.Query(q)
+		thelper.AssertNoError(t, err)
+	}
+	// The row read by c1 is changed before COMMIT
+	_, err := c2.Query("UPDATE hello.world SET message = 'baz' WHERE id = 1")
+	thelper.AssertNoError(t, err)
+	commit := &pbs.ChangeSet{
+		Lsn:  origin.wal.CurrentLsn(),
+		Data: &pbs.ChangeSet_Commit{Commit: c1.currentTransaction.CreateCommitChangeSet()},
+	}
+
+	// The replica hasn't read the row but decides the same with the ChangeSet
+	replica := newDefaultServer(t)
+	css, err := origin.wal.ReadFrom(0)
+	thelper.AssertNoError(t, err)
+	for _, cs := range css {
+		thelper.AssertNoError(t, replica.ApplyChangeSet(cs, false))
+	}
+	for _, s := range []*Server{origin, replica} {
+		err = s.ApplyChangeSet(proto.Clone(commit).(*pbs.ChangeSet), false)
+		if _, ok := err.(*data.TransactionConflictError); !ok {
+			t.Errorf("COMMIT doesn't conflict: %v", err)
+		}
+	}
+
+	// COMMIT of unknown transactions fails without stopping the Server
+	unknown := &pbs.ChangeSet{
+		Lsn:  replica.wal.CurrentLsn(),
+		Data: &pbs.ChangeSet_Commit{Commit: &pbs.CommitChangeSet{Number: 12345}},
+	}
+	err = replica.ApplyChangeSet(unknown, false)
+	thelper.AssertBool(t, "Unknown transaction is committed", true, err != nil && strings.Contains(err.Error(), "not started"))
+}
+
+func TestServer_ApplyChangeSet_DroppedDatabase(t *testing.T) {
+	s := newDefaultServer(t)
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.world(id int, message varchar(10))")
+	exec(t, c, "INSERT INTO hello.world(id, message) VALUES (1, 'foo')")
+
+	// ChangeSets made before DROP DATABASE is applied
+	num := int64(data.ImmediateTransactionNumber)
+	css := []*pbs.ChangeSet{
+		{Data: &pbs.ChangeSet_CreateTable{CreateTable: &pbs.CreateTableChangeSet{DBName: "hello", Name: "other"}}},
+		{Data: &pbs.ChangeSet_InsertSets{InsertSets: &pbs.InsertChangeSets{
+			DBName: "hello", TableName: "world", TransactionNumber: num,
+			Rows: []*pbs.InsertRow{{Columns: map[string]string{"id": "2", "message": "bar"}}},
+		}}},
+		{Data: &pbs.ChangeSet_UpdateSets{UpdateSets: &pbs.UpdateChangeSets{
+			DBName: "hello", TableName: "world", TransactionNumber: num,
+			Rows: []*pbs.UpdateRow{{PrimaryKey: []string{"1"}, Columns: map[string]string{"message": "baz"}}},
+		}}},
+		{Data: &pbs.ChangeSet_DeleteSets{DeleteSets: &pbs.DeleteChangeSets{
+			DBName: "hello", TableName: "world", TransactionNumber: num,
+			Rows: []*pbs.DeleteRow{{PrimaryKey: []string{"1"}}},
+		}}},
+	}
+	exec(t, c, "DROP DATABASE hello")
+
+	for _, cs := range css {
+		err := s.ApplyChangeSet(cs, true)
+		thelper.AssertBool(t, "ChangeSet is applied to the dropped database", true, err != nil && strings.Contains(err.Error(), "doesn't exist"))
+	}
+}
+
+func TestServer_ApplyChangeSet_FailedIsNotWritten(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.world(id int, message varchar(10))")
+	exec(t, c, "INSERT INTO hello.world(id, message) VALUES (1, 'foo'), (2, 'bar')")
+
+	// UPDATE made before the row 2 is deleted
+	update := &pbs.ChangeSet{Data: &pbs.ChangeSet_UpdateSets{UpdateSets: &pbs.UpdateChangeSets{
+		DBName: "hello", TableName: "world", TransactionNumber: data.ImmediateTransactionNumber,
+		Rows: []*pbs.UpdateRow{
+			{PrimaryKey: []string{"1"}, Columns: map[string]string{"message": "baz"}},
+			{PrimaryKey: []string{"2"}, Columns: map[string]string{"message": "baz"}},
+		},
+	}}}
+	exec(t, c, "DELETE FROM hello.world WHERE id = 2")
+	lsn := s.wal.CurrentLsn()
+	err = s.ApplyChangeSet(update, true)
+	thelper.AssertBool(t, "UPDATE of the deleted row is applied", true, err != nil && strings.Contains(err.Error(), "no row found"))
+	thelper.AssertInt64(t, "Failed ChangeSet is written", lsn, s.wal.CurrentLsn())
+	res := exec(t, c, "SELECT message FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"message": "foo"}})
+
+	exec(t, c, "INSERT INTO hello.world(id, message) VALUES (3, 'qux')")
+	thelper.AssertNoError(t, s.Close())
+
+	recovered, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, recovered.Recover())
+	res = exec(t, recovered.StartNewConnection(), "SELECT message FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"message": "foo"}, {"message": "qux"}})
+}
+
 func newDefaultServer(t *testing.T) *Server {
 	s, err := NewTestServer(&wal.Memory{})
 	if err != nil {
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// snapshotScheduler takes a snapshot in background every time the WAL grows by `every` records.
+type snapshotScheduler struct {
+	every   int64
+	lastLsn int64
+
+	notifyc chan struct{}
+	stopc   chan struct{}
+	donec   chan struct{}
+}
+
+// StartSnapshotScheduler takes snapshots in background every `every` WAL records.
+func (s *Server) StartSnapshotScheduler(every int64) error {
+	if every < 1 {
+		return errors.Errorf("snapshot interval must be positive: %d", every)
+	}
+	s.StopSnapshotScheduler()
+
+	sc := &snapshotScheduler{
+		every:   every,
+		lastLsn: s.wal.CurrentLsn(),
+		notifyc: make(chan struct{}, 1),
+		stopc:   make(chan struct{}),
+		donec:   make(chan struct{}),
+	}
+	s.scheduler = sc
+	go s.runSnapshotScheduler(sc)
+	return nil
+}
+
+// StopSnapshotScheduler stops the scheduler and waits for the snapshot in progress.
+func (s *Server) StopSnapshotScheduler() {
+	if s.scheduler == nil {
+		return
+	}
+	close(s.scheduler.stopc)
+	<-s.scheduler.donec
+	s.scheduler = nil
+}
+
+// notifySnapshotScheduler wakes the scheduler up without waiting for it.
+func (s *Server) notifySnapshotScheduler() {
+	sc := s.scheduler
+	if sc == nil {
+		return
+	}
+	select {
+	case sc.notifyc <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Server) runSnapshotScheduler(sc *snapshotScheduler) {
+	defer close(sc.donec)
+	for {
+		select {
+		case <-sc.stopc:
+			return
+		case <-sc.notifyc:
+			if s.wal.CurrentLsn()-sc.lastLsn < sc.every {
+				continue
+			}
+			lsn := s.wal.CurrentLsn()
+			err := s.TakeSnapshot()
+			if err != nil {
+				log.Error().Stack().Err(err).Msg("Failed to take snapshot")
+				continue
+			}
+			sc.lastLsn = lsn
+		}
+	}
+}
@@ -11,10 +11,14 @@ import (
 type QueryType int
 
 const (
-	CreateDB    = 1
-	CreateTable = 100
-	Insert      = 200
-	Update      = 210
+	CreateDB      = 1
+	DropDB        = 2
+	CreateTable   = 100
+	DropTable     = 110
+	TruncateTable = 120
+	Insert        = 200
+	Update        = 210
+	Delete        = 220
 
 	Begin    = 900
 	Commit   = 910
@@ -23,10 +27,14 @@ const (
 )
 
 var QueryTypeMap = map[int32]reflect.Type{
-	CreateDB:    reflect.TypeOf((*CreateDBChangeSet)(nil)),
-	CreateTable: reflect.TypeOf((*CreateTableChangeSet)(nil)),
-	Insert:      reflect.TypeOf((*InsertChangeSet)(nil)),
-	Update:      reflect.TypeOf((*UpdateChangeSet)(nil)),
+	CreateDB:      reflect.TypeOf((*CreateDBChangeSet)(nil)),
+	DropDB:        reflect.TypeOf((*DropDBChangeSet)(nil)),
+	CreateTable:   reflect.TypeOf((*CreateTableChangeSet)(nil)),
+	DropTable:     reflect.TypeOf((*DropTableChangeSet)(nil)),
+	TruncateTable: reflect.TypeOf((*TruncateTableChangeSet)(nil)),
+	Insert:        reflect.TypeOf((*InsertChangeSet)(nil)),
+	Update:        reflect.TypeOf((*UpdateChangeSet)(nil)),
+	Delete:        reflect.TypeOf((*DeleteChangeSet)(nil)),
 
 	Begin:    reflect.TypeOf((*BeginChangeSet)(nil)),
 	Commit:   reflect.TypeOf((*CommitChangeSet)(nil)),
@@ -77,12 +85,30 @@ func (cs *CreateDBChangeSet) ToWalFormat(lsn int64) ([]byte, error) {
 	return cs.toWalFormatWith(lsn, cs, CreateDB)
 }
 
+func (cs *DropDBChangeSet) setLsn(lsn int64) { cs.Lsn = lsn }
+func (cs *DropDBChangeSet) GetLsn() int64    { return cs.Lsn }
+func (cs *DropDBChangeSet) ToWalFormat(lsn int64) ([]byte, error) {
+	return cs.toWalFormatWith(lsn, cs, DropDB)
+}
+
 func (cs *CreateTableChangeSet) setLsn(lsn int64) { cs.Lsn = lsn }
 func (cs *CreateTableChangeSet) GetLsn() int64    { return cs.Lsn }
 func (cs *CreateTableChangeSet) ToWalFormat(lsn int64) ([]byte, error) {
 	return cs.toWalFormatWith(lsn, cs, CreateTable)
 }
 
+func (cs *DropTableChangeSet) setLsn(lsn int64) { cs.Lsn = lsn }
+func (cs *DropTableChangeSet) GetLsn() int64    { return cs.Lsn }
+func (cs *DropTableChangeSet) ToWalFormat(lsn int64) ([]byte, error) {
+	return cs.toWalFormatWith(lsn, cs, DropTable)
+}
+
+func (cs *TruncateTableChangeSet) setLsn(lsn int64) { cs.Lsn = lsn }
+func (cs *TruncateTableChangeSet) GetLsn() int64    { return cs.Lsn }
+func (cs *TruncateTableChangeSet) ToWalFormat(lsn int64) ([]byte, error) {
+	return cs.toWalFormatWith(lsn, cs, TruncateTable)
+}
+
 func (cs *InsertChangeSet) setLsn(lsn int64) { cs.Lsn = lsn }
 func (cs *InsertChangeSet) GetLsn() int64    { return cs.Lsn }
 func (cs *InsertChangeSet) ToWalFormat(lsn int64) ([]byte, error) {
@@ -95,6 +121,12 @@ func (cs *UpdateChangeSet) ToWalFormat(lsn int64) ([]byte, error) {
 	return cs.toWalFormatWith(lsn, cs, Update)
 }
 
+func (cs *DeleteChangeSet) setLsn(lsn int64) { cs.Lsn = lsn }
+func (cs *DeleteChangeSet) GetLsn() int64    { return cs.Lsn }
+func (cs *DeleteChangeSet) ToWalFormat(lsn int64) ([]byte, error) {
+	return cs.toWalFormatWith(lsn, cs, Delete)
+}
+
 func (cs *BeginChangeSet) setLsn(lsn int64) { cs.Lsn = lsn }
 func (cs *BeginChangeSet) GetLsn() int64    { return cs.Lsn }
 func (cs *BeginChangeSet) ToWalFormat(lsn int64) ([]byte, error) {
@@ -125,12 +157,35 @@ type CreateDBChangeSet struct {
 	Name string `json:"name"`
 }
 
+type DropDBChangeSet struct {
+	*AWalFormat
+	Lsn  int64  `json:"lsn"`
+	Name string `json:"name"`
+}
+
 type CreateTableChangeSet struct {
 	*AWalFormat
-	Lsn      int64      `json:"lsn"`
-	DBName   string     `json:"db_name"`
-	Name     string     `json:"name"`
-	RowMetas []*RowMeta `json:"row_metas"`
+	Lsn      int64        `json:"lsn"`
+	DBName   string       `json:"db_name"`
+	Name     string       `json:"name"`
+	RowMetas []*RowMeta   `json:"row_metas"`
+	Indexes  []*IndexMeta `json:"indexes"`
+	// PrimaryKey is columns of PRIMARY KEY
+	PrimaryKey []string `json:"primary_key"`
+}
+
+type DropTableChangeSet struct {
+	*AWalFormat
+	Lsn    int64  `json:"lsn"`
+	DBName string `json:"db_name"`
+	Name   string `json:"name"`
+}
+
+type TruncateTableChangeSet struct {
+	*AWalFormat
+	Lsn    int64  `json:"lsn"`
+	DBName string `json:"db_name"`
+	Name   string `json:"name"`
 }
 
 type InsertChangeSet struct {
@@ -154,6 +209,16 @@ type UpdateChangeSet struct {
 	TransactionNumber int64 `json:"trx_num"`
 }
 
+type DeleteChangeSet struct {
+	*AWalFormat
+	Lsn          int64  `json:"lsn"`
+	DBName       string `json:"db_name"`
+	TableName    string `json:"table_name"`
+	PrimaryKeyId int64  `json:"pk_id"`
+
+	TransactionNumber int64 `json:"trx_num"`
+}
+
 type BeginChangeSet struct {
 	*AWalFormat
 	Lsn    int64 `json:"lsn"`
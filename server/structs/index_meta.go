@@ -0,0 +1,14 @@
+package structs
+
+import "strings"
+
+// IndexMeta is the definition of a secondary index.
+type IndexMeta struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+}
+
+// String returns the definition of the index, e.g. `INDEX idx (c1, c2)`.
+func (m *IndexMeta) String() string {
+	return "INDEX " + m.Name + " (" + strings.Join(m.Columns, ", ") + ")"
+}
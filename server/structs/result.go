@@ -7,6 +7,11 @@ import (
 type Result struct {
 	Columns []string
 	Values  [][]string
+
+	// AffectedRows is the number of rows changed by INSERT, UPDATE and DELETE.
+	AffectedRows int64
+	// InsertId is the first value given by AUTO_INCREMENT on INSERT.
+	InsertId int64
 }
 
 func NewResult(columns []string, values [][]string) *Result {
@@ -20,6 +25,14 @@ func NewEmptyResult() *Result {
 	return NewResult([]string{}, [][]string{})
 }
 
+// NewChangedResult returns the result of INSERT, UPDATE and DELETE.
+func NewChangedResult(affectedRows, insertId int64) *Result {
+	r := NewEmptyResult()
+	r.AffectedRows = affectedRows
+	r.InsertId = insertId
+	return r
+}
+
 func (r *Result) Inspect() {
 	fmt.Println("<==========Inspect")
 	for i, val := range r.Values {
@@ -1,6 +1,10 @@
 package structs
 
-import "github.com/mrasu/ddb/server/data/types"
+import (
+	"fmt"
+
+	"github.com/mrasu/ddb/server/data/types"
+)
 
 type RowMeta struct {
 	Name       string           `json:"name"`
@@ -8,3 +12,17 @@ type RowMeta struct {
 	Length     int64            `json:"length"`
 	AllowsNull bool             `json:"allows_null"`
 }
+
+// String returns the definition of the column, e.g. `id INT AUTO_INCREMENT`.
+func (m *RowMeta) String() string {
+	txt := m.Name
+	switch m.ColumnType {
+	case types.Int:
+		txt += " INT"
+	case types.AutoIncrementInt:
+		txt += " INT AUTO_INCREMENT"
+	case types.VarChar:
+		txt += fmt.Sprintf(" VARCHAR(%d)", m.Length)
+	}
+	return txt
+}
@@ -1,8 +1,14 @@
 package structs
 
 type SData struct {
-	Lsn       int64        `json:"lsn"`
-	Databases []*SDatabase `json:"databases"`
+	Lsn int64 `json:"lsn"`
+	// Timestamp is Unix time in nanoseconds when the snapshot is taken
+	Timestamp int64 `json:"timestamp"`
+	// ReplayLsn is the LSN of the first BEGIN of transactions in progress, or Lsn when there is none
+	ReplayLsn int64 `json:"replay_lsn"`
+	// LastTransactionNumber is the largest number given to transactions when the snapshot is taken
+	LastTransactionNumber int64        `json:"last_trx_num"`
+	Databases             []*SDatabase `json:"databases"`
 }
 
 type SDatabase struct {
@@ -15,13 +21,20 @@ type STable struct {
 	RowMetas []*RowMeta `json:"row_metas"`
 	Rows     []*SRow    `json:"rows"`
 	Indexes  []*SIndex  `json:"indexes"`
+	// PrimaryKey is columns of PRIMARY KEY, which is empty in snapshots taken by older versions
+	PrimaryKey []string `json:"primary_key"`
+	// AutoIncrements holds the last value given to each AUTO_INCREMENT column
+	AutoIncrements map[string]int64 `json:"auto_increments"`
 }
 
 type SRow struct {
 	Columns map[string]string `json:"columns"`
+	// Lsn is the LSN of the ChangeSet which committed the row, which is 0 in old snapshots
+	Lsn int64 `json:"lsn,omitempty"`
 }
 
+// SIndex is the definition of an index. Rows are indexed again when the snapshot is restored.
 type SIndex struct {
-	Name string           `json:"name"`
-	Tree map[string]int64 `json:"tree"`
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
 }
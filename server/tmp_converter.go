@@ -15,13 +15,44 @@ func toPbCreateDatabase(c *structs.CreateDBChangeSet) *pbs.ChangeSet {
 	}
 }
 
+func toPbDropDatabase(c *structs.DropDBChangeSet) *pbs.ChangeSet {
+	return &pbs.ChangeSet{
+		Lsn: c.Lsn,
+		Data: &pbs.ChangeSet_DropDB{DropDB: &pbs.DropDBChangeSet{
+			Name: c.Name,
+		}},
+	}
+}
+
 func toPbCreateTable(c *structs.CreateTableChangeSet) *pbs.ChangeSet {
 	return &pbs.ChangeSet{
 		Lsn: c.Lsn,
 		Data: &pbs.ChangeSet_CreateTable{CreateTable: &pbs.CreateTableChangeSet{
-			DBName:   c.DBName,
-			Name:     c.Name,
-			RowMetas: data.ToPbRowMetas(c.RowMetas),
+			DBName:     c.DBName,
+			Name:       c.Name,
+			RowMetas:   data.ToPbRowMetas(c.RowMetas),
+			Indexes:    data.ToPbIndexMetas(c.Indexes),
+			PrimaryKey: c.PrimaryKey,
+		}},
+	}
+}
+
+func toPbDropTable(c *structs.DropTableChangeSet) *pbs.ChangeSet {
+	return &pbs.ChangeSet{
+		Lsn: c.Lsn,
+		Data: &pbs.ChangeSet_DropTable{DropTable: &pbs.DropTableChangeSet{
+			DBName: c.DBName,
+			Name:   c.Name,
+		}},
+	}
+}
+
+func toPbTruncateTable(c *structs.TruncateTableChangeSet) *pbs.ChangeSet {
+	return &pbs.ChangeSet{
+		Lsn: c.Lsn,
+		Data: &pbs.ChangeSet_TruncateTable{TruncateTable: &pbs.TruncateTableChangeSet{
+			DBName: c.DBName,
+			Name:   c.Name,
 		}},
 	}
 }
@@ -55,6 +86,20 @@ func toPBUpdateChangeSets(c *structs.UpdateChangeSet) *pbs.ChangeSet {
 	}
 }
 
+func toPBDeleteChangeSets(c *structs.DeleteChangeSet) *pbs.ChangeSet {
+	return &pbs.ChangeSet{
+		Lsn: c.Lsn,
+		Data: &pbs.ChangeSet_DeleteSets{DeleteSets: &pbs.DeleteChangeSets{
+			DBName:            c.DBName,
+			TableName:         c.TableName,
+			TransactionNumber: c.TransactionNumber,
+			Rows: []*pbs.DeleteRow{{
+				PrimaryKeyId: c.PrimaryKeyId,
+			}},
+		}},
+	}
+}
+
 func toPBBeginChangeSets(c *structs.BeginChangeSet) *pbs.ChangeSet {
 	return &pbs.ChangeSet{
 		Lsn: c.Lsn,
@@ -90,67 +135,3 @@ func toPBAbortChangeSets(c *structs.AbortChangeSet) *pbs.ChangeSet {
 		}},
 	}
 }
-
-func toStructsChangeSets(pbcs *pbs.ChangeSet) []structs.ChangeSet {
-	switch c := pbcs.Data.(type) {
-	case *pbs.ChangeSet_CreateDB:
-		return []structs.ChangeSet{&structs.CreateDBChangeSet{
-			Lsn:  pbcs.Lsn,
-			Name: c.CreateDB.Name,
-		}}
-	case *pbs.ChangeSet_CreateTable:
-		return []structs.ChangeSet{&structs.CreateTableChangeSet{
-			Lsn:      pbcs.Lsn,
-			DBName:   c.CreateTable.DBName,
-			Name:     c.CreateTable.Name,
-			RowMetas: data.ToRowMetas(c.CreateTable.RowMetas),
-		}}
-	case *pbs.ChangeSet_InsertSets:
-		var rows []structs.ChangeSet
-		for _, r := range c.InsertSets.Rows {
-			rows = append(rows, &structs.InsertChangeSet{
-				Lsn:               pbcs.Lsn,
-				DBName:            c.InsertSets.DBName,
-				TableName:         c.InsertSets.TableName,
-				Columns:           r.Columns,
-				TransactionNumber: c.InsertSets.TransactionNumber,
-			})
-		}
-		return rows
-	case *pbs.ChangeSet_UpdateSets:
-		var rows []structs.ChangeSet
-		for _, r := range c.UpdateSets.Rows {
-			rows = append(rows, &structs.UpdateChangeSet{
-				Lsn:               pbcs.Lsn,
-				DBName:            c.UpdateSets.DBName,
-				TableName:         c.UpdateSets.TableName,
-				PrimaryKeyId:      r.PrimaryKeyId,
-				Columns:           r.Columns,
-				TransactionNumber: c.UpdateSets.TransactionNumber,
-			})
-		}
-		return rows
-	case *pbs.ChangeSet_Begin:
-		return []structs.ChangeSet{&structs.BeginChangeSet{
-			Lsn:    pbcs.Lsn,
-			Number: c.Begin.Number,
-		}}
-	case *pbs.ChangeSet_Commit:
-		return []structs.ChangeSet{&structs.CommitChangeSet{
-			Lsn:    pbcs.Lsn,
-			Number: c.Commit.Number,
-		}}
-	case *pbs.ChangeSet_Rollback:
-		return []structs.ChangeSet{&structs.RollbackChangeSet{
-			Lsn:    pbcs.Lsn,
-			Number: c.Rollback.Number,
-		}}
-	case *pbs.ChangeSet_Abort:
-		return []structs.ChangeSet{&structs.AbortChangeSet{
-			Lsn:    pbcs.Lsn,
-			Number: c.Abort.Number,
-		}}
-	default:
-		panic("Unsupported changeSet")
-	}
-}
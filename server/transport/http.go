@@ -0,0 +1,221 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	messagePath    = "/raft/message"
+	confChangePath = "/raft/confchange"
+
+	contentType = "application/x-protobuf"
+
+	// Messages over this are dropped while a peer is slow or down. Raft sends them again.
+	queueSize = 1024
+)
+
+// HTTPTransport sends raft messages as protobuf over HTTP.
+type HTTPTransport struct {
+	listenAddress string
+	listener      net.Listener
+	server        *http.Server
+	client        *http.Client
+	receiver      Receiver
+
+	mu     sync.Mutex
+	queues map[string]chan raftpb.Message
+	stopc  chan struct{}
+}
+
+func NewHTTPTransport(listenAddress string) *HTTPTransport {
+	return &HTTPTransport{
+		listenAddress: listenAddress,
+		client:        &http.Client{Timeout: 5 * time.Second},
+		queues:        map[string]chan raftpb.Message{},
+		stopc:         make(chan struct{}),
+	}
+}
+
+func (t *HTTPTransport) Address() string {
+	if t.listener != nil {
+		return t.listener.Addr().String()
+	}
+	return t.listenAddress
+}
+
+func (t *HTTPTransport) Start(r Receiver) error {
+	l, err := net.Listen("tcp", t.listenAddress)
+	if err != nil {
+		return err
+	}
+	t.listener = l
+	t.receiver = r
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(messagePath, t.handleMessage)
+	mux.HandleFunc(confChangePath, t.handleConfChange)
+	t.server = &http.Server{Handler: mux}
+	go func() {
+		err := t.server.Serve(l)
+		if err != nil && err != http.ErrServerClosed {
+			log.Error().Stack().Err(err).Msg("raft transport stopped")
+		}
+	}()
+	return nil
+}
+
+func (t *HTTPTransport) Stop() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	close(t.stopc)
+	if t.server == nil {
+		return nil
+	}
+	return t.server.Close()
+}
+
+func (t *HTTPTransport) Send(address string, msg raftpb.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q, ok := t.queues[address]
+	if !ok {
+		q = make(chan raftpb.Message, queueSize)
+		t.queues[address] = q
+		go t.runQueue(address, q)
+	}
+
+	select {
+	case q <- msg:
+	default:
+		log.Debug().Str("address", address).Msg("raft message is dropped because the queue is full")
+		t.reportSnapshot(msg, errors.New("queue is full"))
+	}
+}
+
+// runQueue keeps the order of messages to the same peer.
+func (t *HTTPTransport) runQueue(address string, q chan raftpb.Message) {
+	for {
+		select {
+		case <-t.stopc:
+			return
+		case msg, ok := <-q:
+			if !ok {
+				return
+			}
+			bs, err := msg.Marshal()
+			if err != nil {
+				log.Error().Stack().Err(err).Msg("failed to marshal raft message")
+				t.reportSnapshot(msg, err)
+				continue
+			}
+			_, err = t.post(address, messagePath, bs)
+			if err != nil {
+				log.Debug().Err(err).Str("address", address).Msg("failed to send raft message")
+			}
+			t.reportSnapshot(msg, err)
+		}
+	}
+}
+
+func (t *HTTPTransport) reportSnapshot(msg raftpb.Message, err error) {
+	if msg.Type == raftpb.MsgSnap {
+		t.receiver.ReportSnapshot(msg.To, err)
+	}
+}
+
+func (t *HTTPTransport) RemovePeer(address string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if q, ok := t.queues[address]; ok {
+		close(q)
+		delete(t.queues, address)
+	}
+}
+
+func (t *HTTPTransport) SendConfChange(address string, cc raftpb.ConfChange) (uint64, error) {
+	bs, err := cc.Marshal()
+	if err != nil {
+		return 0, err
+	}
+	res, err := t.post(address, confChangePath, bs)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(res), 10, 64)
+}
+
+func (t *HTTPTransport) post(address, path string, body []byte) ([]byte, error) {
+	url := fmt.Sprintf("http://%s%s", address, path)
+	res, err := t.client.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	bs, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("%s returns %d: %s", url, res.StatusCode, bs)
+	}
+	return bs, nil
+}
+
+func (t *HTTPTransport) handleMessage(w http.ResponseWriter, r *http.Request) {
+	bs, ok := readRequest(w, r)
+	if !ok {
+		return
+	}
+	var msg raftpb.Message
+	err := msg.Unmarshal(bs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t.receiver.ReceiveMessage(msg)
+}
+
+func (t *HTTPTransport) handleConfChange(w http.ResponseWriter, r *http.Request) {
+	bs, ok := readRequest(w, r)
+	if !ok {
+		return
+	}
+	var cc raftpb.ConfChange
+	err := cc.Unmarshal(bs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	go t.receiver.ReceiveConfChange(cc)
+	_, _ = w.Write([]byte(strconv.FormatUint(t.receiver.ID(), 10)))
+}
+
+func readRequest(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	bs, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return bs, true
+}
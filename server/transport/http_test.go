@@ -0,0 +1,191 @@
+package transport
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/mrasu/ddb/thelper"
+)
+
+type recordingReceiver struct {
+	id uint64
+
+	mu          sync.Mutex
+	messages    []raftpb.Message
+	confChanges []raftpb.ConfChange
+	// snapshotReports holds errors given to ReportSnapshot
+	snapshotReports []error
+}
+
+func (r *recordingReceiver) ID() uint64 {
+	return r.id
+}
+
+func (r *recordingReceiver) ReceiveMessage(msg raftpb.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, msg)
+}
+
+func (r *recordingReceiver) ReceiveConfChange(cc raftpb.ConfChange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.confChanges = append(r.confChanges, cc)
+}
+
+func (r *recordingReceiver) ReportSnapshot(to uint64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.snapshotReports = append(r.snapshotReports, err)
+}
+
+func (r *recordingReceiver) counts() (int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.messages), len(r.confChanges)
+}
+
+func TestHTTPTransport_Send(t *testing.T) {
+	t1, _ := startHTTPTransport(t, 1)
+	defer t1.Stop()
+	t2, r2 := startHTTPTransport(t, 2)
+	defer t2.Stop()
+
+	for i := 1; i <= 3; i++ {
+		t1.Send(t2.Address(), raftpb.Message{From: 1, To: 2, Index: uint64(i), Type: raftpb.MsgApp})
+	}
+	waitFor(t, func() bool {
+		count, _ := r2.counts()
+		return count == 3
+	})
+
+	r2.mu.Lock()
+	defer r2.mu.Unlock()
+	for i, msg := range r2.messages {
+		thelper.AssertInt(t, "Message order is not kept", i+1, int(msg.Index))
+		thelper.AssertInt(t, "Invalid message type", int(raftpb.MsgApp), int(msg.Type))
+	}
+}
+
+func TestHTTPTransport_Send_ReportSnapshot(t *testing.T) {
+	t1, r1 := startHTTPTransport(t, 1)
+	defer t1.Stop()
+	t2, _ := startHTTPTransport(t, 2)
+
+	t1.Send(t2.Address(), raftpb.Message{From: 1, To: 2, Type: raftpb.MsgSnap})
+	waitFor(t, func() bool {
+		r1.mu.Lock()
+		defer r1.mu.Unlock()
+		return len(r1.snapshotReports) == 1
+	})
+	thelper.AssertNoError(t, r1.snapshotReports[0])
+
+	// Nothing listens on the address of the stopped transport
+	address := t2.Address()
+	thelper.AssertNoError(t, t2.Stop())
+	t1.Send(address, raftpb.Message{From: 1, To: 2, Type: raftpb.MsgSnap})
+	waitFor(t, func() bool {
+		r1.mu.Lock()
+		defer r1.mu.Unlock()
+		return len(r1.snapshotReports) == 2
+	})
+	if r1.snapshotReports[1] == nil {
+		t.Error("Failure of the snapshot is not reported")
+	}
+}
+
+func TestMemoryTransport_Send_ReportSnapshot(t *testing.T) {
+	network := NewMemoryNetwork()
+	t1 := network.NewTransport("node1")
+	r1 := &recordingReceiver{id: 1}
+	thelper.AssertNoError(t, t1.Start(r1))
+	defer t1.Stop()
+
+	t1.Send("nothing", raftpb.Message{From: 1, To: 2, Type: raftpb.MsgSnap})
+	thelper.AssertInt(t, "Snapshot is not reported", 1, len(r1.snapshotReports))
+	if r1.snapshotReports[0] == nil {
+		t.Error("Failure of the snapshot is not reported")
+	}
+}
+
+func TestHTTPTransport_SendConfChange(t *testing.T) {
+	t1, _ := startHTTPTransport(t, 1)
+	defer t1.Stop()
+	t2, r2 := startHTTPTransport(t, 2)
+	defer t2.Stop()
+
+	cc := raftpb.ConfChange{NodeID: 1, Type: raftpb.ConfChangeAddNode, Context: []byte(t1.Address())}
+	id, err := t1.SendConfChange(t2.Address(), cc)
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt64(t, "Invalid id of the receiver", 2, int64(id))
+
+	waitFor(t, func() bool {
+		_, count := r2.counts()
+		return count == 1
+	})
+	r2.mu.Lock()
+	defer r2.mu.Unlock()
+	thelper.AssertString(t, "Invalid ConfChange context", t1.Address(), string(r2.confChanges[0].Context))
+}
+
+func TestHTTPTransport_SendConfChange_NoNode(t *testing.T) {
+	t1, _ := startHTTPTransport(t, 1)
+	address := t1.Address()
+	thelper.AssertNoError(t, t1.Stop())
+
+	t2, _ := startHTTPTransport(t, 2)
+	defer t2.Stop()
+	_, err := t2.SendConfChange(address, raftpb.ConfChange{})
+	if err == nil {
+		t.Error("No error for the stopped node")
+	}
+}
+
+func startHTTPTransport(t *testing.T, id uint64) (*HTTPTransport, *recordingReceiver) {
+	tr := NewHTTPTransport("127.0.0.1:0")
+	r := &recordingReceiver{id: id}
+	err := tr.Start(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr, r
+}
+
+func waitFor(t *testing.T, fn func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if fn() {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("timeout")
+}
+
+func TestHTTPTransport_RemovePeer(t *testing.T) {
+	t1, _ := startHTTPTransport(t, 1)
+	defer t1.Stop()
+	t2, r2 := startHTTPTransport(t, 2)
+	defer t2.Stop()
+
+	t1.Send(t2.Address(), raftpb.Message{From: 1, To: 2, Type: raftpb.MsgApp})
+	waitFor(t, func() bool {
+		count, _ := r2.counts()
+		return count == 1
+	})
+
+	t1.RemovePeer(t2.Address())
+	t1.mu.Lock()
+	_, ok := t1.queues[t2.Address()]
+	t1.mu.Unlock()
+	thelper.AssertBool(t, "Queue of the removed peer is kept", false, ok)
+
+	// The peer can be added again.
+	t1.Send(t2.Address(), raftpb.Message{From: 1, To: 2, Type: raftpb.MsgApp})
+	waitFor(t, func() bool {
+		count, _ := r2.counts()
+		return count == 2
+	})
+}
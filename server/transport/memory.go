@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"sync"
+
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/pkg/errors"
+)
+
+// MemoryNetwork connects MemoryTransports in the same process.
+type MemoryNetwork struct {
+	mu        sync.Mutex
+	receivers map[string]Receiver
+}
+
+var DefaultMemoryNetwork = NewMemoryNetwork()
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{receivers: map[string]Receiver{}}
+}
+
+func (n *MemoryNetwork) NewTransport(address string) *MemoryTransport {
+	return &MemoryTransport{network: n, address: address}
+}
+
+func (n *MemoryNetwork) register(address string, r Receiver) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.receivers[address]; ok {
+		return errors.Errorf("address is already used: %s", address)
+	}
+	n.receivers[address] = r
+	return nil
+}
+
+func (n *MemoryNetwork) unregister(address string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.receivers, address)
+}
+
+func (n *MemoryNetwork) receiver(address string) (Receiver, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	r, ok := n.receivers[address]
+	return r, ok
+}
+
+type MemoryTransport struct {
+	network  *MemoryNetwork
+	address  string
+	receiver Receiver
+}
+
+func (t *MemoryTransport) Address() string {
+	return t.address
+}
+
+func (t *MemoryTransport) Start(r Receiver) error {
+	t.receiver = r
+	return t.network.register(t.address, r)
+}
+
+func (t *MemoryTransport) Stop() error {
+	t.network.unregister(t.address)
+	return nil
+}
+
+func (t *MemoryTransport) Send(address string, msg raftpb.Message) {
+	r, ok := t.network.receiver(address)
+	if msg.Type == raftpb.MsgSnap {
+		var err error
+		if !ok {
+			err = errors.Errorf("no node at %s", address)
+		}
+		t.receiver.ReportSnapshot(msg.To, err)
+	}
+	if !ok {
+		return
+	}
+	// use `go` to emulate concurrency
+	go r.ReceiveMessage(msg)
+}
+
+func (t *MemoryTransport) SendConfChange(address string, cc raftpb.ConfChange) (uint64, error) {
+	r, ok := t.network.receiver(address)
+	if !ok {
+		return 0, errors.Errorf("no node at %s", address)
+	}
+	go r.ReceiveConfChange(cc)
+	return r.ID(), nil
+}
+
+func (t *MemoryTransport) RemovePeer(_ string) {
+	// nothing is kept per peer
+}
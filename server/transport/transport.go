@@ -0,0 +1,29 @@
+package transport
+
+import "github.com/coreos/etcd/raft/raftpb"
+
+// Receiver handles what peers send through a Transport.
+type Receiver interface {
+	ID() uint64
+	ReceiveMessage(msg raftpb.Message)
+	ReceiveConfChange(cc raftpb.ConfChange)
+	// ReportSnapshot tells whether the snapshot sent to the node is delivered. err is nil on success.
+	ReportSnapshot(to uint64, err error)
+}
+
+// Transport delivers raft messages between nodes.
+// Nodes are identified by addresses, which are carried in ConfChange.Context so that every member learns them from the raft log.
+type Transport interface {
+	// Address returns where peers reach this node. It is valid after Start.
+	Address() string
+	Start(r Receiver) error
+	Stop() error
+
+	// Send delivers msg asynchronously. Raft retries lost messages, so failures are not reported
+	// except for snapshots, whose results are given to ReportSnapshot of the Receiver.
+	Send(address string, msg raftpb.Message)
+	// SendConfChange asks the node at address to propose cc and returns the id of the node.
+	SendConfChange(address string, cc raftpb.ConfChange) (uint64, error)
+	// RemovePeer releases what is kept to send messages to address.
+	RemovePeer(address string)
+}
@@ -0,0 +1,61 @@
+package wal
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/pkg/errors"
+)
+
+// SegmentFile is a segment found by ListSegmentFiles.
+type SegmentFile struct {
+	Path     string
+	StartLsn int64
+	Size     int64
+}
+
+// CorruptRecordError tells where a broken record is in a segment.
+type CorruptRecordError struct {
+	Path   string
+	Offset int
+	Err    error
+}
+
+func (e *CorruptRecordError) Error() string {
+	return fmt.Sprintf("corrupt record in %s at offset %d: %s", e.Path, e.Offset, e.Err)
+}
+
+// ListSegmentFiles returns segments of the WAL under dir sorted by their starting LSN.
+// It is for tools reading the WAL without opening it by NewWal, which migrates and truncates segments.
+func ListSegmentFiles(dir, prefix string) ([]*SegmentFile, error) {
+	segments, err := listSegments(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+	w := &Wal{dir: dir, prefix: prefix}
+
+	var files []*SegmentFile
+	for _, sg := range segments {
+		files = append(files, &SegmentFile{Path: w.segmentPath(sg), StartLsn: sg.startLsn, Size: sg.size})
+	}
+	return files, nil
+}
+
+// ReadSegmentFile decodes records in the segment without changing it.
+// When a record is broken, records before it are returned with CorruptRecordError.
+func ReadSegmentFile(path string) ([]*pbs.ChangeSet, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to read WAL: %s", path))
+	}
+	if isLegacy(bs) {
+		return readLegacy(bs)
+	}
+
+	css, size, err := readRecords(bs)
+	if err != nil {
+		return css, &CorruptRecordError{Path: path, Offset: size, Err: err}
+	}
+	return css, nil
+}
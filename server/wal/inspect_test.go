@@ -0,0 +1,39 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/mrasu/ddb/thelper"
+)
+
+func TestReadSegmentFile_Corrupt(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	w, err := NewWal(dir, "wal_")
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, w.WriteSlice([]*pbs.ChangeSet{createDBChangeSet("hello1"), createDBChangeSet("hello2")}))
+	thelper.AssertNoError(t, w.Close())
+
+	files, err := ListSegmentFiles(dir, "wal_")
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Invalid segment count", 1, len(files))
+	bs, err := ioutil.ReadFile(files[0].Path)
+	thelper.AssertNoError(t, err)
+	bs[len(bs)-1] ^= 0xff
+	thelper.AssertNoError(t, ioutil.WriteFile(files[0].Path, bs, 0600))
+
+	css, err := ReadSegmentFile(files[0].Path)
+	cErr, ok := err.(*CorruptRecordError)
+	thelper.AssertBool(t, "Corruption is not reported", true, ok)
+	thelper.AssertInt(t, "Invalid record count", 1, len(css))
+	thelper.AssertBool(t, "Invalid offset", true, 0 < cErr.Offset && cErr.Offset < len(bs))
+
+	// The file is left as it is
+	after, err := ioutil.ReadFile(files[0].Path)
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "File is changed", len(bs), len(after))
+}
@@ -0,0 +1,116 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/mrasu/ddb/server/structs"
+	"github.com/pkg/errors"
+)
+
+// isLegacy tells whether bs is written in the legacy format, `<querytype>-<json>\n`.
+// Binary records start with a smaller byte than ASCII digits.
+func isLegacy(bs []byte) bool {
+	return len(bs) > 0 && '0' <= bs[0] && bs[0] <= '9'
+}
+
+// readLegacy reads the legacy format. Every record holds one row, so the ChangeSets have one row at most.
+func readLegacy(bs []byte) ([]*pbs.ChangeSet, error) {
+	css := []*pbs.ChangeSet{}
+
+	lines := bytes.Split(bs, structs.NewLineBytes)
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		bb := bytes.SplitN(line, structs.SeparatorBytes, 2)
+		if len(bb) != 2 {
+			return nil, errors.Errorf("Invalid WAL: %s", line)
+		}
+		num, err := strconv.Atoi(string(bb[0]))
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Invalid WAL: %s", line))
+		}
+
+		cs, err := structs.ToChangeSet(int32(num))
+		if err != nil {
+			return nil, errors.Errorf("Invalid WAL number: %s", line)
+		}
+
+		err = json.Unmarshal(bb[1], cs)
+		if err != nil {
+			return nil, err
+		}
+		pbcs, err := legacyToPb(cs)
+		if err != nil {
+			return nil, err
+		}
+		css = append(css, pbcs)
+	}
+
+	return css, nil
+}
+
+func legacyToPb(cs structs.ChangeSet) (*pbs.ChangeSet, error) {
+	pbcs := &pbs.ChangeSet{Lsn: cs.GetLsn()}
+	switch c := cs.(type) {
+	case *structs.CreateDBChangeSet:
+		pbcs.Data = &pbs.ChangeSet_CreateDB{CreateDB: &pbs.CreateDBChangeSet{Name: c.Name}}
+	case *structs.DropDBChangeSet:
+		pbcs.Data = &pbs.ChangeSet_DropDB{DropDB: &pbs.DropDBChangeSet{Name: c.Name}}
+	case *structs.CreateTableChangeSet:
+		var metas []*pbs.RowMeta
+		for _, m := range c.RowMetas {
+			metas = append(metas, &pbs.RowMeta{
+				Name:       m.Name,
+				ColumnType: pbs.ColumnType(m.ColumnType),
+				Length:     m.Length,
+				AllowsNull: m.AllowsNull,
+			})
+		}
+		pbcs.Data = &pbs.ChangeSet_CreateTable{CreateTable: &pbs.CreateTableChangeSet{
+			DBName:   c.DBName,
+			Name:     c.Name,
+			RowMetas: metas,
+		}}
+	case *structs.DropTableChangeSet:
+		pbcs.Data = &pbs.ChangeSet_DropTable{DropTable: &pbs.DropTableChangeSet{DBName: c.DBName, Name: c.Name}}
+	case *structs.TruncateTableChangeSet:
+		pbcs.Data = &pbs.ChangeSet_TruncateTable{TruncateTable: &pbs.TruncateTableChangeSet{DBName: c.DBName, Name: c.Name}}
+	case *structs.InsertChangeSet:
+		pbcs.Data = &pbs.ChangeSet_InsertSets{InsertSets: &pbs.InsertChangeSets{
+			DBName:            c.DBName,
+			TableName:         c.TableName,
+			TransactionNumber: c.TransactionNumber,
+			Rows:              []*pbs.InsertRow{{Columns: c.Columns}},
+		}}
+	case *structs.UpdateChangeSet:
+		pbcs.Data = &pbs.ChangeSet_UpdateSets{UpdateSets: &pbs.UpdateChangeSets{
+			DBName:            c.DBName,
+			TableName:         c.TableName,
+			TransactionNumber: c.TransactionNumber,
+			Rows:              []*pbs.UpdateRow{{PrimaryKeyId: c.PrimaryKeyId, Columns: c.Columns}},
+		}}
+	case *structs.DeleteChangeSet:
+		pbcs.Data = &pbs.ChangeSet_DeleteSets{DeleteSets: &pbs.DeleteChangeSets{
+			DBName:            c.DBName,
+			TableName:         c.TableName,
+			TransactionNumber: c.TransactionNumber,
+			Rows:              []*pbs.DeleteRow{{PrimaryKeyId: c.PrimaryKeyId}},
+		}}
+	case *structs.BeginChangeSet:
+		pbcs.Data = &pbs.ChangeSet_Begin{Begin: &pbs.BeginChangeSet{Number: c.Number}}
+	case *structs.CommitChangeSet:
+		pbcs.Data = &pbs.ChangeSet_Commit{Commit: &pbs.CommitChangeSet{Number: c.Number}}
+	case *structs.RollbackChangeSet:
+		pbcs.Data = &pbs.ChangeSet_Rollback{Rollback: &pbs.RollbackChangeSet{Number: c.Number}}
+	case *structs.AbortChangeSet:
+		pbcs.Data = &pbs.ChangeSet_Abort{Abort: &pbs.AbortChangeSet{Number: c.Number}}
+	default:
+		return nil, errors.Errorf("Not supported ChangeSet: %v", c)
+	}
+	return pbcs, nil
+}
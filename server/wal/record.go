@@ -0,0 +1,75 @@
+package wal
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/pkg/errors"
+)
+
+// A record is `[length(4)][crc32c of payload(4)][payload]` where payload is a pbs.ChangeSet.
+const recordHeaderSize = 8
+
+// maxRecordSize keeps the first byte of records smaller than ASCII digits, which start records of the legacy format.
+const maxRecordSize = 1 << 28
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func appendRecord(bs []byte, cs *pbs.ChangeSet) ([]byte, error) {
+	payload, err := proto.Marshal(cs)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > maxRecordSize {
+		return nil, errors.Errorf("too large ChangeSet: %d bytes", len(payload))
+	}
+
+	header := make([]byte, recordHeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.Checksum(payload, crcTable))
+	bs = append(bs, header...)
+	return append(bs, payload...), nil
+}
+
+// readRecords decodes records in bs and returns the size of valid records.
+// Decoding stops at the first broken record, which is a torn write when it is at the tail.
+func readRecords(bs []byte) ([]*pbs.ChangeSet, int, error) {
+	css := []*pbs.ChangeSet{}
+	pos := 0
+	for pos < len(bs) {
+		cs, size, err := readRecord(bs[pos:])
+		if err != nil {
+			return css, pos, err
+		}
+		css = append(css, cs)
+		pos += size
+	}
+	return css, pos, nil
+}
+
+func readRecord(bs []byte) (*pbs.ChangeSet, int, error) {
+	if len(bs) < recordHeaderSize {
+		return nil, 0, errors.New("incomplete record header")
+	}
+	length := binary.BigEndian.Uint32(bs[0:4])
+	if length > maxRecordSize {
+		return nil, 0, errors.Errorf("too large record: %d", length)
+	}
+	size := recordHeaderSize + int(length)
+	if len(bs) < size {
+		return nil, 0, errors.New("incomplete record")
+	}
+	payload := bs[recordHeaderSize:size]
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(bs[4:8]) {
+		return nil, 0, errors.New("checksum mismatch")
+	}
+
+	cs := &pbs.ChangeSet{}
+	err := proto.Unmarshal(payload, cs)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "invalid ChangeSet")
+	}
+	return cs, size, nil
+}
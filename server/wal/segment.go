@@ -0,0 +1,221 @@
+package wal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const segmentSuffix = ".log"
+
+const discardedSuffix = ".discarded"
+
+// A segment is a file of the WAL named by the LSN of its first record, e.g. `wal_1024.log`.
+type segment struct {
+	startLsn int64
+	size     int64
+}
+
+// listSegments returns segments under dir sorted by their starting LSN.
+func listSegments(dir, prefix string) ([]*segment, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []*segment
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		lsn, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, prefix), segmentSuffix), 10, 64)
+		if err != nil {
+			// other logs sharing the prefix, e.g. `wal_tmp_0.log` for `wal_`
+			continue
+		}
+		segments = append(segments, &segment{startLsn: lsn, size: f.Size()})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].startLsn < segments[j].startLsn })
+	return segments, nil
+}
+
+func (w *Wal) segmentPath(sg *segment) string {
+	return filepath.Join(w.dir, w.prefix+strconv.FormatInt(sg.startLsn, 10)+segmentSuffix)
+}
+
+func (w *Wal) currentSegment() *segment {
+	if len(w.segments) == 0 {
+		return nil
+	}
+	return w.segments[len(w.segments)-1]
+}
+
+// segmentsFrom returns segments which may hold records at lsn or later.
+func (w *Wal) segmentsFrom(lsn int64) []*segment {
+	for i := len(w.segments) - 1; i >= 0; i-- {
+		if w.segments[i].startLsn <= lsn {
+			return w.segments[i:]
+		}
+	}
+	return w.segments
+}
+
+// RemoveBefore removes segments whose records are all before lsn, e.g. covered by a snapshot.
+// Segments are moved to the archive directory instead when it is set.
+// The current segment is kept to append records.
+func (w *Wal) RemoveBefore(lsn int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.testReadWriter != nil {
+		return nil
+	}
+
+	removed := 0
+	for i := 0; i < len(w.segments)-1; i++ {
+		if w.segments[i+1].startLsn > lsn {
+			break
+		}
+
+		sg := w.segments[i]
+		var err error
+		if w.archiveDir == "" {
+			err = os.Remove(w.segmentPath(sg))
+		} else {
+			err = os.Rename(w.segmentPath(sg), filepath.Join(w.archiveDir, filepath.Base(w.segmentPath(sg))))
+		}
+		if err != nil && !os.IsNotExist(err) {
+			w.segments = w.segments[removed:]
+			return errors.Wrap(err, "failed to remove WAL segment")
+		}
+		removed++
+	}
+	w.segments = w.segments[removed:]
+	return nil
+}
+
+// OldestLsn returns the first LSN which the WAL can still read.
+func (w *Wal) OldestLsn() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.segments) == 0 {
+		return w.lsn
+	}
+	return w.segments[0].startLsn
+}
+
+// TruncateFrom discards records at lsn and later, and the next record is numbered lsn.
+// Discarded segments are moved to the archive directory when it is set.
+func (w *Wal) TruncateFrom(lsn int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.truncateFrom(lsn, w.archiveDir)
+}
+
+// ArchiveFrom discards records at lsn and later like TruncateFrom, moving segments which have them to dir.
+func (w *Wal) ArchiveFrom(lsn int64, dir string) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.truncateFrom(lsn, dir)
+}
+
+func (w *Wal) truncateFrom(lsn int64, archiveDir string) error {
+	if w.testReadWriter != nil {
+		return errors.New("cannot truncate the test WAL")
+	}
+	if len(w.segments) > 0 && lsn < w.segments[0].startLsn {
+		return errors.Errorf("WAL before LSN %d is already removed", w.segments[0].startLsn)
+	}
+	err := w.closeFile()
+	if err != nil {
+		return err
+	}
+
+	kept := 0
+	for i, sg := range w.segments {
+		if sg.startLsn >= lsn && i > 0 {
+			err = w.discardSegment(sg, nil, archiveDir)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		kept++
+		if i+1 < len(w.segments) && w.segments[i+1].startLsn <= lsn {
+			continue
+		}
+
+		bs, err := w.readFile(sg)
+		if err != nil {
+			return err
+		}
+		size, err := recordsSizeBefore(bs, lsn)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("broken WAL segment: %s", w.segmentPath(sg)))
+		}
+		if size < len(bs) {
+			err = w.discardSegment(sg, bs[:size], archiveDir)
+			if err != nil {
+				return err
+			}
+			sg.size = int64(size)
+		}
+	}
+	w.segments = w.segments[:kept]
+	w.lsn = lsn
+	return nil
+}
+
+// discardSegment moves sg to archiveDir, or removes it when archiveDir is empty, and writes bs as its new content when bs is not nil.
+// Archived segments are named `<segment>.<unix nano>.discarded` not to be confused with segments removed by RemoveBefore.
+func (w *Wal) discardSegment(sg *segment, bs []byte, archiveDir string) error {
+	path := w.segmentPath(sg)
+	var err error
+	if archiveDir == "" {
+		if bs == nil {
+			err = os.Remove(path)
+		}
+	} else {
+		name := fmt.Sprintf("%s.%d%s", filepath.Base(path), time.Now().UnixNano(), discardedSuffix)
+		err = os.Rename(path, filepath.Join(archiveDir, name))
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to discard WAL segment")
+	}
+	if bs == nil {
+		return nil
+	}
+	return writeFileAtomically(path, bs)
+}
+
+// recordsSizeBefore returns the size of records before lsn in bs.
+func recordsSizeBefore(bs []byte, lsn int64) (int, error) {
+	pos := 0
+	for pos < len(bs) {
+		cs, size, err := readRecord(bs[pos:])
+		if err != nil {
+			return 0, err
+		}
+		if cs.Lsn >= lsn {
+			break
+		}
+		pos += size
+	}
+	return pos, nil
+}
@@ -0,0 +1,134 @@
+package wal
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+type SyncMode int
+
+const (
+	// SyncAlways flushes records to the disk before WriteSlice returns.
+	SyncAlways SyncMode = iota
+	// SyncInterval flushes records periodically. Records written in the last interval can be lost by a crash.
+	SyncInterval
+	// SyncNever leaves flushing to the OS.
+	SyncNever
+)
+
+// SyncPolicy decides when written records are flushed to the disk.
+type SyncPolicy struct {
+	Mode SyncMode
+	// Interval is used by SyncInterval
+	Interval time.Duration
+}
+
+// ParseSyncPolicy parses "always", "never" or an interval like "100ms".
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "always":
+		return SyncPolicy{Mode: SyncAlways}, nil
+	case "never":
+		return SyncPolicy{Mode: SyncNever}, nil
+	default:
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return SyncPolicy{}, errors.Errorf("invalid sync policy: %s", s)
+		}
+		return SyncPolicy{Mode: SyncInterval, Interval: d}, nil
+	}
+}
+
+// SetSyncPolicy changes the policy. The default is SyncAlways.
+func (w *Wal) SetSyncPolicy(p SyncPolicy) error {
+	if p.Mode == SyncInterval && p.Interval <= 0 {
+		return errors.Errorf("invalid sync interval: %v", p.Interval)
+	}
+
+	w.stopSyncLoop()
+	w.syncPolicy = p
+	if p.Mode == SyncInterval {
+		w.syncStopc = make(chan struct{})
+		w.syncDonec = make(chan struct{})
+		go w.syncLoop(p.Interval, w.syncStopc, w.syncDonec)
+	}
+	return nil
+}
+
+func (w *Wal) stopSyncLoop() {
+	if w.syncStopc == nil {
+		return
+	}
+	close(w.syncStopc)
+	<-w.syncDonec
+	w.syncStopc = nil
+}
+
+func (w *Wal) syncLoop(interval time.Duration, stopc, donec chan struct{}) {
+	defer close(donec)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stopc:
+			return
+		case <-t.C:
+			w.mu.Lock()
+			seq := w.writtenSeq
+			w.mu.Unlock()
+			err := w.waitSync(seq)
+			if err != nil {
+				log.Error().Stack().Err(err).Msg("Failed to sync WAL")
+			}
+		}
+	}
+}
+
+// waitSync returns after records up to seq are flushed.
+// Only one goroutine calls fsync at a time and it covers every record written before that,
+// so concurrent writers share one fsync. (group commit)
+func (w *Wal) waitSync(seq uint64) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	for w.syncedSeq < seq {
+		if w.syncing {
+			w.syncCond.Wait()
+			continue
+		}
+
+		w.mu.Lock()
+		file := w.file
+		target := w.writtenSeq
+		w.mu.Unlock()
+
+		w.syncing = true
+		w.syncMu.Unlock()
+		var err error
+		if file != nil {
+			err = file.Sync()
+			if isClosedError(err) {
+				// The segment is synced when it is closed by rotation.
+				err = nil
+			}
+		}
+		w.syncMu.Lock()
+		w.syncing = false
+		w.syncCond.Broadcast()
+		if err != nil {
+			return errors.Wrap(err, "failed to sync WAL")
+		}
+		if target > w.syncedSeq {
+			w.syncedSeq = target
+		}
+	}
+	return nil
+}
+
+func isClosedError(err error) bool {
+	pe, ok := err.(*os.PathError)
+	return ok && pe.Err == os.ErrClosed
+}
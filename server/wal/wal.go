@@ -1,31 +1,52 @@
 package wal
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"strconv"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
-	"github.com/mrasu/ddb/server/structs"
+	"github.com/mrasu/ddb/server/pbs"
 
 	"github.com/pkg/errors"
 )
 
+// DefaultMaxSegmentSize is the size to start a new segment.
+const DefaultMaxSegmentSize = 64 * 1024 * 1024
+
 type Wal struct {
-	dir        string
-	prefix     string
-	fileNumber int
-	lsn        int64
+	dir    string
+	prefix string
+
+	// mu guards lsn, segments, file and writtenSeq
+	mu             sync.Mutex
+	lsn            int64
+	segments       []*segment
+	maxSegmentSize int64
+	archiveDir     string
+	// file is the current segment opened to append
+	file *os.File
+	// writtenSeq counts writes to know which of them are flushed
+	writtenSeq uint64
+
+	// syncMu guards syncing and syncedSeq
+	syncMu     sync.Mutex
+	syncCond   *sync.Cond
+	syncing    bool
+	syncedSeq  uint64
+	syncPolicy SyncPolicy
+	syncStopc  chan struct{}
+	syncDonec  chan struct{}
 
 	// TODO: DI
 	testReadWriter io.ReadWriteCloser
 }
 
+// NewWal opens the WAL under dir. A log in the legacy format is converted to the binary format.
 func NewWal(dir, prefix string) (*Wal, error) {
 	if _, err := os.Stat(dir); err != nil {
 		if os.IsNotExist(err) {
@@ -34,149 +55,351 @@ func NewWal(dir, prefix string) (*Wal, error) {
 		return nil, errors.Wrap(err, fmt.Sprintf("Invalid directory: %s", dir))
 	}
 
-	return &Wal{
-		dir:        dir,
-		prefix:     prefix,
-		fileNumber: 0,
-		lsn:        0,
-	}, nil
+	segments, err := listSegments(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+	w := &Wal{
+		dir:            dir,
+		prefix:         prefix,
+		lsn:            0,
+		segments:       segments,
+		maxSegmentSize: DefaultMaxSegmentSize,
+	}
+	w.syncCond = sync.NewCond(&w.syncMu)
+	for _, sg := range segments {
+		err = w.migrateLegacy(sg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
 }
 
 func NewTestWal(writer io.ReadWriteCloser) *Wal {
-	return &Wal{
-		fileNumber:     0,
+	w := &Wal{
 		lsn:            0,
 		testReadWriter: writer,
 	}
+	w.syncCond = sync.NewCond(&w.syncMu)
+	return w
+}
+
+// SetMaxSegmentSize changes the size to start a new segment.
+func (w *Wal) SetMaxSegmentSize(size int64) {
+	w.maxSegmentSize = size
+}
+
+// SetArchiveDir makes RemoveBefore move segments to dir instead of deleting them.
+func (w *Wal) SetArchiveDir(dir string) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	w.archiveDir = dir
+	return nil
 }
 
 func (w *Wal) CurrentLsn() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	return w.lsn
 }
 
 func (w *Wal) Exists() (bool, error) {
-	data, err := ioutil.ReadFile(w.fileName())
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return false, err
-		}
-		return false, nil
-	} else {
-		if len(data) <= 0 {
-			return false, nil
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, sg := range w.segments {
+		if sg.size > 0 {
+			return true, nil
 		}
-		return true, nil
 	}
+	return false, nil
 }
 
 func (w *Wal) Remove() {
-	_ = os.Remove(w.fileName())
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closeFile()
+	for _, sg := range w.segments {
+		_ = os.Remove(w.segmentPath(sg))
+	}
+	w.segments = nil
 }
 
-func (w *Wal) Write(cs structs.ChangeSet) error {
-	bs, err := cs.ToWalFormat(w.lsn)
+// Close flushes records and closes the current segment.
+func (w *Wal) Close() error {
+	w.stopSyncLoop()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Sync()
+	if err != nil {
+		return errors.Wrap(err, "failed to sync WAL")
+	}
+	return w.closeFile()
+}
+
+func (w *Wal) closeFile() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// Write numbers cs with the next LSN, stamps the current time and appends it.
+func (w *Wal) Write(cs *pbs.ChangeSet) error {
+	return w.WriteSlice([]*pbs.ChangeSet{cs})
+}
+
+// WriteSlice appends css to the same segment.
+// It returns after the records are flushed when the sync policy is SyncAlways.
+func (w *Wal) WriteSlice(css []*pbs.ChangeSet) error {
+	seq, err := w.appendSlice(css)
 	if err != nil {
 		return err
 	}
-	w.lsn += 1
+	return w.WaitSync(seq)
+}
 
-	return w.writeFile(bs)
+// Append numbers cs like Write but returns without waiting for the flush.
+// The returned sequence is given to WaitSync, so that callers can release their locks while the record is flushed.
+func (w *Wal) Append(cs *pbs.ChangeSet) (uint64, error) {
+	return w.appendSlice([]*pbs.ChangeSet{cs})
 }
 
-func (w *Wal) WriteSlice(css []structs.ChangeSet) error {
+func (w *Wal) appendSlice(css []*pbs.ChangeSet) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	var bs []byte
+	lsn := w.lsn
+	now := time.Now().UnixNano()
 	for _, cs := range css {
-		b, err := cs.ToWalFormat(w.lsn)
+		cs.Lsn = lsn
+		cs.Timestamp = now
+		var err error
+		bs, err = appendRecord(bs, cs)
 		if err != nil {
-			return err
+			return 0, err
 		}
-		bs = append(bs, b...)
-		w.lsn += 1
+		lsn += 1
 	}
 
-	return w.writeFile(bs)
+	err := w.writeFile(bs)
+	if err != nil {
+		return 0, err
+	}
+	w.lsn = lsn
+	w.writtenSeq++
+	return w.writtenSeq, nil
+}
+
+// WaitSync returns after records appended up to seq are flushed when the sync policy is SyncAlways.
+func (w *Wal) WaitSync(seq uint64) error {
+	if w.testReadWriter == nil && w.syncPolicy.Mode == SyncAlways {
+		return w.waitSync(seq)
+	}
+	return nil
 }
 
+// writeFile must be called with mu held.
 func (w *Wal) writeFile(bs []byte) error {
-	log.Debug().Str("wal", string(bs)).Msg("")
+	log.Debug().Int("size", len(bs)).Msg("write wal")
 
-	var writer io.Writer
 	if w.testReadWriter != nil {
-		writer = w.testReadWriter
-	} else {
-		file, err := os.OpenFile(w.fileName(), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+		_, err := w.testReadWriter.Write(bs)
+		if err != nil {
+			return errors.Wrap(err, "failed to write file")
+		}
+		return nil
+	}
+
+	sg := w.currentSegment()
+	if sg == nil || (sg.size > 0 && sg.size+int64(len(bs)) > w.maxSegmentSize) {
+		err := w.rotate()
+		if err != nil {
+			return err
+		}
+		sg = w.currentSegment()
+	}
+	if w.file == nil {
+		file, err := os.OpenFile(w.segmentPath(sg), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
 		if err != nil {
 			return errors.Wrap(err, "failed to open file")
 		}
-		writer = file
+		w.file = file
 	}
 
-	_, err := writer.Write(bs)
+	_, err := w.file.Write(bs)
 	if err != nil {
 		return errors.Wrap(err, "failed to write file")
 	}
+	sg.size += int64(len(bs))
+	return nil
+}
+
+// rotate closes the current segment after flushing it and starts a new segment from the next LSN.
+func (w *Wal) rotate() error {
+	if w.file != nil {
+		if w.syncPolicy.Mode != SyncNever {
+			err := w.file.Sync()
+			if err != nil {
+				return errors.Wrap(err, "failed to sync WAL")
+			}
+		}
+		err := w.closeFile()
+		if err != nil {
+			return err
+		}
+	}
+	w.segments = append(w.segments, &segment{startLsn: w.lsn})
 	return nil
 }
 
 func (w *Wal) ProceedLsn(p int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	w.lsn += p
 }
 
 func (w *Wal) SetLsn(l int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	w.lsn = l
 }
 
-func (w *Wal) fileName() string {
-	return w.dir + "/" + w.prefix + strconv.Itoa(w.fileNumber) + ".log"
+// Read returns all ChangeSets in the WAL.
+func (w *Wal) Read() ([]*pbs.ChangeSet, error) {
+	return w.ReadFrom(0)
 }
 
-func (w *Wal) Read() ([]structs.ChangeSet, error) {
-	var bs []byte
-	if w.testReadWriter == nil {
-		if _, err := os.Stat(w.fileName()); err != nil {
-			if os.IsNotExist(err) {
-				return []structs.ChangeSet{}, nil
-			}
-			return nil, errors.Wrap(err, fmt.Sprintf("Invalid directory: %s", w.fileName()))
-		}
-
-		b, err := ioutil.ReadFile(w.fileName())
+// ReadFrom returns ChangeSets in segments which may hold lsn or later LSNs.
+// Records before lsn can be included.
+// Records after a broken one in the last segment are discarded because they are a torn write by a crash.
+func (w *Wal) ReadFrom(lsn int64) ([]*pbs.ChangeSet, error) {
+	if w.testReadWriter != nil {
+		bs, err := ioutil.ReadAll(w.testReadWriter)
 		if err != nil {
 			return nil, err
 		}
-		bs = b
-	} else {
-		b, err := ioutil.ReadAll(w.testReadWriter)
+		if isLegacy(bs) {
+			return readLegacy(bs)
+		}
+		css, _, err := readRecords(bs)
+		if err != nil {
+			log.Warn().Err(err).Msg("WAL has a torn tail")
+		}
+		return css, nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	css := []*pbs.ChangeSet{}
+	segments := w.segmentsFrom(lsn)
+	for i, sg := range segments {
+		scss, err := w.readSegment(sg, i == len(segments)-1)
 		if err != nil {
 			return nil, err
 		}
-		bs = b
+		css = append(css, scss...)
 	}
+	return css, nil
+}
 
-	var css []structs.ChangeSet
+func (w *Wal) readSegment(sg *segment, last bool) ([]*pbs.ChangeSet, error) {
+	bs, err := w.readFile(sg)
+	if err != nil {
+		return nil, err
+	}
+	if isLegacy(bs) {
+		return readLegacy(bs)
+	}
 
-	lines := bytes.Split(bs, structs.NewLineBytes)
-	for _, line := range lines {
-		if len(line) == 0 {
-			continue
+	css, size, err := readRecords(bs)
+	if err != nil {
+		if !last {
+			return nil, errors.Wrap(err, fmt.Sprintf("broken WAL segment: %s", w.segmentPath(sg)))
 		}
-		bb := bytes.SplitN(line, structs.SeparatorBytes, 2)
-		num, err := strconv.Atoi(string(bb[0]))
+		log.Warn().Err(err).Int("offset", size).Msg("WAL has a torn tail. truncating")
+		err = os.Truncate(w.segmentPath(sg), int64(size))
 		if err != nil {
-			return nil, errors.Wrap(err, fmt.Sprintf("Invalid WAL: %s", line))
+			return nil, errors.Wrap(err, "failed to truncate WAL")
 		}
+		sg.size = int64(size)
+	}
+	return css, nil
+}
 
-		cs, err := structs.ToChangeSet(int32(num))
-		if err != nil {
-			return nil, errors.Errorf("Invalid WAL number: %s", line)
+// readFile returns nil when the file doesn't exist.
+func (w *Wal) readFile(sg *segment) ([]byte, error) {
+	bs, err := ioutil.ReadFile(w.segmentPath(sg))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
 		}
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to read WAL: %s", w.segmentPath(sg)))
+	}
+	return bs, nil
+}
 
-		err = json.Unmarshal(bb[1], cs)
+// migrateLegacy rewrites the segment in the legacy format with the binary format.
+func (w *Wal) migrateLegacy(sg *segment) error {
+	bs, err := w.readFile(sg)
+	if err != nil {
+		return err
+	}
+	if !isLegacy(bs) {
+		return nil
+	}
+
+	css, err := readLegacy(bs)
+	if err != nil {
+		return err
+	}
+	var nbs []byte
+	for _, cs := range css {
+		nbs, err = appendRecord(nbs, cs)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		css = append(css, cs)
 	}
 
-	return css, nil
+	log.Info().Str("file", w.segmentPath(sg)).Int("records", len(css)).Msg("migrating WAL from the legacy format")
+	err = writeFileAtomically(w.segmentPath(sg), nbs)
+	if err != nil {
+		return err
+	}
+	sg.size = int64(len(nbs))
+	return nil
+}
+
+// writeFileAtomically replaces the file at path with bs through a temporary file.
+func writeFileAtomically(path string, bs []byte) error {
+	tmpName := path + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open file")
+	}
+	_, err = file.Write(bs)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "failed to write file")
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path)
 }
@@ -1,10 +1,17 @@
 package wal
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
-	"reflect"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/mrasu/ddb/thelper"
 	"github.com/rs/zerolog"
 
 	"github.com/mrasu/ddb/server/structs"
@@ -34,8 +41,9 @@ func TestWal_Write(t *testing.T) {
 		t.Errorf("Invalid lsn initialization: %d", w.CurrentLsn())
 	}
 
-	dcs := &structs.CreateDBChangeSet{Name: "hello"}
-	err := w.Write(dcs)
+	before := time.Now().UnixNano()
+	cs := createDBChangeSet("hello")
+	err := w.Write(cs)
 	if err != nil {
 		t.Error(err)
 	}
@@ -44,9 +52,8 @@ func TestWal_Write(t *testing.T) {
 		t.Errorf("Lsn didn't proceed: %d", w.CurrentLsn())
 	}
 
-	assertDBChangeSets(t, w, []*structs.CreateDBChangeSet{
-		{Name: "hello"},
-	})
+	assertDBChangeSets(t, w, []string{"hello"})
+	thelper.AssertBool(t, "Record doesn't have the written time", true, cs.Timestamp >= before)
 }
 
 func TestWal_Write_MultipleTimes(t *testing.T) {
@@ -55,9 +62,9 @@ func TestWal_Write_MultipleTimes(t *testing.T) {
 		t.Errorf("Invalid lsn initialization: %d", w.CurrentLsn())
 	}
 
-	origins := []structs.ChangeSet{
-		&structs.CreateDBChangeSet{Name: "hello1"},
-		&structs.CreateDBChangeSet{Name: "hello2"},
+	origins := []*pbs.ChangeSet{
+		createDBChangeSet("hello1"),
+		createDBChangeSet("hello2"),
 	}
 	for _, cs := range origins {
 		err := w.Write(cs)
@@ -70,10 +77,7 @@ func TestWal_Write_MultipleTimes(t *testing.T) {
 		t.Errorf("Lsn didn't proceed: %d", w.CurrentLsn())
 	}
 
-	assertDBChangeSets(t, w, []*structs.CreateDBChangeSet{
-		{Name: "hello1"},
-		{Name: "hello2"},
-	})
+	assertDBChangeSets(t, w, []string{"hello1", "hello2"})
 }
 
 func TestWal_WriteSlice(t *testing.T) {
@@ -82,9 +86,9 @@ func TestWal_WriteSlice(t *testing.T) {
 		t.Errorf("Invalid lsn initialization: %d", w.CurrentLsn())
 	}
 
-	origins := []structs.ChangeSet{
-		&structs.CreateDBChangeSet{Name: "hello1"},
-		&structs.CreateDBChangeSet{Name: "hello2"},
+	origins := []*pbs.ChangeSet{
+		createDBChangeSet("hello1"),
+		createDBChangeSet("hello2"),
 	}
 	err := w.WriteSlice(origins)
 	if err != nil {
@@ -95,24 +99,23 @@ func TestWal_WriteSlice(t *testing.T) {
 		t.Errorf("Lsn didn't proceed: %d", w.CurrentLsn())
 	}
 
-	assertDBChangeSets(t, w, []*structs.CreateDBChangeSet{
-		{Name: "hello1"},
-		{Name: "hello2"},
-	})
+	assertDBChangeSets(t, w, []string{"hello1", "hello2"})
 }
 
 func TestWal_Read(t *testing.T) {
-	var Rows []structs.ChangeSet
-	for num := range structs.QueryTypeMap {
-		cs, err := structs.ToChangeSet(num)
-		if err != nil {
-			t.Error(err)
-		}
-		Rows = append(Rows, cs)
+	origins := []*pbs.ChangeSet{
+		{Data: &pbs.ChangeSet_CreateDB{CreateDB: &pbs.CreateDBChangeSet{Name: "hello"}}},
+		{Data: &pbs.ChangeSet_CreateTable{CreateTable: &pbs.CreateTableChangeSet{DBName: "hello", Name: "world"}}},
+		{Data: &pbs.ChangeSet_InsertSets{InsertSets: &pbs.InsertChangeSets{Rows: []*pbs.InsertRow{{Columns: map[string]string{"id": "1"}}}}}},
+		{Data: &pbs.ChangeSet_UpdateSets{UpdateSets: &pbs.UpdateChangeSets{Rows: []*pbs.UpdateRow{{PrimaryKeyId: 1}}}}},
+		{Data: &pbs.ChangeSet_Begin{Begin: &pbs.BeginChangeSet{Number: 1}}},
+		{Data: &pbs.ChangeSet_Commit{Commit: &pbs.CommitChangeSet{Number: 1}}},
+		{Data: &pbs.ChangeSet_Rollback{Rollback: &pbs.RollbackChangeSet{Number: 1}}},
+		{Data: &pbs.ChangeSet_Abort{Abort: &pbs.AbortChangeSet{Number: 1}}},
 	}
 	w := NewTestWal(&Memory{})
 
-	err := w.WriteSlice(Rows)
+	err := w.WriteSlice(origins)
 	if err != nil {
 		t.Error(err)
 	}
@@ -121,40 +124,365 @@ func TestWal_Read(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if len(css) != len(Rows) && len(Rows) > 0 {
-		t.Errorf("Saving invalid wal size: %d", len(css))
+	if len(css) != len(origins) {
+		t.Fatalf("Saving invalid wal size: %d", len(css))
 	}
 
 	for i, cs := range css {
-		eCs := Rows[i]
-		if reflect.TypeOf(cs) != reflect.TypeOf(eCs) {
-			t.Errorf("Invalid type record: %T, %T", cs, eCs)
+		if !proto.Equal(cs, origins[i]) {
+			t.Errorf("Invalid record: %v, %v", cs, origins[i])
 		}
-
-		if cs.GetLsn() != int64(i) {
+		if cs.Lsn != int64(i) {
 			t.Errorf("Invalid lsn: %v", cs)
 		}
 	}
 }
 
-func assertDBChangeSets(t *testing.T, w *Wal, eCss []*structs.CreateDBChangeSet) {
+func TestWal_Read_Legacy(t *testing.T) {
+	var origins []structs.ChangeSet
+	for num := range structs.QueryTypeMap {
+		cs, err := structs.ToChangeSet(num)
+		if err != nil {
+			t.Error(err)
+		}
+		origins = append(origins, cs)
+	}
+	m := &Memory{}
+	for i, cs := range origins {
+		bs, err := cs.ToWalFormat(int64(i))
+		thelper.AssertNoError(t, err)
+		_, err = m.Write(bs)
+		thelper.AssertNoError(t, err)
+	}
+
+	css, err := NewTestWal(m).Read()
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Invalid wal size", len(origins), len(css))
+	for i, cs := range css {
+		thelper.AssertInt64(t, "Invalid lsn", int64(i), cs.Lsn)
+		if cs.Data == nil {
+			t.Errorf("Record is not converted: %v", origins[i])
+		}
+	}
+}
+
+func TestWal_Read_TornTail(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	w, err := NewWal(dir, "wal_")
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, w.WriteSlice([]*pbs.ChangeSet{createDBChangeSet("hello1"), createDBChangeSet("hello2")}))
+
+	bs, err := ioutil.ReadFile(w.segmentPath(w.currentSegment()))
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, ioutil.WriteFile(w.segmentPath(w.currentSegment()), bs[:len(bs)-3], 0600))
+
+	assertDBChangeSets(t, w, []string{"hello1"})
+	// Appended records are readable after the torn tail is removed.
+	thelper.AssertNoError(t, w.Write(createDBChangeSet("hello3")))
+	css, err := w.Read()
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Invalid wal size", 2, len(css))
+	thelper.AssertString(t, "Invalid record", "hello3", css[1].GetCreateDB().Name)
+}
+
+func TestWal_Read_Checksum(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	w, err := NewWal(dir, "wal_")
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, w.WriteSlice([]*pbs.ChangeSet{createDBChangeSet("hello1"), createDBChangeSet("hello2")}))
+
+	bs, err := ioutil.ReadFile(w.segmentPath(w.currentSegment()))
+	thelper.AssertNoError(t, err)
+	bs[len(bs)-1] ^= 0xff
+	thelper.AssertNoError(t, ioutil.WriteFile(w.segmentPath(w.currentSegment()), bs, 0600))
+
+	assertDBChangeSets(t, w, []string{"hello1"})
+}
+
+func TestNewWal_MigratesLegacy(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	var bs []byte
+	for i, name := range []string{"hello1", "hello2"} {
+		b, err := (&structs.CreateDBChangeSet{Name: name}).ToWalFormat(int64(i))
+		thelper.AssertNoError(t, err)
+		bs = append(bs, b...)
+	}
+	thelper.AssertNoError(t, ioutil.WriteFile(filepath.Join(dir, "wal_0.log"), bs, 0600))
+
+	w, err := NewWal(dir, "wal_")
+	thelper.AssertNoError(t, err)
+	w.SetLsn(2)
+	thelper.AssertNoError(t, w.Write(createDBChangeSet("hello3")))
+
+	migrated, err := ioutil.ReadFile(w.segmentPath(w.currentSegment()))
+	thelper.AssertNoError(t, err)
+	thelper.AssertBool(t, "WAL is not migrated", false, isLegacy(migrated))
+	assertDBChangeSets(t, w, []string{"hello1", "hello2", "hello3"})
+}
+
+func TestWal_Write_RotatesSegments(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	w, err := NewWal(dir, "wal_")
+	thelper.AssertNoError(t, err)
+	w.SetMaxSegmentSize(1)
+	for _, name := range []string{"hello1", "hello2", "hello3"} {
+		thelper.AssertNoError(t, w.Write(createDBChangeSet(name)))
+	}
+
+	for _, name := range []string{"wal_0.log", "wal_1.log", "wal_2.log"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Segment is not created: %s", name)
+		}
+	}
+
+	// Reopened Wal reads segments in the order of LSN
+	w, err = NewWal(dir, "wal_")
+	thelper.AssertNoError(t, err)
+	assertDBChangeSets(t, w, []string{"hello1", "hello2", "hello3"})
+
+	css, err := w.ReadFrom(2)
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Invalid wal size", 1, len(css))
+	thelper.AssertInt64(t, "Invalid lsn", 2, css[0].Lsn)
+}
+
+func TestWal_RemoveBefore(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	w, err := NewWal(dir, "wal_")
+	thelper.AssertNoError(t, err)
+	w.SetMaxSegmentSize(1)
+	for _, name := range []string{"hello1", "hello2", "hello3"} {
+		thelper.AssertNoError(t, w.Write(createDBChangeSet(name)))
+	}
+
+	thelper.AssertNoError(t, w.RemoveBefore(2))
+	_, err = os.Stat(filepath.Join(dir, "wal_0.log"))
+	thelper.AssertBool(t, "Covered segment is not removed", true, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "wal_1.log"))
+	thelper.AssertBool(t, "Covered segment is not removed", true, os.IsNotExist(err))
+
+	// The current segment is kept even if it is covered.
+	thelper.AssertNoError(t, w.RemoveBefore(3))
 	css, err := w.Read()
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Invalid wal size", 1, len(css))
+	thelper.AssertString(t, "Invalid record", "hello3", css[0].GetCreateDB().Name)
+}
+
+func TestWal_RemoveBefore_Archive(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	w, err := NewWal(dir, "wal_")
+	thelper.AssertNoError(t, err)
+	w.SetMaxSegmentSize(1)
+	thelper.AssertNoError(t, w.SetArchiveDir(filepath.Join(dir, "archive")))
+	for _, name := range []string{"hello1", "hello2"} {
+		thelper.AssertNoError(t, w.Write(createDBChangeSet(name)))
+	}
+
+	thelper.AssertNoError(t, w.RemoveBefore(1))
+	_, err = os.Stat(filepath.Join(dir, "archive", "wal_0.log"))
+	thelper.AssertNoError(t, err)
+	assertDBChangeSetsFrom(t, w, 1, []string{"hello2"})
+}
+
+func TestWal_TruncateFrom(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	w, err := NewWal(dir, "wal_")
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, w.WriteSlice([]*pbs.ChangeSet{createDBChangeSet("hello1"), createDBChangeSet("hello2")}))
+	w.SetMaxSegmentSize(1)
+	for _, name := range []string{"hello3", "hello4"} {
+		thelper.AssertNoError(t, w.Write(createDBChangeSet(name)))
+	}
+
+	thelper.AssertNoError(t, w.TruncateFrom(1))
+	thelper.AssertInt64(t, "Invalid lsn", 1, w.CurrentLsn())
+	_, err = os.Stat(filepath.Join(dir, "wal_2.log"))
+	thelper.AssertBool(t, "Truncated segment is not removed", true, os.IsNotExist(err))
+	assertDBChangeSets(t, w, []string{"hello1"})
+
+	thelper.AssertNoError(t, w.Write(createDBChangeSet("hello5")))
+	thelper.AssertNoError(t, w.Close())
+	w, err = NewWal(dir, "wal_")
+	thelper.AssertNoError(t, err)
+	assertDBChangeSets(t, w, []string{"hello1", "hello5"})
+}
+
+func TestWal_TruncateFrom_Archive(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	w, err := NewWal(dir, "wal_")
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, w.SetArchiveDir(filepath.Join(dir, "archive")))
+	for _, name := range []string{"hello1", "hello2"} {
+		thelper.AssertNoError(t, w.Write(createDBChangeSet(name)))
+	}
+
+	thelper.AssertNoError(t, w.TruncateFrom(1))
+	assertDBChangeSets(t, w, []string{"hello1"})
+	discarded, err := filepath.Glob(filepath.Join(dir, "archive", "wal_0.log.*.discarded"))
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Truncated segment is not archived", 1, len(discarded))
+	bs, err := ioutil.ReadFile(discarded[0])
+	thelper.AssertNoError(t, err)
+	css, _, err := readRecords(bs)
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Invalid archived records", 2, len(css))
+
+	w.SetMaxSegmentSize(1)
+	thelper.AssertNoError(t, w.Write(createDBChangeSet("hello3")))
+	thelper.AssertNoError(t, w.RemoveBefore(2))
+	err = w.TruncateFrom(0)
+	if err == nil {
+		t.Error("No error for removed LSN")
+	}
+}
+
+func TestWal_ArchiveFrom(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	w, err := NewWal(dir, "wal_")
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, w.Write(createDBChangeSet("hello1")))
+	w.SetMaxSegmentSize(1)
+	thelper.AssertNoError(t, w.Write(createDBChangeSet("hello2")))
+
+	// Segments are archived without the archive directory of RemoveBefore
+	archive := filepath.Join(dir, "discarded")
+	thelper.AssertNoError(t, w.ArchiveFrom(1, archive))
+	assertDBChangeSets(t, w, []string{"hello1"})
+	discarded, err := filepath.Glob(filepath.Join(archive, "wal_1.log.*.discarded"))
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Truncated segment is not archived", 1, len(discarded))
+}
+
+func TestWal_WriteSlice_Concurrently(t *testing.T) {
+	for _, policy := range []string{"always", "10ms", "never"} {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		w, err := NewWal(dir, "wal_")
+		thelper.AssertNoError(t, err)
+		p, err := ParseSyncPolicy(policy)
+		thelper.AssertNoError(t, err)
+		thelper.AssertNoError(t, w.SetSyncPolicy(p))
+		w.SetMaxSegmentSize(256)
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					err := w.Write(createDBChangeSet(fmt.Sprintf("hello%d_%d", i, j)))
+					if err != nil {
+						t.Error(err)
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		thelper.AssertNoError(t, w.Close())
+
+		css, err := w.Read()
+		thelper.AssertNoError(t, err)
+		thelper.AssertInt(t, "Invalid wal size", 100, len(css))
+		for i, cs := range css {
+			thelper.AssertInt64(t, "Invalid lsn", int64(i), cs.Lsn)
+		}
+	}
+}
+
+func TestWal_Append(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	w, err := NewWal(dir, "wal_")
+	thelper.AssertNoError(t, err)
+	defer w.Close()
+	seq1, err := w.Append(createDBChangeSet("hello1"))
+	thelper.AssertNoError(t, err)
+	seq2, err := w.Append(createDBChangeSet("hello2"))
+	thelper.AssertNoError(t, err)
+	thelper.AssertBool(t, "Sequence is not increased", true, seq2 > seq1)
+
+	// Appended records are readable before they are flushed
+	assertDBChangeSets(t, w, []string{"hello1", "hello2"})
+	thelper.AssertNoError(t, w.WaitSync(seq2))
+	thelper.AssertBool(t, "Records are not flushed", true, w.syncedSeq >= seq2)
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	p, err := ParseSyncPolicy("always")
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Invalid mode", int(SyncAlways), int(p.Mode))
+
+	p, err = ParseSyncPolicy("never")
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Invalid mode", int(SyncNever), int(p.Mode))
+
+	p, err = ParseSyncPolicy("100ms")
+	thelper.AssertNoError(t, err)
+	thelper.AssertInt(t, "Invalid mode", int(SyncInterval), int(p.Mode))
+	thelper.AssertInt64(t, "Invalid interval", int64(100*time.Millisecond), int64(p.Interval))
+
+	_, err = ParseSyncPolicy("sometimes")
+	thelper.AssertBool(t, "Invalid policy is accepted", true, err != nil)
+}
+
+func createDBChangeSet(name string) *pbs.ChangeSet {
+	return &pbs.ChangeSet{Data: &pbs.ChangeSet_CreateDB{CreateDB: &pbs.CreateDBChangeSet{Name: name}}}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func assertDBChangeSets(t *testing.T, w *Wal, eNames []string) {
+	t.Helper()
+	assertDBChangeSetsFrom(t, w, 0, eNames)
+}
+
+func assertDBChangeSetsFrom(t *testing.T, w *Wal, lsn int64, eNames []string) {
+	t.Helper()
+	css, err := w.ReadFrom(lsn)
 	if err != nil {
 		t.Error(err)
 	}
-	if len(css) != len(eCss) {
-		t.Errorf("Saving invalid wal size: %d", len(css))
+	if len(css) != len(eNames) {
+		t.Fatalf("Saving invalid wal size: %d", len(css))
 	}
-	for i, eCs := range eCss {
+	for i, eName := range eNames {
 		cs := css[i]
-		dcs, ok := cs.(*structs.CreateDBChangeSet)
-		if !ok {
-			t.Errorf("Invalid ChangeSet type: %v", css[0])
+		dcs := cs.GetCreateDB()
+		if dcs == nil {
+			t.Errorf("Invalid ChangeSet type: %v", cs)
+			continue
 		}
-		if dcs.Name != eCs.Name {
+		if dcs.Name != eName {
 			t.Errorf("Invalid ChangeSet content: %v", cs)
 		}
-		if dcs.Lsn != int64(i) {
+		if cs.Lsn != lsn+int64(i) {
 			t.Errorf("Invalid lsn: %v", cs)
 		}
 	}
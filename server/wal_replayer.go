@@ -0,0 +1,128 @@
+package server
+
+import (
+	"github.com/mrasu/ddb/server/data"
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// walReplayer applies ChangeSets read from the WAL.
+// ChangeSets of a transaction are buffered until its COMMIT and dropped by ROLLBACK, ABORT or the end of the log.
+type walReplayer struct {
+	s *Server
+	// ChangeSets before snapshotLsn are in the snapshot except ones of transactions in progress at the snapshot
+	snapshotLsn int64
+	pending     map[int64][]*pbs.ChangeSet
+}
+
+func newWalReplayer(s *Server, snapshotLsn int64) *walReplayer {
+	return &walReplayer{
+		s:           s,
+		snapshotLsn: snapshotLsn,
+		pending:     map[int64][]*pbs.ChangeSet{},
+	}
+}
+
+func (r *walReplayer) replay(cs *pbs.ChangeSet) error {
+	r.observe(cs)
+
+	num, ok := transactionNumber(cs)
+	if !ok {
+		if cs.Lsn < r.snapshotLsn {
+			return nil
+		}
+		return r.s.applyReplayedChangeSets([]*pbs.ChangeSet{cs}, cs.Lsn)
+	}
+
+	switch cs.Data.(type) {
+	case *pbs.ChangeSet_Begin:
+		if _, ok := r.pending[num]; ok {
+			// The former transaction is never finished because the server crashed.
+			log.Warn().Int64("transaction", num).Msg("dropping unfinished transaction which has the same number")
+		}
+		r.pending[num] = []*pbs.ChangeSet{cs}
+	case *pbs.ChangeSet_Commit:
+		css, ok := r.pending[num]
+		if !ok {
+			return r.unknownTransaction(cs, num)
+		}
+		delete(r.pending, num)
+		if cs.Lsn < r.snapshotLsn {
+			return nil
+		}
+		return r.s.applyReplayedChangeSets(append(css, cs), cs.Lsn)
+	case *pbs.ChangeSet_Rollback, *pbs.ChangeSet_Abort:
+		if _, ok := r.pending[num]; !ok {
+			return r.unknownTransaction(cs, num)
+		}
+		delete(r.pending, num)
+	default:
+		css, ok := r.pending[num]
+		if !ok {
+			return r.unknownTransaction(cs, num)
+		}
+		r.pending[num] = append(css, cs)
+	}
+	r.s.advanceLsn(cs.Lsn)
+	return nil
+}
+
+// observe keeps numbers of transactions and values of AUTO_INCREMENT in cs from being given again,
+// even when cs is in the snapshot or its transaction is not committed.
+func (r *walReplayer) observe(cs *pbs.ChangeSet) {
+	switch c := cs.Data.(type) {
+	case *pbs.ChangeSet_Begin:
+		data.AdvanceTransactionNumber(c.Begin.Number)
+	case *pbs.ChangeSet_InsertSets:
+		r.s.stateMu.RLock()
+		defer r.s.stateMu.RUnlock()
+
+		if db, ok := r.s.databases[c.InsertSets.DBName]; ok {
+			db.ObserveInsertChangeSets(c.InsertSets)
+		}
+	}
+}
+
+// unknownTransaction ignores transactions started before the WAL is read, which are finished before the snapshot.
+func (r *walReplayer) unknownTransaction(cs *pbs.ChangeSet, num int64) error {
+	if cs.Lsn < r.snapshotLsn {
+		return nil
+	}
+	return errors.Errorf("found not started transaction: %d (lsn: %d)", num, cs.Lsn)
+}
+
+// finish drops transactions not finished at the end of the log.
+func (r *walReplayer) finish() {
+	for num, css := range r.pending {
+		log.Info().Int64("transaction", num).Int("changesets", len(css)).Msg("dropping transaction not committed in WAL")
+	}
+	r.pending = map[int64][]*pbs.ChangeSet{}
+}
+
+// transactionNumber returns the number of the transaction which cs belongs to.
+func transactionNumber(cs *pbs.ChangeSet) (int64, bool) {
+	var num int64
+	switch c := cs.Data.(type) {
+	case *pbs.ChangeSet_InsertSets:
+		num = c.InsertSets.TransactionNumber
+	case *pbs.ChangeSet_UpdateSets:
+		num = c.UpdateSets.TransactionNumber
+	case *pbs.ChangeSet_DeleteSets:
+		num = c.DeleteSets.TransactionNumber
+	case *pbs.ChangeSet_Begin:
+		num = c.Begin.Number
+	case *pbs.ChangeSet_Commit:
+		num = c.Commit.Number
+	case *pbs.ChangeSet_Rollback:
+		num = c.Rollback.Number
+	case *pbs.ChangeSet_Abort:
+		num = c.Abort.Number
+	default:
+		return 0, false
+	}
+	if num == data.ImmediateTransactionNumber {
+		return 0, false
+	}
+	return num, true
+}
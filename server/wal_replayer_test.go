@@ -0,0 +1,320 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mrasu/ddb/server/data"
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/mrasu/ddb/server/structs"
+	"github.com/mrasu/ddb/thelper"
+)
+
+func TestServer_Recover_DropsUnfinishedTransactions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	c1 := s.StartNewConnection()
+	c2 := s.StartNewConnection()
+	c3 := s.StartNewConnection()
+	exec(t, c1, "CREATE DATABASE hello")
+	exec(t, c1, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	exec(t, c1, "BEGIN")
+	exec(t, c2, "BEGIN")
+	exec(t, c3, "BEGIN")
+	exec(t, c1, "INSERT INTO hello.world(id, message) VALUES (1, 'crashed')")
+	exec(t, c2, "INSERT INTO hello.world(id, message) VALUES (2, 'rollback')")
+	exec(t, c3, "INSERT INTO hello.world(id, message) VALUES (3, 'commit')")
+	exec(t, c2, "ROLLBACK")
+	exec(t, c3, "COMMIT")
+	// c1 crashes without COMMIT
+	thelper.AssertNoError(t, s.Close())
+
+	recovered, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, recovered.Recover())
+	thelper.AssertInt(t, "Unfinished transaction remains", 0, recovered.transactionHolder.Count())
+	res := exec(t, recovered.StartNewConnection(), "SELECT message FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"message": "commit"}})
+}
+
+func TestServer_Recover_SnapshotSplitsTransaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	s.wal.SetMaxSegmentSize(1)
+	c1 := s.StartNewConnection()
+	c2 := s.StartNewConnection()
+	c3 := s.StartNewConnection()
+	exec(t, c1, "CREATE DATABASE hello")
+	exec(t, c1, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	exec(t, c2, "BEGIN")
+	exec(t, c2, "INSERT INTO hello.world(id, message) VALUES (1, 'before')")
+	exec(t, c3, "BEGIN")
+	exec(t, c3, "INSERT INTO hello.world(id, message) VALUES (2, 'rollback')")
+	exec(t, c1, "INSERT INTO hello.world(id, message) VALUES (3, 'immediate')")
+	thelper.AssertNoError(t, s.TakeSnapshot())
+	exec(t, c2, "INSERT INTO hello.world(id, message) VALUES (4, 'after')")
+	exec(t, c2, "COMMIT")
+	exec(t, c3, "ROLLBACK")
+	thelper.AssertNoError(t, s.TakeSnapshot())
+	thelper.AssertNoError(t, s.Close())
+
+	// Recover from the first snapshot taken in the middle of the transactions
+	lsns, err := data.ListSnapshotLsns(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, os.Remove(filepath.Join(dir, fmt.Sprintf("snapshot_%d.snap", lsns[1]))))
+
+	recovered, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, recovered.Recover())
+	res := exec(t, recovered.StartNewConnection(), "SELECT message FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"message": "immediate"}, {"message": "before"}, {"message": "after"}})
+}
+
+func TestWalReplayer_IgnoresTransactionsFinishedBeforeSnapshot(t *testing.T) {
+	s := newDefaultServer(t)
+	s.wal.SetLsn(3)
+	r := newWalReplayer(s, 3)
+
+	css := []*pbs.ChangeSet{
+		{Lsn: 1, Data: &pbs.ChangeSet_InsertSets{InsertSets: &pbs.InsertChangeSets{TransactionNumber: 10}}},
+		{Lsn: 2, Data: &pbs.ChangeSet_Commit{Commit: &pbs.CommitChangeSet{Number: 10}}},
+	}
+	for _, cs := range css {
+		thelper.AssertNoError(t, r.replay(cs))
+	}
+	thelper.AssertInt64(t, "Invalid lsn", 3, s.wal.CurrentLsn())
+
+	err := r.replay(&pbs.ChangeSet{Lsn: 3, Data: &pbs.ChangeSet_Commit{Commit: &pbs.CommitChangeSet{Number: 11}}})
+	if err == nil {
+		t.Error("No error for not started transaction after the snapshot")
+	}
+}
+
+func TestServer_Recover_RestoresCounters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	c1 := s.StartNewConnection()
+	c2 := s.StartNewConnection()
+	exec(t, c1, "CREATE DATABASE hello")
+	exec(t, c1, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	exec(t, c1, "BEGIN")
+	exec(t, c2, "BEGIN")
+	exec(t, c1, "INSERT INTO hello.world(message) VALUES ('foo')")
+	exec(t, c2, "INSERT INTO hello.world(message) VALUES ('bar')")
+	exec(t, c1, "COMMIT")
+	exec(t, c2, "ROLLBACK")
+	thelper.AssertNoError(t, s.TakeSnapshot())
+	exec(t, c1, "BEGIN")
+	exec(t, c1, "INSERT INTO hello.world(message) VALUES ('rollback')")
+	exec(t, c1, "ROLLBACK")
+	exec(t, c1, "BEGIN")
+	exec(t, c1, "INSERT INTO hello.world(message) VALUES ('crashed')")
+	// Numbers given by other processes are known only from the WAL
+	num := data.LastTransactionNumber() + 100
+	thelper.AssertNoError(t, s.wal.WriteSlice([]*pbs.ChangeSet{
+		{Data: &pbs.ChangeSet_Begin{Begin: &pbs.BeginChangeSet{Number: num}}},
+		{Data: &pbs.ChangeSet_Rollback{Rollback: &pbs.RollbackChangeSet{Number: num}}},
+	}))
+	thelper.AssertNoError(t, s.Close())
+
+	recovered, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, recovered.Recover())
+	thelper.AssertInt64(t, "Transaction number is reused", num, data.LastTransactionNumber())
+
+	rc := recovered.StartNewConnection()
+	exec(t, rc, "INSERT INTO hello.world(message) VALUES ('baz')")
+	res := exec(t, rc, "SELECT * FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"id": "1", "message": "foo"}, {"id": "5", "message": "baz"}})
+}
+
+func TestServer_Recover_Delete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	c1 := s.StartNewConnection()
+	c2 := s.StartNewConnection()
+	exec(t, c1, "CREATE DATABASE hello")
+	exec(t, c1, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	exec(t, c1, "INSERT INTO hello.world(message) VALUES ('foo'), ('bar'), ('baz'), ('qux')")
+	exec(t, c1, "DELETE FROM hello.world WHERE id = 1")
+	exec(t, c1, "BEGIN")
+	exec(t, c1, "DELETE FROM hello.world WHERE id = 2")
+	thelper.AssertNoError(t, s.TakeSnapshot())
+	exec(t, c1, "COMMIT")
+	exec(t, c2, "BEGIN")
+	exec(t, c2, "DELETE FROM hello.world WHERE id = 3")
+	exec(t, c2, "ROLLBACK")
+	exec(t, c2, "BEGIN")
+	exec(t, c2, "DELETE FROM hello.world WHERE id = 4")
+	// c2 crashes without COMMIT
+	thelper.AssertNoError(t, s.Close())
+
+	recovered, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, recovered.Recover())
+	res := exec(t, recovered.StartNewConnection(), "SELECT message FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"message": "baz"}, {"message": "qux"}})
+}
+
+func TestServer_Recover_Drop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE DATABASE bye")
+	exec(t, c, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10))")
+	exec(t, c, "CREATE TABLE hello.dropped(id int AUTO_INCREMENT, message varchar(10))")
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('foo'), ('bar')")
+	exec(t, c, "DROP TABLE hello.dropped")
+	thelper.AssertNoError(t, s.TakeSnapshot())
+	exec(t, c, "DROP DATABASE bye")
+	exec(t, c, "TRUNCATE hello.world")
+	exec(t, c, "INSERT INTO hello.world(message) VALUES ('baz')")
+	thelper.AssertNoError(t, s.Close())
+
+	recovered, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, recovered.Recover())
+	thelper.AssertInt(t, "Dropped database remains", 1, len(recovered.databases))
+	thelper.AssertInt(t, "Dropped table remains", 1, len(data.CopyTables(recovered.databases["hello"])))
+	res := exec(t, recovered.StartNewConnection(), "SELECT * FROM hello.world")
+	data.AssertResult(t, res, []map[string]string{{"id": "3", "message": "baz"}})
+}
+
+func TestServer_Recover_AlterTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10), num int)")
+	exec(t, c, "INSERT INTO hello.world(message, num) VALUES ('foo', 1)")
+	exec(t, c, "ALTER TABLE hello.world ADD lang varchar(2) DEFAULT 'en'")
+	thelper.AssertNoError(t, s.TakeSnapshot())
+	exec(t, c, "ALTER TABLE hello.world DROP num")
+	exec(t, c, "ALTER TABLE hello.world RENAME COLUMN message TO greeting")
+	exec(t, c, "ALTER TABLE hello.world MODIFY greeting varchar(3) NOT NULL")
+	exec(t, c, "RENAME TABLE hello.world TO hello.earth")
+	thelper.AssertNoError(t, s.Close())
+
+	recovered, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, recovered.Recover())
+	res := exec(t, recovered.StartNewConnection(), "SELECT * FROM hello.earth")
+	data.AssertResult(t, res, []map[string]string{{"id": "1", "greeting": "foo", "lang": "en"}})
+
+	metas := data.CopyRowMetas(data.CopyTables(recovered.databases["hello"])[0])
+	thelper.AssertString(t, "Invalid columns", "id INT AUTO_INCREMENT,greeting VARCHAR(3),lang VARCHAR(2)", metasString(metas))
+	thelper.AssertBool(t, "Nullability is not modified", false, metas[1].AllowsNull)
+}
+
+func TestServer_Recover_Index(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.world(id int AUTO_INCREMENT, message varchar(10), num int, INDEX idx_num (num))")
+	exec(t, c, "INSERT INTO hello.world(message, num) VALUES ('foo', 1), ('bar', 2)")
+	thelper.AssertNoError(t, s.TakeSnapshot())
+	exec(t, c, "INSERT INTO hello.world(message, num) VALUES ('baz', 3)")
+	exec(t, c, "CREATE INDEX idx_message ON hello.world (message)")
+	exec(t, c, "UPDATE hello.world SET num = 4 WHERE message = 'foo'")
+	thelper.AssertNoError(t, s.Close())
+
+	recovered, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, recovered.Recover())
+	res := exec(t, recovered.StartNewConnection(), "SELECT message FROM hello.world WHERE num >= 3")
+	data.AssertResult(t, res, []map[string]string{{"message": "baz"}, {"message": "foo"}})
+
+	indexes := data.CopyIndexMetas(data.CopyTables(recovered.databases["hello"])[0])
+	thelper.AssertInt(t, "Invalid index size", 2, len(indexes))
+	thelper.AssertString(t, "Invalid index", "INDEX idx_message (message)", indexes[0].String())
+	thelper.AssertString(t, "Invalid index", "INDEX idx_num (num)", indexes[1].String())
+}
+
+func TestServer_Recover_PrimaryKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	c := s.StartNewConnection()
+	exec(t, c, "CREATE DATABASE hello")
+	exec(t, c, "CREATE TABLE hello.scores(name varchar(10), game int, score int, PRIMARY KEY (name, game))")
+	exec(t, c, "INSERT INTO hello.scores(name, game, score) VALUES ('alice', 1, 10), ('alice', 2, 20)")
+	thelper.AssertNoError(t, s.TakeSnapshot())
+	exec(t, c, "INSERT INTO hello.scores(name, game, score) VALUES ('bob', 1, 30)")
+	exec(t, c, "UPDATE hello.scores SET score = 15 WHERE name = 'alice' AND game = 1")
+	exec(t, c, "DELETE FROM hello.scores WHERE name = 'alice' AND game = 2")
+	thelper.AssertNoError(t, s.Close())
+
+	recovered, err := NewServerAt(dir)
+	thelper.AssertNoError(t, err)
+	thelper.AssertNoError(t, recovered.Recover())
+	rc := recovered.StartNewConnection()
+	res := exec(t, rc, "SELECT * FROM hello.scores")
+	data.AssertResult(t, res, []map[string]string{
+		{"name": "alice", "game": "1", "score": "15"},
+		{"name": "bob", "game": "1", "score": "30"},
+	})
+
+	_, err = rc.Query("INSERT INTO hello.scores(name, game) VALUES ('bob', 1)")
+	thelper.AssertBool(t, "Duplicated primary key is inserted after recovery", true, err != nil)
+}
+
+func metasString(metas []*structs.RowMeta) string {
+	var txts []string
+	for _, m := range metas {
+		txts = append(txts, m.String())
+	}
+	return strings.Join(txts, ",")
+}
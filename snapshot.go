@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mrasu/ddb/server/data"
+	"github.com/mrasu/ddb/server/structs"
+	"github.com/pkg/errors"
+)
+
+func snapshotCommand(args []string) {
+	if len(args) == 0 || args[0] != "inspect" {
+		die(errors.New("usage: ddb snapshot inspect [flags]"))
+	}
+
+	err := snapshotInspect(os.Stdout, args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// snapshotInspect lists snapshots and prints the contents of one of them.
+func snapshotInspect(out io.Writer, args []string) error {
+	fs := flag.NewFlagSet("snapshot inspect", flag.ContinueOnError)
+	dir := fs.String("dir", "./log", "directory of snapshots")
+	lsn := fs.Int64("lsn", -1, "LSN of the snapshot to print. -1 prints the newest valid one")
+	rows := fs.Bool("rows", false, "print rows of tables")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	lsns, err := data.ListSnapshotLsns(*dir)
+	if err != nil {
+		return err
+	}
+	for _, l := range lsns {
+		ss, err := data.ReadSnapshot(*dir, l)
+		if err != nil {
+			fmt.Fprintf(out, "lsn=%d INVALID %v\n", l, err)
+			continue
+		}
+		fmt.Fprintf(out, "lsn=%d replay_lsn=%d at=%s\n", ss.Lsn(), ss.ReplayLsn(), snapshotTime(ss))
+	}
+
+	var ss *data.Snapshot
+	if *lsn >= 0 {
+		ss, err = data.ReadSnapshot(*dir, *lsn)
+	} else {
+		ss, err = data.RecoverSnapshot(*dir)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("no snapshot in %s", *dir)
+		}
+		return err
+	}
+	printSnapshot(out, ss, *rows)
+	return nil
+}
+
+func printSnapshot(out io.Writer, ss *data.Snapshot, rows bool) {
+	sdata := ss.Data()
+	fmt.Fprintf(out, "snapshot lsn=%d replay_lsn=%d last_trx_num=%d at=%s\n", sdata.Lsn, sdata.ReplayLsn, sdata.LastTransactionNumber, snapshotTime(ss))
+	for _, sdb := range sdata.Databases {
+		fmt.Fprintf(out, "database %s\n", sdb.Name)
+		for _, st := range sdb.Tables {
+			var cols []string
+			for _, m := range st.RowMetas {
+				cols = append(cols, m.String())
+			}
+			if def := primaryKeyDefinition(st.PrimaryKey); def != "" {
+				cols = append(cols, def)
+			}
+			for _, i := range st.Indexes {
+				cols = append(cols, (&structs.IndexMeta{Name: i.Name, Columns: i.Columns}).String())
+			}
+			fmt.Fprintf(out, "  table %s(%s) rows=%d\n", st.Name, strings.Join(cols, ", "), len(st.Rows))
+			if !rows {
+				continue
+			}
+			for _, r := range st.Rows {
+				fmt.Fprintf(out, "    %s\n", columnsString(r.Columns))
+			}
+		}
+	}
+}
+
+func snapshotTime(ss *data.Snapshot) string {
+	if ss.Timestamp() == 0 {
+		return "unknown"
+	}
+	return time.Unix(0, ss.Timestamp()).Format(time.RFC3339Nano)
+}
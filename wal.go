@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrasu/ddb/server/data"
+	"github.com/mrasu/ddb/server/pbs"
+	"github.com/mrasu/ddb/server/wal"
+	"github.com/pkg/errors"
+)
+
+func walCommand(args []string) {
+	if len(args) == 0 {
+		die(errors.New("usage: ddb wal dump|verify [flags]"))
+	}
+
+	var err error
+	switch args[0] {
+	case "dump":
+		err = walDump(os.Stdout, args[1:])
+	case "verify":
+		err = walVerify(os.Stdout, args[1:])
+	default:
+		err = errors.Errorf("unknown wal command: %s", args[0])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// walDump prints records of the WAL matching the filters.
+func walDump(out io.Writer, args []string) error {
+	fs := flag.NewFlagSet("wal dump", flag.ContinueOnError)
+	dir := fs.String("dir", "./log", "directory of the WAL")
+	prefix := fs.String("prefix", "wal_", "prefix of WAL segments")
+	from := fs.Int64("from", 0, "first LSN to print")
+	to := fs.Int64("to", -1, "last LSN to print. -1 prints to the end")
+	trx := fs.Int64("trx", 0, "transaction number to print. 0 prints every transaction")
+	table := fs.String("table", "", "table to print, `db.table` or `table`")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	files, err := wal.ListSegmentFiles(*dir, *prefix)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		css, err := wal.ReadSegmentFile(f.Path)
+		for _, cs := range css {
+			if cs.Lsn < *from || (*to >= 0 && cs.Lsn > *to) {
+				continue
+			}
+			r := describeChangeSet(cs)
+			if *trx != 0 && (!r.hasTrx || r.trx != *trx) {
+				continue
+			}
+			if *table != "" && r.table != *table && !strings.HasSuffix(r.table, "."+*table) {
+				continue
+			}
+			fmt.Fprintln(out, r.String())
+		}
+		if err != nil {
+			fmt.Fprintf(out, "ERROR %v\n", err)
+		}
+	}
+	return nil
+}
+
+// walVerify reads every record of the WAL and reports broken records and gaps of LSNs.
+func walVerify(out io.Writer, args []string) error {
+	fs := flag.NewFlagSet("wal verify", flag.ContinueOnError)
+	dir := fs.String("dir", "./log", "directory of the WAL")
+	prefix := fs.String("prefix", "wal_", "prefix of WAL segments")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	files, err := wal.ListSegmentFiles(*dir, *prefix)
+	if err != nil {
+		return err
+	}
+
+	problems := 0
+	records := 0
+	prevLsn := int64(-1)
+	for i, f := range files {
+		css, err := wal.ReadSegmentFile(f.Path)
+		if err != nil {
+			problems++
+			if _, ok := err.(*wal.CorruptRecordError); ok && i == len(files)-1 {
+				fmt.Fprintf(out, "CORRUPT %v (torn tail, truncated by recovery)\n", err)
+			} else {
+				fmt.Fprintf(out, "CORRUPT %v\n", err)
+			}
+		}
+		if len(css) > 0 && css[0].Lsn != f.StartLsn {
+			problems++
+			fmt.Fprintf(out, "MISMATCH %s starts at LSN %d but its first record is LSN %d\n", f.Path, f.StartLsn, css[0].Lsn)
+		}
+		for _, cs := range css {
+			if prevLsn >= 0 && cs.Lsn != prevLsn+1 {
+				problems++
+				fmt.Fprintf(out, "GAP LSN %d is followed by LSN %d in %s\n", prevLsn, cs.Lsn, f.Path)
+			}
+			prevLsn = cs.Lsn
+			records++
+		}
+	}
+
+	fmt.Fprintf(out, "%d records in %d segments", records, len(files))
+	if records > 0 {
+		fmt.Fprintf(out, ", last LSN %d", prevLsn)
+	}
+	fmt.Fprintln(out)
+	if problems > 0 {
+		return errors.Errorf("found %d problems", problems)
+	}
+	return nil
+}
+
+type changeSetDescription struct {
+	lsn    int64
+	kind   string
+	trx    int64
+	hasTrx bool
+	table  string
+	detail string
+}
+
+func (d *changeSetDescription) String() string {
+	txts := []string{fmt.Sprintf("lsn=%d", d.lsn), d.kind}
+	if d.hasTrx {
+		txts = append(txts, fmt.Sprintf("trx=%d", d.trx))
+	}
+	if d.table != "" {
+		txts = append(txts, d.table)
+	}
+	if d.detail != "" {
+		txts = append(txts, d.detail)
+	}
+	return strings.Join(txts, " ")
+}
+
+func describeChangeSet(cs *pbs.ChangeSet) *changeSetDescription {
+	d := &changeSetDescription{lsn: cs.Lsn}
+	switch c := cs.Data.(type) {
+	case *pbs.ChangeSet_CreateDB:
+		d.kind = "CREATE_DATABASE"
+		d.table = c.CreateDB.Name
+	case *pbs.ChangeSet_DropDB:
+		d.kind = "DROP_DATABASE"
+		d.table = c.DropDB.Name
+	case *pbs.ChangeSet_CreateTable:
+		d.kind = "CREATE_TABLE"
+		d.table = c.CreateTable.DBName + "." + c.CreateTable.Name
+		var cols []string
+		for _, m := range data.ToRowMetas(c.CreateTable.RowMetas) {
+			cols = append(cols, m.String())
+		}
+		if def := primaryKeyDefinition(c.CreateTable.PrimaryKey); def != "" {
+			cols = append(cols, def)
+		}
+		for _, m := range data.ToIndexMetas(c.CreateTable.Indexes) {
+			cols = append(cols, m.String())
+		}
+		d.detail = "(" + strings.Join(cols, ", ") + ")"
+	case *pbs.ChangeSet_DropTable:
+		d.kind = "DROP_TABLE"
+		d.table = c.DropTable.DBName + "." + c.DropTable.Name
+	case *pbs.ChangeSet_TruncateTable:
+		d.kind = "TRUNCATE_TABLE"
+		d.table = c.TruncateTable.DBName + "." + c.TruncateTable.Name
+	case *pbs.ChangeSet_AddColumn:
+		d.kind = "ADD_COLUMN"
+		d.table = c.AddColumn.DBName + "." + c.AddColumn.TableName
+		d.detail = data.ToRowMetas([]*pbs.RowMeta{c.AddColumn.Column})[0].String()
+		if c.AddColumn.HasDefault {
+			d.detail += fmt.Sprintf(" default=%q", c.AddColumn.Default)
+		}
+	case *pbs.ChangeSet_DropColumn:
+		d.kind = "DROP_COLUMN"
+		d.table = c.DropColumn.DBName + "." + c.DropColumn.TableName
+		d.detail = c.DropColumn.Name
+	case *pbs.ChangeSet_RenameColumn:
+		d.kind = "RENAME_COLUMN"
+		d.table = c.RenameColumn.DBName + "." + c.RenameColumn.TableName
+		d.detail = c.RenameColumn.Name + "->" + c.RenameColumn.NewName
+	case *pbs.ChangeSet_ModifyColumn:
+		d.kind = "MODIFY_COLUMN"
+		d.table = c.ModifyColumn.DBName + "." + c.ModifyColumn.TableName
+		d.detail = data.ToRowMetas([]*pbs.RowMeta{c.ModifyColumn.Column})[0].String()
+	case *pbs.ChangeSet_RenameTable:
+		d.kind = "RENAME_TABLE"
+		d.table = c.RenameTable.DBName + "." + c.RenameTable.Name
+		d.detail = "->" + c.RenameTable.DBName + "." + c.RenameTable.NewName
+	case *pbs.ChangeSet_CreateIndex:
+		d.kind = "CREATE_INDEX"
+		d.table = c.CreateIndex.DBName + "." + c.CreateIndex.TableName
+		d.detail = data.ToIndexMetas([]*pbs.IndexMeta{c.CreateIndex.Index})[0].String()
+	case *pbs.ChangeSet_DropIndex:
+		d.kind = "DROP_INDEX"
+		d.table = c.DropIndex.DBName + "." + c.DropIndex.TableName
+		d.detail = c.DropIndex.Name
+	case *pbs.ChangeSet_InsertSets:
+		d.kind = "INSERT"
+		d.trx, d.hasTrx = c.InsertSets.TransactionNumber, true
+		d.table = c.InsertSets.DBName + "." + c.InsertSets.TableName
+		var rows []string
+		for _, r := range c.InsertSets.Rows {
+			rows = append(rows, columnsString(r.Columns))
+		}
+		d.detail = strings.Join(rows, " ")
+	case *pbs.ChangeSet_UpdateSets:
+		d.kind = "UPDATE"
+		d.trx, d.hasTrx = c.UpdateSets.TransactionNumber, true
+		d.table = c.UpdateSets.DBName + "." + c.UpdateSets.TableName
+		var rows []string
+		for _, r := range c.UpdateSets.Rows {
+			rows = append(rows, fmt.Sprintf("pk=%s:%s", primaryKeyString(r.PrimaryKey, r.PrimaryKeyId), columnsString(r.Columns)))
+		}
+		d.detail = strings.Join(rows, " ")
+	case *pbs.ChangeSet_DeleteSets:
+		d.kind = "DELETE"
+		d.trx, d.hasTrx = c.DeleteSets.TransactionNumber, true
+		d.table = c.DeleteSets.DBName + "." + c.DeleteSets.TableName
+		var rows []string
+		for _, r := range c.DeleteSets.Rows {
+			rows = append(rows, "pk="+primaryKeyString(r.PrimaryKey, r.PrimaryKeyId))
+		}
+		d.detail = strings.Join(rows, " ")
+	case *pbs.ChangeSet_Begin:
+		d.kind = "BEGIN"
+		d.trx, d.hasTrx = c.Begin.Number, true
+	case *pbs.ChangeSet_Commit:
+		d.kind = "COMMIT"
+		d.trx, d.hasTrx = c.Commit.Number, true
+		if c.Commit.Timestamp != 0 {
+			d.detail = "at=" + time.Unix(0, c.Commit.Timestamp).Format(time.RFC3339Nano)
+		}
+	case *pbs.ChangeSet_Rollback:
+		d.kind = "ROLLBACK"
+		d.trx, d.hasTrx = c.Rollback.Number, true
+	case *pbs.ChangeSet_Abort:
+		d.kind = "ABORT"
+		d.trx, d.hasTrx = c.Abort.Number, true
+	default:
+		d.kind = fmt.Sprintf("UNKNOWN(%T)", c)
+	}
+	return d
+}
+
+// columnsString encodes columns in JSON, whose keys are sorted.
+func columnsString(columns map[string]string) string {
+	bs, err := json.Marshal(columns)
+	if err != nil {
+		return fmt.Sprintf("%v", columns)
+	}
+	return string(bs)
+}
+
+// primaryKeyString shows values of the primary key, which are only the id in records written by older versions.
+func primaryKeyString(values []string, id int64) string {
+	if len(values) == 0 {
+		return strconv.FormatInt(id, 10)
+	}
+	if len(values) == 1 {
+		return values[0]
+	}
+	return "(" + strings.Join(values, ",") + ")"
+}
+
+// primaryKeyDefinition returns `PRIMARY KEY (...)`, or an empty string for the default primary key `id`.
+func primaryKeyDefinition(columns []string) string {
+	if len(columns) == 0 || (len(columns) == 1 && columns[0] == data.PrimaryKeyName) {
+		return ""
+	}
+	return "PRIMARY KEY (" + strings.Join(columns, ", ") + ")"
+}